@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structured access log. Every request for files (web UI, WebDAV, drop box)
+// is recorded with who made it, what came back and how long it took. The
+// most recent entries are kept in memory; with -state they are also
+// appended as JSON lines to access.log in the state directory (rotated to
+// access.log.1 at 64 MB) so history survives restarts. Admins browse it at
+// /api/v1/access-log.
+//
+// For log analysers and shippers the same entries can be written to
+// -accesslog, in Apache combined format or as JSON lines, rotated by size
+// and/or on a schedule; -verbose writes them to the console.
+
+type accessEntry struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Proto    string    `json:"proto,omitempty"`
+	Status   int       `json:"status"`
+	Bytes    int64     `json:"bytes"`
+	Millis   int64     `json:"ms"`
+	User     string    `json:"user,omitempty"`
+	IP       string    `json:"ip"`
+	Country  string    `json:"country,omitempty"`
+	Agent    string    `json:"agent,omitempty"`
+	Referrer string    `json:"referrer,omitempty"`
+}
+
+const (
+	accessRingSize   = 10000
+	accessLogMaxSize = 64 << 20
+)
+
+var (
+	accessMu   sync.Mutex
+	accessRing []accessEntry // oldest first, the last 5000-10000 entries
+	accessLogs []*accessLog  // files the entries are written to
+)
+
+// accessLog is a file, or the console, the access log is written to.
+type accessLog struct {
+	path    string        // "" for standard output
+	format  string        // "combined" or "json"
+	maxSize int64         // rotate at this size (0 = no limit)
+	every   time.Duration // rotate at this interval from midnight (0 = never)
+	keep    int           // rotated files kept, as path.1 (newest) to path.<keep>
+	file    *os.File
+	size    int64
+	due     time.Time // when the file is next rotated by time
+}
+
+func accessLogPath() string {
+	if stateDir == "" {
+		return ""
+	}
+	if stateShared {
+		// Instances sharing the directory each keep their own log
+		host, _ := os.Hostname()
+		return filepath.Join(stateDir, "access-"+host+".log")
+	}
+	return filepath.Join(stateDir, "access.log")
+}
+
+// setupAccessLog sets up the logs entries are written to: the searchable
+// log in the -state directory, file ("-" for the console) in format, and
+// the console as well when verbose.
+func setupAccessLog(file, format string, maxSizeMB int64, rotate string, keep int, verbose bool) error {
+	if format != "combined" && format != "json" {
+		return fmt.Errorf("invalid -accesslog-format %q (combined or json)", format)
+	}
+	var every time.Duration
+	if rotate != "" && rotate != "0" {
+		d, err := parseDuration(rotate)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("invalid -accesslog-rotate %q (e.g. 1h, 24h, 7d)", rotate)
+		}
+		every = d
+	}
+	if p := accessLogPath(); p != "" {
+		accessLogs = append(accessLogs, &accessLog{path: p, format: "json", maxSize: accessLogMaxSize, keep: 1})
+	}
+	if file != "" && file != "-" {
+		accessLogs = append(accessLogs, &accessLog{path: file, format: format, maxSize: maxSizeMB << 20, every: every, keep: max(keep, 0)})
+	}
+	if file == "-" || verbose {
+		accessLogs = append(accessLogs, &accessLog{format: format})
+	}
+	return nil
+}
+
+// recordAccess adds e to the in-memory log and the log files.
+func recordAccess(e accessEntry) {
+	accessMu.Lock()
+	defer accessMu.Unlock()
+	if len(accessRing) >= accessRingSize {
+		// Drop the older half at once rather than shifting on every request
+		accessRing = append(accessRing[:0], accessRing[accessRingSize/2:]...)
+	}
+	accessRing = append(accessRing, e)
+	for _, l := range accessLogs {
+		l.write(e)
+	}
+}
+
+// write appends e to the log, rotating it first if it is due.
+func (l *accessLog) write(e accessEntry) {
+	line := e.line(l.format)
+	if l.path == "" {
+		os.Stdout.Write(line)
+		return
+	}
+	now := time.Now()
+	if l.file == nil {
+		if err := l.open(now); err != nil {
+			log.Printf("Cannot open access log: %v", err)
+			return
+		}
+	}
+	if l.size > 0 && ((l.maxSize > 0 && l.size >= l.maxSize) || (!l.due.IsZero() && !now.Before(l.due))) {
+		l.rotate()
+		if err := l.open(now); err != nil {
+			log.Printf("Cannot open access log: %v", err)
+			return
+		}
+	}
+	n, _ := l.file.Write(line)
+	l.size += int64(n)
+}
+
+// open opens the log file for appending. A file left from before a restart
+// counts from when it was last written, so it rotates on schedule.
+func (l *accessLog) open(now time.Time) error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	if l.every > 0 {
+		since := now
+		if l.size > 0 {
+			since = info.ModTime()
+		}
+		l.due = nextRotation(since, l.every)
+	}
+	return nil
+}
+
+// rotate closes the log file and shifts it to path.1, path.1 to path.2 and
+// so on, dropping the oldest beyond keep.
+func (l *accessLog) rotate() {
+	l.file.Close()
+	l.file = nil
+	if l.keep == 0 {
+		os.Remove(l.path)
+		return
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	os.Rename(l.path, l.path+".1")
+}
+
+// nextRotation is the end of the period of length every that t falls in,
+// with periods counted from local midnight, so a 24h log rotates at
+// midnight and an hourly one on the hour.
+func nextRotation(t time.Time, every time.Duration) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if every >= 24*time.Hour {
+		return midnight.Add(every)
+	}
+	return midnight.Add(t.Sub(midnight).Truncate(every) + every)
+}
+
+// line formats e as a line of the log: JSON, or Apache's combined format
+// (which has no field for latency; use JSON to get it).
+func (e accessEntry) line(format string) []byte {
+	if format == "json" {
+		line, _ := json.Marshal(e)
+		return append(line, '\n')
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return logEscape(s)
+	}
+	target := (&url.URL{Path: e.Path, RawQuery: e.Query}).RequestURI()
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		e.IP, dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		logEscape(e.Method), logEscape(target), dash(e.Proto),
+		e.Status, size, dash(e.Referrer), dash(e.Agent))
+}
+
+// logEscape escapes quotes, backslashes and control characters the way
+// Apache does, so a field can't break the line apart.
+func logEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// accessLogMiddleware records each request once it has been answered.
+func accessLogMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		e := accessEntry{
+			Time:     start,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Query:    r.URL.RawQuery,
+			Proto:    r.Proto,
+			Status:   rec.status,
+			Bytes:    rec.bytes,
+			Millis:   time.Since(start).Milliseconds(),
+			IP:       ip,
+			Country:  requestCountry(r),
+			Agent:    r.UserAgent(),
+			Referrer: r.Referer(),
+		}
+		if user := getUserFromRequest(r); user != nil {
+			e.User = user.Username
+		}
+		recordAccess(e)
+	}
+}
+
+// accessFilter selects log entries. Empty fields match everything.
+type accessFilter struct {
+	path     *regexp.Regexp // glob when the pattern has wildcards
+	pathSub  string
+	user     string
+	ip       string
+	status   int // exact code, or 1-5 for a class like 4xx
+	from, to time.Time
+}
+
+func parseAccessFilter(r *http.Request) (accessFilter, error) {
+	q := r.URL.Query()
+	f := accessFilter{user: q.Get("user"), ip: q.Get("ip")}
+	if p := q.Get("path"); strings.ContainsAny(p, "*?") {
+		re, err := compileGlob(p)
+		if err != nil {
+			return f, err
+		}
+		f.path = re
+	} else {
+		f.pathSub = strings.ToLower(p)
+	}
+	if s := strings.ToLower(q.Get("status")); s != "" {
+		if len(s) == 3 && strings.HasSuffix(s, "xx") {
+			s = s[:1]
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return f, err
+		}
+		f.status = n
+	}
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.from}, {"to", &f.to}} {
+		if v := q.Get(t.name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, err
+			}
+			*t.dst = parsed
+		}
+	}
+	return f, nil
+}
+
+func (f accessFilter) match(e accessEntry) bool {
+	switch {
+	case f.path != nil && !f.path.MatchString(e.Path):
+		return false
+	case f.pathSub != "" && !strings.Contains(strings.ToLower(e.Path), f.pathSub):
+		return false
+	case f.user != "" && !strings.EqualFold(e.User, f.user):
+		return false
+	case f.ip != "" && !strings.HasPrefix(e.IP, f.ip):
+		return false
+	case f.status >= 100 && e.Status != f.status:
+		return false
+	case f.status > 0 && f.status < 10 && e.Status/100 != f.status:
+		return false
+	case !f.from.IsZero() && e.Time.Before(f.from):
+		return false
+	case !f.to.IsZero() && e.Time.After(f.to):
+		return false
+	}
+	return true
+}
+
+// scanAccessLog calls fn for every logged entry, oldest first: the rotated
+// and current log files when -state is set, otherwise the in-memory log.
+func scanAccessLog(fn func(accessEntry)) {
+	file := accessLogPath()
+	if file == "" {
+		accessMu.Lock()
+		entries := append([]accessEntry(nil), accessRing...)
+		accessMu.Unlock()
+		for _, e := range entries {
+			fn(e)
+		}
+		return
+	}
+	for _, name := range []string{file + ".1", file} {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var e accessEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				fn(e)
+			}
+		}
+		f.Close()
+	}
+}
+
+// handleAccessLog serves /api/v1/access-log: entries matching the path,
+// user, ip, status (404 or 4xx), from and to (RFC 3339) filters, newest
+// first, in pages of limit (default 100). Admins (modify permission) only.
+func handleAccessLog(w http.ResponseWriter, r *http.Request) {
+	if _, admin := permissionsFor(r); !admin {
+		jsonError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+	filter, err := parseAccessFilter(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid filter: "+err.Error())
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var matched []accessEntry
+	scanAccessLog(func(e accessEntry) {
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	})
+	// Newest first
+	total := len(matched)
+	end := max(total-(page-1)*limit, 0)
+	start := max(end-limit, 0)
+	entries := make([]accessEntry, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		entries = append(entries, matched[i])
+	}
+	writeJSON(w, map[string]any{
+		"success":   true,
+		"entries":   entries,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+		"persisted": accessLogPath() != "",
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Automatic certificates. With -acme, listeners marked ,tls get their
+// certificates from Let's Encrypt for the named hosts instead of from
+// -tls-cert and -tls-key, and renew them before they expire. The challenge
+// is answered on the TLS listener itself (TLS-ALPN, which needs it on port
+// 443) or, if there is one, on a plain listener on port 80. Certificates
+// and the account key are kept in -acme-cache so restarts don't request new
+// ones.
+
+var acmeManager *autocert.Manager
+
+// setupACME configures acmeManager for a comma-separated list of hosts.
+func setupACME(hosts, cacheDir, email string) error {
+	if cacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		cacheDir = filepath.Join(dir, "goserve", "acme")
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return err
+	}
+	var names []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(names...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	// Copied links have to use a name the certificate covers
+	if advertiseHost == "" && len(names) > 0 {
+		advertiseHost = names[0]
+	}
+	return nil
+}
+
+// acmeHTTPHandler answers HTTP-01 challenges on plain listeners and passes
+// everything else to next.
+func acmeHTTPHandler(next http.Handler) http.Handler {
+	if acmeManager == nil {
+		return next
+	}
+	return acmeManager.HTTPHandler(next)
+}
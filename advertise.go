@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Advertised URLs. Links the UI copies (Copy Link, short and upload links,
+// pastes, the WebDAV mount URL) and the startup banner should work from
+// other devices, so they use the address the client reached rather than
+// localhost. A client browsing on the server itself gets the machine's
+// Tailscale address, or else its first LAN address, provided the listener
+// accepts connections on them. -advertise-host overrides all of this, for
+// servers behind NAT or a reverse proxy.
+
+var advertiseHost string
+
+// tailscaleNet is the CGNAT range Tailscale assigns addresses from.
+var tailscaleNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// interfaceIPs returns this machine's non-loopback IPv4 addresses, split
+// into Tailscale and other (LAN) addresses.
+func interfaceIPs() (tailscale, lan []net.IP) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		if tailscaleNet.Contains(ipnet.IP) {
+			tailscale = append(tailscale, ipnet.IP)
+		} else {
+			lan = append(lan, ipnet.IP)
+		}
+	}
+	return tailscale, lan
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// preferredHost is the host to advertise for a listener bound to bindHost:
+// -advertise-host, then for wildcard listeners the Tailscale or first LAN
+// address, then the bound address itself.
+func preferredHost(bindHost string) string {
+	if advertiseHost != "" {
+		return advertiseHost
+	}
+	if isWildcardHost(bindHost) {
+		tailscale, lan := interfaceIPs()
+		if ips := append(tailscale, lan...); len(ips) > 0 {
+			return ips[0].String()
+		}
+		return "localhost"
+	}
+	return bindHost
+}
+
+// joinHostPort is net.JoinHostPort, except that an empty port leaves the
+// host alone and a host that already has a port keeps it.
+func joinHostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// advertisedOrigin returns the scheme://host[:port] that links handed to r
+// should use.
+func advertisedOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, ""
+	}
+	if advertiseHost != "" {
+		return scheme + "://" + joinHostPort(advertiseHost, port)
+	}
+	if !isLoopbackHost(strings.Trim(host, "[]")) {
+		return scheme + "://" + r.Host
+	}
+	bindHost := ""
+	if cfg := listenerFor(r); cfg != nil {
+		bindHost, _, _ = net.SplitHostPort(cfg.Addr)
+	}
+	if !isWildcardHost(bindHost) {
+		return scheme + "://" + r.Host
+	}
+	return scheme + "://" + joinHostPort(preferredHost(bindHost), port)
+}
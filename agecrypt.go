@@ -0,0 +1,23 @@
+package main
+
+import (
+	"io"
+
+	"filippo.io/age"
+)
+
+// Passphrase-encrypted TARs. A TAR stream downloaded with a password
+// (POSTed, like an encrypted ZIP's) is wrapped whole in age's scrypt
+// format, so names, sizes and permissions are hidden too, which AES ZIPs
+// leave in the clear. `age -d`, rage and other age tools open it. Each
+// download has its own salt and file key, so none are spooled.
+
+// newAgeWriter returns a writer that encrypts what it is given to out under
+// password; closing it finishes the stream but not out.
+func newAgeWriter(out io.Writer, password string) (io.WriteCloser, error) {
+	recipient, err := age.NewScryptRecipient(password)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(out, recipient)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive summaries. ?contents=1 on a ZIP or TAR (optionally gzipped) file
+// returns its entry count, total uncompressed size and the first entries,
+// so the preview pane can show what an archive holds before it is
+// downloaded. ZIP listings come from the central directory; TAR files are
+// read header by header, skipping file data, and nothing is extracted.
+
+const (
+	archiveListDefault = 200
+	archiveListMax     = 5000
+	archiveScanMax     = 1000000 // entries counted before giving up
+)
+
+type archiveEntry struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	IsDir    bool   `json:"isDir"`
+	Modified string `json:"modified,omitempty"`
+}
+
+type archiveSummary struct {
+	Format    string         `json:"format"`
+	Entries   int            `json:"entries"`
+	Files     int            `json:"files"`
+	TotalSize int64          `json:"totalSize"` // uncompressed bytes of all files
+	Items     []archiveEntry `json:"items"`     // the first entries, in archive order
+	Complete  bool           `json:"complete"`  // false if counting stopped early
+}
+
+// archiveFormat returns "zip", "tar" or "tar.gz" for names it can list.
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+func (s *archiveSummary) add(e archiveEntry, limit int) {
+	s.Entries++
+	if !e.IsDir {
+		s.Files++
+		s.TotalSize += e.Size
+	}
+	if len(s.Items) < limit {
+		s.Items = append(s.Items, e)
+	}
+}
+
+func summarizeZip(fullPath string, limit int) (*archiveSummary, error) {
+	zr, err := zip.OpenReader(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	s := &archiveSummary{Format: "zip", Items: []archiveEntry{}, Complete: true}
+	for _, f := range zr.File {
+		s.add(archiveEntry{
+			Name:     f.Name,
+			Size:     int64(f.UncompressedSize64),
+			IsDir:    f.FileInfo().IsDir(),
+			Modified: f.Modified.UTC().Format(time.RFC3339),
+		}, limit)
+	}
+	return s, nil
+}
+
+func summarizeTar(fullPath, format string, limit int) (*archiveSummary, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f // an *os.File lets tar seek past file data
+	if format == "tar.gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	s := &archiveSummary{Format: format, Items: []archiveEntry{}, Complete: true}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if s.Entries == 0 {
+				return nil, err
+			}
+			// Truncated or damaged: report what could be read
+			s.Complete = false
+			break
+		}
+		if s.Entries >= archiveScanMax {
+			s.Complete = false
+			break
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+		default:
+			continue
+		}
+		s.add(archiveEntry{
+			Name:     hdr.Name,
+			Size:     hdr.Size,
+			IsDir:    hdr.Typeflag == tar.TypeDir,
+			Modified: hdr.ModTime.UTC().Format(time.RFC3339),
+		}, limit)
+	}
+	return s, nil
+}
+
+// handleArchiveContents serves ?contents=1[&limit=N] for archive files.
+func handleArchiveContents(w http.ResponseWriter, r *http.Request, fullPath string) {
+	format := archiveFormat(fullPath)
+	if format == "" {
+		jsonError(w, http.StatusBadRequest, "Not a ZIP or TAR archive")
+		return
+	}
+	limit := archiveListDefault
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n >= 0 {
+		limit = min(n, archiveListMax)
+	}
+	var s *archiveSummary
+	var err error
+	if format == "zip" {
+		s, err = summarizeZip(fullPath, limit)
+	} else {
+		s, err = summarizeTar(fullPath, format, limit)
+	}
+	if err != nil {
+		jsonError(w, http.StatusUnprocessableEntity, "Cannot read archive: "+err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "archive": s})
+}
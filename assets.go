@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// UI assets. The listing page's CSS and JavaScript live in ./web as plain
+// files, one module per feature, and are embedded into the binary. Each is
+// served from /_static/ under a name carrying a hash of its contents
+// (app.3f9c2a1b.css), so browsers can cache them for good: a new build that
+// changes a file changes its URL. The template links them with
+// {{asset "app.css"}}.
+
+//go:embed web
+var webFS embed.FS
+
+type asset struct {
+	data        []byte
+	contentType string
+}
+
+var (
+	assetNames = map[string]string{} // file name -> hashed name
+	assets     = map[string]asset{}  // hashed name -> contents
+)
+
+func init() {
+	entries, err := fs.ReadDir(webFS, "web")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := webFS.ReadFile("web/" + e.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		ext := path.Ext(e.Name())
+		hashed := strings.TrimSuffix(e.Name(), ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		assetNames[e.Name()] = hashed
+		assets[hashed] = asset{data, mime.TypeByExtension(ext)}
+	}
+}
+
+// assetURL returns the URL of the embedded asset name. An unknown name is
+// an error, so a typo fails the page rather than leaving a broken link.
+func assetURL(name string) (string, error) {
+	hashed, ok := assetNames[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %q", name)
+	}
+	return "/_static/" + hashed, nil
+}
+
+// handleStatic serves the hashed assets with long-lived cache headers.
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	a, ok := assets[strings.TrimPrefix(r.URL.Path, "/_static/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(a.data)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Brute-force protection. Failed sign-ins (Basic credentials, the login
+// page, API tokens) are counted per client IP and per username. Once either
+// reaches -auth-max-failures within -auth-lockout, it is locked out for
+// -auth-lockout: its sign-ins are refused with 429 without checking the
+// password, and the lockout is logged. A successful sign-in clears both
+// counts. Behind a proxy on the same machine (tailscale serve and funnel,
+// a local reverse proxy) the client IP is taken from X-Forwarded-For.
+
+var (
+	authMaxFailures = 10
+	authLockout     = 15 * time.Minute
+)
+
+type authFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+var (
+	authFails     = map[string]*authFailures{} // "ip:…" / "user:…"
+	authFailsMu   sync.Mutex
+	authLastSweep time.Time
+)
+
+// authClientIP returns the IP failures are counted against.
+func authClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			// The proxy appends the address it saw last
+			parts := strings.Split(fwd, ",")
+			if client := strings.TrimSpace(parts[len(parts)-1]); client != "" {
+				return client
+			}
+		}
+	}
+	return host
+}
+
+func authFailureKeys(r *http.Request, username string) []string {
+	keys := []string{"ip:" + authClientIP(r)}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+// authLockedOut returns how much longer sign-ins from r, or as username
+// if not empty, are refused; 0 if they aren't.
+func authLockedOut(r *http.Request, username string) time.Duration {
+	if authMaxFailures <= 0 {
+		return 0
+	}
+	authFailsMu.Lock()
+	defer authFailsMu.Unlock()
+	var wait time.Duration
+	for _, key := range authFailureKeys(r, username) {
+		if f, ok := authFails[key]; ok {
+			wait = max(wait, time.Until(f.lockedUntil))
+		}
+	}
+	return wait
+}
+
+// recordAuthFailure counts a failed sign-in from r as username, locking
+// out the IP or the username when it reaches the limit.
+func recordAuthFailure(r *http.Request, username string) {
+	if authMaxFailures <= 0 {
+		return
+	}
+	addMetric("goserve_auth_failures_total", 1)
+	now := time.Now()
+	authFailsMu.Lock()
+	defer authFailsMu.Unlock()
+	if now.Sub(authLastSweep) > time.Minute {
+		for key, f := range authFails {
+			if now.Sub(f.first) > authLockout && now.After(f.lockedUntil) {
+				delete(authFails, key)
+			}
+		}
+		authLastSweep = now
+	}
+	for _, key := range authFailureKeys(r, username) {
+		f, ok := authFails[key]
+		if !ok || (now.Sub(f.first) > authLockout && now.After(f.lockedUntil)) {
+			f = &authFailures{first: now}
+			authFails[key] = f
+		}
+		f.count++
+		if f.count >= authMaxFailures && now.After(f.lockedUntil) {
+			f.lockedUntil = now.Add(authLockout)
+			kind, name, _ := strings.Cut(key, ":")
+			log.Printf("Locked out %s %s for %v after %d failed sign-ins", kind, name, authLockout, f.count)
+			addMetric("goserve_auth_lockouts_total", 1, "kind", kind)
+		}
+	}
+}
+
+// clearAuthFailures forgets the failures of r's IP and username after a
+// successful sign-in.
+func clearAuthFailures(r *http.Request, username string) {
+	authFailsMu.Lock()
+	defer authFailsMu.Unlock()
+	for _, key := range authFailureKeys(r, username) {
+		delete(authFails, key)
+	}
+}
+
+func authLockoutError(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed sign-ins; try again later", http.StatusTooManyRequests)
+}
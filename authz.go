@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Authorization explanations. Permissions come in layers (the listener's
+// level, the user's permission and path rules, the monthly cap, the
+// runtime permission ceiling, read-only and write-once folders), and a
+// bare "Forbidden" doesn't say which one refused. When a handler refuses
+// an action it calls deny, which finds the layer that decided and answers
+// with a reason code, the rule that applied and a sentence for people:
+//
+//	{"success": false, "error": "Forbidden: /docs is read-only (set in its .goserve.json)",
+//	 "action": "delete", "reason": "read_only_folder", "rule": "/docs/.goserve.json readOnly"}
+//
+// Each denial is also passed to the authzLoggers: the last few hundred are
+// kept for admins at /api/v1/denials, and -authz-log writes them to the
+// console or a file of JSON lines. /api/v1/capabilities?path= explains
+// every action it reports as not allowed, so the UI can say why up front.
+
+// Reason codes, from the outermost layer in.
+const (
+	denyFeatureOff = "feature_off"        // the server doesn't offer it
+	denySignIn     = "sign_in_required"   // needs a signed-in account
+	denyLevel      = "permission_level"   // -permlevel or the listener's level
+	denyUser       = "user_permission"    // the user's permission in the logins file
+	denyPathRule   = "path_rule"          // one of the user's path rules
+	denyCap        = "monthly_cap"        // -monthly-cap used up
+	denyCeiling    = "permission_ceiling" // the permCeiling runtime setting
+	denyHome       = "home_folder"        // not for users confined to a home
+	denyReadOnly   = "read_only_folder"   // "readOnly" in .goserve.json
+	denyWriteOnce  = "write_once"         // "writeOnce" in .goserve.json
+	denySettings   = "settings_file"      // .goserve.json or .goserveignore, for admins only
+	denyOther      = "not_allowed"
+)
+
+// denial says why an action was refused.
+type denial struct {
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// authzDecision is a denial as logged, with who asked for what.
+type authzDecision struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	denial
+}
+
+// authzLogger receives every denial. Add one to authzLoggers to send them
+// somewhere new.
+type authzLogger interface {
+	logDenial(d authzDecision)
+}
+
+var (
+	authzLoggers []authzLogger
+	authzRing    = &denialRing{}
+)
+
+// actionNames are the Capabilities fields deny and denialsFor know, with
+// what they are called in messages.
+var actionNames = map[string]string{
+	"upload": "Uploading",
+	"mkdir":  "Creating folders",
+	"edit":   "Editing",
+	"rename": "Renaming and moving",
+	"delete": "Deleting",
+	"touch":  "Setting modification times",
+	"chmod":  "Changing permissions",
+	"copy":   "Copying",
+	"share":  "Creating upload links",
+	"paste":  "Pasting",
+	"chdir":  "Changing the served directory",
+	"admin":  "Server administration",
+}
+
+// allows reports whether c allows action.
+func (c Capabilities) allows(action string) bool {
+	switch action {
+	case "upload":
+		return c.Upload
+	case "mkdir":
+		return c.Mkdir
+	case "edit":
+		return c.Edit
+	case "rename":
+		return c.Rename
+	case "delete":
+		return c.Delete
+	case "touch":
+		return c.Touch
+	case "chmod":
+		return c.Chmod
+	case "copy":
+		return c.Copy
+	case "share":
+		return c.Share
+	case "paste":
+		return c.Paste
+	case "chdir":
+		return c.Chdir
+	case "admin":
+		return c.Admin
+	}
+	return false
+}
+
+// denialsFor explains each action c (the capabilities of r at fullPath)
+// doesn't allow. It retraces capabilitiesFor layer by layer and blames the
+// first layer that takes the action away.
+func denialsFor(r *http.Request, fullPath string, c Capabilities) map[string]denial {
+	user := getUserFromRequest(r)
+	level := permLevelFor(r)
+	levelSource := "-permlevel " + level
+	if cfg := listenerFor(r); cfg != nil {
+		levelSource = "listener " + cfg.Addr + " " + level
+	}
+	ceiling := permCeiling()
+	overCap := overMonthlyCap(r)
+	_, confined := homeOf(r)
+
+	userPerm, userRule, userReason := "", "", denyUser
+	if user != nil {
+		userPerm, userRule = user.Permission, "user "+user.Username+" "+user.Permission
+		if fullPath != "" {
+			if rule, ok := user.pathRuleAt(urlForRequest(r, fullPath)); ok {
+				userPerm, userRule, userReason = rule.Permission, "user "+user.Username+" "+rule.Path+"="+rule.Permission, denyPathRule
+			}
+		}
+	}
+	var readOnlyDir, writeOnceDir, writeOnceSetting string
+	if fullPath != "" {
+		walkDirSettings(fullPath, func(dir string, ds dirSettings) bool {
+			if ds.ReadOnly && readOnlyDir == "" {
+				readOnlyDir = dir
+			}
+			if ds.WriteOnce != "" && writeOnceDir == "" {
+				writeOnceDir, writeOnceSetting = dir, ds.WriteOnce
+			}
+			return readOnlyDir == "" || writeOnceDir == ""
+		})
+	}
+
+	explain := func(action string) denial {
+		d := denial{Action: action}
+		set := func(reason, rule, msg string, args ...any) denial {
+			d.Reason, d.Rule, d.Message = reason, rule, fmt.Sprintf(msg, args...)
+			return d
+		}
+		label := actionNames[action]
+		need := "readwrite"
+		switch action {
+		case "mkdir", "edit", "rename", "delete", "touch", "chmod", "admin":
+			need = "all"
+		}
+
+		switch action {
+		case "paste":
+			if pasteDir == "" {
+				return set(denyFeatureOff, "-paste", "The pastebin is off (-paste is not set)")
+			}
+		case "chmod":
+			if !showPerms {
+				return set(denyFeatureOff, "-show-perms", "Changing permissions is off (-show-perms is not set)")
+			}
+		case "chdir":
+			if !allowChdir {
+				return set(denyFeatureOff, "-allow-chdir", "Changing the served directory is off (-allow-chdir is not set)")
+			}
+			if user == nil {
+				return set(denySignIn, "", "%s needs a signed-in account", label)
+			}
+			if user.Permission != "all" {
+				return set(denyUser, "user "+user.Username+" "+user.Permission,
+					"%s needs an account with all permission; %s has %s", label, user.Username, user.Permission)
+			}
+		}
+		if action == "chdir" || action == "admin" {
+			if confined {
+				return set(denyHome, "user "+user.Username+" home", "%s is not available to accounts kept to a home folder", label)
+			}
+		}
+
+		if !permissionAllows(level, need) {
+			return set(denyLevel, levelSource, "%s needs %s permission; %s allows %s", label, need, levelLabel(r), level)
+		}
+		if user != nil && action != "admin" && !permissionAllows(userPerm, need) {
+			return set(userReason, userRule, "%s needs %s permission; your account has %s here", label, need, userPerm)
+		}
+		if user != nil && action == "admin" && !permissionAllows(user.Permission, need) {
+			return set(denyUser, "user "+user.Username+" "+user.Permission, "%s needs all permission; your account has %s", label, user.Permission)
+		}
+		if overCap {
+			return set(denyCap, "-monthly-cap", "You have used this month's transfer allowance; the server is read-only for you until next month")
+		}
+		if !permissionAllows(ceiling, need) {
+			return set(denyCeiling, "permCeiling "+ceiling, "An administrator has limited the server to %s for now", ceiling)
+		}
+		if readOnlyDir != "" && action != "copy" && action != "admin" && action != "chdir" && action != "paste" {
+			return set(denyReadOnly, path.Join(urlFor(readOnlyDir), dirSettingsFile)+" readOnly",
+				"%s is read-only (set in its %s)", urlForRequest(r, readOnlyDir), dirSettingsFile)
+		}
+		if writeOnceDir != "" && writeOnceLocked(fullPath) {
+			rule := path.Join(urlFor(writeOnceDir), dirSettingsFile) + " writeOnce=" + writeOnceSetting
+			retention, _ := writeOnceRetention(fullPath)
+			if retention < 0 {
+				return set(denyWriteOnce, rule, "%s is write-once: files there can't be changed or removed", urlForRequest(r, writeOnceDir))
+			}
+			return set(denyWriteOnce, rule, "%s is write-once: files there can't be changed or removed for %s after they're added",
+				urlForRequest(r, writeOnceDir), writeOnceSetting)
+		}
+		if isSettingsFile(filepath.Base(fullPath)) && !c.Admin {
+			return set(denySettings, "", "%s sets the rules for its folder; only administrators can change it", filepath.Base(fullPath))
+		}
+		return set(denyOther, "", "%s is not allowed here", label)
+	}
+
+	out := map[string]denial{}
+	for action := range actionNames {
+		if !c.allows(action) {
+			out[action] = explain(action)
+		}
+	}
+	return out
+}
+
+// explainDenial says why r may not take action at fullPath.
+func explainDenial(r *http.Request, fullPath, action string) denial {
+	c := capabilitiesFor(r, fullPath)
+	if d, ok := denialsFor(r, fullPath, c)[action]; ok {
+		return d
+	}
+	return denial{Action: action, Reason: denyOther, Message: actionNames[action] + " is not allowed here"}
+}
+
+// deny refuses action at fullPath with 403 Forbidden and the reason, and
+// logs the decision.
+func deny(w http.ResponseWriter, r *http.Request, fullPath, action string) {
+	writeDenial(w, r, explainDenial(r, fullPath, action))
+}
+
+// writeDenial answers with d as deny does.
+func writeDenial(w http.ResponseWriter, r *http.Request, d denial) {
+	logDenial(r, d)
+	if fromBasicPage(r) {
+		writeBasicMessage(w, r, http.StatusForbidden, "Permission denied", d.Message)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": false,
+		"error":   "Forbidden: " + d.Message,
+		"action":  d.Action,
+		"reason":  d.Reason,
+		"rule":    d.Rule,
+	})
+}
+
+// writeOnceBelowDenial says why r may not take action ("delete" or
+// "rename") on the folder fullPath as a whole when a write-once folder is
+// inside it; ok is false when there is none.
+func writeOnceBelowDenial(r *http.Request, fullPath, action string) (d denial, ok bool) {
+	dir, setting := writeOnceBelow(fullPath)
+	if dir == "" {
+		return denial{}, false
+	}
+	return denial{
+		Action:  action,
+		Reason:  denyWriteOnce,
+		Rule:    path.Join(urlFor(dir), dirSettingsFile) + " writeOnce=" + setting,
+		Message: fmt.Sprintf("%s holds %s, which is write-once", urlForRequest(r, fullPath), urlForRequest(r, dir)),
+	}, true
+}
+
+// denyError returns the denial as an error, for handlers that report
+// failures per item.
+func denyError(r *http.Request, fullPath, action string) error {
+	d := explainDenial(r, fullPath, action)
+	logDenial(r, d)
+	return fmt.Errorf("%s [%s]", d.Message, d.Reason)
+}
+
+func logDenial(r *http.Request, d denial) {
+	dec := authzDecision{
+		Time:   time.Now().UTC(),
+		IP:     authClientIP(r),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		denial: d,
+	}
+	if user := getUserFromRequest(r); user != nil {
+		dec.User = user.Username
+	}
+	authzRing.logDenial(dec)
+	for _, l := range authzLoggers {
+		l.logDenial(dec)
+	}
+}
+
+// permissionAllows reports whether permission (readonly, readwrite, all)
+// includes need.
+func permissionAllows(permission, need string) bool {
+	rank := map[string]int{"readonly": 0, "readwrite": 1, "all": 2}
+	return rank[permission] >= rank[need]
+}
+
+// levelLabel names where r's permission level comes from.
+func levelLabel(r *http.Request) string {
+	if cfg := listenerFor(r); cfg != nil {
+		return "the listener on " + cfg.Addr
+	}
+	return "this server"
+}
+
+// denialRing keeps the most recent denials for /api/v1/denials.
+type denialRing struct {
+	mu      sync.Mutex
+	entries []authzDecision
+}
+
+const denialRingSize = 500
+
+func (d *denialRing) logDenial(dec authzDecision) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) >= denialRingSize {
+		d.entries = append(d.entries[:0], d.entries[denialRingSize/2:]...)
+	}
+	d.entries = append(d.entries, dec)
+}
+
+// consoleAuthzLog writes denials to the server log.
+type consoleAuthzLog struct{}
+
+func (consoleAuthzLog) logDenial(d authzDecision) {
+	who := d.User
+	if who == "" {
+		who = d.IP
+	}
+	log.Printf("Denied %s %s %s to %s: %s [%s; %s]", d.Action, d.Method, d.Path, who, d.Message, d.Reason, d.Rule)
+}
+
+// fileAuthzLog appends denials to a file as JSON lines.
+type fileAuthzLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (f *fileAuthzLog) logDenial(d authzDecision) {
+	line, _ := json.Marshal(d)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file.Write(append(line, '\n'))
+}
+
+// setupAuthzLog adds the -authz-log destination: "-" for the console or a
+// file for JSON lines.
+func setupAuthzLog(dest string) error {
+	switch dest {
+	case "":
+	case "-":
+		authzLoggers = append(authzLoggers, consoleAuthzLog{})
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		authzLoggers = append(authzLoggers, &fileAuthzLog{file: f})
+	}
+	return nil
+}
+
+// handleDenials serves GET /api/v1/denials[?user=bob&limit=100]: recent
+// denials, newest first, for admins.
+func handleDenials(w http.ResponseWriter, r *http.Request) {
+	if _, admin := permissionsFor(r); !admin {
+		deny(w, r, "", "admin")
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			jsonError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, denialRingSize)
+	}
+	user := r.URL.Query().Get("user")
+	authzRing.mu.Lock()
+	list := []authzDecision{}
+	for i := len(authzRing.entries) - 1; i >= 0 && len(list) < limit; i-- {
+		if e := authzRing.entries[i]; user == "" || e.User == user {
+			list = append(list, e)
+		}
+	}
+	authzRing.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{"success": true, "denials": list})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Basic pages, for browsers without JavaScript. ?basic=1 gets a folder as
+// plain server-rendered HTML: a table of links with headers a screen reader
+// can announce, a form to upload files, and Delete links that lead to a
+// confirmation page instead of a script dialog. Nothing on it needs scripts
+// or styles to work, so it also suits text browsers and locked-down kiosks.
+// Text browsers (Lynx, w3m, Links, ELinks) get it without asking, the full
+// listing sends other browsers with scripts turned off there from a
+// <noscript> block, and ?basic=0 goes back to the full listing.
+//
+// Forms on basic pages post with basic=1, and the upload and delete
+// handlers answer those with a page or a redirect rather than JSON.
+
+// textBrowsers are User-Agent prefixes of browsers that don't run scripts.
+var textBrowsers = []string{"Lynx/", "w3m/", "Links (", "ELinks"}
+
+// basicListing reports whether r should get the basic listing.
+func basicListing(r *http.Request) bool {
+	switch r.URL.Query().Get("basic") {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	for _, b := range textBrowsers {
+		if strings.HasPrefix(r.UserAgent(), b) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromBasicPage reports whether r was sent by a form on a basic page.
+func fromBasicPage(r *http.Request) bool {
+	return r.URL.Query().Get("basic") == "1"
+}
+
+// basicNotices are the results a redirect back to a basic listing can
+// announce, by their done= value.
+var basicNotices = map[string]string{
+	"upload": "Upload complete.",
+	"delete": "Deleted.",
+}
+
+const basicTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{if .Confirm}}Delete {{.Confirm.Name}}{{else if .Message}}{{.Message}}{{else}}{{with .Brand.Title}}{{.}}{{else}}GoServe{{end}} - {{.Path}}{{end}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; max-width: 960px; margin: 0 auto; padding: 16px; line-height: 1.5; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #ccd0da; }
+        a:focus, button:focus, input:focus { outline: 3px solid #1e66f5; outline-offset: 2px; }
+        .error { color: #d20f39; }
+    </style>
+</head>
+<body>
+<header>
+    <p><a href="#main">Skip to content</a> | <a href="{{.Path}}?basic=0">Full version</a>{{if .SignedInAs}} | Signed in as {{.SignedInAs}}{{end}}</p>
+    <nav aria-label="Breadcrumb">
+        <a href="/?basic=1">Home</a>{{range .Breadcrumbs}} / <a href="{{.Path}}/?basic=1">{{.Name}}</a>{{end}}
+    </nav>
+</header>
+<main id="main">
+{{if .Message}}
+    <h1>{{.Message}}</h1>
+    <p class="error" role="alert">{{.Detail}}</p>
+    <p><a href="{{.Path}}?basic=1">Back to {{.Path}}</a></p>
+{{else if .Confirm}}
+    <h1>Delete {{.Confirm.Name}}?</h1>
+    <p>{{if .Confirm.IsDir}}The folder and everything in it will be deleted.{{else}}The file will be deleted.{{end}} This can't be undone.</p>
+    <form method="POST" action="{{.Path}}?basic=1&amp;delete={{.Confirm.Path}}">
+        <button type="submit">Delete</button>
+        <a href="{{.Path}}?basic=1">Cancel</a>
+    </form>
+{{else}}
+    <h1>{{.Path}}</h1>
+    {{with .Notice}}<p role="status">{{.}}</p>{{end}}
+    <table>
+        <caption>Contents of {{.Path}}, {{len .Files}} entries</caption>
+        <thead>
+            <tr><th scope="col">Name</th><th scope="col">Size</th><th scope="col">Modified</th>{{if .Caps.Delete}}<th scope="col">Actions</th>{{end}}</tr>
+        </thead>
+        <tbody>
+            {{with .Parent}}<tr><td><a href="{{.}}?basic=1">Parent folder</a></td><td></td><td></td>{{if $.Caps.Delete}}<td></td>{{end}}</tr>{{end}}
+            {{range .Files}}
+            {{if .Error}}
+            <tr><td>{{.Name}} <span class="error">({{.Error}})</span></td><td></td><td></td>{{if $.Caps.Delete}}<td></td>{{end}}</tr>
+            {{else}}
+            <tr>
+                <td>{{if .IsDir}}<a href="{{.Path}}?basic=1">{{.Name}}/</a> (folder){{else}}<a href="{{.Path}}">{{.Name}}</a>{{end}}</td>
+                <td>{{if not .IsDir}}{{.Size}}{{end}}</td>
+                <td>{{.ModTime}}</td>
+                {{if $.Caps.Delete}}<td><a href="{{$.Path}}?basic=1&amp;delete={{.Path}}" aria-label="Delete {{.Name}}">Delete</a></td>{{end}}
+            </tr>
+            {{end}}
+            {{else}}
+            <tr><td colspan="{{if .Caps.Delete}}4{{else}}3{{end}}">This folder is empty.</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+    <p><a href="{{.Path}}?zip=1">Download this folder as ZIP</a></p>
+    {{if .Caps.Upload}}
+    <form method="POST" action="{{.Path}}?basic=1&amp;upload=1" enctype="multipart/form-data">
+        <h2><label for="files">Upload files here</label></h2>
+        <input type="file" id="files" name="files" multiple required>
+        <button type="submit">Upload</button>
+        {{with .Space}}<p{{if .Low}} class="error"{{end}}>{{if .Low}}Low on disk space: {{end}}{{.Summary}}.</p>{{end}}
+    </form>
+    {{end}}
+{{end}}
+</main>
+{{if .SignedInAs}}
+<footer>
+    <form method="POST" action="/_logout"><button type="submit">Sign out</button></form>
+</footer>
+{{end}}
+</body>
+</html>`
+
+var basicTmpl = template.Must(template.New("basic").Parse(basicTemplate))
+
+// basicPage is what the basic template shows: the listing, a delete to
+// confirm, or the outcome of a failed action.
+type basicPage struct {
+	PageData
+	Parent  string    // the folder above, if any
+	Notice  string    // what the last action did
+	Confirm *FileInfo // entry to confirm deleting
+	Message string    // heading of a failure page
+	Detail  string
+}
+
+// writeBasicListing renders data as a basic listing, or the confirmation
+// page when ?delete= names an entry to delete.
+func writeBasicListing(w http.ResponseWriter, r *http.Request, data PageData, baseDir string) {
+	page := basicPage{PageData: data, Notice: basicNotices[r.URL.Query().Get("done")]}
+	if len(data.Breadcrumbs) > 1 {
+		page.Parent = data.Breadcrumbs[len(data.Breadcrumbs)-2].Path + "/"
+	} else if len(data.Breadcrumbs) == 1 {
+		page.Parent = "/"
+	}
+	if target := r.URL.Query().Get("delete"); target != "" {
+		fullPath := filepath.Join(baseDir, filepath.Clean("/"+target))
+		if !capabilitiesFor(r, fullPath).Delete {
+			deny(w, r, fullPath, "delete")
+			return
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil || fullPath == baseDir {
+			writeBasicMessage(w, r, http.StatusNotFound, "Not found", target+" doesn't exist.")
+			return
+		}
+		urlPath := path.Clean("/" + target)
+		if info.IsDir() {
+			urlPath += "/"
+		}
+		page.Confirm = &FileInfo{Name: info.Name(), Path: urlPath, IsDir: info.IsDir()}
+	}
+	var buf bytes.Buffer
+	if err := basicTmpl.Execute(&buf, page); err != nil {
+		http.Error(w, "Cannot render listing", http.StatusInternalServerError)
+		return
+	}
+	writeListingPage(w, r, buf.Bytes())
+}
+
+// writeBasicMessage answers a basic page's form with a failure page that
+// leads back to the folder.
+func writeBasicMessage(w http.ResponseWriter, r *http.Request, status int, message, detail string) {
+	dir := r.URL.Path
+	page := basicPage{
+		PageData: PageData{Path: dir, Breadcrumbs: buildBreadcrumbs(dir), SignedInAs: sessionUser(r)},
+		Message:  message,
+		Detail:   detail,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	basicTmpl.Execute(w, page)
+}
+
+// basicDone sends a basic page's form back to the folder's listing, which
+// announces what happened.
+func basicDone(w http.ResponseWriter, r *http.Request, done string) {
+	http.Redirect(w, r, r.URL.Path+"?basic=1&done="+done, http.StatusSeeOther)
+}
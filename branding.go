@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Folder branding. A folder's .goserve.json can give it a title, a logo
+// and an accent colour:
+//
+//	{"title": "Acme Design", "logo": "logo.png", "accent": "#e4572e"}
+//
+// They apply to the folder, everything below it that doesn't set its own,
+// and the upload-link pages for those folders, so a share handed to a
+// client carries the client's branding while the rest of the server looks
+// as usual. "logo" is an image file relative to the folder (inlined into
+// the page, so visitors of an upload link, who can't read the folder, still
+// see it) or an http(s) URL.
+
+const maxLogoSize = 256 << 10
+
+var accentColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+type branding struct {
+	Title  string
+	Logo   template.URL
+	Accent string
+}
+
+// brandingFor returns the branding for the folder dir, taking each field
+// from the nearest folder up to the base directory that sets it.
+func brandingFor(dir string) branding {
+	var b branding
+	walkDirSettings(dir, func(dir string, ds dirSettings) bool {
+		if b.Title == "" {
+			b.Title = strings.TrimSpace(ds.Title)
+		}
+		if b.Logo == "" && ds.Logo != "" {
+			b.Logo = logoURL(dir, ds.Logo)
+		}
+		if b.Accent == "" && accentColor.MatchString(ds.Accent) {
+			b.Accent = ds.Accent
+		}
+		return true
+	})
+	return b
+}
+
+// logoURL turns a "logo" setting of the folder dir into something an <img>
+// can show: the URL itself, or the file as a data: URL.
+func logoURL(dir, logo string) template.URL {
+	if strings.HasPrefix(logo, "https://") || strings.HasPrefix(logo, "http://") {
+		return template.URL(logo)
+	}
+	file := filepath.Join(dir, filepath.FromSlash(logo))
+	typ := mime.TypeByExtension(filepath.Ext(file))
+	if !isUnderDir(file, getBaseDir()) || !strings.HasPrefix(typ, "image/") {
+		return ""
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxLogoSize {
+		return ""
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Read-through disk cache with LRU eviction. Anything that is expensive to
+// fetch or produce (objects from a remote origin, rendered previews) is
+// stored under -cache by key and evicted least-recently-used first once the
+// total size exceeds -cache-size.
+
+type diskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry // by file name
+	size    int64
+	filling map[string]*sync.Mutex
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// blobCache is nil when -cache is not set; callers then fetch directly.
+var blobCache *diskCache
+
+// newDiskCache opens (creating if needed) a cache in dir and indexes what
+// is already there, so a restart keeps its warm cache.
+func newDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*cacheEntry{},
+		filling:  map[string]*sync.Mutex{},
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if filepath.Ext(f.Name()) == ".part" {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		c.entries[f.Name()] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func cacheFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Open returns the cached object for key, calling fill to produce it on a
+// miss. Concurrent misses for the same key run fill only once.
+func (c *diskCache) Open(key string, fill func(io.Writer) error) (*os.File, error) {
+	name := cacheFileName(key)
+	file := filepath.Join(c.dir, name)
+
+	c.mu.Lock()
+	mu, ok := c.filling[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		c.filling[name] = mu
+	}
+	c.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f, err := c.hit(name, file); err == nil {
+		addMetric("goserve_cache_hits_total", 1)
+		return f, nil
+	}
+	addMetric("goserve_cache_misses_total", 1)
+
+	tmp, err := os.CreateTemp(c.dir, "*.part")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return nil, err
+	}
+	// Open before indexing so eviction can't pull it out from under us
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if old, ok := c.entries[name]; ok {
+		c.size -= old.size
+	}
+	c.entries[name] = &cacheEntry{size: info.Size(), used: time.Now()}
+	c.size += info.Size()
+	c.evict()
+	c.mu.Unlock()
+	return f, nil
+}
+
+// hit opens a cached file and marks it recently used.
+func (c *diskCache) hit(name, file string) (*os.File, error) {
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		e.used = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		c.Remove(name)
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(file, now, now)
+	return f, nil
+}
+
+// Invalidate drops key, e.g. after the origin object changed.
+func (c *diskCache) Invalidate(key string) {
+	c.Remove(cacheFileName(key))
+}
+
+func (c *diskCache) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[name]; ok {
+		c.size -= e.size
+		delete(c.entries, name)
+	}
+	os.Remove(filepath.Join(c.dir, name))
+}
+
+// evict removes least-recently-used entries until the cache fits. Callers
+// hold c.mu. Files still open by readers stay readable until closed.
+func (c *diskCache) evict() {
+	if c.maxBytes <= 0 || c.size <= c.maxBytes {
+		return
+	}
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool { return c.entries[names[a]].used.Before(c.entries[names[b]].used) })
+	for _, name := range names {
+		if c.size <= c.maxBytes {
+			break
+		}
+		c.size -= c.entries[name].size
+		delete(c.entries, name)
+		os.Remove(filepath.Join(c.dir, name))
+		addMetric("goserve_cache_evictions_total", 1)
+	}
+}
+
+// Usage reports the number of cached objects and their total size.
+func (c *diskCache) Usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Capabilities says which actions a requester may take on a path. The
+// page template, the listing's JavaScript and /api/v1/capabilities all
+// gate on it, and the handlers enforce the same fields, so the UI never
+// offers what the server would refuse. It starts from the upload and
+// modify permissions of the listener and user (the user's path rules
+// decide which permission applies to the path), then narrows for the
+// path: folders marked "readOnly" in .goserve.json (and everything below
+// them) allow no writes for anyone, and "writeOnce" folders allow adding
+// files but not changing or removing them. Settings files (.goserve.json
+// and .goserveignore) can only be written by administrators.
+type Capabilities struct {
+	Upload bool `json:"upload"` // upload files, create checksum manifests
+	Mkdir  bool `json:"mkdir"`
+	Edit   bool `json:"edit"` // edit files, replace in files
+	Rename bool `json:"rename"`
+	Delete bool `json:"delete"`
+	Touch  bool `json:"touch"` // set modification times
+	Chmod  bool `json:"chmod"` // change permissions, with -show-perms
+	Copy   bool `json:"copy"`  // copy out of here; the destination needs upload
+	Share  bool `json:"share"` // create upload links
+	Paste  bool `json:"paste"`
+	Chdir  bool `json:"chdir"` // change the served directory
+	Admin  bool `json:"admin"` // access log, search activity, everyone's links
+}
+
+// capabilitiesFor returns what r may do at fullPath, a file or folder
+// under the base directory. An empty fullPath gives the requester's
+// capabilities without any per-path rules.
+func capabilitiesFor(r *http.Request, fullPath string) Capabilities {
+	canUpload, canModify := permissionsAt(r, fullPath)
+	_, admin := permissionsFor(r)
+	c := Capabilities{
+		Upload: canUpload,
+		Mkdir:  canModify,
+		Edit:   canModify,
+		Rename: canModify,
+		Delete: canModify,
+		Touch:  canModify,
+		Chmod:  canModify && showPerms,
+		Copy:   canUpload,
+		Share:  canUpload,
+		Paste:  canUpload && pasteDir != "",
+		Admin:  admin,
+	}
+	// Repointing the whole server needs -allow-chdir and a signed-in user
+	// whose own permission is all, not just an open listener
+	if user := getUserFromRequest(r); allowChdir && admin && user != nil && user.Permission == "all" {
+		c.Chdir = true
+	}
+	if _, ok := homeOf(r); ok {
+		// Server-wide settings and views are not for confined users
+		c.Chdir, c.Admin = false, false
+	}
+	if fullPath != "" && readOnlyFolder(fullPath) {
+		c.Upload, c.Mkdir, c.Edit, c.Rename, c.Delete, c.Touch, c.Chmod, c.Share = false, false, false, false, false, false, false, false
+	}
+	if fullPath != "" && writeOnceLocked(fullPath) {
+		c.Edit, c.Rename, c.Delete, c.Touch, c.Chmod = false, false, false, false, false
+		if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
+			c.Upload = false // no overwriting
+		}
+	}
+	if fullPath != "" && isSettingsFile(filepath.Base(fullPath)) && !c.Admin {
+		// They set the folder's rules, including the ones above
+		c.Upload, c.Edit, c.Rename, c.Delete, c.Touch, c.Chmod = false, false, false, false, false, false
+	}
+	return c
+}
+
+// davPermissionMiddleware refuses WebDAV methods the requester's
+// capabilities don't allow, at the path and, for COPY and MOVE, at the
+// destination, so DAV clients get the same permissions as the web UI, and
+// refuses paths through links -follow-symlinks won't follow or that an
+// ignore file leaves out. prefix is where the DAV handler is mounted.
+func davPermissionMiddleware(prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fullPath, ok := resolvePathFor(r, strings.TrimPrefix(r.URL.Path, prefix))
+		if !ok && ignoredPath(fullPath) {
+			http.NotFound(w, r)
+			return
+		}
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		caps := capabilitiesFor(r, fullPath)
+		action := ""
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "PROPFIND":
+		case "PUT", "MKCOL", "LOCK", "UNLOCK":
+			action = "upload"
+		case "COPY":
+			action = "copy"
+		case "MOVE":
+			action = "rename"
+		case "DELETE":
+			action = "delete"
+		case "PROPPATCH":
+			action = "touch" // only modification times can be set
+		default:
+			action = "edit"
+		}
+		if action != "" && !caps.allows(action) {
+			deny(w, r, fullPath, action)
+			return
+		}
+		if r.Method == "DELETE" || r.Method == "MOVE" {
+			if d, ok := writeOnceBelowDenial(r, fullPath, action); ok {
+				writeDenial(w, r, d)
+				return
+			}
+		}
+		if r.Method == "COPY" || r.Method == "MOVE" {
+			u, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil || !strings.HasPrefix(u.Path, prefix+"/") {
+				http.Error(w, "Bad destination", http.StatusBadRequest)
+				return
+			}
+			dest, ok := resolvePathFor(r, strings.TrimPrefix(u.Path, prefix))
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !capabilitiesFor(r, dest).Upload {
+				deny(w, r, dest, "upload")
+				return
+			}
+			if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
+				if err := uploadPolicyFor(dest).check(path.Base(u.Path), 0, 0); err != nil {
+					http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+					return
+				}
+			}
+		}
+		next(w, r)
+	}
+}
+
+// pathRule gives a user another permission at and below Path, a URL path
+// as the user sees it (from their home, if they have one).
+type pathRule struct {
+	Path       string
+	Permission string
+}
+
+var validPermissions = map[string]bool{"readonly": true, "readwrite": true, "all": true}
+
+// parsePermission parses the permission field of a logins line: the
+// default permission, then optional comma-separated path rules such as
+// "readonly,/incoming=readwrite,/shared/drafts=all".
+func parsePermission(field string) (string, []pathRule, error) {
+	parts := strings.Split(field, ",")
+	permission := strings.TrimSpace(parts[0])
+	var rules []pathRule
+	for _, part := range parts[1:] {
+		p, perm, ok := strings.Cut(strings.TrimSpace(part), "=")
+		perm = strings.TrimSpace(perm)
+		if !ok || !strings.HasPrefix(p, "/") {
+			return "", nil, fmt.Errorf("bad path rule %q (want /path=permission)", part)
+		}
+		if !validPermissions[perm] {
+			return "", nil, fmt.Errorf("unknown permission %q for %s", perm, p)
+		}
+		rules = append(rules, pathRule{Path: path.Clean(strings.TrimSpace(p)), Permission: perm})
+	}
+	return permission, rules, nil
+}
+
+// permissionAt returns u's permission at urlPath: that of the rule for the
+// deepest folder containing it, or u's default.
+func (u *User) permissionAt(urlPath string) string {
+	if rule, ok := u.pathRuleAt(urlPath); ok {
+		return rule.Permission
+	}
+	return u.Permission
+}
+
+// pathRuleAt returns u's rule for the deepest folder containing urlPath,
+// if there is one.
+func (u *User) pathRuleAt(urlPath string) (pathRule, bool) {
+	var found pathRule
+	depth := -1
+	for _, rule := range u.Rules {
+		if (urlPath == rule.Path || rule.Path == "/" || strings.HasPrefix(urlPath, rule.Path+"/")) && len(rule.Path) > depth {
+			found, depth = rule, len(rule.Path)
+		}
+	}
+	return found, depth >= 0
+}
+
+// readOnlyFolder reports whether fullPath is in a folder marked read-only,
+// itself or through any parent up to the base directory. Files and paths
+// that don't exist yet are judged by their folder.
+func readOnlyFolder(fullPath string) bool {
+	readOnly := false
+	walkDirSettings(fullPath, func(dir string, ds dirSettings) bool {
+		readOnly = ds.ReadOnly
+		return !readOnly
+	})
+	return readOnly
+}
+
+// writeOnceRetention returns how long files are kept unchangeable in the
+// write-once folder fullPath is in, if it is in one; -1 means forever.
+// A "writeOnce" value that doesn't parse also means forever, so a typo
+// can't unlock an archive.
+func writeOnceRetention(fullPath string) (time.Duration, bool) {
+	var setting string
+	walkDirSettings(fullPath, func(dir string, ds dirSettings) bool {
+		setting = ds.WriteOnce
+		return setting == ""
+	})
+	if setting == "" {
+		return 0, false
+	}
+	if d, err := parseDuration(setting); err == nil && d > 0 {
+		return d, true
+	}
+	return -1, true
+}
+
+// writeOnceLocked reports whether fullPath is protected by a write-once
+// policy: a folder inside a write-once folder (removing or renaming it
+// would take its files along), or a file there younger than the retention
+// period, counted from its modification time. New files may still be
+// created next to them.
+func writeOnceLocked(fullPath string) bool {
+	retention, ok := writeOnceRetention(fullPath)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return false
+	}
+	return info.IsDir() || retention < 0 || time.Since(info.ModTime()) < retention
+}
+
+// writeOnceBelow returns the first write-once folder inside the folder
+// fullPath and its setting, or "" if there is none. writeOnceLocked only
+// looks up the tree, so removing or moving a folder whole has to look down
+// it too.
+func writeOnceBelow(fullPath string) (string, string) {
+	var dir, setting string
+	filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if ds := loadDirSettings(p); ds.WriteOnce != "" {
+			dir, setting = p, ds.WriteOnce
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return dir, setting
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Change feed. Every file event (see events.go) is numbered and kept in a
+// ring of the last changeLogSize, so another program — a read replica, a
+// backup script — can follow what changes without walking the tree:
+//
+//	GET /api/v1/changes                   the current position, no changes
+//	GET /api/v1/changes?since=N&wait=30   changes after N, waiting up to
+//	                                      30 seconds for the first one
+//
+// Numbers start again when the server restarts, so each answer carries
+// the feed's epoch; a follower that sees a new epoch, or gets "resync":
+// true because it fell further behind than the ring reaches, has to
+// compare the whole tree again. Only changes made through goserve are in
+// the feed, not files changed directly on disk. Each requester sees the
+// changes under the folder they can browse, with paths as they see them.
+
+const (
+	changeLogSize   = 10000
+	changeWaitLimit = 60 * time.Second
+)
+
+type change struct {
+	Seq int64 `json:"seq"`
+	fileEvent
+	fullPath    string // the event's path on disk, to map per requester
+	oldFullPath string
+}
+
+var changeLog struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     int64
+	entries []change
+	notify  chan struct{} // closed when a change arrives
+}
+
+// startChangeLog starts numbering file events.
+func startChangeLog() {
+	b := make([]byte, 8)
+	rand.Read(b)
+	changeLog.epoch = hex.EncodeToString(b)
+	changeLog.notify = make(chan struct{})
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			base := getBaseDir()
+			c := change{fileEvent: ev, fullPath: filepath.Join(base, filepath.FromSlash(ev.Path))}
+			if ev.OldPath != "" {
+				c.oldFullPath = filepath.Join(base, filepath.FromSlash(ev.OldPath))
+			}
+			changeLog.mu.Lock()
+			changeLog.seq++
+			c.Seq = changeLog.seq
+			if len(changeLog.entries) >= changeLogSize {
+				changeLog.entries = append(changeLog.entries[:0], changeLog.entries[changeLogSize/2:]...)
+			}
+			changeLog.entries = append(changeLog.entries, c)
+			close(changeLog.notify)
+			changeLog.notify = make(chan struct{})
+			changeLog.mu.Unlock()
+		}
+	}()
+}
+
+// changesSince returns the changes after since as r sees them, the latest
+// number, whether since is too old (or from another epoch) to follow on
+// from, and a channel closed at the next change.
+func changesSince(r *http.Request, since int64) ([]change, int64, bool, <-chan struct{}) {
+	changeLog.mu.Lock()
+	defer changeLog.mu.Unlock()
+	if since > changeLog.seq || (len(changeLog.entries) > 0 && since < changeLog.entries[0].Seq-1) {
+		return []change{}, changeLog.seq, true, nil
+	}
+	baseDir := baseDirFor(r)
+	_, admin := permissionsFor(r)
+	changes := []change{}
+	for _, c := range changeLog.entries {
+		if c.Seq <= since {
+			continue
+		}
+		in, oldIn := isUnderDir(c.fullPath, baseDir), c.oldFullPath != "" && isUnderDir(c.oldFullPath, baseDir)
+		switch {
+		case in && c.Type == "renamed" && !oldIn:
+			// Moved in from somewhere this requester can't see
+			c.Type, c.OldPath = "created", ""
+		case !in && oldIn:
+			c.Type, c.fullPath, c.OldPath = "deleted", c.oldFullPath, ""
+		case !in:
+			continue
+		}
+		c.Path = urlForRequest(r, c.fullPath)
+		if c.OldPath != "" {
+			c.OldPath = urlForRequest(r, c.oldFullPath)
+		}
+		if !admin {
+			c.User = ""
+		}
+		changes = append(changes, c)
+	}
+	return changes, changeLog.seq, false, changeLog.notify
+}
+
+// handleChanges serves GET /api/v1/changes[?since=N[&wait=seconds]].
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	w.Header().Set("Cache-Control", "no-store")
+	if q.Get("since") == "" {
+		changeLog.mu.Lock()
+		seq := changeLog.seq
+		changeLog.mu.Unlock()
+		writeJSON(w, map[string]any{"success": true, "epoch": changeLog.epoch, "seq": seq, "changes": []change{}})
+		return
+	}
+	since, err := strconv.ParseInt(q.Get("since"), 10, 64)
+	if err != nil || since < 0 {
+		jsonError(w, http.StatusBadRequest, "since must be a change number")
+		return
+	}
+	var wait time.Duration
+	if s := q.Get("wait"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs < 0 {
+			jsonError(w, http.StatusBadRequest, "wait must be a number of seconds")
+			return
+		}
+		wait = min(time.Duration(secs)*time.Second, changeWaitLimit)
+	}
+
+	deadline := time.After(wait)
+	for {
+		changes, seq, resync, next := changesSince(r, since)
+		if len(changes) > 0 || resync || wait == 0 {
+			writeJSON(w, map[string]any{"success": true, "epoch": changeLog.epoch, "seq": seq, "resync": resync, "changes": changes})
+			return
+		}
+		// Changes this requester can't see move the position on too
+		since = seq
+		select {
+		case <-next:
+		case <-deadline:
+			wait = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Download checksums, so clients can verify a transfer without fetching a
+// manifest. File responses carry X-Checksum-SHA256, the hex SHA-256 of the
+// whole file, when it is in the checksum cache: hashes worked out by a
+// checksum manifest job, a deduplicated upload or an earlier download,
+// kept by path, size and modification time so a rewritten file is never
+// vouched for with its old hash. A file served without one is hashed in
+// the background, one file at a time, so the next download has it.
+// Streamed ZIP and TAR downloads, whose bytes aren't known until they are
+// sent, carry the checksum as an HTTP trailer instead; spooled archives
+// (-spool) already have it as a header.
+
+const checksumHeader = "X-Checksum-SHA256"
+
+// At most this many hashes are remembered.
+const checksumCacheMax = 100000
+
+type checksumEntry struct {
+	size int64
+	mod  time.Time
+	sum  string
+}
+
+var (
+	checksums        = map[string]checksumEntry{}
+	checksumsPending = map[string]bool{}
+	checksumsMu      sync.Mutex
+	checksumSlot     = make(chan struct{}, 1)
+)
+
+// cachedChecksum returns the hex SHA-256 of the file at fullPath as it is
+// now, or "" if it isn't known.
+func cachedChecksum(fullPath string, info os.FileInfo) string {
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+	e, ok := checksums[fullPath]
+	if !ok || e.size != info.Size() || !e.mod.Equal(info.ModTime()) {
+		return ""
+	}
+	return e.sum
+}
+
+// recordChecksum remembers sum as the hash of the file at fullPath, whose
+// size and time are in info.
+func recordChecksum(fullPath string, info os.FileInfo, sum string) {
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+	if _, ok := checksums[fullPath]; !ok && len(checksums) >= checksumCacheMax {
+		for p := range checksums {
+			delete(checksums, p)
+			break
+		}
+	}
+	checksums[fullPath] = checksumEntry{info.Size(), info.ModTime(), sum}
+}
+
+// setChecksumHeader adds X-Checksum-SHA256 for the file if its hash is
+// known, and otherwise starts working it out for next time.
+func setChecksumHeader(w http.ResponseWriter, fullPath string, info os.FileInfo) {
+	if sum := cachedChecksum(fullPath, info); sum != "" {
+		w.Header().Set(checksumHeader, sum)
+		return
+	}
+	hashInBackground(fullPath)
+}
+
+// hashInBackground hashes the file at fullPath into the cache, unless
+// another file is being hashed already.
+func hashInBackground(fullPath string) {
+	checksumsMu.Lock()
+	if checksumsPending[fullPath] {
+		checksumsMu.Unlock()
+		return
+	}
+	select {
+	case checksumSlot <- struct{}{}:
+	default:
+		checksumsMu.Unlock()
+		return
+	}
+	checksumsPending[fullPath] = true
+	checksumsMu.Unlock()
+
+	go func() {
+		defer func() {
+			checksumsMu.Lock()
+			delete(checksumsPending, fullPath)
+			checksumsMu.Unlock()
+			<-checksumSlot
+		}()
+		hashFile(fullPath)
+	}()
+}
+
+// hashFile works out the hex SHA-256 of the file at fullPath and records
+// it in the cache.
+func hashFile(fullPath string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !before.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", fullPath)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	// Don't record a hash of a file that changed while it was read
+	after, err := os.Stat(fullPath)
+	if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return "", fmt.Errorf("%s changed while it was read", fullPath)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	recordChecksum(fullPath, after, sum)
+	return sum, nil
+}
+
+// checksumTrailer declares the X-Checksum-SHA256 trailer on w. The body is
+// written through the returned writer; finish sets the trailer once it is
+// complete, and isn't called for a body cut short.
+func checksumTrailer(w http.ResponseWriter) (out io.Writer, finish func()) {
+	w.Header().Add("Trailer", checksumHeader)
+	h := sha256.New()
+	return io.MultiWriter(w, h), func() {
+		w.Header().Set(checksumHeader, hex.EncodeToString(h.Sum(nil)))
+	}
+}
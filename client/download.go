@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrFileChanged is returned by Download when the file was modified on the
+// server part-way through; the caller should start again.
+var ErrFileChanged = errors.New("file changed during download")
+
+// ErrChecksumMismatch is returned by Download when the bytes written to dst
+// don't hash to the SHA-256 the server gave for the file.
+var ErrChecksumMismatch = errors.New("downloaded file does not match its checksum")
+
+// DownloadOptions tunes Download. The zero value uses 4 segments of at
+// least 8 MiB each and 3 retries per segment.
+type DownloadOptions struct {
+	Segments       int   // parallel range requests
+	MinSegmentSize int64 // files smaller than Segments*MinSegmentSize use fewer segments
+	Retries        int   // attempts per segment after the first, resuming where it stopped
+}
+
+// Download fetches the file at path into dst using parallel Range requests
+// pinned to one version of the file with If-Range, and returns the file's
+// details. dst must allow concurrent WriteAt calls at distinct offsets, as
+// *os.File does. If dst can also be read back (io.ReaderAt, as *os.File)
+// and the server knows the file's SHA-256, from download-info or the
+// X-Checksum-SHA256 header, the result is verified against it.
+func (c *Client) Download(ctx context.Context, path string, dst io.WriterAt, opts DownloadOptions, reqEditors ...RequestEditorFn) (*DownloadInfo, error) {
+	rsp, err := c.GetDownloadInfo(ctx, &GetDownloadInfoParams{Path: path}, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ParseGetDownloadInfoResponse(rsp)
+	if err != nil {
+		return nil, err
+	}
+	info := parsed.JSON200
+	if info == nil {
+		return nil, fmt.Errorf("download info: %s", parsed.Status())
+	}
+
+	if opts.Segments <= 0 {
+		opts.Segments = 4
+	}
+	if opts.MinSegmentSize <= 0 {
+		opts.MinSegmentSize = 8 << 20
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+	n := int64(opts.Segments)
+	if limit := info.Size / opts.MinSegmentSize; n > limit {
+		n = limit
+	}
+	if n < 1 {
+		n = 1
+	}
+	if info.Size == 0 {
+		return info, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sumMu    sync.Mutex
+	)
+	noteSum := func(sum string) {
+		sumMu.Lock()
+		if info.Sha256 == nil && sum != "" {
+			info.Sha256 = &sum
+		}
+		sumMu.Unlock()
+	}
+	segment := (info.Size + n - 1) / n
+	for start := int64(0); start < info.Size; start += segment {
+		end := min(start+segment, info.Size) - 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.downloadSegment(ctx, info, start, end, dst, opts.Retries, noteSum, reqEditors); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return info, firstErr
+	}
+	if r, ok := dst.(io.ReaderAt); ok && info.Sha256 != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, info.Size)); err != nil {
+			return info, err
+		}
+		if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), *info.Sha256) {
+			return info, ErrChecksumMismatch
+		}
+	}
+	return info, nil
+}
+
+// downloadSegment copies bytes start..end (inclusive) of the file to dst,
+// retrying from the last byte received on transient failures. noteSum is
+// given the response's X-Checksum-SHA256 header.
+func (c *Client) downloadSegment(ctx context.Context, info *DownloadInfo, start, end int64, dst io.WriterAt, retries int, noteSum func(string), reqEditors []RequestEditorFn) error {
+	fileURL, err := url.Parse(strings.TrimSuffix(c.Server, "/") + info.Url)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt <= retries && start <= end; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		req.Header.Set("If-Range", info.Etag)
+		if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+			return err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			noteSum(resp.Header.Get("X-Checksum-SHA256"))
+		case http.StatusOK:
+			resp.Body.Close()
+			return ErrFileChanged
+		default:
+			resp.Body.Close()
+			return fmt.Errorf("GET %s: %s", info.Path, resp.Status)
+		}
+		n, err := io.Copy(io.NewOffsetWriter(dst, start), io.LimitReader(resp.Body, end-start+1))
+		resp.Body.Close()
+		start += n
+		lastErr = err
+		if err == nil && start <= end {
+			lastErr = io.ErrUnexpectedEOF
+		}
+	}
+	if start <= end {
+		return lastErr
+	}
+	return nil
+}
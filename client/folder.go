@@ -0,0 +1,129 @@
+package client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FolderDownloadOptions tunes DownloadFolder. The zero value starts from
+// the beginning and restarts a broken stream up to 3 times.
+type FolderDownloadOptions struct {
+	After   string // carry on after this entry, as returned by an earlier call
+	Retries int    // restarts after a broken stream, each after the last whole entry
+}
+
+// DownloadFolder fetches the folder at path as a tar.gz stream (?targz=1)
+// and unpacks it under dir. The server sends entries in a fixed order, so
+// when the stream breaks off DownloadFolder asks for those after the last
+// entry it unpacked whole and carries on. It returns the name of that entry,
+// which can be given as opts.After to resume after an error, even from
+// another process. Symbolic links are skipped.
+func (c *Client) DownloadFolder(ctx context.Context, folder, dir string, opts FolderDownloadOptions, reqEditors ...RequestEditorFn) (string, error) {
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+	folderURL, err := url.Parse(strings.TrimSuffix(c.Server, "/") + (&url.URL{Path: path.Join("/", folder) + "/"}).EscapedPath())
+	if err != nil {
+		return opts.After, err
+	}
+	last := opts.After
+	var lastErr error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if ctx.Err() != nil {
+			return last, ctx.Err()
+		}
+		q := url.Values{"targz": {"1"}}
+		if last != "" {
+			q.Set("after", last)
+		}
+		folderURL.RawQuery = q.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, folderURL.String(), nil)
+		if err != nil {
+			return last, err
+		}
+		if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+			return last, err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return last, fmt.Errorf("GET %s: %s", folder, resp.Status)
+		}
+		lastErr = unpackTarGz(resp.Body, dir, &last)
+		resp.Body.Close()
+		if lastErr == nil {
+			return last, nil
+		}
+		var bad *badEntryError
+		if errors.As(lastErr, &bad) {
+			return last, lastErr
+		}
+	}
+	return last, lastErr
+}
+
+// badEntryError is an entry DownloadFolder won't unpack; retrying doesn't help.
+type badEntryError struct{ name, reason string }
+
+func (e *badEntryError) Error() string { return e.name + ": " + e.reason }
+
+// unpackTarGz unpacks the tar.gz stream r under dir, setting *last to the
+// name of each entry once it is unpacked whole.
+func unpackTarGz(r io.Reader, dir string, last *string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return &badEntryError{hdr.Name, "outside the folder"}
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+		*last = name
+	}
+}
@@ -0,0 +1,8 @@
+// Package client is a Go client for the GoServe /api/v1 REST API,
+// generated from api/openapi.json.
+//
+//	c, err := client.NewClientWithResponses("http://localhost:8080")
+//	jobs, err := c.ListJobsWithResponse(ctx)
+package client
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen@v2.5.1 -config oapi-codegen.yaml ../api/openapi.json
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Pasting files. Pressing Ctrl+V (Cmd+V) on a folder page saves what is on
+// the clipboard there: a screenshot or copied image as an image file, copied
+// text as a .txt file. The page asks for a name first, suggesting one with
+// the date and time. It sends the clipboard as the raw body of
+// POST ?paste=<name>, so scripts can do the same with curl --data-binary.
+// A name with no extension gets one from the Content-Type. Pastes never
+// replace a file: if the name is taken, " (1)", " (2)" and so on is added.
+// The folder's upload rules (size and types) apply as for any upload.
+
+// pasteTypeExts picks the usual extension for clipboard types, where
+// mime.ExtensionsByType would offer several (image/jpeg has .jfif too).
+var pasteTypeExts = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"text/plain": ".txt",
+	"text/html":  ".html",
+}
+
+// pasteName checks a suggested file name and gives it an extension from
+// contentType if it has none.
+func pasteName(name, contentType string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("Invalid file name")
+	}
+	if filepath.Ext(name) != "" {
+		return name, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := pasteTypeExts[mediaType]; ok {
+		return name + ext, nil
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return name + exts[0], nil
+	}
+	return name, nil
+}
+
+// handlePasteUpload serves POST ?paste=<name> on the folder dir, saving
+// the request body as a new file there.
+func handlePasteUpload(w http.ResponseWriter, r *http.Request, dir string) {
+	name, err := pasteName(r.URL.Query().Get("paste"), r.Header.Get("Content-Type"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		jsonError(w, http.StatusNotFound, "Folder not found")
+		return
+	}
+	if !capabilitiesFor(r, filepath.Join(dir, name)).Upload {
+		deny(w, r, filepath.Join(dir, name), "upload")
+		return
+	}
+	policy := uploadPolicyFor(filepath.Join(dir, name))
+	if err := policy.check(name, r.ContentLength, policy.limit()); err != nil {
+		jsonError(w, policy.status(name), err.Error())
+		return
+	}
+	if err := quotaError(r, filepath.Join(dir, name), r.ContentLength, 0); err != nil {
+		jsonError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, policy.limit())
+
+	destPath, err := savePaste(r.Body, filepath.Join(dir, name))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			jsonError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s is larger than the %s allowed here", name, formatSize(policy.limit())))
+			return
+		}
+		jsonError(w, http.StatusInternalServerError, "Cannot save: "+err.Error())
+		return
+	}
+	emitFileEvent(r, "created", destPath, "web")
+	m, err := statEntry(r, destPath)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "entry": m})
+}
+
+// savePaste writes src to a new file at p, or at the first free
+// "name (n).ext" beside it, returning the path used.
+func savePaste(src io.Reader, p string) (string, error) {
+	for {
+		dest := uniquePath(p)
+		if dedupDir != "" {
+			_, err := storeDeduplicated(src, dest)
+			return dest, err
+		}
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue // taken since uniquePath looked
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, src)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dest)
+			return "", err
+		}
+		return dest, nil
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Compact listings for phones. Phones get a lighter folder page: a grid of
+// icons instead of the table, no Modified column, and only the first
+// compactPageSize entries with a link to the rest. A phone is recognised by
+// the Sec-CH-UA-Mobile client hint or "Mobi" in the User-Agent; ?compact=1
+// or ?compact=0 overrides that either way.
+//
+// Listing pages of either kind carry an ETag computed from the rendered
+// page and are revalidated on every visit, so going back to a folder over a
+// slow link costs a 304 instead of the whole page when nothing changed.
+
+const compactPageSize = 100
+
+// compactListing reports whether r should get the compact listing.
+func compactListing(r *http.Request) bool {
+	switch r.URL.Query().Get("compact") {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	if hint := r.Header.Get("Sec-CH-UA-Mobile"); hint != "" {
+		return hint == "?1"
+	}
+	return strings.Contains(r.UserAgent(), "Mobi")
+}
+
+// moreURL returns r's URL with all=1, for the link below a truncated
+// compact listing.
+func moreURL(r *http.Request) string {
+	q := r.URL.Query()
+	q.Set("all", "1")
+	return (&url.URL{Path: r.URL.Path, RawQuery: q.Encode()}).String()
+}
+
+// writeListingPage sends a rendered listing page, or 304 if the client
+// already has it.
+func writeListingPage(w http.ResponseWriter, r *http.Request, page []byte) {
+	sum := sha256.Sum256(page)
+	etag := fmt.Sprintf(`W/"%x"`, sum[:12])
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("Accept-CH", "Sec-CH-UA-Mobile")
+	h.Add("Vary", "Sec-CH-UA-Mobile, User-Agent")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(page)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response compression. gzipMiddleware doesn't decide whether to compress
+// until the handler starts answering, when it can see what the answer is:
+//
+//   - Only 200 responses with a body are compressed. A 206 is a byte range
+//     of the raw file, and 304s, HEADs and 204s have nothing to compress.
+//   - Only text-like types are compressed (text/*, JSON, JavaScript, XML,
+//     SVG and a few more). ZIPs, images, video and audio are already
+//     compressed. An answer without a Content-Type is sniffed first, as
+//     net/http would.
+//   - Answers under gzipMinSize aren't worth it. Without a Content-Length,
+//     the first gzipMinSize bytes are held back until that is known.
+//   - A request with a Range header is left alone, and so is a file bigger
+//     than gzipMaxRangeable, whose download should stay resumable.
+//
+// A compressed answer loses its Content-Length and Accept-Ranges, and its
+// ETag is made weak, since the bytes are not the file's. Every answer that
+// could be compressed gets Vary: Accept-Encoding, so caches keep both.
+
+const (
+	gzipMinSize      = 1024
+	gzipMaxRangeable = 16 << 20
+)
+
+// compressibleTypes are the media types outside text/* worth compressing.
+var compressibleTypes = map[string]bool{
+	"application/json":          true,
+	"application/javascript":    true,
+	"application/x-javascript":  true,
+	"application/xml":           true,
+	"application/xhtml+xml":     true,
+	"application/rss+xml":       true,
+	"application/atom+xml":      true,
+	"application/x-ndjson":      true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/x-yaml":        true,
+	"application/yaml":          true,
+	"application/sql":           true,
+	"image/svg+xml":             true,
+	"image/bmp":                 true,
+	"image/x-icon":              true,
+	"font/ttf":                  true,
+	"font/otf":                  true,
+}
+
+// compressibleType reports whether a response of Content-Type ct is worth
+// compressing.
+func compressibleType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false // flushed event by event; gzip would hold them back
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	return compressibleTypes[mt]
+}
+
+// acceptsGzip reports whether the client takes gzip, honouring q=0.
+func acceptsGzip(r *http.Request) bool {
+	for part := range strings.SplitSeq(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int          // held back until decided
+	buf     []byte       // body held back until decided
+	decided bool         // whether to compress is settled
+	gz      *gzip.Writer // non-nil once compressing
+}
+
+func gzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Range responses must be byte offsets into the raw file
+		if r.Method == "HEAD" || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		next(gw, r)
+	}
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	switch {
+	case w.decided || code < 200:
+		// Informational answers, and superfluous calls for net/http to
+		// complain about, go straight through
+		w.ResponseWriter.WriteHeader(code)
+	case w.status == 0:
+		w.status = code
+		if code != http.StatusOK {
+			w.decide(false, nil)
+		}
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	// With a Content-Length the size is known without waiting
+	if !w.decided && w.Header().Get("Content-Length") != "" {
+		w.decide(true, p)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < gzipMinSize {
+			return len(p), nil
+		}
+		if err := w.decideAndFlush(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what is held back; an answer that is still shorter than
+// gzipMinSize goes out uncompressed.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decideAndFlush(len(w.buf) >= gzipMinSize)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decideAndFlush settles whether to compress, with bigEnough telling
+// whether the body so far is long enough, and writes out what was held.
+func (w *gzipResponseWriter) decideAndFlush(bigEnough bool) error {
+	w.decide(bigEnough, w.buf)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// decide settles whether to compress and sends the header. sample is the
+// start of the body, to sniff a missing Content-Type from.
+func (w *gzipResponseWriter) decide(bigEnough bool, sample []byte) {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(sample) > 0 && h.Get("X-Content-Type-Options") != "nosniff" {
+		h.Set("Content-Type", http.DetectContentType(sample))
+	}
+	ct := h.Get("Content-Type")
+	if compressibleType(ct) {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if w.compress(ct, bigEnough) {
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", "gzip")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(w.ResponseWriter)
+		w.gz = gz
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// compress reports whether the answer decided on now should be compressed.
+func (w *gzipResponseWriter) compress(ct string, bigEnough bool) bool {
+	h := w.Header()
+	if w.status != http.StatusOK || !bigEnough || h.Get("Content-Encoding") != "" ||
+		h.Get("Content-Range") != "" || !compressibleType(ct) {
+		return false
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		return n >= gzipMinSize && (n <= gzipMaxRangeable || h.Get("Accept-Ranges") == "")
+	}
+	return true
+}
+
+// finish sends anything still held back and ends the gzip stream.
+func (w *gzipResponseWriter) finish() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return // the handler wrote nothing; net/http sends its 200
+		}
+		if w.Header().Get("Content-Length") == "" && w.status == http.StatusOK {
+			w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		w.decideAndFlush(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Configuration files and GOSERVE_* environment variables, so a service
+// unit or container doesn't need a long command line. Every flag can be
+// set in either, under its own name: "maxsize: 500" in a config file, or
+// GOSERVE_MAXSIZE=500 (dashes become underscores). The command line wins
+// over the environment, which wins over the file.
+//
+// Config files are flat YAML (.yaml, .yml) or TOML (.toml): one setting
+// per line, strings quoted or not, and lists ([a, b], or YAML's "- a"
+// lines) for -listen and the comma-separated flags. Nested sections
+// aren't supported; there is nothing to nest.
+
+// configEnvPrefix prefixes the environment variable for each flag.
+const configEnvPrefix = "GOSERVE_"
+
+// configSetting is one setting read from a config file.
+type configSetting struct {
+	key    string
+	values []string
+	list   bool
+	line   int
+}
+
+// applyConfig sets the flags not given on the command line from the
+// environment and then from the config file, if there is one. file is the
+// -config flag, which may itself come from GOSERVE_CONFIG.
+func applyConfig(file *string) error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*stringSlice); repeatable {
+			values = strings.Fields(value)
+		}
+		for _, v := range values {
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("%s: %v", configEnvName(f.Name), serr)
+				return
+			}
+		}
+		set[f.Name] = true
+	})
+	if err != nil || *file == "" {
+		return err
+	}
+
+	settings, err := readConfigFile(*file)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		name := strings.ReplaceAll(s.key, "_", "-")
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown setting %q", *file, s.line, s.key)
+		}
+		if set[name] {
+			continue
+		}
+		values := s.values
+		if _, repeatable := f.Value.(*stringSlice); !repeatable && s.list {
+			// A list for a single flag is its comma-separated value
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("%s:%d: %s: %v", *file, s.line, s.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configEnvName is the environment variable for the flag name.
+func configEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfigFile parses file as YAML or TOML according to its extension.
+func readConfigFile(file string) ([]configSetting, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var settings []configSetting
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		settings, err = parseYAMLConfig(string(data))
+	case ".toml":
+		settings, err = parseTOMLConfig(string(data))
+	default:
+		return nil, fmt.Errorf("%s: config file must be .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", file, err)
+	}
+	seen := map[string]int{}
+	for _, s := range settings {
+		key := strings.ReplaceAll(s.key, "_", "-")
+		if line, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", file, s.line, s.key, line)
+		}
+		seen[key] = s.line
+	}
+	return settings, nil
+}
+
+// parseYAMLConfig parses flat YAML: "key: value" lines, with a list either
+// inline as [a, b] or as indented "- item" lines after "key:".
+func parseYAMLConfig(text string) ([]configSetting, error) {
+	var settings []configSetting
+	var open *configSetting // a "key:" line whose "- item" lines may follow
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		raw := stripConfigComment(sc.Text())
+		line := strings.TrimSpace(raw)
+		if line == "" || line == "---" {
+			continue
+		}
+		indented := raw[0] == ' ' || raw[0] == '\t'
+		if indented && open != nil && strings.HasPrefix(line, "-") {
+			v, err := parseConfigScalar(strings.TrimSpace(line[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", n, err)
+			}
+			open.values = append(open.values, v)
+			open.list = true
+			continue
+		}
+		if indented {
+			return nil, fmt.Errorf("%d: nested settings are not supported", n)
+		}
+		open = nil
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%d: expected key: value", n)
+		}
+		s := configSetting{key: unquoteConfigKey(key), line: n}
+		value = strings.TrimSpace(value)
+		var err error
+		switch {
+		case value == "":
+			settings = append(settings, s)
+			open = &settings[len(settings)-1]
+			continue
+		case strings.HasPrefix(value, "["):
+			s.values, err = parseConfigList(value)
+			s.list = true
+		default:
+			var v string
+			v, err = parseConfigScalar(value)
+			s.values = []string{v}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", n, err)
+		}
+		settings = append(settings, s)
+	}
+	for _, s := range settings {
+		if s.values == nil {
+			return nil, fmt.Errorf("%d: %s has no value", s.line, s.key)
+		}
+	}
+	return settings, sc.Err()
+}
+
+// parseTOMLConfig parses flat TOML: "key = value" lines, with single-line
+// arrays.
+func parseTOMLConfig(text string) ([]configSetting, error) {
+	var settings []configSetting
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripConfigComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%d: tables are not supported", n)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%d: expected key = value", n)
+		}
+		s := configSetting{key: unquoteConfigKey(key), line: n}
+		value = strings.TrimSpace(value)
+		var err error
+		if strings.HasPrefix(value, "[") {
+			s.values, err = parseConfigList(value)
+			s.list = true
+		} else {
+			var v string
+			v, err = parseConfigScalar(value)
+			s.values = []string{v}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", n, err)
+		}
+		settings = append(settings, s)
+	}
+	return settings, sc.Err()
+}
+
+// stripConfigComment removes a # comment that isn't inside quotes. In
+// YAML a # only starts a comment at the start of a line or after a space,
+// so unquoted values such as colours keep theirs. A quote only opens a
+// string where a value starts, so apostrophes in plain text are fine.
+func stripConfigComment(line string) string {
+	var quote byte
+	prev := byte(' ') // last character outside a string that isn't a space
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case (c == '"' || c == '\'') && strings.IndexByte(" :=[,-", prev) >= 0:
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+		if c != ' ' && c != '\t' {
+			prev = c
+		}
+	}
+	return line
+}
+
+// parseConfigList parses a one-line [a, b, c] list.
+func parseConfigList(value string) ([]string, error) {
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated list %s", value)
+	}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	values := []string{}
+	if inner == "" {
+		return values, nil
+	}
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			if quote != 0 {
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+		item := strings.TrimSpace(inner[start:i])
+		start = i + 1
+		if item == "" && i == len(inner) {
+			break // trailing comma
+		}
+		v, err := parseConfigScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseConfigScalar returns the string form of a value: a double-quoted
+// string with escapes, a single-quoted string, or anything else as written.
+func parseConfigScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", value)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("bad string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+// unquoteConfigKey allows keys to be quoted, as both formats permit.
+func unquoteConfigKey(key string) string {
+	if v, err := parseConfigScalar(key); err == nil {
+		return v
+	}
+	return key
+}
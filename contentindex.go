@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Content index. With -index the server keeps an inverted index of the
+// words in the text files under the base directory, so Find in Files can
+// search by word without reading the whole tree. It is built in the
+// background at start, updated as file events (see events.go) report
+// uploads, edits, renames and deletes, and checked against the disk every
+// -index-rescan to catch changes made outside goserve; a rescan only reads
+// files whose size or time changed. It follows the same rules as Find in
+// Files: -search-exclude paths, files over -search-max-size and binary
+// files are left out. The index is kept in memory and rebuilt after a
+// restart.
+//
+// ?content=words on a folder returns the files beneath it that contain
+// every word, most occurrences first, each with its first matching line.
+// Words are runs of letters and digits, matched whole and ignoring case.
+
+const (
+	indexMinWordLen   = 2
+	indexMaxWordLen   = 64
+	contentMaxResults = 200
+)
+
+var contentIdx *contentIndex // nil without -index
+
+type contentIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedDoc       // by full path
+	paths    map[uint32]string            // full path by doc id
+	postings map[string]map[uint32]uint32 // word -> doc id -> occurrences
+	nextID   uint32
+	ready    bool      // the first full scan has finished
+	scanned  time.Time // when the last full scan finished
+
+	pendingMu sync.Mutex
+	pending   []fileEvent
+	notify    chan struct{}
+}
+
+type indexedDoc struct {
+	id    uint32
+	size  int64
+	mtime time.Time
+	words []string // distinct, to take the file out of postings again
+}
+
+// startContentIndex builds the index in the background and keeps it up to
+// date, rescanning the tree every rescan (0 never).
+func startContentIndex(rescan time.Duration) {
+	x := &contentIndex{
+		docs:     map[string]*indexedDoc{},
+		paths:    map[uint32]string{},
+		postings: map[string]map[uint32]uint32{},
+		notify:   make(chan struct{}, 1),
+	}
+	contentIdx = x
+	events, _ := subscribeEvents()
+	go func() {
+		// Queue events rather than index here, so a big file being read
+		// doesn't make the subscription fall behind and drop events
+		for ev := range events {
+			x.pendingMu.Lock()
+			x.pending = append(x.pending, ev)
+			x.pendingMu.Unlock()
+			select {
+			case x.notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	go func() {
+		x.scan()
+		var tick <-chan time.Time
+		if rescan > 0 {
+			tick = time.Tick(rescan)
+		}
+		for {
+			select {
+			case <-x.notify:
+				x.pendingMu.Lock()
+				evs := x.pending
+				x.pending = nil
+				x.pendingMu.Unlock()
+				for _, ev := range evs {
+					x.apply(ev)
+				}
+			case <-tick:
+				x.scan()
+			}
+		}
+	}()
+}
+
+// apply updates the index for one file event.
+func (x *contentIndex) apply(ev fileEvent) {
+	base := getBaseDir()
+	full := filepath.Join(base, filepath.FromSlash(ev.Path))
+	switch ev.Type {
+	case "deleted":
+		x.removeTree(full)
+		return
+	case "renamed":
+		x.removeTree(filepath.Join(base, filepath.FromSlash(ev.OldPath)))
+	}
+	x.walk(full, nil)
+}
+
+// scan brings the whole index in line with the disk.
+func (x *contentIndex) scan() {
+	seen := map[string]bool{}
+	x.walk(getBaseDir(), seen)
+	x.mu.Lock()
+	for p, d := range x.docs {
+		if !seen[p] {
+			x.remove(p, d)
+		}
+	}
+	x.ready, x.scanned = true, time.Now()
+	x.mu.Unlock()
+}
+
+// walk indexes the file or folder root, noting in seen (if not nil) every
+// file that should be in the index.
+func (x *contentIndex) walk(root string, seen map[string]bool) {
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != getBaseDir() && searchExcluded(urlFor(p)) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || fi.Size() > searchMaxFileSize || fi.Name() == dirSettingsFile {
+			return nil
+		}
+		x.mu.RLock()
+		d := x.docs[p]
+		x.mu.RUnlock()
+		if d != nil && d.size == fi.Size() && d.mtime.Equal(fi.ModTime()) {
+			if seen != nil {
+				seen[p] = true
+			}
+			return nil
+		}
+		if x.indexFile(p, fi) && seen != nil {
+			seen[p] = true
+		}
+		return nil
+	})
+}
+
+// indexFile reads a text file's words into the index, reporting whether
+// the file is in it now.
+func (x *contentIndex) indexFile(p string, fi os.FileInfo) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if isBinaryFile(f) {
+		x.mu.Lock()
+		if d := x.docs[p]; d != nil {
+			x.remove(p, d)
+		}
+		x.mu.Unlock()
+		return false
+	}
+	counts := map[string]uint32{}
+	scanWords(f, func(w string) { counts[w]++ })
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old := x.docs[p]; old != nil {
+		x.remove(p, old)
+	}
+	x.nextID++
+	d := &indexedDoc{id: x.nextID, size: fi.Size(), mtime: fi.ModTime(), words: make([]string, 0, len(counts))}
+	for w, n := range counts {
+		posting := x.postings[w]
+		if posting == nil {
+			posting = map[uint32]uint32{}
+			x.postings[w] = posting
+		}
+		posting[d.id] = n
+		d.words = append(d.words, w)
+	}
+	x.docs[p], x.paths[d.id] = d, p
+	return true
+}
+
+// remove takes a file out of the index. Callers hold x.mu.
+func (x *contentIndex) remove(p string, d *indexedDoc) {
+	for _, w := range d.words {
+		delete(x.postings[w], d.id)
+		if len(x.postings[w]) == 0 {
+			delete(x.postings, w)
+		}
+	}
+	delete(x.docs, p)
+	delete(x.paths, d.id)
+}
+
+// removeTree takes the file or folder root and everything below it out of
+// the index.
+func (x *contentIndex) removeTree(root string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for p, d := range x.docs {
+		if isUnderDir(p, root) {
+			x.remove(p, d)
+		}
+	}
+}
+
+// scanWords calls fn with each word of r, lowercased.
+func scanWords(r io.Reader, fn func(string)) {
+	br := bufio.NewReader(r)
+	var word []rune
+	flush := func() {
+		if len(word) >= indexMinWordLen && len(word) <= indexMaxWordLen {
+			fn(string(word))
+		}
+		word = word[:0]
+	}
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			flush()
+			return
+		}
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			word = append(word, unicode.ToLower(c))
+		} else {
+			flush()
+		}
+	}
+}
+
+// queryWords splits a search into distinct index words.
+func queryWords(q string) []string {
+	var words []string
+	scanWords(strings.NewReader(q), func(w string) {
+		if !slices.Contains(words, w) {
+			words = append(words, w)
+		}
+	})
+	return words
+}
+
+type contentHit struct {
+	fullPath string
+	score    uint32
+}
+
+// lookup returns the files under dir containing every word, best first,
+// with the number of files indexed and whether the first scan is done.
+func (x *contentIndex) lookup(dir string, words []string) (hits []contentHit, files int, ready bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	// Start from the rarest word
+	sort.Slice(words, func(i, j int) bool { return len(x.postings[words[i]]) < len(x.postings[words[j]]) })
+	for id, n := range x.postings[words[0]] {
+		score := n
+		for _, w := range words[1:] {
+			m, ok := x.postings[w][id]
+			if !ok {
+				score = 0
+				break
+			}
+			score += m
+		}
+		if p := x.paths[id]; score > 0 && isUnderDir(p, dir) {
+			hits = append(hits, contentHit{p, score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].fullPath < hits[j].fullPath
+	})
+	return hits, len(x.docs), x.ready
+}
+
+// status describes the index for /api/v1/search-status.
+func (x *contentIndex) status() map[string]any {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	s := map[string]any{"files": len(x.docs), "words": len(x.postings), "ready": x.ready}
+	if !x.scanned.IsZero() {
+		s["lastScan"] = x.scanned
+	}
+	return s
+}
+
+// firstMatch finds the first line of fullPath that re matches.
+func firstMatch(fullPath string, re *regexp.Regexp) (int, string) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return 0, ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), grepMaxLineLen)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if text := scanner.Text(); re.MatchString(text) {
+			return line, text[:min(len(text), 300)]
+		}
+	}
+	return 0, ""
+}
+
+// handleContentSearch serves ?content=words on a folder from the index.
+func handleContentSearch(w http.ResponseWriter, r *http.Request, fullPath string) {
+	if contentIdx == nil {
+		jsonError(w, http.StatusNotFound, "The content index is not enabled (start the server with -index)")
+		return
+	}
+	words := queryWords(r.URL.Query().Get("content"))
+	if len(words) == 0 {
+		jsonError(w, http.StatusBadRequest, "Search for at least one word of two or more letters or digits")
+		return
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	hits, files, ready := contentIdx.lookup(fullPath, words)
+	// Hidden and ignored files are left out as they come, since an ignore
+	// file can change after a file is indexed
+	withHidden := hiddenShown(r)
+	var shown []contentHit
+	for _, h := range hits {
+		if (withHidden || !inHiddenFolder(fullPath, h.fullPath)) && !ignoredPath(h.fullPath) {
+			if shown = append(shown, h); len(shown) > contentMaxResults {
+				break
+			}
+		}
+	}
+	truncated := len(shown) > contentMaxResults
+	hits = shown[:min(len(shown), contentMaxResults)]
+	type contentResult struct {
+		grepMatch
+		Score uint32 `json:"score"` // occurrences of the words
+	}
+	results := make([]contentResult, 0, len(hits))
+	for _, h := range hits {
+		rel, err := filepath.Rel(fullPath, h.fullPath)
+		if err != nil {
+			continue
+		}
+		urlPath := path.Join(r.URL.Path, filepath.ToSlash(rel))
+		line, text := firstMatch(h.fullPath, re)
+		results = append(results, contentResult{grepMatch{Path: urlPath, Name: path.Base(urlPath), Line: line, Text: text}, h.score})
+	}
+	writeJSON(w, map[string]any{
+		"success":   true,
+		"results":   results,
+		"truncated": truncated,
+		"files":     files,
+		// Still building: files not read yet are missing from the results
+		"indexing": !ready,
+	})
+}
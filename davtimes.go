@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modification times over WebDAV. The DAV handler treats getlastmodified
+// as read-only, so a file copied in through a mounted drive or a sync tool
+// would always carry the time of the copy. Two ways of setting it are
+// handled here:
+//
+//   - PROPPATCH of DAV: getlastmodified, or of Win32LastModifiedTime as
+//     Windows sends it, sets the file's modification time. Windows sends
+//     its other Win32 times and attributes alongside, which are accepted
+//     and ignored. A PROPPATCH with any other property is left to the DAV
+//     handler, which refuses it as before, since PROPPATCH is all or
+//     nothing.
+//   - PUT with X-OC-Mtime (Unix seconds), as ownCloud and Nextcloud
+//     clients and rclone send, sets the time of the uploaded file and
+//     answers X-OC-Mtime: accepted.
+//
+// Both need the touch permission, as "Set Modified Time" in the web UI
+// does.
+
+// At most this much PROPPATCH body is read.
+const maxPropPatch = 64 << 10
+
+const msDavNS = "urn:schemas-microsoft-com:"
+
+type davPropertyUpdate struct {
+	XMLName xml.Name      `xml:"DAV: propertyupdate"`
+	Set     []davPropList `xml:"DAV: set"`
+	Remove  []davPropList `xml:"DAV: remove"`
+}
+
+type davPropList struct {
+	Prop struct {
+		Props []davProp `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+func isDavTimeProp(n xml.Name) bool {
+	return (n.Space == "DAV:" && n.Local == "getlastmodified") || (n.Space == msDavNS && n.Local == "Win32LastModifiedTime")
+}
+
+// parseDavTime reads an HTTP date, as DAV clients send, or a time the
+// touch action takes.
+func parseDavTime(s string) (time.Time, error) {
+	if t, err := http.ParseTime(strings.TrimSpace(s)); err == nil {
+		return t, nil
+	}
+	return parseModTime(s)
+}
+
+// davSetTimes answers a PROPPATCH that sets fullPath's modification time.
+// It returns false, with the body put back, for any other PROPPATCH, for
+// the DAV handler to answer. href is the path the client asked for.
+func davSetTimes(w http.ResponseWriter, r *http.Request, fullPath, href string) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPropPatch+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil || len(body) > maxPropPatch {
+		return false
+	}
+	var update davPropertyUpdate
+	if xml.Unmarshal(body, &update) != nil || len(update.Remove) > 0 {
+		return false
+	}
+	// A locked file is left for the DAV handler to check the lock token
+	if _, locked := davLockHolder(urlFor(fullPath)); locked {
+		return false
+	}
+	var mtime time.Time
+	var names []xml.Name
+	for _, set := range update.Set {
+		for _, p := range set.Prop.Props {
+			switch {
+			case isDavTimeProp(p.XMLName):
+				t, err := parseDavTime(p.Value)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s %q", p.XMLName.Local, p.Value), http.StatusBadRequest)
+					return true
+				}
+				mtime = t
+			case p.XMLName.Space == msDavNS && strings.HasPrefix(p.XMLName.Local, "Win32"):
+			default:
+				return false
+			}
+			names = append(names, p.XMLName)
+		}
+	}
+	if mtime.IsZero() {
+		return false
+	}
+	if err := os.Chtimes(fullPath, time.Time{}, mtime); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Cannot set the modification time", http.StatusForbidden)
+		}
+		return true
+	}
+	emitFileEvent(r, "modified", fullPath, "webdav")
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<D:multistatus xmlns:D="DAV:"><D:response><D:href>`)
+	xml.EscapeText(&b, []byte(href))
+	b.WriteString(`</D:href><D:propstat><D:prop>`)
+	for _, n := range names {
+		b.WriteString("<" + n.Local + ` xmlns="`)
+		xml.EscapeText(&b, []byte(n.Space))
+		b.WriteString(`"/>`)
+	}
+	b.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(b.Bytes())
+	return true
+}
+
+// ocMtime returns the time in r's X-OC-Mtime header, if it has one.
+func ocMtime(r *http.Request) (time.Time, bool) {
+	v := r.Header.Get("X-OC-Mtime")
+	if v == "" {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(secs * 1000)), true
+}
+
+// ocMtimeWriter sets the time of a file PUT with X-OC-Mtime once the DAV
+// handler has written it, which it has by the time it sends the status.
+type ocMtimeWriter struct {
+	http.ResponseWriter
+	fullPath string
+	mtime    time.Time
+}
+
+func (w *ocMtimeWriter) WriteHeader(code int) {
+	if code >= 200 && code <= 299 {
+		if err := os.Chtimes(w.fullPath, time.Time{}, w.mtime); err == nil {
+			w.Header().Set("X-OC-Mtime", "accepted")
+			w.Header().Del("ETag") // made from the time it had
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
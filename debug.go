@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profiling endpoints, with -debug. /debug/pprof/ serves the same profiles
+// as net/http/pprof, so `go tool pprof http://host/debug/pprof/heap` works,
+// but only to users allowed to administer the server. net/http/pprof itself
+// isn't imported: it registers its handlers on the default mux, where they
+// would be reachable without signing in and shadow a served folder named
+// "debug" even without the flag. There is no cmdline endpoint, since flags
+// can carry secrets such as -oidc-client-secret.
+
+var debugEnabled bool
+
+// maxProfileSeconds caps ?seconds= for CPU profiles and traces.
+const maxProfileSeconds = 300
+
+func handleDebug(w http.ResponseWriter, r *http.Request) {
+	if _, admin := permissionsFor(r); !admin {
+		deny(w, r, "", "admin")
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, "/debug/pprof/")
+	if !ok {
+		http.Redirect(w, r, "/debug/pprof/", http.StatusFound)
+		return
+	}
+	switch name {
+	case "":
+		debugIndex(w)
+	case "profile":
+		debugTimed(w, r, 30, "profile", func() error {
+			return pprof.StartCPUProfile(w)
+		}, pprof.StopCPUProfile)
+	case "trace":
+		debugTimed(w, r, 1, "trace", func() error {
+			return trace.Start(w)
+		}, trace.Stop)
+	default:
+		debugProfile(w, r, name)
+	}
+}
+
+// debugIndex lists the profiles with their current counts.
+func debugIndex(w http.ResponseWriter) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, "<!DOCTYPE html><html><head><title>goserve profiles</title></head><body>\n")
+	fmt.Fprint(w, "<h1>Profiles</h1>\n<table>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(w, "<tr><td align=right>%d</td><td><a href=\"%s?debug=1\">%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	fmt.Fprint(w, "<tr><td></td><td><a href=\"profile\">profile</a> (CPU, 30s; ?seconds= to change)</td></tr>\n")
+	fmt.Fprint(w, "<tr><td></td><td><a href=\"trace?seconds=5\">trace</a> (execution trace)</td></tr>\n")
+	fmt.Fprint(w, "</table>\n")
+	fmt.Fprintf(w, "<p>%d goroutines. Fetch with <code>go tool pprof</code> by leaving off <code>?debug=1</code>.</p>\n", runtime.NumGoroutine())
+	fmt.Fprint(w, "</body></html>\n")
+}
+
+// debugProfile writes the named runtime profile: binary for go tool pprof,
+// or text with ?debug=1 (or 2 for full goroutine stacks).
+func debugProfile(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "Unknown profile", http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	p.WriteTo(w, debug)
+}
+
+// debugTimed runs start, waits ?seconds= (def by default) or until the
+// client goes away, and runs stop. Only one CPU profile or trace can run at
+// a time; start fails for the second.
+func debugTimed(w http.ResponseWriter, r *http.Request, def int, name string, start func() error, stop func()) {
+	seconds := def
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxProfileSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be 1 to %d", maxProfileSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := start(); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Could not start "+name+": "+err.Error(), http.StatusConflict)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	stop()
+}
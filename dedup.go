@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/net/webdav"
+)
+
+// Content-addressable upload storage (-dedup DIR). Each uploaded file is
+// stored once in DIR by its SHA-256 and the destination path becomes a hard
+// link to that object, so near-identical artifact uploads cost no extra
+// space. DIR must be on the same filesystem as the served directory;
+// otherwise files are copied and nothing is saved.
+//
+// Because links share one inode, writes never modify a file in place while
+// dedup is on: the old link is removed (or replaced via rename) first.
+
+var dedupDir string
+
+// storeDeduplicated saves src at destPath via the object store. shared
+// reports whether the contents already existed, in which case the file's
+// metadata (e.g. mtime) is shared with other paths.
+func storeDeduplicated(src io.Reader, destPath string) (shared bool, err error) {
+	tmp, err := os.CreateTemp(dedupDir, ".upload-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	object := filepath.Join(dedupDir, sum[:2], sum)
+	if _, err := os.Stat(object); err == nil {
+		shared = true
+		addMetric("goserve_dedup_saved_bytes_total", float64(n))
+	} else {
+		if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+			return false, err
+		}
+		os.Chmod(tmp.Name(), 0644)
+		if err := os.Rename(tmp.Name(), object); err != nil {
+			return false, err
+		}
+	}
+
+	os.Remove(destPath)
+	if err := os.Link(object, destPath); err != nil {
+		// Different filesystem (or no hard link support): fall back to a copy
+		return false, copyFile(object, destPath)
+	}
+	if info, err := os.Stat(destPath); err == nil {
+		recordChecksum(destPath, info, sum)
+	}
+	return shared, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeFileUnlinked replaces name's contents without touching the inode
+// it may share with other deduplicated paths.
+func writeFileUnlinked(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".goserve-edit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), perm)
+	return os.Rename(tmp.Name(), name)
+}
+
+// dedupFS wraps the WebDAV filesystem so DAV writes detach a path from
+// its shared object before modifying it.
+type dedupFS struct {
+	webdav.Dir
+}
+
+func (fs dedupFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if full := fs.resolve(name); full != "" {
+			if flag&os.O_TRUNC != 0 {
+				os.Remove(full)
+			} else if info, err := os.Stat(full); err == nil && info.Mode().IsRegular() {
+				// Partial write: give the path its own copy first
+				tmp := full + ".goserve-detach"
+				if copyFile(full, tmp) == nil {
+					os.Rename(tmp, full)
+				}
+			}
+		}
+	}
+	return fs.Dir.OpenFile(ctx, name, flag, perm)
+}
+
+// resolve maps a WebDAV name to its path on disk, as webdav.Dir does.
+func (fs dedupFS) resolve(name string) string {
+	dir := string(fs.Dir)
+	if dir == "" {
+		dir = "."
+	}
+	full := filepath.Join(dir, filepath.FromSlash(slashClean(name)))
+	if !isUnderDir(full, dir) {
+		return ""
+	}
+	return full
+}
+
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Per-directory settings. A folder may contain a .goserve.json file:
+//
+//	{"sort": "modified,desc", "forceSort": true, "readOnly": true}
+//
+// "sort" orders the listing on the server, so the page arrives already
+// sorted; without it the -sort default applies. With "forceSort" visitors
+// can't re-sort the folder by clicking column headers. "readOnly" protects
+// the folder and everything below it from uploads, edits, renames and
+// deletes, whatever the user's permissions (see capabilitiesFor).
+// "writeOnce" allows new files but keeps them from being changed or removed
+// for a retention period (see writeOnceLocked). "title",
+// "logo" and "accent" brand the folder (see brandingFor). "maxUploadMB" and
+// "uploadTypes" limit what can be uploaded to the folder and below it (see
+// uploadPolicyFor). The file itself is hidden from listings.
+
+const dirSettingsFile = ".goserve.json"
+
+// isSettingsFile reports whether name is one of the files that set rules
+// for their folder, this one or an ignore file (see ignore.go).
+func isSettingsFile(name string) bool {
+	return name == dirSettingsFile || name == ignoreFileName
+}
+
+type dirSettings struct {
+	Sort      string `json:"sort,omitempty"`
+	ForceSort bool   `json:"forceSort,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Logo      string `json:"logo,omitempty"`
+	Accent    string `json:"accent,omitempty"`
+	WriteOnce string `json:"writeOnce,omitempty"`
+
+	MaxUploadMB int64    `json:"maxUploadMB,omitempty"`
+	UploadTypes []string `json:"uploadTypes,omitempty"` // ".iso", "image/*"
+}
+
+// defaultSort is the -sort flag: the order of folders without their own.
+var defaultSort string
+
+// sortSpec is a listing order. Col matches the listing's columns: 0 name,
+// 1 size, 2 modified.
+type sortSpec struct {
+	Col  int
+	Desc bool
+}
+
+// parseSort parses "name", "size" or "modified", optionally followed by
+// ",asc" or ",desc".
+func parseSort(s string) (sortSpec, error) {
+	field, dir, _ := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ",")
+	var spec sortSpec
+	switch strings.TrimSpace(field) {
+	case "name":
+		spec.Col = 0
+	case "size":
+		spec.Col = 1
+	case "modified", "mtime", "date", "time":
+		spec.Col = 2
+	default:
+		return spec, fmt.Errorf("unknown sort %q (valid: name, size, modified)", field)
+	}
+	switch strings.TrimSpace(dir) {
+	case "", "asc":
+	case "desc":
+		spec.Desc = true
+	default:
+		return spec, fmt.Errorf("unknown sort direction %q (valid: asc, desc)", dir)
+	}
+	return spec, nil
+}
+
+// loadDirSettings reads dir's settings file; a missing or unreadable file
+// means no settings.
+func loadDirSettings(dir string) dirSettings {
+	var ds dirSettings
+	data, err := os.ReadFile(filepath.Join(dir, dirSettingsFile))
+	if err != nil {
+		return ds
+	}
+	if err := json.Unmarshal(data, &ds); err != nil {
+		log.Printf("Ignoring %s: %v", filepath.Join(dir, dirSettingsFile), err)
+		return dirSettings{}
+	}
+	return ds
+}
+
+// walkDirSettings calls fn with the settings of the folder fullPath is in
+// (fullPath itself if it is a folder) and then of each parent up to the
+// base directory, until fn returns false.
+func walkDirSettings(fullPath string, fn func(dir string, ds dirSettings) bool) {
+	baseDir := filepath.Clean(getBaseDir())
+	dir := filepath.Clean(fullPath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for isUnderDir(dir, baseDir) {
+		if !fn(dir, loadDirSettings(dir)) || dir == baseDir {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// listingSort returns the order for dir's listing, and whether one is
+// configured at all (otherwise the listing is by name and no column is
+// marked as sorted).
+func listingSort(ds dirSettings) (sortSpec, bool) {
+	for _, s := range []string{ds.Sort, defaultSort} {
+		if s == "" {
+			continue
+		}
+		if spec, err := parseSort(s); err == nil {
+			return spec, true
+		}
+	}
+	return sortSpec{}, false
+}
+
+// sortFiles orders a listing the way the page's column headers do: by
+// name with folders first, or by size or modification time.
+func sortFiles(files []FileInfo, spec sortSpec) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if spec.Desc {
+			a, b = b, a
+		}
+		switch spec.Col {
+		case 1:
+			if a.RawSize != b.RawSize {
+				return a.RawSize < b.RawSize
+			}
+		case 2:
+			if a.RawMod != b.RawMod {
+				return a.RawMod < b.RawMod
+			}
+		default:
+			if a.IsDir != b.IsDir {
+				return files[i].IsDir
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+// uploadPolicy is what a folder accepts: files up to MaxBytes (0 when no
+// folder sets a limit) of Types (any when empty). Types has the
+// "uploadTypes" of each folder that sets them, nearest first, and a file
+// must be one of each.
+type uploadPolicy struct {
+	MaxBytes int64
+	Types    [][]string
+}
+
+// uploadPolicyFor returns the upload limits for a file at fullPath, from
+// the "maxUploadMB" and "uploadTypes" of its folder and every folder
+// above it. A folder can only tighten what the ones above it allow: the
+// smallest size limit applies, and a file must be of a type each of them
+// takes.
+func uploadPolicyFor(fullPath string) uploadPolicy {
+	var p uploadPolicy
+	walkDirSettings(fullPath, func(dir string, ds dirSettings) bool {
+		if mb := ds.MaxUploadMB; mb > 0 && (p.MaxBytes == 0 || mb*1024*1024 < p.MaxBytes) {
+			p.MaxBytes = mb * 1024 * 1024
+		}
+		if len(ds.UploadTypes) > 0 {
+			p.Types = append(p.Types, ds.UploadTypes)
+		}
+		return true
+	})
+	return p
+}
+
+// limit returns the largest file a browser upload may send: the folder's
+// limit, otherwise -maxsize.
+func (p uploadPolicy) limit() int64 {
+	if p.MaxBytes > 0 {
+		return p.MaxBytes
+	}
+	return maxUploadSize
+}
+
+// allowsType reports whether name may be uploaded. Types are extensions
+// (".pdf") or MIME patterns ("image/*", "video/mp4") matched against the
+// type of name's extension. The server's -upload-allow and -upload-deny
+// (see uploadfilter.go) come first.
+func (p uploadPolicy) allowsType(name string) bool {
+	if uploadFilterError(name) != nil {
+		return false
+	}
+	return p.refusingTypes(name) == nil
+}
+
+// refusingTypes returns the nearest list in p.Types that name is not one
+// of, or nil if every list takes it.
+func (p uploadPolicy) refusingTypes(name string) []string {
+	for _, types := range p.Types {
+		if !typeListAllows(types, name) {
+			return types
+		}
+	}
+	return nil
+}
+
+// typeListAllows reports whether name is one of types, extensions (".pdf")
+// or MIME patterns ("image/*").
+func typeListAllows(types []string, name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case t == "*":
+			return true
+		case strings.HasPrefix(t, "."):
+			if ext == t {
+				return true
+			}
+		case mimeType != "":
+			if ok, _ := path.Match(t, mimeType); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// status returns the HTTP status for check refusing name: 415 for a type
+// the policy doesn't take, otherwise 413 for a file too large.
+func (p uploadPolicy) status(name string) int {
+	if !p.allowsType(name) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+// check returns why a file called name of size bytes (-1 if not known
+// yet) can't be uploaded under the policy, with limit as the size cap.
+func (p uploadPolicy) check(name string, size, limit int64) error {
+	if err := uploadFilterError(name); err != nil {
+		return err
+	}
+	if types := p.refusingTypes(name); types != nil {
+		return fmt.Errorf("%s: only %s can be uploaded here", name, strings.Join(types, ", "))
+	}
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%s is larger than the %s allowed here", name, formatSize(limit))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Folder sizes. The Size column shows each folder's total size, everything
+// beneath it included. Sizes are worked out by one background worker, so
+// a listing never waits for a walk of a big tree: a folder not measured yet
+// shows "…" and the page asks ?dirsizes=1 for it until it is ready. Every
+// folder met on the way is remembered, so opening a subfolder afterwards
+// costs nothing. File events (see events.go) forget the sizes of the
+// changed path's folders, and sizes older than dirSizeTTL are measured
+// again to catch changes made outside goserve. -dir-sizes=false turns this
+// off for trees too big to walk.
+
+const dirSizeTTL = 10 * time.Minute
+
+var dirSizes *dirSizeCache // nil with -dir-sizes=false
+
+type dirSizeCache struct {
+	mu      sync.Mutex
+	sizes   map[string]dirSize // by full path
+	pending map[string]bool    // queued or being measured
+	queue   chan string
+}
+
+type dirSize struct {
+	bytes    int64
+	measured time.Time
+}
+
+// startDirSizes starts the worker and forgets sizes as files change.
+func startDirSizes() {
+	c := &dirSizeCache{
+		sizes:   map[string]dirSize{},
+		pending: map[string]bool{},
+		queue:   make(chan string, 1024),
+	}
+	dirSizes = c
+	go func() {
+		for dir := range c.queue {
+			c.measure(dir)
+			c.mu.Lock()
+			delete(c.pending, dir)
+			c.mu.Unlock()
+		}
+	}()
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			base := getBaseDir()
+			c.forget(filepath.Join(base, filepath.FromSlash(ev.Path)))
+			if ev.OldPath != "" {
+				c.forget(filepath.Join(base, filepath.FromSlash(ev.OldPath)))
+			}
+		}
+	}()
+}
+
+// size returns the folder's total size if it is known, and otherwise
+// queues it to be measured.
+func (c *dirSizeCache) size(dir string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.sizes[dir]; ok && time.Since(s.measured) < dirSizeTTL {
+		return s.bytes, true
+	}
+	if !c.pending[dir] {
+		select {
+		case c.queue <- dir:
+			c.pending[dir] = true
+		default:
+			// Full; the page asks again
+		}
+	}
+	return 0, false
+}
+
+// measure walks dir, remembering the size of it and of every folder below
+// it. Symbolic links are not followed, so a link can't make it loop.
+func (c *dirSizeCache) measure(dir string) int64 {
+	c.mu.Lock()
+	s, ok := c.sizes[dir]
+	c.mu.Unlock()
+	if ok && time.Since(s.measured) < dirSizeTTL {
+		return s.bytes
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, e := range entries {
+		switch {
+		case e.IsDir():
+			total += c.measure(filepath.Join(dir, e.Name()))
+		case e.Type().IsRegular():
+			if info, err := e.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+	c.mu.Lock()
+	c.sizes[dir] = dirSize{total, time.Now()}
+	c.mu.Unlock()
+	return total
+}
+
+// forget drops the sizes of path and the folders above it.
+func (c *dirSizeCache) forget(p string) {
+	base := getBaseDir()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		delete(c.sizes, p)
+		parent := filepath.Dir(p)
+		if parent == p || !isUnderDir(parent, base) {
+			return
+		}
+		p = parent
+	}
+}
+
+// handleDirSizes serves ?dirsizes=1 on a folder: the sizes of its
+// subfolders that are known, and the names of those still being measured.
+func handleDirSizes(w http.ResponseWriter, r *http.Request, fullPath string) {
+	if dirSizes == nil {
+		jsonError(w, http.StatusNotFound, "Folder sizes are turned off (-dir-sizes=false)")
+		return
+	}
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Cannot read directory")
+		return
+	}
+	type folderSize struct {
+		Bytes int64  `json:"bytes"`
+		Size  string `json:"size"` // as the listing shows it
+	}
+	sizes := map[string]folderSize{}
+	pending := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if n, ok := dirSizes.size(filepath.Join(fullPath, e.Name())); ok {
+			sizes[e.Name()] = folderSize{n, formatSize(n)}
+		} else {
+			pending = append(pending, e.Name())
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{"success": true, "sizes": sizes, "pending": pending})
+}
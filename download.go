@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// Segmented downloads. Plain file responses carry a strong ETag derived
+// from the file's size and modification time, so a client can fetch byte
+// ranges in parallel with If-Range and be sure every segment comes from the
+// same version: if the file changes mid-download the server answers the
+// next segment with the whole new file (200) instead of a 206, and the
+// client starts over. /api/v1/download-info tells the client the size and
+// ETag up front, and the SHA-256 when it is in the checksum cache.
+
+// entryMeta describes a file or folder as it is on disk now, for responses
+// that let a client update its view without listing the folder again.
+type entryMeta struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	IsDir    bool   `json:"isDir"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	ETag     string `json:"etag,omitempty"`
+	Mode     string `json:"mode,omitempty"`  // octal, with -show-perms
+	Owner    string `json:"owner,omitempty"` // user:group, with -show-perms
+	Error    string `json:"error,omitempty"` // set, alone with name and path, for an entry that can't be read
+	Link     string `json:"link,omitempty"`  // where a symlink points
+}
+
+func statEntry(r *http.Request, fullPath string) (entryMeta, error) {
+	info, link, err := statListed(fullPath, baseDirFor(r))
+	if err != nil {
+		return entryMeta{}, err
+	}
+	m := entryMeta{
+		Name:     info.Name(),
+		Path:     urlForRequest(r, fullPath),
+		IsDir:    info.IsDir(),
+		Modified: info.ModTime().UTC().Format(time.RFC3339Nano),
+		Link:     link,
+	}
+	if !info.IsDir() {
+		m.Size = info.Size()
+		m.ETag = fileETag(fullPath, info)
+	}
+	if showPerms {
+		m.Mode, m.Owner = octalMode(info.Mode()), fileOwner(info)
+	}
+	return m, nil
+}
+
+// handleDownloadInfo serves /api/v1/download-info?path=/file.
+func handleDownloadInfo(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		jsonError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	fullPath, ok := resolvePathFor(r, p)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		jsonError(w, http.StatusNotFound, "Not a file")
+		return
+	}
+	urlPath := path.Clean("/" + p)
+	resp := map[string]any{
+		"success":      true,
+		"path":         urlPath,
+		"url":          (&url.URL{Path: urlPath}).String(),
+		"size":         info.Size(),
+		"etag":         fileETag(fullPath, info),
+		"modified":     info.ModTime().UTC().Format(time.RFC3339Nano),
+		"acceptRanges": "bytes",
+	}
+	if sum := cachedChecksum(fullPath, info); sum != "" {
+		resp["sha256"] = sum
+	} else {
+		hashInBackground(fullPath)
+	}
+	writeJSON(w, resp)
+}
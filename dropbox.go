@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Drop-box mode (-dropbox DIR). Anonymous visitors to /_drop/ get their own
+// folder under DIR, identified by a random token kept in a cookie and in the
+// URL (so it can be bookmarked). They can upload into it and see and
+// re-download their own submissions, but nothing else on the server. The
+// rest of the server treats DIR as if an ignore file left it out (see
+// ignore.go), so no one can list the session folders or reach them except
+// through /_drop/.
+
+var dropboxDir string
+
+const dropboxCookie = "goserve_drop"
+
+const dropboxTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>GoServe - Drop Box</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #eff1f5; color: #4c4f69; margin: 0; padding: 30px; }
+        .box { max-width: 720px; margin: 0 auto; background: #fff; border: 1px solid #ccd0da; border-radius: 8px; padding: 24px; }
+        h1 { font-size: 20px; margin: 0 0 6px; }
+        h1 span { color: #1e66f5; }
+        p { color: #6c6f85; font-size: 14px; }
+        table { width: 100%; border-collapse: collapse; margin-top: 16px; font-size: 14px; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #ccd0da; }
+        a { color: #1e66f5; text-decoration: none; }
+        .btn { background: #1e66f5; color: #fff; border: none; border-radius: 4px; padding: 8px 16px; cursor: pointer; }
+        code { background: #e6e9ef; padding: 2px 6px; border-radius: 3px; word-break: break-all; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        <h1>Go<span>Serve</span> Drop Box</h1>
+        <p>Files you upload here are only visible to you. Bookmark this page to come back to them: <code>{{.Link}}</code></p>
+        <form method="POST" action="{{.Path}}?upload=1" enctype="multipart/form-data">
+            <input type="file" name="files" multiple required>
+            <button class="btn" type="submit">Upload</button>
+        </form>
+        <table>
+            <tr><th>Your submissions</th><th>Size</th><th>Uploaded</th></tr>
+            {{range .Files}}
+            <tr><td><a href="{{.Path}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.ModTime}}</td></tr>
+            {{else}}
+            <tr><td colspan="3">Nothing uploaded yet.</td></tr>
+            {{end}}
+        </table>
+    </div>
+</body>
+</html>`
+
+var dropboxTmpl = template.Must(template.New("dropbox").Parse(dropboxTemplate))
+
+// dropboxRoot returns the directory holding all drop-box sessions.
+func dropboxRoot() string {
+	if filepath.IsAbs(dropboxDir) {
+		return dropboxDir
+	}
+	return filepath.Join(getBaseDir(), dropboxDir)
+}
+
+// inDropbox reports whether fullPath is the drop-box directory or inside it.
+func inDropbox(fullPath string) bool {
+	return dropboxDir != "" && isUnderDir(fullPath, dropboxRoot())
+}
+
+func newDropboxToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validDropboxToken(t string) bool {
+	if len(t) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(t)
+	return err == nil
+}
+
+// handleDropbox serves /_drop/ (session assignment) and /_drop/<token>/...
+func handleDropbox(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/_drop/")
+	token, sub, _ := strings.Cut(rest, "/")
+
+	if token == "" {
+		if c, err := r.Cookie(dropboxCookie); err == nil && validDropboxToken(c.Value) {
+			token = c.Value
+		} else {
+			token = newDropboxToken()
+		}
+		http.Redirect(w, r, "/_drop/"+token+"/", http.StatusSeeOther)
+		return
+	}
+	if !validDropboxToken(token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Remember the session so /_drop/ leads back here
+	http.SetCookie(w, &http.Cookie{
+		Name:     dropboxCookie,
+		Value:    token,
+		Path:     "/_drop/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	sessionDir := filepath.Join(dropboxRoot(), token)
+	fullPath := filepath.Join(sessionDir, filepath.FromSlash(path.Clean("/"+sub)))
+	if !isUnderDir(fullPath, sessionDir) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("upload") != "" && r.Method == "POST" {
+		if err := os.MkdirAll(sessionDir, 0755); err != nil {
+			http.Error(w, "Cannot create drop box folder", http.StatusInternalServerError)
+			return
+		}
+		handleUpload(w, r, sessionDir, sessionDir)
+		return
+	}
+
+	if sub != "" {
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		// Anything may have been dropped, so it is never rendered on the
+		// server's own origin, where a page could act as a signed-in user
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(fullPath)}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		http.ServeFile(w, r, fullPath)
+		return
+	}
+
+	// List this session's files, including those from folder uploads
+	var files []FileInfo
+	filepath.Walk(sessionDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(sessionDir, p)
+		rel = filepath.ToSlash(rel)
+		files = append(files, FileInfo{
+			Name:    rel,
+			Path:    "/_drop/" + token + "/" + rel,
+			Size:    formatSize(info.Size()),
+			ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
+			RawMod:  info.ModTime().Unix(),
+		})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].RawMod > files[j].RawMod })
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dropboxTmpl.Execute(w, map[string]any{
+		"Path":  r.URL.Path,
+		"Link":  scheme + "://" + r.Host + r.URL.Path,
+		"Files": files,
+	})
+}
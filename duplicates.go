@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Duplicate finder. The "duplicates" job scans a folder and everything
+// below it for files to review before deleting, the usual clean-up of a
+// photo dump folder that several phones and backups were copied into.
+// Files with the same content are grouped as identical (same size, then
+// same SHA-256). Images are also compared by a perceptual hash, a
+// difference hash of a 9x8 grayscale thumbnail, so resized and re-encoded
+// copies of a photo are grouped as similar. Nothing is changed; the groups
+// are listed on the job.
+
+const (
+	// Hashes differing in at most this many of their 64 bits are similar
+	dupSimilarBits = 6
+	// Images larger than this aren't decoded for similarity
+	dupMaxImageBytes = 50 * 1024 * 1024
+	// At most this many groups are listed on a job
+	maxJobDuplicates = 500
+)
+
+var dupImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+type dupGroup struct {
+	Kind  string    `json:"kind"` // identical or similar
+	Files []dupFile `json:"files"`
+}
+
+type dupFile struct {
+	Path   string `json:"path"` // relative to the folder scanned
+	Size   int64  `json:"size"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// duplicatesJob groups the identical files and similar images under dir.
+func duplicatesJob(ctx context.Context, j *Job, dir string) error {
+	var files []dupFile
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p != dir && (strings.HasPrefix(info.Name(), ".") || searchExcluded(urlFor(p))) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && info.Size() > 0 {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, dupFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Only files sharing a size can be identical
+	bySize := map[int64][]int{}
+	for i, f := range files {
+		bySize[f.Size] = append(bySize[f.Size], i)
+	}
+	var toHash []int
+	for _, idx := range bySize {
+		if len(idx) > 1 {
+			toHash = append(toHash, idx...)
+		}
+	}
+	var images []int
+	for i, f := range files {
+		if dupImageExts[strings.ToLower(filepath.Ext(f.Path))] && f.Size <= dupMaxImageBytes {
+			images = append(images, i)
+		}
+	}
+	j.setProgress(0, int64(len(toHash)+len(images)), "Comparing contents")
+
+	var groups []dupGroup
+	copyOf := map[int]bool{} // identical to an earlier file
+	byDigest := map[string][]int{}
+	for _, i := range toHash {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if sum, err := fileDigest(filepath.Join(dir, filepath.FromSlash(files[i].Path))); err == nil {
+			byDigest[sum] = append(byDigest[sum], i)
+		}
+		j.addProgress(1, "")
+	}
+	for _, idx := range byDigest {
+		if len(idx) < 2 {
+			continue
+		}
+		sort.Slice(idx, func(a, b int) bool { return files[idx[a]].Path < files[idx[b]].Path })
+		g := dupGroup{Kind: "identical"}
+		for n, i := range idx {
+			g.Files = append(g.Files, files[i])
+			copyOf[i] = n > 0
+		}
+		groups = append(groups, g)
+	}
+	// Largest waste first
+	sort.Slice(groups, func(a, b int) bool {
+		wa := groups[a].Files[0].Size * int64(len(groups[a].Files)-1)
+		wb := groups[b].Files[0].Size * int64(len(groups[b].Files)-1)
+		if wa != wb {
+			return wa > wb
+		}
+		return groups[a].Files[0].Path < groups[b].Files[0].Path
+	})
+	identical := len(groups)
+
+	// Similar images, comparing one copy of each set of identical ones
+	j.setProgress(int64(len(toHash)), int64(len(toHash)+len(images)), "Comparing images")
+	var hashed []int
+	hashes := map[int]uint64{}
+	for _, i := range images {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !copyOf[i] {
+			if h, w, ht, err := imageFileHash(filepath.Join(dir, filepath.FromSlash(files[i].Path))); err == nil {
+				hashes[i] = h
+				files[i].Width, files[i].Height = w, ht
+				hashed = append(hashed, i)
+			}
+		}
+		j.addProgress(1, "")
+	}
+	parent := map[int]int{}
+	var find func(i int) int
+	find = func(i int) int {
+		if p, ok := parent[i]; ok && p != i {
+			parent[i] = find(p)
+			return parent[i]
+		}
+		return i
+	}
+	for a := 0; a < len(hashed); a++ {
+		for b := a + 1; b < len(hashed); b++ {
+			ia, ib := hashed[a], hashed[b]
+			if bits.OnesCount64(hashes[ia]^hashes[ib]) <= dupSimilarBits {
+				parent[find(ib)] = find(ia)
+			}
+		}
+	}
+	sets := map[int][]int{}
+	for _, i := range hashed {
+		root := find(i)
+		sets[root] = append(sets[root], i)
+	}
+	var similar []dupGroup
+	for _, idx := range sets {
+		if len(idx) < 2 {
+			continue
+		}
+		// Biggest first: usually the original
+		sort.Slice(idx, func(a, b int) bool {
+			fa, fb := files[idx[a]], files[idx[b]]
+			if fa.Width*fa.Height != fb.Width*fb.Height {
+				return fa.Width*fa.Height > fb.Width*fb.Height
+			}
+			return fa.Path < fb.Path
+		})
+		g := dupGroup{Kind: "similar"}
+		for _, i := range idx {
+			g.Files = append(g.Files, files[i])
+		}
+		similar = append(similar, g)
+	}
+	sort.Slice(similar, func(a, b int) bool { return similar[a].Files[0].Path < similar[b].Files[0].Path })
+	groups = append(groups, similar...)
+
+	var wasted int64
+	for _, g := range groups[:identical] {
+		wasted += g.Files[0].Size * int64(len(g.Files)-1)
+	}
+	if len(groups) > maxJobDuplicates {
+		groups = groups[:maxJobDuplicates]
+	}
+	jobsMu.Lock()
+	j.Duplicates = groups
+	jobsMu.Unlock()
+	j.complete(fmt.Sprintf("Identical files: %d groups, %s to reclaim; similar images: %d groups",
+		identical, formatSize(wasted), len(similar)), "")
+	return nil
+}
+
+func fileDigest(fullPath string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// imageFileHash decodes the image at fullPath and returns its difference
+// hash and size.
+func imageFileHash(fullPath string) (hash uint64, width, height int, err error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	b := img.Bounds()
+	if b.Dx() < 9 || b.Dy() < 8 {
+		return 0, 0, 0, fmt.Errorf("too small")
+	}
+	return differenceHash(img), b.Dx(), b.Dy(), nil
+}
+
+// differenceHash shrinks img to 9x8 gray cells and sets a bit for each
+// cell brighter than its right-hand neighbour. Scaling and re-encoding
+// barely change it.
+func differenceHash(img image.Image) uint64 {
+	b := img.Bounds()
+	var gray [8][9]float64
+	for y := range 8 {
+		y0, y1 := b.Min.Y+y*b.Dy()/8, b.Min.Y+(y+1)*b.Dy()/8
+		for x := range 9 {
+			x0, x1 := b.Min.X+x*b.Dx()/9, b.Min.X+(x+1)*b.Dx()/9
+			// Average a grid of at most 16x16 samples of the cell
+			stepX, stepY := max((x1-x0)/16, 1), max((y1-y0)/16, 1)
+			var sum float64
+			var n int
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					r, g, bl, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			gray[y][x] = sum / float64(n)
+		}
+	}
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Soft locks taken by the web editor. They are registered in the same
+// LockSystem the WebDAV handler uses, so a file open in the browser is
+// also locked for DAV clients and vice versa.
+//
+// With shared state the editor locks are the "editlocks" document, so an
+// editor on one instance locks the file for editors on all of them. Tokens
+// are then our own rather than the LockSystem's, which is per instance:
+// each instance the editor's refreshes reach takes a LockSystem lock of its
+// own (editDavTokens) against its WebDAV clients.
+
+var lockSystem webdav.LockSystem = ownerLS{webdav.NewMemLS()}
+
+// The editor refreshes its lock every 30 seconds; a closed tab lets the
+// lock lapse after editLockDuration.
+const editLockDuration = 2 * time.Minute
+
+type editLock struct {
+	Owner   string
+	Token   string
+	Expires time.Time
+}
+
+// errFileLocked is returned by withEditLock when another party holds the lock.
+var errFileLocked = errors.New("file is locked by another user")
+
+var (
+	editLocks     = map[string]editLock{}
+	editDavTokens = map[string]string{} // shared state: this instance's LockSystem token by name
+	editLocksMu   sync.Mutex
+)
+
+// davLockOwner names the WebDAV client holding a lock on name.
+func davLockOwner(name string) string {
+	if l, ok := davLockHolder(name); ok {
+		return l.Owner
+	}
+	return "a WebDAV client"
+}
+
+// requesterName names the requester for locks and job ownership: the
+// authenticated user, or the client IP when auth is off.
+func requesterName(r *http.Request) string {
+	if user := getUserFromRequest(r); user != nil {
+		return user.Username
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// acquireEditLock takes the lock on name (a slash path relative to the base
+// dir) or refreshes it when token already holds it. If someone else holds
+// the lock, the returned editLock describes them and err is webdav.ErrLocked.
+func acquireEditLock(name, owner, token string) (editLock, error) {
+	if stateShared {
+		var l editLock
+		var err error
+		serr := updateState("editlocks", &editLocksMu, &editLocks, func() {
+			l, err = acquireSharedEditLock(name, owner, token)
+		})
+		if err == nil {
+			err = serr
+		}
+		return l, err
+	}
+
+	editLocksMu.Lock()
+	defer editLocksMu.Unlock()
+
+	now := time.Now()
+	if l, ok := editLocks[name]; ok && now.Before(l.Expires) {
+		if token == "" || l.Token != token {
+			return l, webdav.ErrLocked
+		}
+		if _, err := lockSystem.Refresh(now, token, editLockDuration); err != nil {
+			return editLock{}, err
+		}
+		l.Expires = now.Add(editLockDuration)
+		editLocks[name] = l
+		return l, nil
+	}
+
+	token, err := lockSystem.Create(now, webdav.LockDetails{
+		Root:      name,
+		Duration:  editLockDuration,
+		OwnerXML:  "<D:href>" + html.EscapeString(owner) + " (web editor)</D:href>",
+		ZeroDepth: true,
+	})
+	if err == webdav.ErrLocked {
+		return editLock{Owner: davLockOwner(name)}, err
+	}
+	if err != nil {
+		return editLock{}, err
+	}
+	l := editLock{Owner: owner, Token: token, Expires: now.Add(editLockDuration)}
+	editLocks[name] = l
+	return l, nil
+}
+
+// acquireSharedEditLock is acquireEditLock on the freshly loaded shared
+// locks. Callers hold editLocksMu.
+func acquireSharedEditLock(name, owner, token string) (editLock, error) {
+	now := time.Now()
+	for n, l := range editLocks {
+		if now.After(l.Expires) {
+			delete(editLocks, n)
+		}
+	}
+	l, held := editLocks[name]
+	if held && (token == "" || l.Token != token) {
+		return l, webdav.ErrLocked
+	}
+
+	dav, ok := editDavTokens[name]
+	if ok {
+		_, err := lockSystem.Refresh(now, dav, editLockDuration)
+		ok = err == nil
+	}
+	if !ok {
+		var err error
+		dav, err = lockSystem.Create(now, webdav.LockDetails{
+			Root:      name,
+			Duration:  editLockDuration,
+			OwnerXML:  "<D:href>" + html.EscapeString(owner) + " (web editor)</D:href>",
+			ZeroDepth: true,
+		})
+		if err == webdav.ErrLocked {
+			return editLock{Owner: davLockOwner(name)}, err
+		}
+		if err != nil {
+			return editLock{}, err
+		}
+		editDavTokens[name] = dav
+	}
+
+	if !held {
+		l = editLock{Owner: owner, Token: randomString(24)}
+	}
+	l.Expires = now.Add(editLockDuration)
+	editLocks[name] = l
+	return l, nil
+}
+
+func releaseEditLock(name, token string) {
+	if stateShared {
+		err := updateState("editlocks", &editLocksMu, &editLocks, func() {
+			if l, ok := editLocks[name]; ok && l.Token == token {
+				delete(editLocks, name)
+				if dav, ok := editDavTokens[name]; ok {
+					lockSystem.Unlock(time.Now(), dav)
+					delete(editDavTokens, name)
+				}
+			}
+		})
+		if err != nil {
+			log.Printf("Releasing edit lock on %s: %v", name, err)
+		}
+		return
+	}
+
+	editLocksMu.Lock()
+	defer editLocksMu.Unlock()
+
+	if l, ok := editLocks[name]; ok && l.Token == token {
+		lockSystem.Unlock(time.Now(), token)
+		delete(editLocks, name)
+	}
+}
+
+// withEditLock runs fn if name is unlocked or locked by token, holding the
+// lock for the duration so concurrent WebDAV writes are refused.
+func withEditLock(name, token string, fn func() error) error {
+	now := time.Now()
+	if stateShared {
+		editLocksMu.Lock()
+		l, held := editLocks[name]
+		dav := editDavTokens[name]
+		editLocksMu.Unlock()
+		if held && now.Before(l.Expires) && l.Token != token {
+			return errFileLocked
+		}
+		token = ""
+		if held {
+			token = dav
+		}
+	}
+	if token != "" {
+		release, err := lockSystem.Confirm(now, name, "", webdav.Condition{Token: token})
+		if err == nil {
+			defer release()
+			return fn()
+		}
+	}
+	tmp, err := lockSystem.Create(now, webdav.LockDetails{
+		Root:      name,
+		Duration:  -1,
+		OwnerXML:  "<D:href>web editor (saving)</D:href>",
+		ZeroDepth: true,
+	})
+	if err == webdav.ErrLocked {
+		return errFileLocked
+	}
+	if err != nil {
+		return err
+	}
+	defer lockSystem.Unlock(time.Now(), tmp)
+	return fn()
+}
+
+// handleEditLock serves ?lock=1 (acquire or refresh, with optional token)
+// and ?unlock=<token> for the file at name.
+func handleEditLock(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if token := r.URL.Query().Get("unlock"); token != "" {
+		releaseEditLock(name, token)
+		fmt.Fprintf(w, `{"success": true}`)
+		return
+	}
+
+	l, err := acquireEditLock(name, requesterName(r), r.URL.Query().Get("token"))
+	if err == webdav.ErrLocked {
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":  false,
+			"error":    "Locked by " + l.Owner,
+			"lockedBy": l.Owner,
+		})
+		return
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "token": l.Token})
+}
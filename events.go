@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File change events. Every mutation made through the web UI, the API,
+// jobs or WebDAV is published here; subscribers (the rules engine, tag
+// bookkeeping) receive them on their own channel.
+
+type fileEvent struct {
+	Type    string    `json:"type"` // created, modified, deleted, renamed
+	Path    string    `json:"path"`
+	OldPath string    `json:"oldPath,omitempty"`
+	IsDir   bool      `json:"isDir,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Source  string    `json:"source"` // web, webdav, job, rules
+	Time    time.Time `json:"time"`
+}
+
+var (
+	eventSubs   = map[chan fileEvent]struct{}{}
+	eventSubsMu sync.Mutex
+)
+
+// subscribeEvents returns a channel receiving all future events and a
+// function to unsubscribe.
+func subscribeEvents() (<-chan fileEvent, func()) {
+	ch := make(chan fileEvent, 1024)
+	eventSubsMu.Lock()
+	eventSubs[ch] = struct{}{}
+	eventSubsMu.Unlock()
+	return ch, func() {
+		eventSubsMu.Lock()
+		delete(eventSubs, ch)
+		eventSubsMu.Unlock()
+	}
+}
+
+// publishEvent delivers ev to every subscriber. A subscriber that has
+// fallen 1024 events behind misses events rather than stalling requests.
+func publishEvent(ev fileEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	eventSubsMu.Lock()
+	defer eventSubsMu.Unlock()
+	for ch := range eventSubs {
+		select {
+		case ch <- ev:
+		default:
+			addMetric("goserve_events_dropped_total", 1)
+		}
+	}
+}
+
+// emitFileEvent publishes an event for a change made by request r to the
+// file at fullPath.
+func emitFileEvent(r *http.Request, typ, fullPath, source string) {
+	ev := fileEvent{Type: typ, Path: urlFor(fullPath), Source: source}
+	if r != nil {
+		ev.User = requesterName(r)
+	}
+	if info, err := os.Stat(fullPath); err == nil {
+		ev.IsDir = info.IsDir()
+	}
+	publishEvent(ev)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// davEventsMiddleware publishes events for successful mutating WebDAV
+// requests. prefix is the mount point stripped from DAV paths.
+func davEventsMiddleware(prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Events name files from the base directory, not a user's home
+		davPath := func(p string) string {
+			fullPath, _ := resolvePathFor(r, strings.TrimPrefix(p, prefix))
+			return urlFor(fullPath)
+		}
+		// The DAV handler answers 201 to every PUT, so check beforehand
+		existed := false
+		if r.Method == "PUT" {
+			if fullPath, ok := resolvePathFor(r, strings.TrimPrefix(r.URL.Path, prefix)); ok {
+				_, err := os.Stat(fullPath)
+				existed = err == nil
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status < 200 || rec.status > 299 {
+			return
+		}
+
+		ev := fileEvent{Path: davPath(r.URL.Path), User: requesterName(r), Source: "webdav"}
+		switch r.Method {
+		case "PUT":
+			ev.Type = "created"
+			if existed {
+				ev.Type = "modified"
+			}
+		case "MKCOL":
+			ev.Type, ev.IsDir = "created", true
+		case "DELETE":
+			ev.Type = "deleted"
+		case "MOVE", "COPY":
+			dest, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil {
+				return
+			}
+			if r.Method == "MOVE" {
+				ev.Type, ev.OldPath = "renamed", ev.Path
+			} else {
+				ev.Type = "created"
+			}
+			ev.Path = davPath(dest.Path)
+		default:
+			return
+		}
+		publishEvent(ev)
+	}
+}
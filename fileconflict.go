@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Name conflicts when moving and copying. Batch moves and copies and copy
+// jobs take "conflict" to say what happens when an item's name is already
+// taken in the destination:
+//
+//	(none)     the item fails with "already exists", as before
+//	overwrite  files replace what's there; folders are merged into
+//	skip       what's there is kept; folders are still merged into, so
+//	           what's missing from them is added
+//	rename     the item is put beside it as "name (1)", never merged
+//
+// Merging goes down the tree with the same policy, so a folder copied onto
+// an older copy of itself brings it up to date. Something that can't be
+// merged, such as a file where the destination has a folder, fails on its
+// own and the rest carries on. With rename a copy into its own folder makes
+// a duplicate.
+
+// placeItem decides where src goes when it is moved or copied to dst under
+// policy. It returns the path to use and what will happen: "created",
+// "renamed", "replaced", "merged" (dst is a folder to merge into) or
+// "skipped" (leave src alone).
+func placeItem(src, dst, policy string) (string, string, error) {
+	existing, err := os.Lstat(dst)
+	if err != nil {
+		return dst, "created", nil
+	}
+	if policy == "" {
+		return "", "", fmt.Errorf("%s already exists", urlFor(dst))
+	}
+	if policy == conflictRename {
+		return uniquePath(dst), "renamed", nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case info.IsDir() && existing.IsDir():
+		return dst, "merged", nil
+	case policy == conflictSkip:
+		return "", "skipped", nil
+	case existing.IsDir():
+		return "", "", fmt.Errorf("%s is a folder", urlFor(dst))
+	case info.IsDir():
+		return "", "", fmt.Errorf("%s is a file", urlFor(dst))
+	}
+	return dst, "replaced", nil
+}
+
+// placeRefusal returns the check moves and copies for r make before
+// putting each entry somewhere: the folder it goes in must take uploads
+// from r, and so must the path itself, which keeps write-once files and
+// settings files from being replaced. Files must also be of a type and
+// within the size the folder takes; -maxsize is for uploads, not copies.
+func placeRefusal(r *http.Request) func(dst string, info os.FileInfo) error {
+	// Background jobs check after the request is answered
+	r = r.Clone(context.WithoutCancel(r.Context()))
+	return func(dst string, info os.FileInfo) error {
+		if dir := filepath.Dir(dst); !capabilitiesFor(r, dir).Upload {
+			return denyError(r, dir, "upload")
+		}
+		if !capabilitiesFor(r, dst).Upload {
+			return denyError(r, dst, "upload")
+		}
+		if !info.IsDir() {
+			policy := uploadPolicyFor(dst)
+			return policy.check(filepath.Base(dst), info.Size(), policy.MaxBytes)
+		}
+		return nil
+	}
+}
+
+// placeError returns why the job may not put src at dst, or nil. A folder
+// that would arrive whole (by a rename) is checked entry by entry.
+func (j *Job) placeError(src, dst string, info os.FileInfo) error {
+	if j.place == nil {
+		return nil
+	}
+	if err := j.place(dst, info); err != nil || !info.IsDir() {
+		return err
+	}
+	var refused error
+	filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == src {
+			return nil
+		}
+		rel, _ := filepath.Rel(src, p)
+		if err := j.place(filepath.Join(dst, rel), fi); err != nil {
+			refused = err
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return refused
+}
+
+// moveTree moves src to dst under policy, merging folders entry by entry,
+// and returns what happened to src. Like copyTree, failures below src are
+// recorded on the job and skipped, and the error is only for cancellation.
+// A merged folder is removed once it is empty, and left with whatever
+// wasn't moved out of it.
+func moveTree(ctx context.Context, j *Job, src, dst, policy string) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	target, status, err := placeItem(src, dst, policy)
+	if err != nil {
+		j.addFailure(urlFor(src), err)
+		return "", nil
+	}
+	switch status {
+	case "skipped":
+		return status, nil
+	case "merged":
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			j.addFailure(urlFor(src), err)
+			return status, nil
+		}
+		for _, e := range entries {
+			if _, err := moveTree(ctx, j, filepath.Join(src, e.Name()), filepath.Join(target, e.Name()), policy); err != nil {
+				return status, err
+			}
+		}
+		if os.Remove(src) == nil {
+			publishEvent(fileEvent{Type: "deleted", Path: urlFor(src), IsDir: true, User: j.Owner, Source: "web"})
+		}
+		return status, nil
+	}
+	// The caller only checked the top item and what's above it
+	if writeOnceLocked(src) {
+		j.addFailure(urlFor(src), errors.New("in a write-once folder"))
+		return "", nil
+	}
+	if dir, _ := writeOnceBelow(src); dir != "" {
+		j.addFailure(urlFor(src), fmt.Errorf("holds %s, which is write-once", urlFor(dir)))
+		return "", nil
+	}
+	if info, err := os.Lstat(src); err == nil {
+		if err := j.placeError(src, target, info); err != nil {
+			j.addFailure(urlFor(src), err)
+			return "", nil
+		}
+	}
+	if err := os.Rename(src, target); err != nil {
+		j.addFailure(urlFor(src), err)
+		return "", nil
+	}
+	publishEvent(fileEvent{Type: "renamed", OldPath: urlFor(src), Path: urlFor(target), User: j.Owner, Source: "web"})
+	return status, nil
+}
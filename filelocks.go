@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Who holds a file. Locks taken by WebDAV clients are recorded as they go
+// through the LockSystem, with the owner the client sent and the user the
+// LOCK request came from, so a file someone has open in Word over WebDAV
+// can be shown as locked by them rather than by "a WebDAV client". File
+// responses for a locked file carry X-Locked-By, and the browser shows
+// such files read-only. A file another program has open exclusively
+// (a sharing violation on Windows) is answered with 423 Locked instead of
+// a bare 500.
+
+// lockedByHeader names the holder of a file's lock on file responses.
+const lockedByHeader = "X-Locked-By"
+
+// fileLock describes a lock on a file.
+type fileLock struct {
+	Owner   string    `json:"owner"`
+	Via     string    `json:"via"`               // "editor" or "webdav"
+	Expires time.Time `json:"expires,omitempty"` // zero for a lock without a timeout
+}
+
+// davLock is a LockSystem lock as ownerLS recorded it.
+type davLock struct {
+	root      string
+	zeroDepth bool
+	owner     string // from the lock's owner XML
+	user      string // who sent the LOCK request, once known
+	expires   time.Time
+}
+
+var (
+	davLocks   = map[string]*davLock{} // by token
+	davLocksMu sync.Mutex
+)
+
+// ownerLS is a LockSystem that remembers the locks it hands out.
+type ownerLS struct {
+	webdav.LockSystem
+}
+
+func (ls ownerLS) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	token, err := ls.LockSystem.Create(now, details)
+	if err != nil {
+		return token, err
+	}
+	davLocksMu.Lock()
+	davLocks[token] = &davLock{
+		root:      details.Root,
+		zeroDepth: details.ZeroDepth,
+		owner:     lockOwnerText(details.OwnerXML),
+		expires:   lockExpiry(now, details.Duration),
+	}
+	davLocksMu.Unlock()
+	return token, nil
+}
+
+func (ls ownerLS) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	davLocksMu.Lock()
+	if l, ok := davLocks[token]; ok {
+		if err != nil {
+			delete(davLocks, token)
+		} else {
+			l.expires = lockExpiry(now, duration)
+		}
+	}
+	davLocksMu.Unlock()
+	return details, err
+}
+
+func (ls ownerLS) Unlock(now time.Time, token string) error {
+	davLocksMu.Lock()
+	delete(davLocks, token)
+	davLocksMu.Unlock()
+	return ls.LockSystem.Unlock(now, token)
+}
+
+// lockExpiry is when a lock taken at now for duration lapses; a negative
+// duration is an infinite timeout.
+func lockExpiry(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+var xmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// lockOwnerText is the text of a lock's owner XML, typically a user name or
+// mailto: URL in a <D:href>.
+func lockOwnerText(ownerXML string) string {
+	text := html.UnescapeString(xmlTagPattern.ReplaceAllString(ownerXML, " "))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// lockHolder returns the lock on name (a slash path relative to the base
+// dir) held by an editor or a WebDAV client, if there is one.
+func lockHolder(name string) (fileLock, bool) {
+	now := time.Now()
+	editLocksMu.Lock()
+	l, ok := editLocks[name]
+	editLocksMu.Unlock()
+	if ok && now.Before(l.Expires) {
+		return fileLock{Owner: l.Owner, Via: "editor", Expires: l.Expires}, true
+	}
+	return davLockHolder(name)
+}
+
+// davLockHolder returns the WebDAV client's lock on name, if there is one.
+// It doesn't take editLocksMu, so lock code holding it can name a client.
+func davLockHolder(name string) (fileLock, bool) {
+	now := time.Now()
+	davLocksMu.Lock()
+	defer davLocksMu.Unlock()
+	for token, d := range davLocks {
+		if !d.expires.IsZero() && now.After(d.expires) {
+			delete(davLocks, token)
+			continue
+		}
+		if d.root != name && (d.zeroDepth || !strings.HasPrefix(name, strings.TrimSuffix(d.root, "/")+"/")) {
+			continue
+		}
+		if strings.HasSuffix(d.owner, "(web editor)") || d.owner == "web editor (saving)" {
+			continue // an editor lock, found above if it is current
+		}
+		owner := d.user
+		switch {
+		case owner == "" && d.owner != "":
+			owner = d.owner
+		case owner == "":
+			owner = "a WebDAV client"
+		case d.owner != "" && d.owner != owner:
+			owner += " (" + d.owner + ")"
+		}
+		return fileLock{Owner: owner, Via: "webdav", Expires: d.expires}, true
+	}
+	return fileLock{}, false
+}
+
+// davLockUserMiddleware notes who sent each successful LOCK request, so
+// lockHolder can name them.
+func davLockUserMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r)
+		if r.Method != "LOCK" {
+			return
+		}
+		token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+		if token == "" {
+			return
+		}
+		davLocksMu.Lock()
+		if l, ok := davLocks[token]; ok && l.user == "" {
+			l.user = requesterName(r)
+		}
+		davLocksMu.Unlock()
+	}
+}
+
+// Windows errors for a file another process has open without sharing it.
+const (
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+)
+
+// fileBusy reports whether err means another program has the file open
+// exclusively.
+func fileBusy(err error) bool {
+	return runtime.GOOS == "windows" && (errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation))
+}
+
+// serveFileBusy answers a request for a file another program has open.
+func serveFileBusy(w http.ResponseWriter, name string) {
+	http.Error(w, name+" is in use by another program and can't be read until it is closed", http.StatusLocked)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Recursive delete and copy, run as background jobs so huge trees report
+// progress and can be canceled. A failure on one entry doesn't stop the
+// rest; failed entries are listed on the job instead.
+//
+// /api/v1/batch runs delete, move or copy on several items in one request
+// and answers with a result per item, for selections that don't need a
+// progress bar.
+
+// At most this many failures are kept on a job.
+const maxJobFailures = 100
+
+// addFailure records a failed entry (a URL path) on the job.
+func (j *Job) addFailure(urlPath string, err error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.FailedCount++
+	if len(j.Failures) < maxJobFailures {
+		j.Failures = append(j.Failures, urlPath+": "+err.Error())
+	}
+}
+
+// partialFailure returns an error summarizing recorded failures, or nil.
+func (j *Job) partialFailure(verb string) error {
+	n := j.snapshot().FailedCount
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d items could not be %s", n, verb)
+}
+
+// eventSource is the Source of the file events the job's helpers publish:
+// batch requests run them on a scratch job with no ID.
+func (j *Job) eventSource() string {
+	if j.ID == "" {
+		return "web"
+	}
+	return "job"
+}
+
+// countEntries returns the number of files and directories under root,
+// including root itself.
+func countEntries(ctx context.Context, root string) int64 {
+	var n int64
+	filepath.Walk(root, func(_ string, _ os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n++
+		return nil
+	})
+	return n
+}
+
+// urlFor maps a path under the base directory back to its URL path.
+func urlFor(fullPath string) string {
+	rel, err := filepath.Rel(getBaseDir(), fullPath)
+	if err != nil {
+		return fullPath
+	}
+	return path.Join("/", filepath.ToSlash(rel))
+}
+
+// deleteJob removes each of fullPaths, children before parents, counting
+// progress in entries.
+func deleteJob(ctx context.Context, j *Job, fullPaths []string) error {
+	var total int64
+	for _, p := range fullPaths {
+		total += countEntries(ctx, p)
+	}
+	j.setProgress(0, total, "Deleting")
+
+	for _, root := range fullPaths {
+		err := deleteTree(ctx, j, root)
+		if _, statErr := os.Lstat(root); os.IsNotExist(statErr) {
+			publishEvent(fileEvent{Type: "deleted", Path: urlFor(root), User: j.Owner, Source: "job"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := j.partialFailure("deleted"); err != nil {
+		return err
+	}
+	j.complete(fmt.Sprintf("Deleted %d items", total), "")
+	return nil
+}
+
+// deleteTree removes root depth-first. It only returns an error when the
+// job is canceled; other failures are recorded and skipped, leaving the
+// parent directory in place.
+func deleteTree(ctx context.Context, j *Job, root string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		j.addFailure(urlFor(root), err)
+		return nil
+	}
+	// Checked per entry: the caller only checked root and what's above it
+	if writeOnceLocked(root) {
+		j.addFailure(urlFor(root), errors.New("in a write-once folder"))
+		return nil
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			j.addFailure(urlFor(root), err)
+			return nil
+		}
+		for _, e := range entries {
+			if err := deleteTree(ctx, j, filepath.Join(root, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(root); err != nil {
+		j.addFailure(urlFor(root), err)
+		return nil
+	}
+	j.addProgress(1, urlFor(root))
+	return nil
+}
+
+// copyJob copies each of fullPaths into destDir, counting progress in bytes.
+// destURL is destDir as the requester sees it. policy is what to do about
+// names already taken there (see fileconflict.go).
+func copyJob(ctx context.Context, j *Job, fullPaths []string, destDir, destURL, policy string) error {
+	var total int64
+	for _, p := range fullPaths {
+		total += treeSize(p)
+	}
+	j.setProgress(0, total, "Copying")
+
+	for _, src := range fullPaths {
+		dst := filepath.Join(destDir, filepath.Base(src))
+		if (dst == src && policy != conflictRename) || strings.HasPrefix(destDir+string(filepath.Separator), src+string(filepath.Separator)) {
+			j.addFailure(urlFor(src), fmt.Errorf("cannot copy into itself"))
+			continue
+		}
+		dst, status, err := placeItem(src, dst, policy)
+		if err != nil {
+			j.addFailure(urlFor(src), err)
+			continue
+		}
+		if status == "skipped" {
+			continue
+		}
+		err = copyTree(ctx, j, src, dst, policy)
+		if info, statErr := os.Lstat(dst); statErr == nil && status != "merged" {
+			publishEvent(fileEvent{Type: "created", Path: urlFor(dst), IsDir: info.IsDir(), User: j.Owner, Source: "job"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := j.partialFailure("copied"); err != nil {
+		return err
+	}
+	j.complete("Copied", destURL)
+	return nil
+}
+
+// copyTree copies src to dst preserving modes and modification times,
+// merging into a folder already at dst if policy allows. Like deleteTree it
+// only returns an error on cancellation.
+func copyTree(ctx context.Context, j *Job, src, dst, policy string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		j.addFailure(urlFor(src), err)
+		return nil
+	}
+	dst, status, err := placeItem(src, dst, policy)
+	if err != nil {
+		j.addFailure(urlFor(src), err)
+		return nil
+	}
+	// Folders are checked as their entries are copied
+	if j.place != nil && status != "skipped" && status != "merged" {
+		if err := j.place(dst, info); err != nil {
+			j.addFailure(urlFor(src), err)
+			return nil
+		}
+	}
+
+	switch {
+	case status == "skipped":
+		return nil
+	case info.IsDir():
+		entries, err := os.ReadDir(src)
+		if err == nil && status != "merged" {
+			err = os.Mkdir(dst, info.Mode().Perm()|0700)
+		}
+		if err != nil {
+			j.addFailure(urlFor(src), err)
+			return nil
+		}
+		sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+		for _, e := range entries {
+			from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+			prev, prevErr := os.Lstat(to)
+			if err := copyTree(ctx, j, from, to, policy); err != nil {
+				return err
+			}
+			// Only the top of a copy is announced, except in a merge, where
+			// each entry added or replaced is
+			if status != "merged" {
+				continue
+			}
+			if now, err := os.Lstat(to); err == nil && prevErr != nil {
+				publishEvent(fileEvent{Type: "created", Path: urlFor(to), IsDir: now.IsDir(), User: j.Owner, Source: j.eventSource()})
+			} else if err == nil && policy == conflictOverwrite && !e.IsDir() && !prev.IsDir() {
+				publishEvent(fileEvent{Type: "modified", Path: urlFor(to), User: j.Owner, Source: j.eventSource()})
+			}
+		}
+		if status == "merged" {
+			return nil // the folder there keeps its own mode and time
+		}
+		os.Chmod(dst, info.Mode().Perm())
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err == nil && status == "replaced" {
+			err = os.Remove(dst)
+		}
+		if err == nil {
+			err = os.Symlink(target, dst)
+		}
+		if err != nil {
+			j.addFailure(urlFor(src), err)
+		}
+		return nil
+	case info.Mode().IsRegular():
+		if err := copyRegular(ctx, j, src, dst, info, status == "replaced"); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			j.addFailure(urlFor(src), err)
+			return nil
+		}
+	default:
+		// Sockets, devices and pipes are skipped
+		return nil
+	}
+	os.Chtimes(dst, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// copyRegular copies one file, removing the partial copy on failure. A
+// file replacing one at dst is written beside it and renamed over it, so a
+// failed copy leaves the old one.
+func copyRegular(ctx context.Context, j *Job, src, dst string, info os.FileInfo, replace bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var out *os.File
+	if replace {
+		out, err = os.CreateTemp(filepath.Dir(dst), ".goserve-copy-*")
+		if err == nil {
+			out.Chmod(info.Mode().Perm())
+		}
+	} else {
+		out, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(progressWriter{ctx, out, j}, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && replace {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// At most this many paths are accepted in one batch request.
+const maxBatchPaths = 10000
+
+// batchResult is the outcome of a batch operation on one item.
+type batchResult struct {
+	Path    string `json:"path"`
+	OK      bool   `json:"ok"`
+	NewPath string `json:"newPath,omitempty"`
+	Status  string `json:"status,omitempty"` // for a move or copy: created, replaced, renamed, merged or skipped
+	Error   string `json:"error,omitempty"`
+}
+
+// handleBatch serves POST /api/v1/batch:
+//
+//	{"op": "delete", "paths": ["/a", "/b"]}
+//	{"op": "move", "paths": ["/a"], "dest": "/dir"}
+//	{"op": "copy", "paths": ["/a"], "dest": "/dir", "conflict": "rename"}
+//
+// Every item is attempted, in order, whatever happened to the ones before
+// it. Items are all-or-nothing where the filesystem allows: a move is a
+// single rename, and a copy that fails part way is removed again. A delete
+// that fails part way leaves what it couldn't remove, and so does a move
+// or copy merged into a folder that was already there.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Op       string   `json:"op"`
+		Paths    []string `json:"paths"`
+		Dest     string   `json:"dest"`
+		Conflict string   `json:"conflict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	switch {
+	case req.Op != "delete" && req.Op != "move" && req.Op != "copy":
+		jsonError(w, http.StatusBadRequest, "Unknown operation (valid: delete, move, copy)")
+		return
+	case req.Conflict != "" && !validConflictPolicy(req.Conflict):
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("Invalid conflict policy %q (use overwrite, skip or rename)", req.Conflict))
+		return
+	case len(req.Paths) == 0:
+		jsonError(w, http.StatusBadRequest, "No files specified")
+		return
+	case len(req.Paths) > maxBatchPaths:
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("Too many files (at most %d)", maxBatchPaths))
+		return
+	}
+
+	var destDir string
+	if req.Op != "delete" {
+		var ok bool
+		if destDir, ok = resolvePathFor(r, req.Dest); !ok || req.Dest == "" {
+			jsonError(w, http.StatusBadRequest, "Invalid destination")
+			return
+		}
+		if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+			jsonError(w, http.StatusNotFound, "Destination folder not found")
+			return
+		}
+		if !capabilitiesFor(r, destDir).Upload {
+			deny(w, r, destDir, "upload")
+			return
+		}
+	}
+
+	results := make([]batchResult, 0, len(req.Paths))
+	failed := 0
+	for _, p := range req.Paths {
+		res := batchItem(r, req.Op, p, destDir, req.Conflict)
+		if !res.OK {
+			failed++
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, map[string]any{"success": failed == 0, "failed": failed, "results": results})
+}
+
+// batchItem applies op to one path of a batch, with policy for a move or
+// copy whose name is taken.
+func batchItem(r *http.Request, op, p, destDir, policy string) batchResult {
+	res := batchResult{Path: path.Clean("/" + p)}
+	fail := func(err error) batchResult {
+		res.Error = err.Error()
+		return res
+	}
+	fullPath, ok := resolvePathFor(r, p)
+	if !ok || fullPath == filepath.Clean(baseDirFor(r)) {
+		return fail(errors.New("invalid path"))
+	}
+	if _, err := os.Lstat(fullPath); err != nil {
+		return fail(errors.New("not found"))
+	}
+	caps := capabilitiesFor(r, fullPath)
+	ctx := r.Context()
+	// Scratch job: collects the failures of the recursive helpers
+	j := &Job{Owner: requesterName(r), place: placeRefusal(r)}
+
+	if op == "delete" {
+		if !caps.Delete {
+			return fail(denyError(r, fullPath, "delete"))
+		}
+		err := deleteTree(ctx, j, fullPath)
+		if _, statErr := os.Lstat(fullPath); os.IsNotExist(statErr) {
+			emitFileEvent(r, "deleted", fullPath, "web")
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if j.FailedCount > 0 {
+			return fail(fmt.Errorf("%d entries could not be deleted (%s)", j.FailedCount, j.Failures[0]))
+		}
+		res.OK = true
+		return res
+	}
+
+	if op == "move" && !caps.Rename {
+		return fail(denyError(r, fullPath, "rename"))
+	}
+	if op == "copy" && !caps.Copy {
+		return fail(denyError(r, fullPath, "copy"))
+	}
+	dst := filepath.Join(destDir, filepath.Base(fullPath))
+	if dst == fullPath && (op == "move" || policy != conflictRename) {
+		return fail(errors.New("already in that folder"))
+	}
+	if strings.HasPrefix(destDir+string(filepath.Separator), fullPath+string(filepath.Separator)) {
+		return fail(fmt.Errorf("cannot %s into itself", op))
+	}
+	if !capabilitiesFor(r, dst).Upload {
+		return fail(denyError(r, dst, "upload"))
+	}
+	if _, err := os.Lstat(dst); err == nil && policy == "" {
+		return fail(fmt.Errorf("%s already exists", urlForRequest(r, dst)))
+	}
+	dst, status, err := placeItem(fullPath, dst, policy)
+	if err != nil {
+		return fail(err)
+	}
+	res.Status = status
+	if status == "skipped" {
+		res.OK = true
+		return res
+	}
+	res.NewPath = urlForRequest(r, dst)
+
+	if op == "move" {
+		if _, err := moveTree(ctx, j, fullPath, dst, policy); err != nil {
+			return fail(err)
+		}
+		if j.FailedCount > 0 {
+			return fail(fmt.Errorf("%d entries could not be moved (%s)", j.FailedCount, j.Failures[0]))
+		}
+		res.OK = true
+		return res
+	}
+
+	if err := quotaError(r, dst, treeSize(fullPath), 0); err != nil {
+		return fail(err)
+	}
+	err = copyTree(ctx, j, fullPath, dst, policy)
+	if err == nil && j.FailedCount > 0 {
+		err = fmt.Errorf("%d entries could not be copied (%s)", j.FailedCount, j.Failures[0])
+	}
+	if err != nil {
+		if status != "merged" && status != "replaced" {
+			os.RemoveAll(dst)
+		}
+		return fail(err)
+	}
+	if status != "merged" {
+		emitFileEvent(r, "created", dst, "web")
+	}
+	res.OK = true
+	return res
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Per-entry filesystem errors. A listing, archive or search that meets an
+// entry it can't stat or open (no permission, a broken symlink, a file
+// another program holds open on Windows) carries on without it and says
+// so, rather than failing the whole request or dropping the entry
+// silently: listings show the entry greyed out with the reason, archives
+// count what they left out (in a trailer, and in a ZIP's comment), and
+// searches report how many entries they couldn't read.
+
+// entryError is an entry a walk couldn't read.
+type entryError struct {
+	Path   string `json:"path"` // archive name, or path relative to the folder walked
+	Reason string `json:"error"`
+}
+
+func (e *entryError) Error() string { return e.Path + ": " + e.Reason }
+
+// Response header (a trailer on streamed archives) with the number of
+// entries an archive left out.
+const archiveSkippedHeader = "X-Archive-Skipped"
+
+// entryErrorReason says briefly why fullPath couldn't be read.
+func entryErrorReason(fullPath string, err error) string {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return "Permission denied"
+	case errors.Is(err, fs.ErrNotExist):
+		if info, lerr := os.Lstat(fullPath); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "Broken link"
+		}
+		return "No longer exists"
+	case errors.Is(err, syscall.ELOOP):
+		return "Link loop"
+	case fileBusy(err):
+		return "In use by another program"
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	msg := err.Error()
+	if msg == "" {
+		return "Cannot read"
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// skippedComment describes the entries an archive left out, for its
+// comment.
+func skippedComment(skipped []entryError) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d entries could not be read and are missing, or incomplete where it says so:\n", len(skipped))
+	for i, e := range skipped {
+		line := e.Error() + "\n"
+		// ZIP comments are limited to 64 KB
+		if b.Len()+len(line) > 60000 {
+			fmt.Fprintf(&b, "... and %d more\n", len(skipped)-i)
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// setSkipped reports how many entries an archive left out, as a header or,
+// once the body has started, a declared trailer.
+func setSkipped(w http.ResponseWriter, skipped []entryError) {
+	w.Header().Set(archiveSkippedHeader, fmt.Sprint(len(skipped)))
+}
+
+// walkArchive walks root, adding each entry below it with add under its
+// slash-separated name relative to relBase, except those an ignore file
+// leaves out. Entries the walk can't read,
+// and those add rejects with an *entryError, are skipped and returned;
+// any other error from add ends the walk.
+func walkArchive(root, relBase string, add func(fullPath, name string, info os.FileInfo) error) ([]entryError, error) {
+	var skipped []entryError
+	ignore := ignoreWalker{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		relPath, _ := filepath.Rel(relBase, p)
+		name := filepath.ToSlash(relPath)
+		if err != nil {
+			if relPath == "." || (p == root && info == nil) {
+				return err
+			}
+			skipped = append(skipped, entryError{name, entryErrorReason(p, err)})
+			return nil
+		}
+		if p != root && ignore.skip(p, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath == "." {
+			return nil
+		}
+		err = add(p, name, info)
+		var skip *entryError
+		if errors.As(err, &skip) {
+			skipped = append(skipped, *skip)
+			return nil
+		}
+		return err
+	})
+	return skipped, err
+}
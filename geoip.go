@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Optional GeoIP tagging and country allow/deny rules, backed by a MaxMind
+// (GeoLite2/GeoIP2 Country or City) database loaded with -geoip.
+
+var (
+	geoDB        *maxminddb.Reader
+	geoAllow     map[string]bool
+	geoDeny      map[string]bool
+	geoAllowList string
+	geoDenyList  string
+)
+
+func loadGeoIP(path string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	geoDB = db
+	geoAllow = parseCountryList(geoAllowList)
+	geoDeny = parseCountryList(geoDenyList)
+	return nil
+}
+
+// parseCountryList turns "us, CA" into a set of upper-case ISO codes.
+func parseCountryList(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+// requestCountry returns the ISO country code for the client, or "" when
+// GeoIP is off or the address is private/unknown.
+func requestCountry(r *http.Request) string {
+	if geoDB == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := geoDB.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// geoMiddleware counts requests per country and enforces -geoip-allow and
+// -geoip-deny. Clients without a country (LAN, loopback) are always allowed.
+func geoMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if geoDB == nil {
+			next(w, r)
+			return
+		}
+		country := requestCountry(r)
+		if country == "" {
+			addMetric("goserve_requests_by_country_total", 1, "country", "unknown")
+			next(w, r)
+			return
+		}
+		addMetric("goserve_requests_by_country_total", 1, "country", country)
+		if geoDeny[country] || (len(geoAllow) > 0 && !geoAllow[country]) {
+			addMetric("goserve_geoip_denied_total", 1, "country", country)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
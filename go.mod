@@ -3,6 +3,18 @@ module goserve
 go 1.25.6
 
 require (
+	filippo.io/age v1.2.1
+	github.com/klauspost/compress v1.18.0
+	github.com/oapi-codegen/runtime v1.7.0
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/russross/blackfriday/v2 v2.1.0
+	golang.org/x/crypto v0.48.0
 	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
+)
+
+require (
+	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
 )
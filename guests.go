@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Guest accounts. The administrators who may change runtime settings can
+// add temporary users through /api/v1/admin/guests or "Guest Accounts" in
+// the footer menu — a contractor with a week of readwrite access, say —
+// without editing the logins file. A guest has a permission with path
+// rules and an optional home, as a logins line would, and stops working
+// after a duration or at a date. Guests are kept in the -state directory
+// (in memory only without it), shared by instances that share it.
+//
+// Logins file users can expire too, with expires=2026-12-31 (or an RFC 3339
+// time) after the permission: alice:pw:readwrite,expires=2026-12-31. A
+// date lasts to the end of that day, server time.
+//
+// An expired account is refused however it signs in — Basic credentials in
+// the web UI, the API and WebDAV, the login page, and sessions started
+// before it expired. Expired guests stay listed, so an administrator can
+// extend them, until guestKeepExpired has passed.
+
+type guestAccount struct {
+	Username   string     `json:"username"`
+	Password   string     `json:"password"` // bcrypt hash
+	Permission string     `json:"permission"`
+	Rules      []pathRule `json:"rules,omitempty"`
+	Home       string     `json:"home,omitempty"`
+	Expires    time.Time  `json:"expires"`
+	Created    time.Time  `json:"created"`
+	CreatedBy  string     `json:"createdBy"`
+}
+
+// guestKeepExpired is how long an expired guest is kept before it is
+// removed for good.
+const guestKeepExpired = 30 * 24 * time.Hour
+
+var (
+	guests   = map[string]*guestAccount{}
+	guestsMu sync.Mutex
+)
+
+func loadGuests() {
+	if err := loadState("guests", &guests); err != nil {
+		log.Printf("Cannot load guest accounts: %v", err)
+	}
+	shareState("guests", &guestsMu, &guests)
+}
+
+// updateGuests runs change on the guests under guestsMu and persists them.
+func updateGuests(change func()) error {
+	return updateState("guests", &guestsMu, &guests, func() {
+		for name, g := range guests {
+			if time.Since(g.Expires) > guestKeepExpired {
+				delete(guests, name)
+			}
+		}
+		change()
+	})
+}
+
+func (g *guestAccount) user() User {
+	return User{
+		Username:   g.Username,
+		Password:   g.Password,
+		Permission: g.Permission,
+		Rules:      g.Rules,
+		Home:       g.Home,
+		Expires:    g.Expires,
+	}
+}
+
+// expired reports whether u's account has run out.
+func (u *User) expired() bool {
+	return !u.Expires.IsZero() && time.Now().After(u.Expires)
+}
+
+// findUser returns the account called name, from the logins file or the
+// guests, whether or not it has expired.
+func findUser(name string) (User, bool) {
+	if u, ok := users[name]; ok {
+		return u, true
+	}
+	lookup := func() (User, bool) {
+		guestsMu.Lock()
+		defer guestsMu.Unlock()
+		if g, ok := guests[name]; ok {
+			return g.user(), true
+		}
+		return User{}, false
+	}
+	u, ok := lookup()
+	if !ok && refreshState("guests", &guestsMu, &guests) {
+		u, ok = lookup()
+	}
+	return u, ok
+}
+
+// lookupUser returns the account called name if it may sign in.
+func lookupUser(name string) (User, bool) {
+	u, ok := findUser(name)
+	if !ok || u.expired() {
+		return User{}, false
+	}
+	return u, true
+}
+
+// cutExpiry removes expires=<date> from a logins file permission field.
+func cutExpiry(field string) (string, time.Time, error) {
+	var kept []string
+	var expires time.Time
+	for part := range strings.SplitSeq(field, ",") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(part), "expires=")
+		if !ok {
+			kept = append(kept, part)
+			continue
+		}
+		t, err := parseExpiryTime(strings.TrimSpace(v))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		expires = t
+	}
+	return strings.Join(kept, ","), expires, nil
+}
+
+// parseExpiryTime parses a date, which lasts to the end of that day, or an
+// RFC 3339 time.
+func parseExpiryTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad expiry %q (want a date such as 2026-12-31)", s)
+}
+
+// parseGuestExpiry parses a guest's expiry: a duration from now such as
+// 7d or 12h, or a time parseExpiryTime accepts. It must be in the future.
+func parseGuestExpiry(s string) (time.Time, error) {
+	t, err := parseExpiryTime(s)
+	if err != nil {
+		d, derr := parseDuration(s)
+		if derr != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("expires must be a duration such as 7d or a date such as 2026-12-31")
+		}
+		t = time.Now().Add(d)
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("expires must be in the future")
+	}
+	return t, nil
+}
+
+// permissionField writes a permission and its path rules as a logins
+// file does.
+func permissionField(permission string, rules []pathRule) string {
+	s := permission
+	for _, r := range rules {
+		s += "," + r.Path + "=" + r.Permission
+	}
+	return s
+}
+
+// guestInfo is an account as the guest API lists it.
+type guestInfo struct {
+	Username   string    `json:"username"`
+	Permission string    `json:"permission"` // with path rules, as in a logins file
+	Home       string    `json:"home,omitempty"`
+	Expires    time.Time `json:"expires"`
+	Expired    bool      `json:"expired"`
+	Source     string    `json:"source"` // guest, or logins for a logins file user who expires
+	Created    time.Time `json:"created,omitzero"`
+	CreatedBy  string    `json:"createdBy,omitempty"`
+}
+
+func guestInfoFor(u User, source string) guestInfo {
+	return guestInfo{
+		Username:   u.Username,
+		Permission: permissionField(u.Permission, u.Rules),
+		Home:       u.Home,
+		Expires:    u.Expires,
+		Expired:    u.expired(),
+		Source:     source,
+	}
+}
+
+func (g *guestAccount) info() guestInfo {
+	info := guestInfoFor(g.user(), "guest")
+	info.Created, info.CreatedBy = g.Created, g.CreatedBy
+	return info
+}
+
+// handleGuests serves the guest account API, for the administrators who
+// may change runtime settings:
+//
+//	GET    /api/v1/admin/guests         guests, and logins file users who expire
+//	POST   /api/v1/admin/guests         {"username": "dana", "permission": "readwrite,/in=all", "expires": "7d"}
+//	PATCH  /api/v1/admin/guests/{name}  {"expires": "2026-12-31"}
+//	DELETE /api/v1/admin/guests/{name}  remove now
+//
+// A guest created without a password gets a random one, returned once.
+func handleGuests(w http.ResponseWriter, r *http.Request) {
+	if !settingsAdmin(r) {
+		jsonError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/guests"), "/")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if name == "" {
+		switch r.Method {
+		case "GET":
+			list := []guestInfo{}
+			for _, u := range users {
+				if !u.Expires.IsZero() {
+					list = append(list, guestInfoFor(u, "logins"))
+				}
+			}
+			guestsMu.Lock()
+			for _, g := range guests {
+				list = append(list, g.info())
+			}
+			guestsMu.Unlock()
+			sort.Slice(list, func(a, b int) bool { return list[a].Expires.Before(list[b].Expires) })
+			writeJSON(w, map[string]any{"success": true, "guests": list})
+		case "POST":
+			createGuest(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	var expires time.Time
+	switch r.Method {
+	case "PATCH":
+		var req struct {
+			Expires string `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		var err error
+		if expires, err = parseGuestExpiry(req.Expires); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "DELETE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var g guestAccount
+	var old time.Time
+	found := false
+	err := updateGuests(func() {
+		existing := guests[name]
+		if found = existing != nil; !found {
+			return
+		}
+		old = existing.Expires
+		if r.Method == "DELETE" {
+			delete(guests, name)
+		} else {
+			existing.Expires = expires
+		}
+		g = *existing
+	})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot save guest accounts: "+err.Error())
+		return
+	}
+	if !found {
+		jsonError(w, http.StatusNotFound, "No such guest")
+		return
+	}
+	if r.Method == "DELETE" {
+		log.Printf("Guest %s removed by %s from %s", name, requesterName(r), authClientIP(r))
+		writeJSON(w, map[string]any{"success": true})
+		return
+	}
+	log.Printf("Guest %s changed by %s from %s: expires %s -> %s", name, requesterName(r), authClientIP(r),
+		old.Format(time.RFC3339), expires.Format(time.RFC3339))
+	writeJSON(w, map[string]any{"success": true, "guest": g.info()})
+}
+
+func createGuest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		Permission string `json:"permission"`
+		Home       string `json:"home"`
+		Expires    string `json:"expires"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || len(req.Username) > 64 || strings.ContainsAny(req.Username, ": \t/\\") {
+		jsonError(w, http.StatusBadRequest, "Username must be 1 to 64 characters without spaces, colons or slashes")
+		return
+	}
+	permission, rules, err := parsePermission(req.Permission)
+	if err == nil && !validPermissions[permission] {
+		err = fmt.Errorf("permission must be readonly, readwrite or all")
+	}
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expires, err := parseGuestExpiry(req.Expires)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	g := &guestAccount{
+		Username:   req.Username,
+		Permission: permission,
+		Rules:      rules,
+		Home:       strings.TrimSpace(req.Home),
+		Expires:    expires,
+		Created:    time.Now(),
+		CreatedBy:  requesterName(r),
+	}
+	u := g.user()
+	if dir, ok := userHome(&u); ok && dir == "" {
+		jsonError(w, http.StatusBadRequest, "The home must be inside "+getBaseDir())
+		return
+	}
+	password := req.Password
+	if password == "" {
+		password = rand.Text()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Cannot use that password: "+err.Error())
+		return
+	}
+	g.Password = string(hash)
+
+	if _, ok := users[g.Username]; ok {
+		jsonError(w, http.StatusConflict, g.Username+" is in the logins file")
+		return
+	}
+	taken := false
+	err = updateGuests(func() {
+		if taken = guests[g.Username] != nil; !taken {
+			guests[g.Username] = g
+		}
+	})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot save guest accounts: "+err.Error())
+		return
+	}
+	if taken {
+		jsonError(w, http.StatusConflict, "There is already a guest called "+g.Username)
+		return
+	}
+	log.Printf("Guest %s (%s) added by %s from %s, expires %s", g.Username, permissionField(g.Permission, g.Rules),
+		g.CreatedBy, authClientIP(r), g.Expires.Format(time.RFC3339))
+	resp := map[string]any{"success": true, "guest": g.info()}
+	if req.Password == "" {
+		resp["password"] = password
+	}
+	writeJSON(w, resp)
+}
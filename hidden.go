@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Hidden files. Listings leave out dotfiles (.git, .DS_Store, .env) and, on
+// Windows, files with the hidden attribute, unless -show-hidden is set.
+// Anyone can show them for the rest of their browser session with Show
+// Hidden Files in the folder menu, which sets a session cookie, and scripts
+// can ask with ?hidden=1 (or ?hidden=0 to leave them out whatever the
+// cookie says). Searching subfolders and Find in Files follow the same
+// setting. Hiding is only tidiness: a hidden file is still there for
+// anyone who knows its name, and in ZIP and TAR downloads.
+
+var showHidden bool
+
+const hiddenCookie = "goserve_hidden"
+
+// hiddenShown reports whether r's listings and searches include hidden
+// files.
+func hiddenShown(r *http.Request) bool {
+	switch r.URL.Query().Get("hidden") {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	if c, err := r.Cookie(hiddenCookie); err == nil {
+		return c.Value == "1"
+	}
+	return showHidden
+}
+
+// isHidden reports whether an entry called name is hidden. info may be nil
+// for an entry that couldn't be read, which is then judged by its name.
+func isHidden(name string, info os.FileInfo) bool {
+	return strings.HasPrefix(name, ".") || (info != nil && hiddenAttr(info))
+}
+
+// inHiddenFolder reports whether fullPath, under dir, is a dotfile or is in
+// a dot folder below dir, for results that come without file info.
+func inHiddenFolder(dir, fullPath string) bool {
+	rel, err := filepath.Rel(dir, fullPath)
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(name, ".") && name != ".." && name != "." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// Only Windows has a hidden attribute; elsewhere the name says it all.
+func hiddenAttr(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Per-user home directories. A line in the logins file may name a fourth
+// field, the user's home: alice:<hash>:readwrite:/srv/files/users/alice, or
+// users/alice relative to -dir. The web UI, the API and WebDAV then treat
+// that folder as the root for that user: "/" is their home and nothing
+// outside it can be reached. The home must be inside -dir, so the paths
+// the rest of the server keeps (events, tags, share links, rules, edit
+// locks) stay relative to -dir and name the same file for everyone.
+
+type homeKey struct{}
+
+// userHome returns the directory user is confined to; ok is false if they
+// have none. A home outside the base directory is refused with ok true
+// and an empty dir.
+func userHome(user *User) (dir string, ok bool) {
+	if user == nil || user.Home == "" {
+		return "", false
+	}
+	baseDir := getBaseDir()
+	dir = user.Home
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(baseDir, dir)
+	}
+	dir = filepath.Clean(dir)
+	if !isUnderDir(dir, baseDir) {
+		return "", true
+	}
+	return dir, true
+}
+
+// withHome confines r to dir.
+func withHome(r *http.Request, dir string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), homeKey{}, dir))
+}
+
+// homeOf returns the home r is confined to, if any.
+func homeOf(r *http.Request) (string, bool) {
+	dir, ok := r.Context().Value(homeKey{}).(string)
+	return dir, ok
+}
+
+// baseDirFor returns the root of what r may see: the user's home, or the
+// base directory.
+func baseDirFor(r *http.Request) string {
+	if dir, ok := homeOf(r); ok {
+		return dir
+	}
+	return getBaseDir()
+}
+
+// resolvePathFor is resolvePath for a path as r sees it.
+func resolvePathFor(r *http.Request, urlPath string) (string, bool) {
+	baseDir := baseDirFor(r)
+	fullPath := filepath.Join(baseDir, filepath.Clean("/"+urlPath))
+	return fullPath, reachable(fullPath, baseDir)
+}
+
+// urlForRequest is urlFor as r sees it: relative to the user's home.
+func urlForRequest(r *http.Request, fullPath string) string {
+	rel, err := filepath.Rel(baseDirFor(r), fullPath)
+	if err != nil {
+		return fullPath
+	}
+	return path.Join("/", filepath.ToSlash(rel))
+}
+
+// checkHomes warns at startup about homes that can't be served.
+func checkHomes() {
+	for _, u := range users {
+		dir, ok := userHome(&u)
+		switch {
+		case !ok:
+		case dir == "":
+			log.Printf("Warning: home %s of %s is outside %s; they will be refused", u.Home, u.Username, getBaseDir())
+		default:
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				log.Printf("Warning: home %s of %s is not a directory", dir, u.Username)
+			}
+		}
+	}
+}
+
+// WebDAV handlers for home folders, one per home, sharing the main
+// LockSystem.
+var (
+	homeDavHandlers   = map[string]*webdav.Handler{}
+	homeDavHandlersMu sync.Mutex
+)
+
+func homeDavHandler(dir string, logger func(*http.Request, error)) *webdav.Handler {
+	homeDavHandlersMu.Lock()
+	defer homeDavHandlersMu.Unlock()
+	h, ok := homeDavHandlers[dir]
+	if !ok {
+		h = &webdav.Handler{
+			FileSystem: davFileSystem(dir),
+			LockSystem: homeLS{lockSystem, dir},
+			Logger:     logger,
+		}
+		homeDavHandlers[dir] = h
+	}
+	return h
+}
+
+// homeLS is the shared LockSystem as seen from a home folder. Names come in
+// relative to the home and are locked by their path from the base
+// directory, so a DAV lock in a home holds against the web editor and
+// everyone else's DAV clients.
+type homeLS struct {
+	webdav.LockSystem
+	dir string
+}
+
+func (ls homeLS) full(name string) string {
+	if name == "" {
+		return ""
+	}
+	return path.Join(urlFor(ls.dir), name)
+}
+
+func (ls homeLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	return ls.LockSystem.Confirm(now, ls.full(name0), ls.full(name1), conditions...)
+}
+
+func (ls homeLS) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = ls.full(details.Root)
+	return ls.LockSystem.Create(now, details)
+}
+
+func (ls homeLS) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err == nil {
+		details.Root = path.Join("/", strings.TrimPrefix(details.Root, urlFor(ls.dir)))
+	}
+	return details, err
+}
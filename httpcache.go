@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Caching headers for served files. Every file carries a strong ETag and
+// Last-Modified, and a request for an unchanged file with If-None-Match or
+// If-Modified-Since gets a 304; folder pages and ?format=json listings
+// carry an ETag of their own and are revalidated the same way. By default
+// browsers have to revalidate a file on every use (Cache-Control:
+// no-cache). -cache-control gives files a max-age by content type instead,
+// such as "image/*=7d,video/*=7d,text/css=1h,*=0", for shares whose files
+// rarely change; the first pattern that matches wins and 0 means
+// revalidate. Files fetched by a signed-in user are cached "private", so
+// shared caches never keep them.
+//
+// -etag hash bases the ETag on the file's SHA-256 instead of its size and
+// modification time, so a file saved again unchanged, or restored from a
+// backup, still validates. Files up to hashETagMaxSize are hashed when
+// first requested; larger ones use size and time until their hash has
+// been worked out in the background.
+
+var (
+	cachePolicy []cacheRule
+	etagMode    string // "mtime" or "hash"
+)
+
+const hashETagMaxSize = 16 << 20
+
+type cacheRule struct {
+	pattern string // media type, type/*, or *
+	maxAge  time.Duration
+}
+
+// parseCachePolicy parses -cache-control: pattern=age pairs separated by
+// commas.
+func parseCachePolicy(spec string) ([]cacheRule, error) {
+	var rules []cacheRule
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, age, ok := strings.Cut(part, "=")
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if !ok || (pattern != "*" && !strings.Contains(pattern, "/")) {
+			return nil, fmt.Errorf("%q is not type=age, such as image/*=7d", part)
+		}
+		d, err := parseDuration(strings.TrimSpace(age))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("bad age in %q", part)
+		}
+		rules = append(rules, cacheRule{pattern, d})
+	}
+	return rules, nil
+}
+
+// maxAgeFor returns the max-age -cache-control gives a file of media type
+// mt, 0 if none does.
+func maxAgeFor(mt string) time.Duration {
+	for _, rule := range cachePolicy {
+		prefix, wildcard := strings.CutSuffix(rule.pattern, "*")
+		if rule.pattern == mt || (wildcard && strings.HasPrefix(mt, prefix)) {
+			return rule.maxAge
+		}
+	}
+	return 0
+}
+
+// setFileCaching sets Cache-Control and ETag for a file response.
+func setFileCaching(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo) {
+	h := w.Header()
+	h.Set("ETag", fileETag(fullPath, info))
+	mt, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(fullPath)))
+	maxAge := maxAgeFor(mt)
+	if maxAge <= 0 {
+		h.Set("Cache-Control", "no-cache")
+		return
+	}
+	scope := "public"
+	if getUserFromRequest(r) != nil {
+		scope = "private"
+	}
+	h.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int64(maxAge/time.Second)))
+}
+
+// fileETag returns a strong validator for a regular file: by default size
+// plus the nanosecond modification time, which changes whenever the
+// content is rewritten, and with -etag hash the content's SHA-256 once it
+// is known.
+func fileETag(fullPath string, info os.FileInfo) string {
+	if etagMode == "hash" {
+		sum := cachedChecksum(fullPath, info)
+		if sum == "" && info.Size() <= hashETagMaxSize {
+			sum, _ = hashFile(fullPath)
+		}
+		if sum != "" {
+			return `"` + sum[:32] + `"`
+		}
+		hashInBackground(fullPath)
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
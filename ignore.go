@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// Ignore files. A .goserveignore in any folder lists, in .gitignore
+// syntax, entries of that folder and those below it that the server
+// should act as if weren't there: listings, searches, the content index
+// and ZIP and TAR downloads leave them out, WebDAV doesn't show them, and
+// opening or changing one, over the web or WebDAV, answers as if it didn't
+// exist. A file uploaded under an ignored name is saved but drops out of
+// sight with the rest. This is for keeping secrets or build output out of
+// a shared tree without moving them.
+//
+// As in .gitignore, a pattern without a slash matches a name at any depth,
+// one with a slash (other than at the end) matches the path from the
+// ignore file's folder, a trailing slash matches only folders, ** matches
+// any number of folders, and ! puts back something an earlier pattern
+// left out; later lines and deeper files win. Everything inside an
+// ignored folder is ignored with it. The ignore files themselves are
+// never listed or served, so only people with access to the disk see or
+// change them, and the drop-box directory (-dropbox) is left out in the
+// same way.
+
+const ignoreFileName = ".goserveignore"
+
+type ignoreRule struct {
+	dir      string // folder of the ignore file
+	pattern  string // slash-separated, no leading or trailing slash
+	anchored bool   // matched against the path from dir, not the name
+	dirOnly  bool
+	negate   bool
+}
+
+// ignoreMatcher holds the rules of a folder's ignore file and those of
+// every folder above it, shallowest first.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// readIgnoreFile parses dir's .goserveignore, if it has one.
+func readIgnoreFile(dir string) []ignoreRule {
+	data, err := os.ReadFile(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		return nil
+	}
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern == "" {
+			continue
+		}
+		if _, err := path.Match(strings.ReplaceAll(rule.pattern, "**", "*"), ""); err != nil {
+			log.Printf("Ignoring %q in %s: %v", scanner.Text(), filepath.Join(dir, ignoreFileName), err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignoreMatcherFor collects the ignore rules that apply inside dir, from
+// the base directory down.
+func ignoreMatcherFor(dir string) *ignoreMatcher {
+	base := filepath.Clean(getBaseDir())
+	dir = filepath.Clean(dir)
+	if !isUnderDir(dir, base) {
+		return &ignoreMatcher{}
+	}
+	var dirs []string
+	for d := dir; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if d == base || filepath.Dir(d) == d {
+			break
+		}
+	}
+	m := &ignoreMatcher{}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		m.rules = append(m.rules, readIgnoreFile(dirs[i])...)
+	}
+	return m
+}
+
+// child returns the matcher for dir, a folder directly inside m's.
+func (m *ignoreMatcher) child(dir string) *ignoreMatcher {
+	more := readIgnoreFile(dir)
+	if len(more) == 0 {
+		return m
+	}
+	return &ignoreMatcher{rules: append(slices.Clip(m.rules), more...)}
+}
+
+// ignored reports whether the entry fullPath, directly inside m's folder,
+// is left out.
+func (m *ignoreMatcher) ignored(fullPath string, isDir bool) bool {
+	if filepath.Base(fullPath) == ignoreFileName || inDropbox(fullPath) {
+		return true
+	}
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.negate == ignored && rule.matches(fullPath, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (rule ignoreRule) matches(fullPath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(rule.dir, fullPath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if !rule.anchored {
+		return globMatch(strings.Split(rule.pattern, "/"), []string{path.Base(rel)})
+	}
+	return globMatch(strings.Split(rule.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// globMatch matches path segments against pattern segments, where a "**"
+// segment stands for any number of them.
+func globMatch(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if globMatch(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// ignoredPath reports whether fullPath, or a folder it is in, is left out
+// by an ignore file.
+func ignoredPath(fullPath string) bool {
+	base := filepath.Clean(getBaseDir())
+	fullPath = filepath.Clean(fullPath)
+	if fullPath == base || !isUnderDir(fullPath, base) {
+		return false
+	}
+	if inDropbox(fullPath) {
+		return true
+	}
+	rel, _ := filepath.Rel(base, fullPath)
+	names := strings.Split(rel, string(filepath.Separator))
+	m, dir := ignoreMatcherFor(base), base
+	for i, name := range names {
+		p := filepath.Join(dir, name)
+		isDir := i < len(names)-1
+		if !isDir {
+			info, err := os.Stat(p)
+			isDir = err == nil && info.IsDir()
+		}
+		if m.ignored(p, isDir) {
+			return true
+		}
+		if isDir {
+			m, dir = m.child(p), p
+		}
+	}
+	return false
+}
+
+// ignoreWalker tracks the ignore rules of the folders a filepath.Walk goes
+// through, so it reads each ignore file once.
+type ignoreWalker map[string]*ignoreMatcher
+
+// skip reports whether the walk should leave out p; the caller returns
+// filepath.SkipDir for a folder.
+func (w ignoreWalker) skip(p string, info os.FileInfo) bool {
+	parent := filepath.Dir(p)
+	m, ok := w[parent]
+	if !ok {
+		m = ignoreMatcherFor(parent)
+		w[parent] = m
+	}
+	if m.ignored(p, info.IsDir()) {
+		return true
+	}
+	if info.IsDir() {
+		w[p] = m.child(p)
+	}
+	return false
+}
+
+// ignoreFS leaves ignored entries out of WebDAV folder listings; requests
+// for them are refused before they get here (see davPermissionMiddleware).
+type ignoreFS struct {
+	webdav.FileSystem
+	dir string
+}
+
+func (fs ignoreFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return ignoreDavFile{f, filepath.Join(fs.dir, filepath.FromSlash(slashClean(name)))}, nil
+}
+
+type ignoreDavFile struct {
+	webdav.File
+	fullPath string
+}
+
+func (f ignoreDavFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	m := ignoreMatcherFor(f.fullPath)
+	return slices.DeleteFunc(infos, func(fi os.FileInfo) bool {
+		return m.ignored(filepath.Join(f.fullPath, fi.Name()), fi.IsDir())
+	}), err
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// Machine-readable server description at /_info, for monitoring fleets of
+// goserve instances. The served directory is only included for users with
+// modify permission. /api/v1/capabilities describes what the requester can
+// do, for the in-page help overlay.
+
+// serverInfo holds startup settings that aren't kept elsewhere. The rates
+// are those of the flags; runtime settings may override them.
+var serverInfo struct {
+	started   time.Time
+	listeners []string
+	permLevel string
+	rate      float64 // requests per second, 0 = unlimited
+	heavyRate float64 // archive and search requests per minute
+	cacheSize int64   // bytes
+	maxConns  int     // per listener, 0 = unlimited
+}
+
+// enabledFeatures lists optional subsystems and whether they are on.
+func enabledFeatures() map[string]bool {
+	rate, heavyRate := currentRates()
+	return map[string]bool{
+		"webdav":      true,
+		"dropbox":     dropboxDir != "",
+		"paste":       pasteDir != "",
+		"dedup":       dedupDir != "",
+		"spool":       spoolDir != "",
+		"cache":       blobCache != nil,
+		"geoip":       geoDB != nil,
+		"rules":       len(rules) > 0,
+		"state":       stateDir != "" || stateRedis != nil,
+		"sharedState": stateShared,
+		"rateLimit":   rate > 0 || heavyRate > 0,
+		"monthlyCap":  monthlyCap > 0,
+	}
+}
+
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	canUpload, canModify := permissionsFor(r)
+	rate, heavyRate := currentRates()
+	maintenance, _ := maintenanceMode()
+	info := map[string]any{
+		"version":       version,
+		"goVersion":     runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"started":       serverInfo.started.UTC().Format(time.RFC3339),
+		"uptimeSeconds": int64(time.Since(serverInfo.started).Seconds()),
+		"listeners":     serverInfo.listeners,
+		"origin":        advertisedOrigin(r),
+		"permLevel":     permLevelFor(r),
+		"auth":          authRequired(r),
+		"canUpload":     canUpload,
+		"canModify":     canModify,
+		"features":      enabledFeatures(),
+		"permCeiling":   permCeiling(),
+		"maintenance":   maintenance,
+		"limits": map[string]any{
+			"maxUploadBytes":     maxUploadSize,
+			"ratePerSecond":      rate,
+			"heavyRatePerMinute": heavyRate,
+			"monthlyCapBytes":    monthlyCap,
+			"cacheSizeBytes":     serverInfo.cacheSize,
+			"maxConns":           serverInfo.maxConns,
+			"maxRequests":        maxRequests,
+			"maxDownloadsPerIP":  maxDownloadsPerIP,
+		},
+	}
+	if replicaOf != nil {
+		info["replica"] = replicaStatus()
+	}
+	if canModify {
+		info["baseDir"] = baseDirFor(r)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, info)
+}
+
+type helpItem struct {
+	Keys        string `json:"keys,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// handleCapabilities serves /api/v1/capabilities[?path=/folder]: what the
+// requester can do on this server, and in that folder, with the keyboard
+// shortcuts and actions that apply, for the help overlay.
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	canUpload, canModify := permissionsFor(r)
+	fullPath := ""
+	if p := r.URL.Query().Get("path"); p != "" {
+		var ok bool
+		if fullPath, ok = resolvePathFor(r, p); !ok {
+			jsonError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+	}
+	c := capabilitiesFor(r, fullPath)
+	features := enabledFeatures()
+
+	shortcuts := []helpItem{
+		{"↑ / ↓", "Move selection", "Select the previous or next item; hold Shift to extend the selection"},
+		{"Home / End", "First / last item", "Jump to the top or bottom of the list"},
+		{"→ / Enter", "Open", "Open the selected folder, or preview the selected file"},
+		{"←", "Parent folder", "Go up one level"},
+		{"Ctrl+Click / Shift+Click", "Multi-select", "Add an item to the selection, or select a range"},
+	}
+	if c.Upload {
+		shortcuts = append(shortcuts, helpItem{"Ctrl+V", "Paste", "Save a copied image or text here as a new file"})
+	}
+	if c.Delete {
+		shortcuts = append(shortcuts, helpItem{"Delete", "Delete", "Delete the selected items"})
+	}
+	shortcuts = append(shortcuts,
+		helpItem{"Esc", "Close", "Close dialogs and menus, clear the selection"},
+		helpItem{"?", "Help", "Show this overlay"},
+	)
+
+	actions := []helpItem{
+		{Name: "Search", Description: "Filter the listing as you type; * and ? work as wildcards"},
+		{Name: "Preview", Description: "View images, video with subtitles, text, markdown and code in the browser, and list what is inside ZIP and TAR files"},
+		{Name: "Download ZIP / TAR", Description: "Download folders and selections as one archive"},
+		{Name: "Download queue", Description: "Queue files to download one at a time with automatic resume"},
+		{Name: "Find in Files", Description: "Search text inside files under the current folder"},
+		{Name: "Copy Short Link", Description: "Copy a short /_s/ link to a file or folder (item menu); manage them under Links"},
+	}
+	if c.Upload {
+		actions = append(actions, helpItem{Name: "Upload", Description: "Drop files or folders onto the list, or use the upload button"})
+	}
+	if c.Mkdir {
+		actions = append(actions, helpItem{Name: "New Folder", Description: "Create a folder here"})
+	}
+	if c.Copy {
+		actions = append(actions, helpItem{Name: "Copy To", Description: "Copy the selection into another folder"})
+	}
+	if c.Share {
+		actions = append(actions, helpItem{Name: "Upload Link", Description: "Let others upload into a folder without seeing it (folder menu)"})
+	}
+	if c.Rename || c.Delete {
+		actions = append(actions, helpItem{Name: "Rename / Move / Delete", Description: "From the item's menu; a selection is moved or deleted in one go, with a report of any items that failed"})
+	}
+	if c.Edit {
+		actions = append(actions,
+			helpItem{Name: "Edit", Description: "Edit text files in the browser, with find and replace"},
+			helpItem{Name: "Replace in Files", Description: "Replace text across the results of Find in Files"},
+		)
+	}
+	if c.Touch {
+		actions = append(actions, helpItem{Name: "Set Modified Time", Description: "Change a file's date"})
+	}
+	if c.Chmod {
+		actions = append(actions, helpItem{Name: "Change Permissions", Description: "Set the mode of files and folders, such as 644 (item menu)"})
+	}
+	if c.Admin {
+		actions = append(actions, helpItem{Name: "Access Log", Description: "Search who accessed what (settings menu)"})
+	}
+	if features["dropbox"] {
+		actions = append(actions, helpItem{Name: "Drop box", Description: "Anyone can send files at /_drop/"})
+	}
+	if c.Paste {
+		actions = append(actions, helpItem{Name: "Paste", Description: "Share a snippet of text as a short link at /_paste (settings menu)"})
+	}
+	actions = append(actions,
+		helpItem{Name: "WebDAV", Description: "Mount the server as a network drive at /webdav/ (see About)"},
+		helpItem{Name: "WebDAV views", Description: "Mount read-only views of recent files, tags and name searches at /_views/"},
+	)
+
+	caps := map[string]any{
+		"success":   true,
+		"canUpload": canUpload,
+		"canModify": canModify,
+		"caps":      c,
+		"auth":      authRequired(r),
+		"features":  features,
+		"shortcuts": shortcuts,
+		"actions":   actions,
+		"denied":    denialsFor(r, fullPath, c),
+	}
+	if user := getUserFromRequest(r); user != nil {
+		caps["user"] = user.Username
+	}
+	writeJSON(w, caps)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Background jobs. Long-running operations run as jobs with an ID, progress
+// and cancellation instead of blocking a request. They are listed and
+// controlled through /api/v1/jobs and the Jobs panel in the UI.
+
+// Finished jobs stay visible for this long.
+const jobRetention = time.Hour
+
+type Job struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Path     string     `json:"path"`
+	Owner    string     `json:"owner"`
+	Status   string     `json:"status"` // running, done, failed, canceled
+	Done     int64      `json:"done"`
+	Total    int64      `json:"total"`
+	Message  string     `json:"message,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Result   string     `json:"result,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Entries that failed in an operation that carries on past errors
+	FailedCount int      `json:"failedCount,omitempty"`
+	Failures    []string `json:"failures,omitempty"`
+
+	// Files an organize job moved, or would move in a dry run
+	Moves []jobMove `json:"moves,omitempty"`
+
+	// Files a duplicates job found, in groups to review
+	Duplicates []dupGroup `json:"duplicates,omitempty"`
+
+	// For moves and copies: why an entry may not be put at a path, checked
+	// for each one (see placeRefusal)
+	place func(dst string, info os.FileInfo) error
+
+	cancel context.CancelFunc
+}
+
+var (
+	jobs   = map[string]*Job{}
+	jobsMu sync.Mutex
+)
+
+// startJob runs fn in the background as a new job and returns it.
+func startJob(typ, urlPath, owner string, fn func(ctx context.Context, j *Job) error) *Job {
+	b := make([]byte, 8)
+	rand.Read(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		ID:      hex.EncodeToString(b),
+		Type:    typ,
+		Path:    urlPath,
+		Owner:   owner,
+		Status:  "running",
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+
+	jobsMu.Lock()
+	pruneJobs()
+	jobs[j.ID] = j
+	jobsMu.Unlock()
+
+	go func() {
+		err := fn(ctx, j)
+		canceled := ctx.Err() != nil
+		cancel()
+
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		now := time.Now()
+		j.Finished = &now
+		switch {
+		case canceled && err != nil:
+			j.Status = "canceled"
+		case err != nil:
+			j.Status = "failed"
+			j.Error = err.Error()
+		default:
+			j.Status = "done"
+		}
+	}()
+	return j
+}
+
+// pruneJobs drops jobs finished more than jobRetention ago. Callers hold jobsMu.
+func pruneJobs() {
+	for id, j := range jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+}
+
+// setProgress records how far the job has got; total may be 0 if unknown.
+func (j *Job) setProgress(done, total int64, msg string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Done, j.Total, j.Message = done, total, msg
+}
+
+func (j *Job) addProgress(n int64, msg string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Done += n
+	if j.Total > 0 && j.Done > j.Total {
+		j.Done = j.Total
+	}
+	if msg != "" {
+		j.Message = msg
+	}
+}
+
+// complete marks all work as done with a final message and result.
+func (j *Job) complete(msg, result string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Done = j.Total
+	j.Message = msg
+	j.Result = result
+}
+
+// snapshot copies the job's exported fields under the lock.
+func (j *Job) snapshot() Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	c := *j
+	c.cancel = nil
+	return c
+}
+
+// progressWriter counts bytes written through it into a job and aborts
+// once the job is canceled.
+type progressWriter struct {
+	ctx context.Context
+	w   io.Writer
+	job *Job
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.w.Write(b)
+	p.job.addProgress(int64(n), "")
+	return n, err
+}
+
+// treeSize returns the total size of regular files under root.
+func treeSize(root string) int64 {
+	var total int64
+	filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// canSeeJob reports whether r may view or cancel j: admins (modify
+// permission) see everything, others only their own jobs.
+func canSeeJob(r *http.Request, j *Job) bool {
+	if _, canModify := permissionsFor(r); canModify {
+		return true
+	}
+	return j.Owner == requesterName(r)
+}
+
+// handleJobs serves /api/v1/jobs and /api/v1/jobs/{id}.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/jobs"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			jobsMu.Lock()
+			pruneJobs()
+			all := make([]*Job, 0, len(jobs))
+			for _, j := range jobs {
+				all = append(all, j)
+			}
+			jobsMu.Unlock()
+
+			list := []Job{}
+			for _, j := range all {
+				if canSeeJob(r, j) {
+					list = append(list, j.snapshot())
+				}
+			}
+			sort.Slice(list, func(a, b int) bool { return list[a].Started.After(list[b].Started) })
+			writeJSON(w, map[string]any{"success": true, "jobs": list})
+		case "POST":
+			createJob(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	jobsMu.Lock()
+	j, ok := jobs[id]
+	jobsMu.Unlock()
+	if !ok || !canSeeJob(r, j) {
+		jsonError(w, http.StatusNotFound, "No such job")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, map[string]any{"success": true, "job": j.snapshot()})
+	case "DELETE":
+		j.cancel()
+		writeJSON(w, map[string]any{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createJob starts a job from a JSON request:
+//
+//	{"type": "checksum", "path": "/dir"}                 write SHA256SUMS into dir
+//	{"type": "archive", "path": "/dir", "format": "zip"} pre-build a spooled archive
+//	{"type": "delete", "paths": ["/a", "/b"]}            recursive delete
+//	{"type": "copy", "paths": ["/a"], "dest": "/dir"}    recursive copy into dest
+//	  (with "conflict": overwrite, skip or rename for names already there)
+//	{"type": "organize", "path": "/dir", "dryRun": true} sort media into Year/Month
+//	{"type": "extract", "path": "/a.zip", "password": ""} unzip into a new folder
+func createJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type     string   `json:"type"`
+		Path     string   `json:"path"`
+		Paths    []string `json:"paths"`
+		Dest     string   `json:"dest"`
+		Format   string   `json:"format"`
+		DryRun   bool     `json:"dryRun"`
+		Password string   `json:"password"`
+		Conflict string   `json:"conflict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if req.Type == "delete" || req.Type == "copy" {
+		createFileOpJob(w, r, req.Type, req.Paths, req.Dest, req.Conflict)
+		return
+	}
+	if req.Type == "extract" {
+		createExtractJob(w, r, req.Path, req.Password)
+		return
+	}
+	fullPath, ok := resolvePathFor(r, req.Path)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Invalid path")
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.IsDir() {
+		jsonError(w, http.StatusNotFound, "Directory not found")
+		return
+	}
+	caps := capabilitiesFor(r, fullPath)
+	owner := requesterName(r)
+	urlPath := path.Clean("/" + req.Path)
+
+	var j *Job
+	switch req.Type {
+	case "checksum":
+		if !caps.Upload {
+			deny(w, r, fullPath, "upload")
+			return
+		}
+		j = startJob("checksum", urlPath, owner, func(ctx context.Context, j *Job) error {
+			return checksumManifestJob(ctx, j, fullPath)
+		})
+	case "archive":
+		if spoolDir == "" {
+			jsonError(w, http.StatusBadRequest, "Archive jobs need -spool")
+			return
+		}
+		format := req.Format
+		if format == "" {
+			format = "zip"
+		}
+		if format != "zip" && format != "tar" {
+			jsonError(w, http.StatusBadRequest, "Unknown archive format")
+			return
+		}
+		j = startJob("archive", urlPath, owner, func(ctx context.Context, j *Job) error {
+			return archiveJob(ctx, j, fullPath, urlPath, format)
+		})
+	case "organize":
+		if !caps.Mkdir || !caps.Rename {
+			deny(w, r, fullPath, "rename")
+			return
+		}
+		dryRun := req.DryRun
+		j = startJob("organize", urlPath, owner, func(ctx context.Context, j *Job) error {
+			return organizeJob(ctx, j, fullPath, dryRun)
+		})
+	case "duplicates":
+		j = startJob("duplicates", urlPath, owner, func(ctx context.Context, j *Job) error {
+			return duplicatesJob(ctx, j, fullPath)
+		})
+	default:
+		jsonError(w, http.StatusBadRequest, "Unknown job type")
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "job": j.snapshot()})
+}
+
+// archiveJob builds the spooled archive that ?zip=1 / ?tar=1 will serve.
+func archiveJob(ctx context.Context, j *Job, fullPath, urlPath, format string) error {
+	j.setProgress(0, treeSize(fullPath), "Building "+format)
+	write := writeZipTree
+	if format == "tar" {
+		write = func(out io.Writer, fullPath string) ([]entryError, error) {
+			return writeTarTree(out, fullPath, "")
+		}
+	}
+	_, _, skipped, err := ensureSpooled(treeFingerprint(format, fullPath), "."+format, func(out io.Writer) ([]entryError, error) {
+		return write(progressWriter{ctx, out, j}, fullPath)
+	})
+	if err != nil {
+		return err
+	}
+	msg := "Ready"
+	if skipped > 0 {
+		msg = fmt.Sprintf("Ready (%d unreadable entries left out)", skipped)
+	}
+	j.complete(msg, strings.TrimSuffix(urlPath, "/")+"/?"+format+"=1")
+	return nil
+}
+
+// checksumManifestJob writes a sha256sum-compatible SHA256SUMS file listing
+// every file under fullPath.
+func checksumManifestJob(ctx context.Context, j *Job, fullPath string) error {
+	j.setProgress(0, treeSize(fullPath), "Hashing")
+
+	var lines []string
+	err := filepath.Walk(fullPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.Mode().IsRegular() || p == filepath.Join(fullPath, "SHA256SUMS") {
+			return nil
+		}
+		rel, _ := filepath.Rel(fullPath, p)
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(progressWriter{ctx, h, j}, f); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s", h.Sum(nil), filepath.ToSlash(rel)))
+		recordChecksum(p, info, hex.EncodeToString(h.Sum(nil)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest := filepath.Join(fullPath, "SHA256SUMS")
+	if err := os.WriteFile(manifest, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	j.complete(fmt.Sprintf("%d files", len(lines)), strings.TrimSuffix(j.Path, "/")+"/SHA256SUMS")
+	return nil
+}
+
+// createFileOpJob starts a recursive delete or copy of paths.
+func createFileOpJob(w http.ResponseWriter, r *http.Request, typ string, paths []string, dest, conflict string) {
+	if len(paths) == 0 {
+		jsonError(w, http.StatusBadRequest, "No files specified")
+		return
+	}
+	if conflict != "" && !validConflictPolicy(conflict) {
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("Invalid conflict policy %q (use overwrite, skip or rename)", conflict))
+		return
+	}
+
+	fullPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		fp, ok := resolvePathFor(r, p)
+		if !ok || fp == filepath.Clean(baseDirFor(r)) {
+			jsonError(w, http.StatusForbidden, "Invalid path: "+p)
+			return
+		}
+		caps := capabilitiesFor(r, fp)
+		if typ == "delete" && !caps.Delete {
+			deny(w, r, fp, "delete")
+			return
+		}
+		if typ == "copy" && !caps.Copy {
+			deny(w, r, fp, "copy")
+			return
+		}
+		fullPaths = append(fullPaths, fp)
+	}
+	jobPath := path.Clean("/" + paths[0])
+	if len(paths) > 1 {
+		jobPath = path.Dir(jobPath)
+	}
+	owner := requesterName(r)
+
+	var j *Job
+	if typ == "delete" {
+		j = startJob("delete", jobPath, owner, func(ctx context.Context, j *Job) error {
+			return deleteJob(ctx, j, fullPaths)
+		})
+	} else {
+		destDir, ok := resolvePathFor(r, dest)
+		if !ok {
+			jsonError(w, http.StatusForbidden, "Invalid destination")
+			return
+		}
+		if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+			jsonError(w, http.StatusNotFound, "Destination folder not found")
+			return
+		}
+		if !capabilitiesFor(r, destDir).Upload {
+			deny(w, r, destDir, "upload")
+			return
+		}
+		for _, fp := range fullPaths {
+			if dst := filepath.Join(destDir, filepath.Base(fp)); !capabilitiesFor(r, dst).Upload {
+				deny(w, r, dst, "upload")
+				return
+			}
+		}
+		if quotas != nil {
+			var total int64
+			for _, fp := range fullPaths {
+				total += treeSize(fp)
+			}
+			if err := quotaError(r, destDir, total, 0); err != nil {
+				jsonError(w, http.StatusInsufficientStorage, err.Error())
+				return
+			}
+		}
+		destURL := urlForRequest(r, destDir)
+		place := placeRefusal(r)
+		j = startJob("copy", jobPath, owner, func(ctx context.Context, j *Job) error {
+			j.place = place
+			return copyJob(ctx, j, fullPaths, destDir, destURL, conflict)
+		})
+	}
+	writeJSON(w, map[string]any{"success": true, "job": j.snapshot()})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Concurrency limits, for small machines such as a Raspberry Pi serving
+// big files. -max-conns caps open connections on each listener (further
+// ones wait to be accepted), -max-requests caps requests being handled at
+// once across the server, and -max-downloads-per-ip caps how many file and
+// archive downloads one client address runs at once, which reins in
+// download managers that open a dozen range requests. A request over a
+// limit waits its turn for up to -queue-timeout; if no slot frees up by
+// then, or the timeout is 0, it gets a 503 with Retry-After. Requests for
+// the admin API are never held back, so the server can still be managed
+// while it is saturated.
+
+var (
+	maxRequests       int
+	maxDownloadsPerIP int
+	queueTimeout      time.Duration
+	requestSlots      chan struct{} // one token per request in progress; nil when unlimited
+	downloadSlots     = &keyedSlots{slots: map[string]*clientSlots{}}
+)
+
+// keyedSlots counts downloads in progress per client address.
+type keyedSlots struct {
+	mu    sync.Mutex
+	slots map[string]*clientSlots
+}
+
+type clientSlots struct {
+	tokens chan struct{}
+	refs   int // requests holding or waiting for a token
+}
+
+// acquire takes one of max slots for key, waiting up to wait; release must
+// be called when it returns true.
+func (k *keyedSlots) acquire(r *http.Request, key string, max int, wait time.Duration) bool {
+	k.mu.Lock()
+	c, ok := k.slots[key]
+	if !ok {
+		c = &clientSlots{tokens: make(chan struct{}, max)}
+		k.slots[key] = c
+	}
+	c.refs++
+	k.mu.Unlock()
+	if takeSlot(r, c.tokens, wait) {
+		return true
+	}
+	k.done(key, c)
+	return false
+}
+
+func (k *keyedSlots) release(key string) {
+	k.mu.Lock()
+	c := k.slots[key]
+	k.mu.Unlock()
+	<-c.tokens
+	k.done(key, c)
+}
+
+// done drops the client's entry once nobody holds or waits for its slots.
+func (k *keyedSlots) done(key string, c *clientSlots) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if c.refs--; c.refs == 0 {
+		delete(k.slots, key)
+	}
+}
+
+// takeSlot puts a token into slots, waiting up to wait for room, or until
+// the client gives up.
+func takeSlot(r *http.Request, slots chan struct{}, wait time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	defer func() { addMetric("goserve_limit_queued_seconds_total", time.Since(start).Seconds()) }()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+// isDownloadRequest reports whether r fetches a file or an archive, as
+// opposed to a page, an API call or a preview.
+func isDownloadRequest(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	if isArchiveRequest(r) {
+		return true
+	}
+	p := r.URL.Path
+	return r.URL.RawQuery == "" && !strings.HasSuffix(p, "/") &&
+		!strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/_")
+}
+
+func limitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// An open page's event stream would hold a slot for as long as the
+		// page stays open
+		if strings.HasPrefix(r.URL.Path, "/api/v1/admin/") || isLiveListing(r) {
+			next(w, r)
+			return
+		}
+		// A client's own download slot comes first, so a request waiting
+		// for one doesn't hold up everyone else's
+		if maxDownloadsPerIP > 0 && isDownloadRequest(r) {
+			ip := authClientIP(r)
+			if !downloadSlots.acquire(r, ip, maxDownloadsPerIP, queueTimeout) {
+				overLimit(w, r, "downloads", fmt.Sprintf("At most %d downloads at once per client", maxDownloadsPerIP))
+				return
+			}
+			defer downloadSlots.release(ip)
+		}
+		if requestSlots != nil {
+			if !takeSlot(r, requestSlots, queueTimeout) {
+				overLimit(w, r, "requests", "The server is busy")
+				return
+			}
+			defer func() { <-requestSlots }()
+		}
+		next(w, r)
+	}
+}
+
+// overLimit answers a request that found no free slot.
+func overLimit(w http.ResponseWriter, r *http.Request, limit, message string) {
+	addMetric("goserve_limit_rejected_total", 1, "limit", limit)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Max(5, math.Ceil(queueTimeout.Seconds())))))
+	if strings.HasPrefix(r.URL.Path, "/api/") || r.Header.Get("Accept") == "application/json" {
+		jsonError(w, http.StatusServiceUnavailable, message)
+		return
+	}
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Per-listener options. Each -listen may carry comma-separated options
+// after the address, e.g. "-listen :8080,readonly,noauth -listen
+// :8443,tls,auth,all", so one process can offer a public read-only view
+// and a private full-access one. Options not given fall back to the
+// server-wide -permlevel and -logins settings.
+
+type listenerConfig struct {
+	Addr      string
+	TLS       bool
+	Auth      bool
+	PermLevel string // readonly, readwrite or all
+}
+
+type listenerKey struct{}
+
+// parseListenSpec parses "addr[,option...]". defaultAuth and defaultLevel
+// apply when the spec doesn't say.
+func parseListenSpec(spec string, defaultAuth bool, defaultLevel string) (listenerConfig, error) {
+	parts := strings.Split(spec, ",")
+	cfg := listenerConfig{Addr: strings.TrimSpace(parts[0]), Auth: defaultAuth, PermLevel: defaultLevel}
+	if cfg.Addr == "" {
+		return cfg, fmt.Errorf("-listen %q: missing address", spec)
+	}
+	for _, opt := range parts[1:] {
+		switch opt = strings.ToLower(strings.TrimSpace(opt)); opt {
+		case "tls":
+			cfg.TLS = true
+		case "auth":
+			cfg.Auth = true
+		case "noauth":
+			cfg.Auth = false
+		case "readonly", "readwrite", "all":
+			cfg.PermLevel = opt
+		default:
+			return cfg, fmt.Errorf("-listen %q: unknown option %q (valid: tls, auth, noauth, readonly, readwrite, all)", spec, opt)
+		}
+	}
+	return cfg, nil
+}
+
+// String describes the options for the startup banner and /_info.
+func (c listenerConfig) String() string {
+	opts := []string{c.PermLevel}
+	if c.Auth {
+		opts = append(opts, "auth")
+	}
+	if c.TLS {
+		opts = append(opts, "tls")
+	}
+	return strings.Join(opts, ", ")
+}
+
+// withListener tags requests with the configuration of the listener they
+// arrived on.
+func withListener(cfg *listenerConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), listenerKey{}, cfg)))
+	})
+}
+
+// listenerFor returns the configuration of the listener r arrived on, or
+// nil for requests that didn't come through one.
+func listenerFor(r *http.Request) *listenerConfig {
+	cfg, _ := r.Context().Value(listenerKey{}).(*listenerConfig)
+	return cfg
+}
+
+// authRequired reports whether r must log in.
+func authRequired(r *http.Request) bool {
+	if cfg := listenerFor(r); cfg != nil {
+		return cfg.Auth
+	}
+	return requireAuth
+}
+
+// levelPermissions maps a permission level to upload and modify rights.
+func levelPermissions(level string) (canUpload, canModify bool) {
+	switch level {
+	case "readwrite":
+		return true, false
+	case "all":
+		return true, true
+	}
+	return false, false
+}
+
+// permLevelFor returns the permission level in force for r before any
+// narrowing by the logged-in user.
+func permLevelFor(r *http.Request) string {
+	if cfg := listenerFor(r); cfg != nil {
+		return cfg.PermLevel
+	}
+	return serverInfo.permLevel
+}
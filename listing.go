@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSON listings. A folder requested with ?format=json returns its entries
+// instead of the HTML page. The response carries a weak ETag built from the
+// folder's modification time and number of entries, so clients that poll a
+// folder can send If-None-Match and get a 304 without the server statting
+// every entry. Creating, deleting or renaming an entry changes the folder's
+// mtime; rewriting a file in place does not, so a client that cares about
+// content compares the entries' own etags when it does fetch the listing.
+//
+// HTML and JSON listings alike take ?sort=name|size|mtime with
+// &order=asc|desc, and ?filter= with part of a name or a glob such as
+// *.log, so a sorted or filtered view can be bookmarked and linked, and the
+// compact layout's pages follow it. A folder whose settings fix its order
+// (forceSort) ignores ?sort.
+
+// listingView is the order and filter a listing request asks for.
+type listingView struct {
+	sort   sortSpec
+	sorted bool                   // ?sort or ?order was given
+	filter func(name string) bool // nil shows everything
+}
+
+// parseListingView reads ?sort, ?order and ?filter.
+func parseListingView(r *http.Request) (listingView, error) {
+	q := r.URL.Query()
+	var v listingView
+	if q.Get("sort") != "" || q.Get("order") != "" {
+		s := q.Get("sort")
+		if s == "" {
+			s = "name"
+		}
+		if o := q.Get("order"); o != "" {
+			s += "," + o
+		}
+		spec, err := parseSort(s)
+		if err != nil {
+			return v, err
+		}
+		v.sort, v.sorted = spec, true
+	}
+	if f := q.Get("filter"); f != "" {
+		v.filter = nameFilter(f)
+	}
+	return v, nil
+}
+
+// nameFilter matches names the way the page's search box filters them: a
+// pattern with * or ? has to match the whole name, anything else is looked
+// for within it, and case doesn't matter.
+func nameFilter(pattern string) func(string) bool {
+	if strings.ContainsAny(pattern, "*?") {
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		re := regexp.MustCompile("(?is)^" + expr + "$")
+		return re.MatchString
+	}
+	lower := strings.ToLower(pattern)
+	return func(name string) bool { return strings.Contains(strings.ToLower(name), lower) }
+}
+
+// sortEntries orders JSON listing entries as sortFiles orders the page.
+func sortEntries(entries []entryMeta, spec sortSpec) {
+	modified := func(e entryMeta) time.Time {
+		t, _ := time.Parse(time.RFC3339Nano, e.Modified)
+		return t
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if spec.Desc {
+			a, b = b, a
+		}
+		switch spec.Col {
+		case 1:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case 2:
+			if ma, mb := modified(a), modified(b); !ma.Equal(mb) {
+				return ma.Before(mb)
+			}
+		default:
+			if a.IsDir != b.IsDir {
+				return entries[i].IsDir
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+// listingETag returns the validator for the folder at fullPath.
+func listingETag(fullPath string, info os.FileInfo) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), len(names)), nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 specifies for it.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListingJSON serves the folder fullPath for ?format=json.
+func handleListingJSON(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo) {
+	// The validator is taken before reading, so a change in between makes
+	// the next poll fetch again rather than be missed
+	view, err := parseListingView(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	etag, err := listingETag(fullPath, info)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot read directory")
+		return
+	}
+	if view.sorted || view.filter != nil {
+		// Another view of the folder is another representation
+		sum := sha256.Sum256([]byte(r.URL.Query().Get("sort") + "\x00" + r.URL.Query().Get("order") + "\x00" + r.URL.Query().Get("filter")))
+		etag = strings.TrimSuffix(etag, `"`) + fmt.Sprintf("-%x\"", sum[:6])
+	}
+	withHidden := hiddenShown(r)
+	if withHidden {
+		etag = strings.TrimSuffix(etag, `"`) + `-h"`
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	dirEntries, err := os.ReadDir(fullPath)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot read directory")
+		return
+	}
+	entries := []entryMeta{}
+	ignore := ignoreMatcherFor(fullPath)
+	for _, e := range dirEntries {
+		p := filepath.Join(fullPath, e.Name())
+		if e.Name() == dirSettingsFile || (view.filter != nil && !view.filter(e.Name())) || ignore.ignored(p, e.IsDir()) {
+			continue
+		}
+		if !withHidden {
+			if info, _ := e.Info(); isHidden(e.Name(), info) {
+				continue
+			}
+		}
+		m, err := statEntry(r, p)
+		if err != nil {
+			m = entryMeta{Name: e.Name(), Path: urlForRequest(r, p), Error: entryErrorReason(p, err)}
+			if e.Type()&os.ModeSymlink != 0 {
+				m.Link = linkTarget(p, baseDirFor(r))
+			}
+		}
+		entries = append(entries, m)
+	}
+	settings := loadDirSettings(fullPath)
+	if _, fixed := listingSort(settings); view.sorted && !(fixed && settings.ForceSort) {
+		sortEntries(entries, view.sort)
+	}
+	writeJSON(w, map[string]any{"success": true, "path": urlForRequest(r, fullPath), "entries": entries})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Live listings. An open folder page listens on ?events=1, a stream of
+// server-sent events, and reloads its file list a moment after anything in
+// the folder is added, removed, renamed or rewritten, whether by another
+// browser, a WebDAV client, a job or a rule. Only changes made through
+// goserve are seen (they come from the file events in events.go), and only
+// those to the folder's own entries, so a busy subfolder doesn't keep
+// refreshing its parent. A comment line every liveKeepAlive keeps proxies
+// from closing an idle stream. Streams last as long as the page is open, so
+// they are left out of -max-requests and don't count as interactive
+// traffic for -qos.
+
+const liveKeepAlive = 25 * time.Second
+
+// isLiveListing reports whether r is a folder page's event stream.
+func isLiveListing(r *http.Request) bool {
+	return r.Method == "GET" && r.URL.Query().Get("events") != ""
+}
+
+// liveChange is what the page is told about one change.
+type liveChange struct {
+	Type    string `json:"type"` // created, modified, deleted, renamed
+	Name    string `json:"name"`
+	OldName string `json:"oldName,omitempty"`
+	IsDir   bool   `json:"isDir,omitempty"`
+}
+
+// handleLiveListing serves ?events=1 on the folder fullPath.
+func handleLiveListing(w http.ResponseWriter, r *http.Request, fullPath string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := subscribeEvents()
+	defer unsubscribe()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no") // nginx would otherwise hold events back
+	w.WriteHeader(http.StatusOK)
+	// Clients reconnect after this long if the stream drops
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	base := getBaseDir()
+	inFolder := func(p string) (string, bool) {
+		if p == "" {
+			return "", false
+		}
+		full := filepath.Join(base, filepath.FromSlash(p))
+		return path.Base(filepath.ToSlash(full)), filepath.Dir(full) == fullPath
+	}
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			name, in := inFolder(ev.Path)
+			oldName, oldIn := inFolder(ev.OldPath)
+			if !in && !oldIn {
+				continue
+			}
+			c := liveChange{Type: ev.Type, Name: name, IsDir: ev.IsDir}
+			switch {
+			case ev.Type == "renamed" && !oldIn:
+				c.Type = "created" // moved in from elsewhere
+			case ev.Type == "renamed" && !in:
+				c.Type, c.Name = "deleted", oldName // moved out
+			case ev.Type == "renamed":
+				c.OldName = oldName
+			}
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
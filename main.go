@@ -2,24 +2,29 @@ package main
 
 import (
 	"archive/zip"
-	"compress/gzip"
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/russross/blackfriday/v2"
+	"golang.org/x/net/netutil"
 	"golang.org/x/net/webdav"
 )
 
@@ -60,6 +65,13 @@ type FileInfo struct {
 	IsEditable bool
 	RawSize    int64
 	RawMod     int64
+	Error      string // why the entry can't be read
+	// A folder whose size is still being worked out; the page asks for it
+	SizePending bool
+	Mode        string // as ls -l shows it, with -show-perms
+	Perm        string // the same in octal
+	Owner       string
+	LinkTarget  string // where a symlink points
 }
 
 type PageData struct {
@@ -67,9 +79,43 @@ type PageData struct {
 	FullPath    string
 	Files       []FileInfo
 	Breadcrumbs []Breadcrumb
-	CanUpload   bool
-	CanModify   bool
+	Caps        Capabilities
+	ArchiveJobs bool
+	Indexed     bool // -index is on, so Find in Files can search by word
 	Version     string
+	Origin      string // scheme://host for links meant for other devices
+	SortCol     int    // column the listing is sorted by, -1 for the default
+	SortDesc    bool
+	ForceSort   bool   // column headers don't re-sort
+	Filter      string // ?filter the listing was cut down to
+	Brand       branding
+	SignedInAs  string       // user of a login-page session, who can sign out
+	SSO         bool         // signed in through single sign-on, so can make an app password
+	GuestAdmin  bool         // may manage guest accounts
+	Compact     bool         // phone layout: icon grid, no Modified column
+	ShowPerms   bool         // Owner and Mode columns
+	ShowHidden  bool         // dotfiles and hidden files are listed
+	HiddenFiles int          // hidden files left out
+	Hidden      int          // entries left out of a compact listing
+	MoreURL     string       // shows them
+	Space       *volumeSpace // free space on the folder's volume, unless hidden
+	Quota       *quotaUsage  // the user's storage quota, if they have one
+}
+
+// clientData is the part of PageData the listing's JavaScript reads, from
+// the pageData JSON block.
+type clientData struct {
+	Caps      Capabilities `json:"caps"`
+	Origin    string       `json:"origin"`
+	SortCol   int          `json:"sortCol"`
+	SortDesc  bool         `json:"sortDesc"`
+	ForceSort bool         `json:"forceSort"`
+	Filter    string       `json:"filter,omitempty"`
+	Accent    string       `json:"accent,omitempty"`
+}
+
+func (d PageData) Client() clientData {
+	return clientData{d.Caps, d.Origin, d.SortCol, d.SortDesc, d.ForceSort, d.Filter, d.Brand.Accent}
 }
 
 type Breadcrumb struct {
@@ -80,7 +126,11 @@ type Breadcrumb struct {
 type User struct {
 	Username   string
 	Password   string
-	Permission string // readonly, readwrite, all
+	Permission string     // readonly, readwrite, all
+	Rules      []pathRule // other permissions below some paths
+	Home       string     // confine the user to this folder; see homes.go
+	Expires    time.Time  // zero = never; see guests.go
+	Quota      int64      // bytes from quota=; 0 = -user-quota, -1 = none; see quota.go
 }
 
 type stringSlice []string
@@ -98,6 +148,7 @@ var (
 	maxUploadSize int64
 	allowUpload   bool
 	allowModify   bool
+	allowChdir    bool
 	users         map[string]User
 	requireAuth   bool
 )
@@ -107,7 +158,7 @@ const htmlTemplate = `<!DOCTYPE html>
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>GoServe - {{.Path}}</title>
+    <title>{{with .Brand.Title}}{{.}}{{else}}GoServe{{end}} - {{.Path}}</title>
     <link rel="icon" type="image/svg+xml" href="data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 48 48'%3E%3Cdefs%3E%3ClinearGradient id='g' x1='0%25' y1='0%25' x2='100%25' y2='100%25'%3E%3Cstop offset='0%25' style='stop-color:%2300ADD8'/%3E%3Cstop offset='100%25' style='stop-color:%235DC9E2'/%3E%3C/linearGradient%3E%3C/defs%3E%3Cpath d='M8 24 Q16 12 24 24 T40 24' stroke='url(%23g)' stroke-width='4' fill='none' stroke-linecap='round' opacity='0.7'/%3E%3Cpath d='M8 30 Q16 20 24 30 T40 30' stroke='url(%23g)' stroke-width='4' fill='none' stroke-linecap='round' opacity='0.5'/%3E%3Ccircle cx='24' cy='24' r='8' fill='url(%23g)'/%3E%3Ccircle cx='24' cy='24' r='5' fill='%23fff'/%3E%3Cpath d='M24 20 L24 28 M24 20 L22 22 M24 20 L26 22' stroke='url(%23g)' stroke-width='2' stroke-linecap='round' fill='none'/%3E%3C/svg%3E">
     <link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/codemirror.min.css">
     <link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/theme/monokai.min.css">
@@ -119,579 +170,35 @@ const htmlTemplate = `<!DOCTYPE html>
     <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/css/css.min.js"></script>
     <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/markdown/markdown.min.js"></script>
     <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/shell/shell.min.js"></script>
-    <style>
-        :root {
-            --bg-primary: #dce0e8;
-            --bg-secondary: #eff1f5;
-
-            --text-primary: #4c4f69;
-            --text-secondary: #6c6f85;
-            --border-color: #ccd0da;
-            --hover-bg: #e6e9ef;
-            --accent: #1e66f5;
-        }
-        [data-theme="catppuccin-mocha"] {
-            --bg-primary: #11111b;
-            --bg-secondary: #1e1e2e;
-
-            --text-primary: #cdd6f4;
-            --text-secondary: #a6adc8;
-            --border-color: #313244;
-            --hover-bg: #313244;
-            --accent: #89b4fa;
-        }
-        [data-theme="dracula"] {
-            --bg-primary: #1e1f29;
-            --bg-secondary: #282a36;
-
-            --text-primary: #f8f8f2;
-            --text-secondary: #6272a4;
-            --border-color: #44475a;
-            --hover-bg: #44475a;
-            --accent: #bd93f9;
-        }
-        [data-theme="nord"] {
-            --bg-primary: #242933;
-            --bg-secondary: #2e3440;
-
-            --text-primary: #d8dee9;
-            --text-secondary: #4c566a;
-            --border-color: #3b4252;
-            --hover-bg: #3b4252;
-            --accent: #88c0d0;
-        }
-        [data-theme="solarized-dark"] {
-            --bg-primary: #001e26;
-            --bg-secondary: #002b36;
-
-            --text-primary: #839496;
-            --text-secondary: #586e75;
-            --border-color: #073642;
-            --hover-bg: #073642;
-            --accent: #268bd2;
-        }
-        [data-theme="solarized-light"] {
-            --bg-primary: #fdf6e3;
-            --bg-secondary: #eee8d5;
-
-            --text-primary: #657b83;
-            --text-secondary: #93a1a1;
-            --border-color: #ddd6c1;
-            --hover-bg: #fdf6e3;
-            --accent: #268bd2;
-        }
-        [data-theme="one-dark"] {
-            --bg-primary: #1b1f23;
-            --bg-secondary: #21252b;
-
-            --text-primary: #abb2bf;
-            --text-secondary: #5c6370;
-            --border-color: #181a1f;
-            --hover-bg: #2c313a;
-            --accent: #61afef;
-        }
-        [data-theme="gruvbox"] {
-            --bg-primary: #1d2021;
-            --bg-secondary: #282828;
-
-            --text-primary: #ebdbb2;
-            --text-secondary: #a89984;
-            --border-color: #3c3836;
-            --hover-bg: #3c3836;
-            --accent: #b8bb26;
-        }
-        [data-theme="monokai-dimmed"] {
-            --bg-primary: #1e1e1e;
-            --bg-secondary: #272727;
-
-            --text-primary: #c5c8c6;
-            --text-secondary: #b0b0b0;
-            --border-color: #303030;
-            --hover-bg: #383838;
-            --accent: #707070;
-        }
-        [data-theme="abyss"] {
-            --bg-primary: #000c18;
-            --bg-secondary: #000c18;
-
-            --text-primary: #6688cc;
-            --text-secondary: #384887;
-            --border-color: #082050;
-            --hover-bg: #082050;
-            --accent: #225588;
-        }
-        [data-theme="github-light"] {
-            --bg-primary: #f0f3f6;
-            --bg-secondary: #ffffff;
-
-            --text-primary: #1f2328;
-            --text-secondary: #656d76;
-            --border-color: #d0d7de;
-            --hover-bg: #f6f8fa;
-            --accent: #0969da;
-        }
-        [data-theme="ibm-3278"] {
-            --bg-primary: #010401;
-            --bg-secondary: #020602;
-
-            --text-primary: #33ff33;
-            --text-secondary: #1a9a1a;
-            --border-color: #0a3a0a;
-            --hover-bg: #0a1a0a;
-            --accent: #33ff33;
-        }
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
-            background: var(--bg-primary);
-            color: var(--text-primary);
-            margin: 0;
-            height: 100vh;
-            overflow: hidden;
-            transition: background 0.3s, color 0.3s;
-        }
-        .container {
-            display: flex;
-            flex-direction: column;
-            height: 100vh;
-            background: var(--bg-secondary);
-            overflow: hidden;
-        }
-        header {
-            background: var(--bg-secondary);
-            color: var(--text-primary);
-            padding: 12px 20px;
-            display: flex;
-            align-items: center;
-            gap: 6px;
-            border-bottom: 1px solid var(--border-color);
-        }
-        .title {
-            font-size: 18px;
-            font-weight: 700;
-            color: var(--text-primary);
-        }
-        .title .accent {
-            color: var(--accent);
-        }
-        .breadcrumb {
-            display: flex;
-            gap: 8px;
-            flex-wrap: wrap;
-            font-size: 14px;
-            align-items: center;
-        }
-        .breadcrumb a {
-            color: var(--accent);
-            text-decoration: none;
-            transition: opacity 0.2s;
-        }
-        .breadcrumb a:hover { opacity: 0.7; }
-        .breadcrumb span { 
-            opacity: 0.5;
-            color: var(--text-secondary);
-        }
-        .btn {
-            background: var(--bg-primary);
-            border: 1px solid var(--border-color);
-            color: var(--text-primary);
-            padding: 6px 12px;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 13px;
-            transition: all 0.2s;
-        }
-        .btn:hover {
-            background: var(--hover-bg);
-            border-color: var(--accent);
-        }
-        h1 { font-size: 24px; margin-bottom: 10px; }
-        .toolbar {
-            padding: 0 20px;
-            border-bottom: 1px solid var(--border-color);
-            display: flex;
-            gap: 15px;
-            align-items: center;
-            height: 44px;
-        }
-        .selection-bar {
-            display: none;
-            align-items: center;
-            gap: 6px;
-            margin-left: auto;
-        }
-        .selection-bar.active { display: flex; }
-        .selection-count {
-            font-size: 14px;
-            font-weight: 500;
-            color: var(--text-primary);
-            margin-right: 8px;
-            white-space: nowrap;
-        }
-        .sel-btn {
-            background: none;
-            border: none;
-            color: var(--text-secondary);
-            cursor: pointer;
-            padding: 4px;
-            border-radius: 4px;
-            font-size: 18px;
-            line-height: 1;
-            transition: all 0.15s;
-            display: flex;
-            align-items: center;
-        }
-        .sel-btn:hover { background: var(--hover-bg); color: var(--text-primary); }
-        .sel-btn.danger:hover { color: #dc3545; }
-        .search-box {
-            width: 33%;
-            min-width: 150px;
-            margin-left: auto;
-            padding: 6px 12px;
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            background: var(--bg-primary);
-            color: var(--text-primary);
-            font-size: 13px;
-        }
-        .btn-primary {
-            background: var(--bg-secondary);
-            border: 1px solid var(--border-color);
-            color: var(--accent);
-            padding: 8px 16px;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 13px;
-            font-weight: 500;
-            transition: all 0.2s;
-        }
-        .btn-primary:hover { background: var(--hover-bg); border-color: var(--accent); }
-        .btn-secondary {
-            background: var(--bg-secondary);
-            border: 1px solid var(--border-color);
-            color: var(--accent);
-            padding: 8px 16px;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 13px;
-            transition: all 0.2s;
-        }
-        .btn-secondary:hover {
-            background: var(--hover-bg);
-            border-color: var(--accent);
-        }
-        .breadcrumb-caret {
-            background: none;
-            border: none;
-            color: var(--accent);
-            cursor: pointer;
-            font-size: 14px;
-            padding: 0 2px;
-            margin-left: 2px;
-            transition: opacity 0.2s;
-        }
-        .breadcrumb-caret:hover { opacity: 0.7; }
-        .context-menu {
-            display: none;
-            position: fixed;
-            background: var(--bg-secondary);
-            border: 1px solid var(--border-color);
-            border-radius: 6px;
-            box-shadow: 0 4px 12px rgba(0,0,0,0.15);
-            z-index: 500;
-            min-width: 180px;
-            padding: 4px 0;
-        }
-        .context-menu.show { display: block; }
-        .context-menu-item {
-            padding: 8px 16px;
-            cursor: pointer;
-            font-size: 13px;
-            color: var(--text-primary);
-            display: flex;
-            align-items: center;
-            gap: 10px;
-            width: 100%;
-            border: none;
-            background: none;
-            text-align: left;
-        }
-        .context-menu-item svg { flex-shrink: 0; }
-        .context-menu-item:hover { background: var(--hover-bg); }
-        .context-menu-separator {
-            height: 1px;
-            background: var(--border-color);
-            margin: 4px 0;
-        }
-        .modal-input {
-            width: 100%;
-            padding: 8px 12px;
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            background: var(--bg-secondary);
-            color: var(--text-primary);
-            font-size: 14px;
-            margin: 15px 0;
-            box-sizing: border-box;
-        }
-        .modal-buttons {
-            display: flex;
-            gap: 10px;
-            justify-content: flex-end;
-        }
-        .dialog-overlay {
-            display: none;
-            position: fixed;
-            top: 0; left: 0; right: 0; bottom: 0;
-            background: rgba(0,0,0,0.5);
-            z-index: 2000;
-            align-items: center;
-            justify-content: center;
-        }
-        .dialog-overlay.active { display: flex; }
-        .dialog-box {
-            background: var(--bg-secondary);
-            border: 1px solid var(--border-color);
-            border-radius: 8px;
-            padding: 24px;
-            min-width: 340px;
-            max-width: 480px;
-            box-shadow: 0 8px 32px rgba(0,0,0,0.3);
-        }
-        .dialog-title {
-            font-size: 16px;
-            font-weight: 600;
-            color: var(--text-primary);
-            margin: 0 0 8px 0;
-        }
-        .dialog-message {
-            font-size: 14px;
-            color: var(--text-secondary);
-            margin: 0 0 20px 0;
-            line-height: 1.5;
-            white-space: pre-wrap;
-            word-break: break-word;
-        }
-        .dialog-input {
-            width: 100%;
-            padding: 8px 12px;
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            background: var(--bg-primary);
-            color: var(--text-primary);
-            font-size: 14px;
-            margin-bottom: 20px;
-            box-sizing: border-box;
-        }
-        .dialog-input:focus { outline: none; border-color: var(--accent); }
-        .dialog-buttons {
-            display: flex;
-            gap: 8px;
-            justify-content: flex-end;
-        }
-        .dialog-btn {
-            padding: 8px 20px;
-            border-radius: 4px;
-            font-size: 13px;
-            font-weight: 500;
-            cursor: pointer;
-            border: 1px solid var(--border-color);
-            background: var(--bg-primary);
-            color: var(--text-primary);
-            transition: all 0.15s;
-        }
-        .dialog-btn:hover { background: var(--hover-bg); }
-        .dialog-btn.primary {
-            background: var(--accent);
-            color: white;
-            border-color: var(--accent);
-        }
-        .dialog-btn.primary:hover { opacity: 0.9; }
-        .dialog-btn.danger {
-            background: #dc3545;
-            color: white;
-            border-color: #dc3545;
-        }
-        .dialog-btn.danger:hover { opacity: 0.9; }
-        table {
-            width: 100%;
-            border-collapse: collapse;
-        }
-        .table-container {
-            flex: 1;
-            overflow-y: auto;
-            min-height: 0;
-        }
-        thead {
-            background: var(--hover-bg);
-            border-bottom: 2px solid var(--border-color);
-            position: sticky;
-            top: 0;
-            z-index: 5;
-        }
-        th {
-            text-align: left;
-            padding: 8px 20px;
-            font-weight: 600;
-            color: var(--text-primary);
-            font-size: 14px;
-            cursor: pointer;
-            user-select: none;
-        }
-        th:hover { background: var(--border-color); }
-        .sort-arrow { display: inline-block; width: 18px; height: 18px; vertical-align: middle; margin-left: 4px; border-radius: 50%; text-align: center; line-height: 18px; font-size: 13px; }
-        th.sorted .sort-arrow { background: var(--accent); color: white; }
-        td {
-            padding: 6px 20px;
-            border-bottom: 1px solid var(--border-color);
-        }
-        tbody tr { cursor: default; user-select: none; }
-        tr:hover { background: var(--hover-bg); }
-        tr.selected { background: var(--accent); }
-        tr.selected td { color: white; }
-        tr.selected .file-link { color: white; }
-        tr.selected .size, tr.selected .modified { color: rgba(255,255,255,0.8); }
-        tr.selected:hover { background: var(--accent); }
-        .icon {
-            font-size: 20px;
-            margin-right: 10px;
-            display: inline-block;
-            width: 24px;
-            text-align: center;
-        }
-        .file-link {
-            color: var(--text-primary);
-            text-decoration: none;
-            display: flex;
-            align-items: center;
-            flex: 1;
-        }
-        .file-link:hover { color: var(--accent); }
-        .name { font-weight: 500; }
-        .size, .modified { color: var(--text-secondary); font-size: 14px; }
-        footer {
-            padding: 4px 16px;
-            display: flex;
-            align-items: center;
-            justify-content: space-between;
-            color: var(--text-secondary);
-            font-size: 12px;
-            border-top: 1px solid var(--border-color);
-            background: var(--bg-secondary);
-            flex-shrink: 0;
-            position: relative;
-        }
-        .footer-left { display: flex; align-items: center; gap: 8px; }
-        .footer-right { display: flex; align-items: center; gap: 12px; }
-        .footer-btn {
-            background: none;
-            border: none;
-            color: var(--text-secondary);
-            cursor: pointer;
-            padding: 4px;
-            display: flex;
-            align-items: center;
-            border-radius: 4px;
-        }
-        .footer-btn:hover { color: var(--text-primary); background: var(--hover-bg); }
-        .footer-menu {
-            display: none;
-            position: absolute;
-            bottom: 100%;
-            left: 8px;
-            background: var(--bg-secondary);
-            border: 1px solid var(--border-color);
-            border-radius: 6px;
-            box-shadow: 0 -4px 16px rgba(0,0,0,0.2);
-            min-width: 200px;
-            padding: 6px 0;
-            z-index: 100;
-            margin-bottom: 4px;
-        }
-        .footer-menu.active { display: block; }
-        .footer-menu-item {
-            padding: 8px 14px;
-            display: flex;
-            align-items: center;
-            gap: 10px;
-            cursor: pointer;
-            font-size: 13px;
-            color: var(--text-primary);
-            border: none;
-            background: none;
-            width: 100%;
-            text-align: left;
-        }
-        .footer-menu-item:hover { background: var(--hover-bg); }
-        .footer-menu-item select {
-            flex: 1;
-            background: var(--bg-primary);
-            color: var(--text-primary);
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            padding: 4px 8px;
-            font-size: 12px;
-            cursor: pointer;
-        }
-        .footer-menu-separator { height: 1px; background: var(--border-color); margin: 4px 0; }
-        .preview-modal {
-            display: none;
-            position: fixed;
-            top: 0;
-            left: 0;
-            right: 0;
-            bottom: 0;
-            background: rgba(0,0,0,0.8);
-            z-index: 1000;
-            padding: 40px;
-        }
-        .preview-content {
-            background: var(--bg-secondary);
-            border-radius: 8px;
-            max-width: 900px;
-            max-height: 90vh;
-            margin: 0 auto;
-            overflow: auto;
-            padding: 30px;
-        }
-        .preview-close {
-            float: right;
-            font-size: 28px;
-            cursor: pointer;
-            color: var(--text-secondary);
-        }
-        .preview-close:hover { color: var(--text-primary); }
-        kbd {
-            background: var(--hover-bg);
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            padding: 2px 8px;
-            font-family: monospace;
-            font-size: 0.9em;
-            box-shadow: 0 2px 0 rgba(0,0,0,0.1);
-        }
-        .markdown-body { line-height: 1.6; }
-        .markdown-body h1, .markdown-body h2 { margin-top: 24px; margin-bottom: 16px; }
-        .markdown-body pre { background: var(--hover-bg); padding: 16px; border-radius: 6px; overflow: auto; }
-        .markdown-body code { background: var(--hover-bg); padding: 2px 6px; border-radius: 3px; }
-        .hidden { display: none !important; }
-        @media (max-width: 768px) {
-            .modified { display: none; }
-        }
-    </style>
+    <link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/addon/dialog/dialog.min.css">
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/addon/dialog/dialog.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/addon/search/searchcursor.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/addon/search/search.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/addon/search/jump-to-line.min.js"></script>
+    <link rel="stylesheet" href="{{asset "app.css"}}">
+    <noscript><meta http-equiv="refresh" content="0; url=?basic=1"></noscript>
 </head>
-<body>
+<body{{if .Compact}} class="compact"{{end}}>
+    <a class="skip-link" href="?basic=1">Basic version, for screen readers and browsers without JavaScript</a>
     <div class="container">
         <header>
-            <svg width="36" height="36" viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg">
-                <path d="M8 24 Q16 12, 24 24 T40 24" stroke="var(--accent)" stroke-width="4" fill="none" stroke-linecap="round" opacity="0.7"/>
-                <path d="M8 30 Q16 20, 24 30 T40 30" stroke="var(--accent)" stroke-width="4" fill="none" stroke-linecap="round" opacity="0.5"/>
-                <circle cx="24" cy="24" r="8" fill="var(--accent)"/>
-                <circle cx="24" cy="24" r="5" fill="var(--bg-secondary)"/>
-                <path d="M24 20 L24 28 M24 20 L22 22 M24 20 L26 22" stroke="var(--accent)" stroke-width="2" stroke-linecap="round" fill="none"/>
-            </svg>
-            <span class="title">Go<span class="accent">Serve</span></span>
-            <input type="text" class="search-box" id="searchBox" placeholder="⌕ Search  |  : command" onkeyup="filterFiles()" onkeydown="handleSearchKey(event)">
+            {{if .Brand.Logo}}
+            <img class="brand-logo" src="{{.Brand.Logo}}" alt="">
+            {{else}}
+                <svg width="36" height="36" viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg">
+                    <path d="M8 24 Q16 12, 24 24 T40 24" stroke="var(--accent)" stroke-width="4" fill="none" stroke-linecap="round" opacity="0.7"/>
+                    <path d="M8 30 Q16 20, 24 30 T40 30" stroke="var(--accent)" stroke-width="4" fill="none" stroke-linecap="round" opacity="0.5"/>
+                    <circle cx="24" cy="24" r="8" fill="var(--accent)"/>
+                    <circle cx="24" cy="24" r="5" fill="var(--bg-secondary)"/>
+                    <path d="M24 20 L24 28 M24 20 L22 22 M24 20 L26 22" stroke="var(--accent)" stroke-width="2" stroke-linecap="round" fill="none"/>
+                </svg>
+            {{end}}
+            {{with .Brand.Title}}<span class="title">{{.}}</span>{{else}}<span class="title">Go<span class="accent">Serve</span></span>{{end}}
+            <div class="search-wrap">
+                <input type="text" class="search-box" id="searchBox" placeholder="⌕ Search  |  : command" value="{{.Filter}}" onkeyup="filterFiles()" onkeydown="handleSearchKey(event)">
+                <button class="search-mode" id="searchMode" onclick="toggleRecursiveSearch()" aria-label="Search subfolders">⊆</button>
+                <div class="find-results" id="findResults"></div>
+            </div>
         </header>
 
         <div class="toolbar" id="toolbar">
@@ -707,38 +214,88 @@ const htmlTemplate = `<!DOCTYPE html>
                 <span class="selection-count" id="selectionCount">0 selected</span>
                 <button class="sel-btn" onclick="ctxDownloadSelected()" title="Download"><svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 3v12m0 0l-5-5m5 5l5-5"/><path d="M5 21h14"/></svg></button>
                 <button class="sel-btn" onclick="ctxCopyLink()" title="Copy link"><svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/></svg></button>
-                {{if .CanModify}}
+                {{if .Caps.Edit}}
                 <button class="sel-btn" id="selEditBtn" onclick="ctxEditSelected()" title="Edit"><svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="transform:scaleX(-1)"><path d="M12 20h9"/><path d="M16.5 3.5a2.12 2.12 0 013 3L7 19l-4 1 1-4L16.5 3.5z"/></svg></button>
+                {{end}}
+                {{if .Caps.Rename}}
                 <button class="sel-btn" id="selRenameBtn" onclick="ctxRenameSelected()" title="Rename"><svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M7 4v16"/><path d="M4 4h6"/><path d="M4 20h6"/><path d="M14 4h6"/><path d="M14 20h6"/><path d="M17 4v16"/><path d="M10 12h4"/></svg></button>
+                {{end}}
+                {{if .Caps.Delete}}
                 <button class="sel-btn danger" onclick="ctxDeleteSelected()" title="Delete"><svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M3 6h18M8 6V4h8v2"/><path d="M5 6v14a2 2 0 002 2h10a2 2 0 002-2V6"/><path d="M10 11v6M14 11v6"/></svg></button>
                 {{end}}
             </div>
-            {{if .CanUpload}}
+            {{if .Caps.Upload}}
             <input type="file" name="files" multiple id="fileInput" style="display:none;">
             <input type="file" name="directory" webkitdirectory directory id="dirInput" style="display:none;">
             {{end}}
         </div>
 
         <div id="folderContextMenu" class="context-menu">
-            {{if .CanModify}}
+            {{if .Caps.Mkdir}}
             <button class="context-menu-item" onclick="showNewFolderModal()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M22 19a2 2 0 01-2 2H4a2 2 0 01-2-2V5a2 2 0 012-2h5l2 3h9a2 2 0 012 2z"/><path d="M12 11v6M9 14h6"/></svg>New Folder</button>
             {{end}}
-            {{if .CanUpload}}
-            {{if .CanModify}}<div class="context-menu-separator"></div>{{end}}
+            {{if .Caps.Upload}}
+            {{if .Caps.Mkdir}}<div class="context-menu-separator"></div>{{end}}
             <button class="context-menu-item" onclick="triggerFileUpload()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M14 2H6a2 2 0 00-2 2v16a2 2 0 002 2h12a2 2 0 002-2V8z"/><polyline points="14 2 14 8 20 8"/><path d="M12 18v-6M9 15l3-3 3 3"/></svg>File Upload</button>
             <button class="context-menu-item" onclick="triggerFolderUpload()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M22 19a2 2 0 01-2 2H4a2 2 0 01-2-2V5a2 2 0 012-2h5l2 3h9a2 2 0 012 2z"/><path d="M12 11v6M9 12l3-3 3 3"/></svg>Folder Upload</button>
             {{end}}
             <div class="context-menu-separator"></div>
+            <button class="context-menu-item" onclick="downloadEncryptedZip()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="4" y="11" width="16" height="10" rx="2"/><path d="M8 11V7a4 4 0 018 0v4"/></svg>Download Encrypted ZIP...</button>
+            <button class="context-menu-item" onclick="downloadEncryptedTar()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="4" y="11" width="16" height="10" rx="2"/><path d="M8 11V7a4 4 0 018 0v4"/></svg>Download Encrypted TAR...</button>
+            <button class="context-menu-item" onclick="hideAllMenus(); window.location.href = window.location.pathname + '?tar=1'"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M10 12h4"/></svg>Download as TAR</button>
+            <button class="context-menu-item" onclick="hideAllMenus(); window.location.href = window.location.pathname + '?targz=1'"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M9 12h6M12 12v5"/></svg>Download as TAR.GZ</button>
+            <button class="context-menu-item" onclick="hideAllMenus(); window.location.href = window.location.pathname + '?tar=zst'"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M9 12h6l-6 5h6"/></svg>Download as TAR.ZST</button>
+            {{if .ArchiveJobs}}
+            <button class="context-menu-item" onclick="startJob('archive', {format: 'zip'})"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M12 11v6M9 14l3 3 3-3"/></svg>Prepare ZIP in Background</button>
+            {{end}}
+            {{if .Caps.Upload}}
+            <button class="context-menu-item" onclick="startJob('checksum')"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M9 11l3 3L22 4"/><path d="M21 12v7a2 2 0 01-2 2H5a2 2 0 01-2-2V5a2 2 0 012-2h11"/></svg>Create Checksum Manifest</button>
+            {{end}}
+            {{if and .Caps.Mkdir .Caps.Rename}}
+            <button class="context-menu-item" onclick="showOrganize()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="3" y="4" width="18" height="18" rx="2"/><path d="M16 2v4M8 2v4M3 10h18"/></svg>Organize by Date...</button>
+            {{end}}
+            <button class="context-menu-item" onclick="showDuplicates()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="9" y="9" width="12" height="12" rx="2"/><path d="M5 15H4a2 2 0 01-2-2V4a2 2 0 012-2h9a2 2 0 012 2v1"/></svg>Find Duplicates...</button>
+            {{if .Caps.Share}}
+            <button class="context-menu-item" onclick="showUploadLinks()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/><path d="M19 15v6M16 18l3-3 3 3"/></svg>Create Upload Link...</button>
+            {{end}}
+            <button class="context-menu-item" onclick="showGrepModal()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><circle cx="11" cy="11" r="7"/><path d="M21 21l-4.35-4.35"/></svg>Find in Files</button>
             <button class="context-menu-item" onclick="copyFolderLink()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/></svg>Copy Link</button>
+            <button class="context-menu-item" onclick="copyShortLink(decodeURIComponent(window.location.pathname))"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/><path d="M4 4l4 4M4 4h3M4 4v3"/></svg>Copy Short Link</button>
+            <div class="context-menu-separator"></div>
+            <button class="context-menu-item" onclick="toggleHiddenFiles({{if .ShowHidden}}false{{else}}true{{end}})"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M1 12s4-8 11-8 11 8 11 8-4 8-11 8-11-8-11-8z"/><circle cx="12" cy="12" r="3"/>{{if .ShowHidden}}<path d="M3 3l18 18"/>{{end}}</svg>{{if .ShowHidden}}Hide Hidden Files{{else}}Show Hidden Files{{if .HiddenFiles}} ({{.HiddenFiles}}){{end}}{{end}}</button>
         </div>
 
         <div id="rowContextMenu" class="context-menu">
             <button class="context-menu-item" id="ctxDownload" onclick="ctxDownloadSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 3v12m0 0l-5-5m5 5l5-5"/><path d="M5 21h14"/></svg>Download</button>
+            <button class="context-menu-item" onclick="ctxQueueDownloads()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M3 6h11M3 12h11M3 18h7"/><path d="M18 9v10m0 0l-3-3m3 3l3-3"/></svg>Add to Download Queue</button>
+            <button class="context-menu-item" onclick="ctxDownloadTar()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M10 12h4"/></svg>Download as TAR</button>
+            <button class="context-menu-item" onclick="ctxDownloadEncryptedZip()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="4" y="11" width="16" height="10" rx="2"/><path d="M8 11V7a4 4 0 018 0v4"/></svg>Download Encrypted ZIP...</button>
+            <button class="context-menu-item" onclick="ctxDownloadEncryptedTar()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="4" y="11" width="16" height="10" rx="2"/><path d="M8 11V7a4 4 0 018 0v4"/></svg>Download Encrypted TAR...</button>
+            {{if .Caps.Upload}}
+            <button class="context-menu-item" id="ctxExtract" onclick="ctxExtractSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 8v13H3V8"/><path d="M1 3h22v5H1z"/><path d="M12 17v-6M9 14l3-3 3 3"/></svg>Extract Here</button>
+            {{end}}
             <button class="context-menu-item" onclick="ctxCopyLink()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/></svg>Copy Link</button>
-            {{if .CanModify}}
-            <div class="context-menu-separator"></div>
+            <button class="context-menu-item" id="ctxShortLink" onclick="ctxCopyShortLink()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/><path d="M4 4l4 4M4 4h3M4 4v3"/></svg>Copy Short Link</button>
+            {{if .Caps.Copy}}
+            <button class="context-menu-item" onclick="ctxCopySelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="9" y="9" width="13" height="13" rx="2"/><path d="M5 15H4a2 2 0 01-2-2V4a2 2 0 012-2h9a2 2 0 012 2v1"/></svg>Copy To...</button>
+            {{end}}
+            {{if .Caps.Rename}}
+            <button class="context-menu-item" onclick="ctxMoveSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M22 19a2 2 0 01-2 2H4a2 2 0 01-2-2V5a2 2 0 012-2h5l2 3h9a2 2 0 012 2z"/><path d="M9 14h6M13 11l3 3-3 3"/></svg>Move To...</button>
+            {{end}}
+            {{if or .Caps.Edit .Caps.Rename .Caps.Touch .Caps.Chmod .Caps.Delete}}<div class="context-menu-separator"></div>{{end}}
+            {{if .Caps.Edit}}
             <button class="context-menu-item" id="ctxEdit" onclick="ctxEditSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="transform:scaleX(-1)"><path d="M12 20h9"/><path d="M16.5 3.5a2.12 2.12 0 013 3L7 19l-4 1 1-4L16.5 3.5z"/></svg>Edit</button>
+            {{end}}
+            {{if .Caps.Rename}}
             <button class="context-menu-item" id="ctxRename" onclick="ctxRenameSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M7 4v16"/><path d="M4 4h6"/><path d="M4 20h6"/><path d="M14 4h6"/><path d="M14 20h6"/><path d="M17 4v16"/><path d="M10 12h4"/></svg>Rename</button>
+            {{end}}
+            {{if .Caps.Touch}}
+            <button class="context-menu-item" onclick="ctxTouchSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><circle cx="12" cy="12" r="10"/><path d="M12 6v6l4 2"/></svg>Set Modified Time</button>
+            {{end}}
+            {{if .Caps.Chmod}}
+            <button class="context-menu-item" onclick="ctxChmodSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 2l8 3v6c0 5-3.5 9-8 11-4.5-2-8-6-8-11V5z"/><path d="M9 12l2 2 4-4"/></svg>Change Permissions...</button>
+            {{end}}
+            {{if .Caps.Delete}}
             <button class="context-menu-item" id="ctxDelete" onclick="ctxDeleteSelected()"><svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M3 6h18M8 6V4h8v2"/><path d="M5 6v14a2 2 0 002 2h10a2 2 0 002-2V6"/><path d="M10 11v6M14 11v6"/></svg>Delete</button>
             {{end}}
         </div>
@@ -749,24 +306,44 @@ const htmlTemplate = `<!DOCTYPE html>
                 <tr>
                     <th onclick="sortTable(0)">Name <span class="sort-arrow"></span></th>
                     <th onclick="sortTable(1)">Size <span class="sort-arrow"></span></th>
-                    <th class="modified" onclick="sortTable(2)">Modified <span class="sort-arrow"></span></th>
+                    {{if not .Compact}}<th class="modified" onclick="sortTable(2)">Modified <span class="sort-arrow"></span></th>{{end}}
+                    {{if and .ShowPerms (not .Compact)}}<th class="perms">Owner</th><th class="perms">Mode</th>{{end}}
                 </tr>
             </thead>
             <tbody>
                 {{range .Files}}
-                <tr data-path="{{.Path}}" data-name="{{.Name}}" data-isdir="{{.IsDir}}" data-size="{{.RawSize}}" data-mod="{{.RawMod}}" {{if .IsEditable}}data-editable="true"{{end}}>
+                {{if .Error}}
+                <tr class="entry-error" data-path="{{.Path}}" data-name="{{.Name}}" data-isdir="false" data-size="0" data-mod="0" data-error="{{.Error}}" title="{{.Error}}">
+                    <td>
+                        <span class="file-link">
+                            <span class="icon">{{.Icon}}</span>
+                            <span class="name">{{.Name}}</span>
+                            {{if .LinkTarget}}<span class="link-target">→ {{.LinkTarget}}</span>{{end}}
+                            <span class="entry-error-reason">{{.Error}}</span>
+                        </span>
+                    </td>
+                    <td class="size">{{.Size}}</td>
+                    {{if not $.Compact}}<td class="modified"></td>{{end}}
+                    {{if and $.ShowPerms (not $.Compact)}}<td class="perms"></td><td class="perms"></td>{{end}}
+                </tr>
+                {{else}}
+                <tr data-path="{{.Path}}" data-name="{{.Name}}" data-isdir="{{.IsDir}}" data-size="{{.RawSize}}" data-mod="{{.RawMod}}" {{if .Perm}}data-perm="{{.Perm}}" {{end}}{{if .IsEditable}}data-editable="true"{{end}}{{if .SizePending}} data-size-pending="true"{{end}}>
                     <td>
                         <a href="{{.Path}}" class="file-link">
                             <span class="icon">{{.Icon}}</span>
                             <span class="name">{{.Name}}</span>
+                            {{if .LinkTarget}}<span class="link-target" title="Link to {{.LinkTarget}}">→ {{.LinkTarget}}</span>{{end}}
                         </a>
                     </td>
                     <td class="size">{{.Size}}</td>
-                    <td class="modified">{{.ModTime}}</td>
+                    {{if not $.Compact}}<td class="modified">{{.ModTime}}</td>{{end}}
+                    {{if and $.ShowPerms (not $.Compact)}}<td class="perms">{{.Owner}}</td><td class="perms" title="{{.Perm}}">{{.Mode}}</td>{{end}}
                 </tr>
                 {{end}}
+                {{end}}
             </tbody>
         </table>
+        {{if .Hidden}}<a class="show-more" href="{{.MoreURL}}">Show {{.Hidden}} more</a>{{end}}
         </div>
 
         <footer>
@@ -792,14 +369,75 @@ const htmlTemplate = `<!DOCTYPE html>
                             <option value="ibm-3278">IBM 3278 Retro</option>
                         </select>
                     </div>
+                    {{if .Caps.Upload}}
+                    <label class="footer-menu-item">
+                        <input type="checkbox" id="keepDates">
+                        Keep file dates on upload
+                    </label>
+                    {{end}}
                     <div class="footer-menu-separator"></div>
+                    <button class="footer-menu-item" onclick="showDownloads(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 3v12m0 0l-5-5m5 5l5-5"/><path d="M5 21h14"/></svg>
+                        Download Queue
+                    </button>
+                    <button class="footer-menu-item" onclick="showUploadLinks(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M10 13a5 5 0 007.54.54l3-3a5 5 0 00-7.07-7.07l-1.72 1.71"/><path d="M14 11a5 5 0 00-7.54-.54l-3 3a5 5 0 007.07 7.07l1.71-1.71"/></svg>
+                        Links
+                    </button>
+                    <button class="footer-menu-item" onclick="showUsage(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M18 20V10M12 20V4M6 20v-6"/></svg>
+                        Bandwidth Usage
+                    </button>
+                    {{if .Caps.Paste}}
+                    <a class="footer-menu-item" href="/_paste" target="_blank" onclick="closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M16 4h2a2 2 0 012 2v14a2 2 0 01-2 2H6a2 2 0 01-2-2V6a2 2 0 012-2h2"/><rect x="8" y="2" width="8" height="4" rx="1"/></svg>
+                        New Paste
+                    </a>
+                    {{end}}
+                    {{if .Caps.Admin}}
+                    <button class="footer-menu-item" onclick="showAccessLog(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M14 2H6a2 2 0 00-2 2v16a2 2 0 002 2h12a2 2 0 002-2V8z"/><path d="M14 2v6h6M16 13H8M16 17H8M10 9H8"/></svg>
+                        Access Log
+                    </button>
+                    {{end}}
+                    {{if .GuestAdmin}}
+                    <button class="footer-menu-item" onclick="showGuests(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M16 21v-2a4 4 0 00-4-4H6a4 4 0 00-4 4v2"/><circle cx="9" cy="7" r="4"/><path d="M22 11h-6M19 8v6"/></svg>
+                        Guest Accounts
+                    </button>
+                    {{end}}
+                    <button class="footer-menu-item" onclick="showJobs(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M8 6h13M8 12h13M8 18h13M3 6h.01M3 12h.01M3 18h.01"/></svg>
+                        Jobs
+                    </button>
+                    <button class="footer-menu-item" onclick="showHelp(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><circle cx="12" cy="12" r="10"/><path d="M9.09 9a3 3 0 015.83 1c0 2-3 3-3 3M12 17h.01"/></svg>
+                        Help &amp; Shortcuts
+                    </button>
                     <button class="footer-menu-item" onclick="showAbout(); closeFooterMenu();">
                         <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><circle cx="12" cy="12" r="10"/><path d="M12 16v-4M12 8h.01"/></svg>
                         About
                     </button>
+                    {{if .SignedInAs}}
+                    <div class="footer-menu-separator"></div>
+                    {{if .SSO}}
+                    <button class="footer-menu-item" onclick="createAppPassword(); closeFooterMenu();">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><rect x="3" y="11" width="18" height="11" rx="2"/><path d="M7 11V7a5 5 0 0110 0v4"/></svg>
+                        WebDAV Password...
+                    </button>
+                    {{end}}
+                    <form method="POST" action="/_logout" style="margin: 0;">
+                        <button class="footer-menu-item" type="submit">
+                            <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M9 21H5a2 2 0 01-2-2V5a2 2 0 012-2h4M16 17l5-5-5-5M21 12H9"/></svg>
+                            Sign Out ({{.SignedInAs}})
+                        </button>
+                    </form>
+                    {{end}}
                 </div>
             </div>
             <div class="footer-right">
+                {{with .Quota}}<span id="quotaUsage" class="disk-space{{if .Low}} low{{end}}" title="Your storage quota">Yours: {{.Summary}}</span>{{end}}
+                {{with .Space}}<span id="diskSpace" class="disk-space{{if .Low}} low{{end}}"{{if .Low}} title="Low on disk space"{{end}}>{{if .Low}}⚠ {{end}}{{.Summary}}</span>{{end}}
                 <span id="itemCount">Items: {{len .Files}}</span>
             </div>
         </footer>
@@ -816,7 +454,9 @@ const htmlTemplate = `<!DOCTYPE html>
             <div style="display: flex; justify-content: space-between; align-items: center; padding: 10px; background: var(--hover-bg); border-radius: 4px; flex-shrink: 0;">
                 <span id="editorFileName" style="font-weight: 600; color: var(--text-primary);"></span>
                 <div>
-                    <button class="btn-primary" onclick="saveFile()" style="margin-right: 10px; display: inline-flex; align-items: center; gap: 6px;"><svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M19 21H5a2 2 0 01-2-2V5a2 2 0 012-2h11l5 5v11a2 2 0 01-2 2z"/><polyline points="17 21 17 13 7 13 7 21"/><polyline points="7 3 7 8 15 8"/></svg>Save</button>
+                    <button class="btn-secondary" onclick="editor.execCommand('find')" style="margin-right: 6px;" title="Find (Ctrl+F)">Find</button>
+                    <button class="btn-secondary" id="editorReplaceBtn" onclick="editor.execCommand('replace')" style="margin-right: 10px;" title="Replace (Shift+Ctrl+F)">Replace</button>
+                    <button class="btn-primary" id="editorSaveBtn" onclick="saveFile()" style="margin-right: 10px; display: inline-flex; align-items: center; gap: 6px;"><svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M19 21H5a2 2 0 01-2-2V5a2 2 0 012-2h11l5 5v11a2 2 0 01-2 2z"/><polyline points="17 21 17 13 7 13 7 21"/><polyline points="7 3 7 8 15 8"/></svg>Save</button>
                     <button class="btn-secondary" onclick="closeEditor()" style="display: inline-flex; align-items: center; gap: 6px;"><svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round"><path d="M18 6L6 18M6 6l12 12"/></svg>Cancel</button>
                 </div>
             </div>
@@ -838,6 +478,7 @@ const htmlTemplate = `<!DOCTYPE html>
                     <span class="title">Go<span class="accent">Serve</span></span>
                     <span style="font-size: 11px; color: var(--text-secondary); margin-left: 4px;">{{.Version}}</span>
                 </div>
+                <div id="updateBanner" style="display: none; background: var(--hover-bg); border: 1px solid var(--accent); border-radius: 6px; padding: 10px 14px; margin-bottom: 12px; font-size: 0.9em;"></div>
                 <p style="color: var(--text-secondary); margin-bottom: 20px;">Lightweight HTTP file server with WebDAV support</p>
                 
                 <h3 style="color: var(--accent); margin-bottom: 10px;">✨ Features</h3>
@@ -846,7 +487,7 @@ const htmlTemplate = `<!DOCTYPE html>
                     <li>📂 Directory upload with structure preservation</li>
                     <li>💾 WebDAV server - mount as network drive</li>
                     <li>🔍 Search & filter with wildcards (* and ?)</li>
-                    <li>👁️ File preview (images, text, markdown, code)</li>
+                    <li>👁️ File preview (images incl. HEIC/RAW, text, markdown, code)</li>
                     <li>📦 ZIP download for directories</li>
                     <li>🔒 Optional authentication (readonly/readwrite/all)</li>
                     <li>🌓 Dark mode toggle</li>
@@ -855,6 +496,7 @@ const htmlTemplate = `<!DOCTYPE html>
                 
                 <h3 style="color: var(--accent); margin-bottom: 10px;">⌨️ Keyboard Shortcuts</h3>
                 <ul style="color: var(--text-secondary); line-height: 1.8; margin-bottom: 20px;">
+                    <li><kbd>?</kbd> - All shortcuts and actions available to you</li>
                     <li><kbd>ESC</kbd> - Close preview/about modal</li>
                     <li><kbd>Ctrl+F</kbd> - Focus search (browser default)</li>
                 </ul>
@@ -867,9 +509,6 @@ const htmlTemplate = `<!DOCTYPE html>
                 <button onclick="copyWebDAVUrl()" style="padding: 8px 16px; background: var(--accent); color: white; border: none; border-radius: 4px; cursor: pointer; margin-bottom: 20px;">
                     📋 Copy URL
                 </button>
-                <script>
-                    document.getElementById('webdavUrl').value = window.location.protocol + '//' + window.location.host + '/webdav/';
-                </script>
                 
                 <h3 style="color: var(--accent); margin-bottom: 10px;">🌐 Share via Tailscale</h3>
                 <p style="color: var(--text-secondary); margin-bottom: 10px; font-size: 0.9em;">
@@ -889,6 +528,17 @@ const htmlTemplate = `<!DOCTYPE html>
                     </p>
                 </div>
                 
+                <h3 style="color: var(--accent); margin-bottom: 10px;">🖥️ Server Info</h3>
+                <div id="serverInfo" style="color: var(--text-secondary); font-size: 0.9em; margin-bottom: 10px;"></div>
+                <p style="margin-bottom: 20px;">
+                    <button class="btn" onclick="printServerInfo()">🖨️ Print</button>
+                    <a class="btn" href="/_info" download="goserve-info.json">⬇️ Export JSON</a>
+                </p>
+                <label style="display: block; color: var(--text-secondary); font-size: 0.9em; margin-bottom: 20px;">
+                    <input type="checkbox" id="updateCheckToggle" onchange="setUpdateCheck(this.checked)">
+                    Check GitHub for new releases when opening About
+                </label>
+
                 <h3 style="color: var(--accent); margin-bottom: 10px;">🔗 Links</h3>
                 <p style="color: var(--text-secondary);">
                     <a href="https://github.com/staceyw/goserve" target="_blank" style="color: var(--accent); text-decoration: none;">📦 GitHub Repository</a><br>
@@ -912,6 +562,197 @@ const htmlTemplate = `<!DOCTYPE html>
         </div>
     </div>
 
+    <div id="folderPickerModal" class="preview-modal" onclick="closeFolderPicker()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 520px;">
+            <span class="preview-close" onclick="closeFolderPicker()">&times;</span>
+            <h3 id="folderPickerTitle" style="color: var(--accent); margin-top: 0;">Move To</h3>
+            <p id="folderPickerItems" style="color: var(--text-secondary); font-size: 13px; margin: 0;"></p>
+            <div class="picker-path"><button class="btn" id="folderPickerUp" onclick="pickerUp()" title="Parent folder">&uarr;</button> <span id="folderPickerPath"></span></div>
+            <div id="folderPickerList" class="picker-list"></div>
+            <label class="picker-conflict">If a name is already taken:
+                <select id="folderPickerConflict">
+                    <option value="">Stop with an error</option>
+                    <option value="overwrite">Merge folders, replace files</option>
+                    <option value="skip">Merge folders, keep existing files</option>
+                    <option value="rename">Keep both, adding a number</option>
+                </select>
+            </label>
+            <div class="modal-buttons">
+                <button class="btn" onclick="closeFolderPicker()">Cancel</button>
+                <button class="btn-primary" id="folderPickerRun" onclick="runFolderPicker()">Move Here</button>
+            </div>
+        </div>
+    </div>
+
+    <div id="mergeModal" class="preview-modal" onclick="closeMerge()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 720px;">
+            <span class="preview-close" onclick="closeMerge()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Merge Folder</h3>
+            <p id="mergeSummary" style="color: var(--text-secondary); font-size: 12px;"></p>
+            <div id="mergeList" class="merge-list"></div>
+            <div style="text-align: right; margin-top: 10px;"><button class="btn" onclick="closeMerge()">Cancel</button> <button class="btn btn-primary" id="mergeRun" onclick="runMerge()">Upload Selected</button></div>
+        </div>
+    </div>
+
+    <div id="jobsModal" class="preview-modal" onclick="closeJobs()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeJobs()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Jobs</h3>
+            <div id="jobsList"></div>
+        </div>
+    </div>
+
+    <div id="organizeModal" class="preview-modal" onclick="closeOrganize()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeOrganize()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Organize by Date</h3>
+            <p style="color: var(--text-secondary); font-size: 12px;">Photos and videos in this folder move into Year/Month folders, dated by EXIF where the file has it and by modification time otherwise.</p>
+            <div id="organizeList" class="organize-list"></div>
+            <div style="text-align: right; margin-top: 10px;"><button class="btn" onclick="closeOrganize()">Cancel</button> <button class="btn btn-primary" id="organizeRun" onclick="runOrganize()" disabled>Organize</button></div>
+        </div>
+    </div>
+
+    <div id="duplicatesModal" class="preview-modal" onclick="closeDuplicates()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 720px;">
+            <span class="preview-close" onclick="closeDuplicates()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Duplicates</h3>
+            <p style="color: var(--text-secondary); font-size: 12px;">Identical files, and images that look alike (resized or re-encoded copies), in this folder and below. Nothing is changed here.</p>
+            <div id="duplicatesList" class="duplicates-list"></div>
+        </div>
+    </div>
+
+    <div id="downloadsModal" class="preview-modal" onclick="closeDownloads()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeDownloads()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Download Queue</h3>
+            <p id="downloadsTarget" style="color: var(--text-secondary); font-size: 12px;"></p>
+            <div id="downloadsList"></div>
+            <div style="text-align: right; margin-top: 10px;"><button class="btn" onclick="clearFinishedDownloads()">Clear Finished</button></div>
+        </div>
+    </div>
+
+    <div id="usageModal" class="preview-modal" onclick="closeUsage()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeUsage()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Bandwidth Usage</h3>
+            <div id="usageBody"></div>
+            <div style="text-align: right; margin-top: 10px;"><a class="btn" href="/api/v1/usage?format=csv">Export CSV</a> <a class="btn" href="/api/v1/transfers?format=csv">Export transfer history</a></div>
+        </div>
+    </div>
+
+    <div id="uploadLinksModal" class="preview-modal" onclick="closeUploadLinks()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeUploadLinks()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Links</h3>
+            {{if .Caps.Share}}
+            <h4 style="margin: 0 0 4px;">Upload links</h4>
+            <p style="color: var(--text-secondary); font-size: 13px; margin: 0 0 8px;">Anyone with the link can upload into <strong id="ulFolder"></strong>, but can't see or change anything else. Add a note to make it a file request ("please upload the Q3 report here").</p>
+            <div style="display: flex; flex-wrap: wrap; gap: 6px; font-size: 13px; align-items: center;">
+                <input type="text" id="ulLabel" class="modal-input" style="flex: 2 1 160px; margin: 0;" placeholder="Note shown to uploaders (optional)">
+                <select id="ulExpires" class="modal-input" style="flex: 1 1 100px; margin: 0;">
+                    <option value="1d">1 day</option>
+                    <option value="7d" selected>7 days</option>
+                    <option value="30d">30 days</option>
+                    <option value="">Never</option>
+                </select>
+                <input type="number" id="ulMaxMB" class="modal-input" style="flex: 1 1 100px; margin: 0;" min="0" placeholder="Total MB cap">
+                <input type="url" id="ulNotify" class="modal-input" style="flex: 3 1 240px; margin: 0;" placeholder="Notify URL when files arrive: webhook or ntfy topic (optional)">
+                <button class="btn-primary" onclick="createUploadLink()">Create</button>
+            </div>
+            <div id="uploadLinksList" style="margin-top: 12px;"></div>
+            {{end}}
+            <h4 style="margin: 12px 0 4px;">Short links</h4>
+            <p style="color: var(--text-secondary); font-size: 13px; margin: 0 0 8px;">Short aliases for deep paths, from <em>Copy Short Link</em> on any file or folder. Visitors still need access to the target.</p>
+            <div id="shortLinksList"></div>
+            <h4 style="margin: 12px 0 4px;">Watched folders</h4>
+            <p style="color: var(--text-secondary); font-size: 13px; margin: 0 0 8px;">Follow changes under <strong id="watchFolder"></strong> in a feed reader, or have each one sent to a webhook or ntfy topic. Anyone with the feed link can read it.</p>
+            <div style="display: flex; flex-wrap: wrap; gap: 6px; font-size: 13px; align-items: center;">
+                <input type="url" id="watchWebhook" class="modal-input" style="flex: 3 1 240px; margin: 0;" placeholder="Webhook or ntfy URL for each change (optional)">
+                <button class="btn-primary" onclick="watchFolder()">Watch</button>
+            </div>
+            <div id="watchesList" style="margin-top: 12px;"></div>
+        </div>
+    </div>
+
+    {{if .GuestAdmin}}
+    <div id="guestsModal" class="preview-modal" onclick="closeGuests()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 720px;">
+            <span class="preview-close" onclick="closeGuests()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Guest Accounts</h3>
+            <p style="color: var(--text-secondary); font-size: 13px; margin: 0 0 8px;">Temporary users who stop working when they expire, in the web UI, the API and WebDAV alike. Leave the password empty for a random one. The permission takes path rules as in the logins file, e.g. <code>readonly,/incoming=readwrite</code>.</p>
+            <div style="display: flex; flex-wrap: wrap; gap: 6px; font-size: 13px; align-items: center;" onkeydown="if(event.key==='Enter') createGuest()">
+                <input type="text" id="guestName" class="modal-input" style="flex: 1 1 110px; margin: 0;" placeholder="Username">
+                <input type="password" id="guestPassword" class="modal-input" style="flex: 1 1 110px; margin: 0;" placeholder="Password (optional)" autocomplete="new-password">
+                <input type="text" id="guestPermission" class="modal-input" style="flex: 2 1 160px; margin: 0;" value="readwrite" placeholder="Permission">
+                <input type="text" id="guestHome" class="modal-input" style="flex: 2 1 140px; margin: 0;" placeholder="Home folder (optional)">
+                <select id="guestExpires" class="modal-input" style="flex: 1 1 100px; margin: 0;">
+                    <option value="1d">1 day</option>
+                    <option value="7d" selected>7 days</option>
+                    <option value="30d">30 days</option>
+                    <option value="90d">90 days</option>
+                </select>
+                <button class="btn-primary" onclick="createGuest()">Add</button>
+            </div>
+            <div id="guestsList" style="margin-top: 12px;"></div>
+        </div>
+    </div>
+    {{end}}
+
+    <div id="helpModal" class="preview-modal" onclick="closeHelp()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 640px;">
+            <span class="preview-close" onclick="closeHelp()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Help</h3>
+            <div id="helpBody"></div>
+        </div>
+    </div>
+
+    <div id="accessLogModal" class="preview-modal" onclick="closeAccessLog()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 960px;">
+            <span class="preview-close" onclick="closeAccessLog()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Access Log</h3>
+            <div style="display: flex; flex-wrap: wrap; gap: 6px; font-size: 13px;" onkeydown="if(event.key==='Enter') loadAccessLog(1)">
+                <input type="text" id="alPath" class="modal-input" style="flex: 2 1 180px; margin: 0;" placeholder="Path (text or *.zip)">
+                <input type="text" id="alUser" class="modal-input" style="flex: 1 1 90px; margin: 0;" placeholder="User">
+                <input type="text" id="alIP" class="modal-input" style="flex: 1 1 90px; margin: 0;" placeholder="IP">
+                <input type="text" id="alStatus" class="modal-input" style="flex: 0 1 70px; margin: 0;" placeholder="Status">
+                <input type="datetime-local" id="alFrom" class="modal-input" style="flex: 1 1 150px; margin: 0;" title="From">
+                <input type="datetime-local" id="alTo" class="modal-input" style="flex: 1 1 150px; margin: 0;" title="To">
+                <button class="btn-primary" onclick="loadAccessLog(1)">Search</button>
+            </div>
+            <div id="accessLogBody" style="max-height: 60vh; overflow: auto; margin-top: 10px;"></div>
+            <div style="display: flex; justify-content: space-between; align-items: center; margin-top: 10px; font-size: 13px;">
+                <span id="accessLogInfo" style="color: var(--text-secondary);"></span>
+                <span><button class="btn" id="alPrev" onclick="loadAccessLog(accessLogPage - 1)">Newer</button>
+                <button class="btn" id="alNext" onclick="loadAccessLog(accessLogPage + 1)">Older</button></span>
+            </div>
+        </div>
+    </div>
+
+    <div id="grepModal" class="preview-modal" onclick="closeGrepModal()">
+        <div class="preview-content" onclick="event.stopPropagation()" style="max-width: 800px;">
+            <span class="preview-close" onclick="closeGrepModal()">&times;</span>
+            <h3 style="color: var(--accent); margin-top: 0;">Find in Files</h3>
+            <p style="color: var(--text-secondary); font-size: 13px; margin: 0;">Search text files in this folder and its subfolders</p>
+            <input type="text" id="grepQuery" class="modal-input" placeholder="Search text"
+                   onkeydown="if(event.key==='Enter') runGrep()">
+            <div style="display: flex; gap: 16px; font-size: 13px; color: var(--text-secondary); margin-bottom: 10px;">
+                <label><input type="checkbox" id="grepRegex"> Regular expression</label>
+                <label><input type="checkbox" id="grepCase"> Match case</label>
+                {{if .Indexed}}<label title="Look words up in the index instead of reading every file"><input type="checkbox" id="grepIndexed" checked> Whole words (indexed)</label>{{end}}
+            </div>
+            {{if .Caps.Edit}}
+            <input type="text" id="grepReplace" class="modal-input" placeholder="Replace with (optional)" style="margin-top: 0;">
+            {{end}}
+            <div class="modal-buttons">
+                {{if .Caps.Edit}}<button class="btn" onclick="replaceInResults()">Replace All</button>{{end}}
+                <button class="btn-primary" onclick="runGrep()">Search</button>
+            </div>
+            <div id="grepStatus" style="font-size: 12px; color: var(--text-secondary); margin-top: 10px;"></div>
+            {{if .Caps.Admin}}<a href="#" onclick="showSearchStatus(); return false;" style="font-size: 12px; color: var(--accent);">Search activity</a>{{end}}
+            <div id="grepResults" class="grep-results"></div>
+        </div>
+    </div>
+
     <div id="dialogOverlay" class="dialog-overlay" onclick="dialogCancel()">
         <div class="dialog-box" onclick="event.stopPropagation()">
             <div class="dialog-title" id="dialogTitle"></div>
@@ -922,876 +763,20 @@ const htmlTemplate = `<!DOCTYPE html>
         </div>
     </div>
 
-    <script>
-        // --- Custom dialog system ---
-        var _dialogResolve = null;
-
-        function dialogCancel() {
-            document.getElementById('dialogOverlay').classList.remove('active');
-            if (_dialogResolve) { _dialogResolve(null); _dialogResolve = null; }
-        }
-
-        function dialogOk() {
-            var overlay = document.getElementById('dialogOverlay');
-            var input = document.getElementById('dialogInput');
-            overlay.classList.remove('active');
-            if (_dialogResolve) {
-                var val = input.style.display === 'none' ? true : input.value;
-                _dialogResolve(val);
-                _dialogResolve = null;
-            }
-        }
-
-        function showAlert(msg, title) {
-            return new Promise(function(resolve) {
-                _dialogResolve = resolve;
-                document.getElementById('dialogTitle').textContent = title || '';
-                document.getElementById('dialogTitle').style.display = title ? '' : 'none';
-                document.getElementById('dialogMessage').textContent = msg;
-                document.getElementById('dialogInput').style.display = 'none';
-                document.getElementById('dialogButtons').innerHTML =
-                    '<button class="dialog-btn primary" onclick="dialogOk()">OK</button>';
-                document.getElementById('dialogOverlay').classList.add('active');
-            });
-        }
-
-        function showConfirm(msg, title, danger) {
-            return new Promise(function(resolve) {
-                _dialogResolve = resolve;
-                document.getElementById('dialogTitle').textContent = title || 'Confirm';
-                document.getElementById('dialogTitle').style.display = '';
-                document.getElementById('dialogMessage').textContent = msg;
-                document.getElementById('dialogInput').style.display = 'none';
-                var btnClass = danger ? 'danger' : 'primary';
-                document.getElementById('dialogButtons').innerHTML =
-                    '<button class="dialog-btn" onclick="dialogCancel()">Cancel</button>' +
-                    '<button class="dialog-btn ' + btnClass + '" onclick="dialogOk()">' + (danger ? 'Delete' : 'OK') + '</button>';
-                document.getElementById('dialogOverlay').classList.add('active');
-            });
-        }
-
-        function showPrompt(msg, defaultVal, title) {
-            return new Promise(function(resolve) {
-                _dialogResolve = resolve;
-                document.getElementById('dialogTitle').textContent = title || '';
-                document.getElementById('dialogTitle').style.display = title ? '' : 'none';
-                document.getElementById('dialogMessage').textContent = msg;
-                var input = document.getElementById('dialogInput');
-                input.style.display = '';
-                input.value = defaultVal || '';
-                document.getElementById('dialogButtons').innerHTML =
-                    '<button class="dialog-btn" onclick="dialogCancel()">Cancel</button>' +
-                    '<button class="dialog-btn primary" onclick="dialogOk()">OK</button>';
-                document.getElementById('dialogOverlay').classList.add('active');
-                setTimeout(function() { input.focus(); input.select(); }, 100);
-            });
-        }
-
-        // Theme system
-        function isDarkTheme(theme) {
-            return theme !== 'light' && theme !== 'solarized-light' && theme !== 'github-light';
-        }
-
-        function updateAboutLogo(theme) {
-            const logoCenter = document.getElementById('aboutLogoCenter');
-            if (logoCenter) {
-                logoCenter.setAttribute('fill', isDarkTheme(theme) ? '#2d2d2d' : '#ffffff');
-            }
-        }
-
-        function changeTheme(theme) {
-            if (theme === 'light') {
-                document.documentElement.removeAttribute('data-theme');
-            } else {
-                document.documentElement.setAttribute('data-theme', theme);
-            }
-            localStorage.setItem('theme', theme);
-            document.getElementById('themeSelect').value = theme;
-            updateAboutLogo(theme);
-            if (editor) {
-                editor.setOption('theme', isDarkTheme(theme) ? 'monokai' : 'default');
-            }
-        }
-
-        // Load saved theme
-        let savedTheme = localStorage.getItem('theme') || 'light';
-        if (savedTheme === 'dark' || savedTheme === 'goserve-dark') savedTheme = 'catppuccin-mocha';
-        if (savedTheme === 'vs-dark') savedTheme = 'one-dark';
-        changeTheme(savedTheme);
-
-        // Search/filter with wildcard support
-        function filterFiles() {
-            const input = document.getElementById('searchBox');
-            const filter = input.value;
-
-            // Command mode: ":" prefix stops filtering
-            if (filter.startsWith(':')) {
-                input.style.borderColor = 'var(--accent)';
-                return;
-            }
-            input.style.borderColor = '';
-
-            const table = document.getElementById('fileTable');
-            const rows = table.getElementsByTagName('tr');
-            
-            // Check if filter contains wildcards
-            const hasWildcard = filter.includes('*') || filter.includes('?');
-            let regex = null;
-            
-            if (hasWildcard) {
-                // Convert wildcard pattern to regex
-                // Escape special regex chars except * and ?
-                let pattern = filter.replace(/[.+^${}()|[\]\\]/g, '\\$&');
-                // Convert wildcards: * -> .* and ? -> .
-                pattern = pattern.replace(/\*/g, '.*').replace(/\?/g, '.');
-                try {
-                    regex = new RegExp('^' + pattern + '$', 'i');
-                } catch (e) {
-                    // Invalid regex, fall back to substring search
-                    regex = null;
-                }
-            }
-            
-            for (let i = 1; i < rows.length; i++) {
-                const nameCell = rows[i].getElementsByClassName('name')[0];
-                if (nameCell) {
-                    let txtValue = nameCell.textContent || nameCell.innerText;
-                    // Remove trailing slash from directories
-                    txtValue = txtValue.replace(/\/$/, '');
-                    
-                    let matches = false;
-                    if (regex) {
-                        matches = regex.test(txtValue);
-                    } else {
-                        matches = txtValue.toLowerCase().indexOf(filter.toLowerCase()) > -1;
-                    }
-                    
-                    rows[i].style.display = matches ? '' : 'none';
-                }
-            }
-            updateItemCount();
-        }
-
-        function handleSearchKey(e) {
-            var input = document.getElementById('searchBox');
-            if (e.key === 'Enter' && input.value.startsWith(':')) {
-                e.preventDefault();
-                var cmd = input.value.substring(1).trim();
-                if (!cmd) return;
-                fetch('/_api/chdir', {
-                    method: 'POST',
-                    headers: {'Content-Type': 'application/json'},
-                    body: JSON.stringify({dir: cmd})
-                })
-                .then(function(r) { return r.json(); })
-                .then(function(d) {
-                    if (d.success) {
-                        window.location.href = '/';
-                    } else {
-                        input.style.borderColor = '#f38ba8';
-                        input.value = ':' + cmd + '  (' + d.error + ')';
-                        setTimeout(function() {
-                            input.value = ':' + cmd;
-                            input.style.borderColor = 'var(--accent)';
-                        }, 2000);
-                    }
-                })
-                .catch(function() {
-                    input.style.borderColor = '#f38ba8';
-                });
-                return;
-            }
-            if (e.key === 'Escape') {
-                input.value = '';
-                input.style.borderColor = '';
-                input.blur();
-                filterFiles();
-            }
-        }
-
-        function updateItemCount() {
-            var rows = document.querySelectorAll('#fileTable tbody tr');
-            var visible = 0;
-            rows.forEach(function(r) { if (r.style.display !== 'none') visible++; });
-            document.getElementById('itemCount').textContent = 'Items: ' + visible;
-        }
-
-        // Sort table
-        var currentSortCol = -1;
-        var currentSortDir = 'asc';
-
-        function sortTable(n) {
-            var tbody = document.querySelector('#fileTable tbody');
-            var rows = Array.from(tbody.querySelectorAll('tr'));
-
-            // Determine direction
-            if (currentSortCol === n) {
-                currentSortDir = currentSortDir === 'asc' ? 'desc' : 'asc';
-            } else {
-                currentSortCol = n;
-                currentSortDir = 'asc';
-            }
-
-            rows.sort(function(a, b) {
-                var av, bv;
-                if (n === 0) {
-                    // Name: directories first, then alphabetical
-                    var aDir = a.dataset.isdir === 'true' ? 0 : 1;
-                    var bDir = b.dataset.isdir === 'true' ? 0 : 1;
-                    if (aDir !== bDir) return aDir - bDir;
-                    av = (a.dataset.name || '').toLowerCase();
-                    bv = (b.dataset.name || '').toLowerCase();
-                    return currentSortDir === 'asc' ? av.localeCompare(bv) : bv.localeCompare(av);
-                } else if (n === 1) {
-                    // Size: numeric
-                    av = parseInt(a.dataset.size) || 0;
-                    bv = parseInt(b.dataset.size) || 0;
-                    return currentSortDir === 'asc' ? av - bv : bv - av;
-                } else {
-                    // Modified: numeric timestamp
-                    av = parseInt(a.dataset.mod) || 0;
-                    bv = parseInt(b.dataset.mod) || 0;
-                    return currentSortDir === 'asc' ? av - bv : bv - av;
-                }
-            });
-
-            // Re-append in order
-            rows.forEach(function(r) { tbody.appendChild(r); });
-
-            // Update header arrows
-            var ths = document.querySelectorAll('#fileTable thead th');
-            ths.forEach(function(th, i) {
-                var arrow = th.querySelector('.sort-arrow');
-                if (i === n) {
-                    th.classList.add('sorted');
-                    arrow.textContent = currentSortDir === 'asc' ? '↑' : '↓';
-                } else {
-                    th.classList.remove('sorted');
-                    arrow.textContent = '';
-                }
-            });
-        }
-
-        // File operations
-        function deleteFile(path, name) {
-            showConfirm('Delete ' + name + '?', 'Delete', true).then(function(ok) {
-                if (!ok) return;
-                fetch('?delete=' + encodeURIComponent(path), { method: 'POST' })
-                    .then(r => r.json())
-                    .then(data => {
-                        if (data.success) location.reload();
-                        else showAlert('Error: ' + data.error);
-                    });
-            });
-        }
-
-        function renameFile(path, oldName) {
-            showPrompt('Rename to:', oldName, 'Rename').then(function(newName) {
-                if (!newName || newName === oldName) return;
-                fetch('?rename=' + encodeURIComponent(path) + '&newname=' + encodeURIComponent(newName), { method: 'POST' })
-                    .then(r => r.json())
-                    .then(data => {
-                        if (data.success) location.reload();
-                        else showAlert('Error: ' + data.error);
-                    });
-            });
-        }
-
-        // Text editor
-        var editor = null;
-        var currentEditPath = '';
-
-        function editFile(path, name) {
-            currentEditPath = path;
-            document.getElementById('editorFileName').textContent = name;
-            
-            fetch(path)
-                .then(r => {
-                    if (!r.ok) throw new Error('Failed to load file');
-                    return r.text();
-                })
-                .then(content => {
-                    document.getElementById('editor').value = content;
-                    document.getElementById('editorModal').style.display = 'block';
-                    
-                    // Initialize CodeMirror if not already initialized
-                    if (!editor) {
-                        const currentTheme = localStorage.getItem('theme') || 'light';
-                        editor = CodeMirror.fromTextArea(document.getElementById('editor'), {
-                            lineNumbers: true,
-                            theme: isDarkTheme(currentTheme) ? 'monokai' : 'default',
-                            mode: getMode(name),
-                            indentUnit: 4,
-                            lineWrapping: true
-                        });
-                        editor.setSize('100%', '70vh');
-                    } else {
-                        editor.setValue(content);
-                        editor.setOption('mode', getMode(name));
-                    }
-                })
-                .catch(err => showAlert('Error loading file: ' + err.message));
-        }
-
-        function getMode(filename) {
-            const ext = filename.split('.').pop().toLowerCase();
-            const modes = {
-                'js': 'javascript',
-                'json': 'javascript',
-                'py': 'python',
-                'go': 'go',
-                'html': 'xml',
-                'xml': 'xml',
-                'css': 'css',
-                'md': 'markdown',
-                'sh': 'shell',
-                'bash': 'shell',
-                'txt': 'text/plain'
-            };
-            return modes[ext] || 'text/plain';
-        }
-
-        function saveFile() {
-            const content = editor.getValue();
-            fetch(currentEditPath + '?edit=1', {
-                method: 'POST',
-                headers: { 'Content-Type': 'text/plain' },
-                body: content
-            })
-            .then(r => r.json())
-            .then(data => {
-                if (data.success) {
-                    showAlert('File saved successfully!', 'Saved').then(function() { closeEditor(); });
-                } else {
-                    showAlert('Error: ' + data.error);
-                }
-            })
-            .catch(err => showAlert('Error saving file: ' + err.message));
-        }
-
-        function closeEditor() {
-            document.getElementById('editorModal').style.display = 'none';
-        }
-
-        function closePreview() {
-            document.getElementById('previewModal').style.display = 'none';
-        }
-
-        function showAbout() {
-            updateAboutLogo(localStorage.getItem('theme') || 'light');
-            document.getElementById('aboutModal').style.display = 'block';
-        }
-
-        function closeAbout() {
-            document.getElementById('aboutModal').style.display = 'none';
-        }
-
-        function copyWebDAVUrl() {
-            const urlInput = document.getElementById('webdavUrl');
-            urlInput.select();
-            urlInput.setSelectionRange(0, 99999);
-
-            try {
-                navigator.clipboard.writeText(urlInput.value).then(() => {
-                    showAlert('WebDAV URL copied to clipboard!', 'Copied');
-                }).catch(() => {
-                    document.execCommand('copy');
-                    showAlert('WebDAV URL copied!', 'Copied');
-                });
-            } catch (err) {
-                document.execCommand('copy');
-                showAlert('WebDAV URL copied!', 'Copied');
-            }
-        }
-
-        function escapeHtml(text) {
-            const map = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#039;' };
-            return text.replace(/[&<>"']/g, m => map[m]);
-        }
-
-        // --- File list selection and navigation ---
-        var selectedRows = [];
-        var lastSelectedRow = null;
-
-        function getVisibleRows() {
-            var rows = Array.from(document.querySelectorAll('#fileTable tbody tr'));
-            return rows.filter(r => r.style.display !== 'none');
-        }
-
-        function updateSelectionBar() {
-            var bar = document.getElementById('selectionBar');
-            var count = selectedRows.length;
-            if (count > 0) {
-                bar.classList.add('active');
-                document.getElementById('selectionCount').textContent = count + ' selected';
-                var single = count === 1;
-                var editBtn = document.getElementById('selEditBtn');
-                var renameBtn = document.getElementById('selRenameBtn');
-                if (editBtn) editBtn.style.display = (single && selectedRows[0].dataset.editable) ? '' : 'none';
-                if (renameBtn) renameBtn.style.display = single ? '' : 'none';
-            } else {
-                bar.classList.remove('active');
-            }
-        }
-
-        function clearSelection() {
-            selectedRows.forEach(r => r.classList.remove('selected'));
-            selectedRows = [];
-            updateSelectionBar();
-        }
-
-        function selectRow(tr, keepExisting) {
-            if (!keepExisting) clearSelection();
-            if (!tr.classList.contains('selected')) {
-                tr.classList.add('selected');
-                selectedRows.push(tr);
-            }
-            lastSelectedRow = tr;
-            tr.scrollIntoView({block: 'nearest'});
-            updateSelectionBar();
-        }
-
-        function selectRange(fromTr, toTr) {
-            var visible = getVisibleRows();
-            var i1 = visible.indexOf(fromTr);
-            var i2 = visible.indexOf(toTr);
-            if (i1 < 0 || i2 < 0) return;
-            var start = Math.min(i1, i2), end = Math.max(i1, i2);
-            clearSelection();
-            for (var i = start; i <= end; i++) {
-                visible[i].classList.add('selected');
-                selectedRows.push(visible[i]);
-            }
-            lastSelectedRow = toTr;
-            updateSelectionBar();
-        }
-
-        function navigateUp() {
-            // Remember current folder name so parent page can select it
-            var parts = window.location.pathname.replace(/\/+$/, '').split('/');
-            var current = parts[parts.length - 1];
-            if (current) sessionStorage.setItem('goserve_select', current);
-            window.location.href = '../';
-        }
-
-        function openRow(tr) {
-            var path = tr.dataset.path;
-            var isDir = tr.dataset.isdir === 'true';
-            var name = tr.dataset.name || '';
-            if (!path) return;
-            if (path === '../') {
-                navigateUp();
-                return;
-            }
-            if (isDir) {
-                window.location.href = path;
-            } else {
-                // Trigger preview or download
-                var ext = name.split('.').pop().toLowerCase();
-                var images = ['jpg','jpeg','png','gif','svg','webp'];
-                var previewable = ['txt','md','json','js','go','py','html','css','xml','log'];
-                if (images.includes(ext)) {
-                    document.getElementById('previewBody').innerHTML = '<img src="' + path + '" style="max-width:100%;height:auto;">';
-                    document.getElementById('previewModal').style.display = 'block';
-                } else if (ext === 'md') {
-                    fetch(path + '?markdown=1').then(r => r.ok ? r.text() : Promise.reject('Failed'))
-                        .then(html => { document.getElementById('previewBody').innerHTML = '<div class="markdown-body">' + html + '</div>'; document.getElementById('previewModal').style.display = 'block'; })
-                        .catch(err => showAlert('Error: ' + err));
-                } else if (previewable.includes(ext)) {
-                    fetch(path).then(r => r.ok ? r.text() : Promise.reject('Failed'))
-                        .then(text => { document.getElementById('previewBody').innerHTML = '<pre>' + escapeHtml(text) + '</pre>'; document.getElementById('previewModal').style.display = 'block'; })
-                        .catch(err => showAlert('Error: ' + err));
-                } else {
-                    window.open(path, '_blank');
-                }
-            }
-        }
-
-        // Single-click: select row. Prevent <a> navigation.
-        document.querySelector('#fileTable tbody')?.addEventListener('click', function(e) {
-            // Don't intercept action button clicks
-
-            e.preventDefault();
-            var tr = e.target.closest('tr');
-            if (!tr || !tr.dataset.path) return;
-
-            if (e.shiftKey && lastSelectedRow) {
-                selectRange(lastSelectedRow, tr);
-            } else if (e.ctrlKey || e.metaKey) {
-                if (tr.classList.contains('selected')) {
-                    tr.classList.remove('selected');
-                    selectedRows = selectedRows.filter(r => r !== tr);
-                    updateSelectionBar();
-                } else {
-                    selectRow(tr, true);
-                }
-            } else {
-                selectRow(tr, false);
-            }
-        });
-
-        // Double-click: open file/folder
-        document.querySelector('#fileTable tbody')?.addEventListener('dblclick', function(e) {
-
-            var tr = e.target.closest('tr');
-            if (!tr || !tr.dataset.path) return;
-            e.preventDefault();
-            openRow(tr);
-        });
-
-        // Keyboard navigation
-        document.addEventListener('keydown', function(e) {
-            // Close modals/menus on Escape
-            if (e.key === 'Escape') {
-                dialogCancel();
-                closePreview();
-                closeAbout();
-                closeEditor();
-                closeNewFolderModal();
-                hideAllMenus();
-                clearSelection();
-                return;
-            }
-
-            // Don't handle keys when typing in inputs or modals open
-            var tag = document.activeElement.tagName;
-            if (tag === 'INPUT' || tag === 'TEXTAREA' || tag === 'SELECT') return;
-            if (document.querySelector('.preview-modal[style*="display: block"]')) return;
-            if (document.getElementById('dialogOverlay').classList.contains('active')) return;
-
-            if (e.key === 'ArrowLeft') {
-                e.preventDefault();
-                navigateUp();
-                return;
-            }
-
-            if (e.key === 'Delete' && selectedRows.length > 0) {
-                e.preventDefault();
-                ctxDeleteSelected();
-                return;
-            }
-
-            var visible = getVisibleRows();
-            if (visible.length === 0) return;
-
-            var currentIdx = lastSelectedRow ? visible.indexOf(lastSelectedRow) : -1;
-
-            if (e.key === 'ArrowDown') {
-                e.preventDefault();
-                var next = currentIdx < visible.length - 1 ? currentIdx + 1 : currentIdx;
-                if (next < 0) next = 0;
-                if (e.shiftKey && lastSelectedRow) {
-                    var tr = visible[next];
-                    if (tr.classList.contains('selected') && next !== currentIdx) {
-                        // Shrink selection: deselect current if moving back
-                        lastSelectedRow.classList.remove('selected');
-                        selectedRows = selectedRows.filter(r => r !== lastSelectedRow);
-                    } else {
-                        tr.classList.add('selected');
-                        if (!selectedRows.includes(tr)) selectedRows.push(tr);
-                    }
-                    lastSelectedRow = tr;
-                    tr.scrollIntoView({block: 'nearest'});
-                    updateSelectionBar();
-                } else {
-                    selectRow(visible[next], false);
-                }
-            } else if (e.key === 'ArrowUp') {
-                e.preventDefault();
-                var prev = currentIdx > 0 ? currentIdx - 1 : 0;
-                if (e.shiftKey && lastSelectedRow) {
-                    var tr = visible[prev];
-                    if (tr.classList.contains('selected') && prev !== currentIdx) {
-                        lastSelectedRow.classList.remove('selected');
-                        selectedRows = selectedRows.filter(r => r !== lastSelectedRow);
-                    } else {
-                        tr.classList.add('selected');
-                        if (!selectedRows.includes(tr)) selectedRows.push(tr);
-                    }
-                    lastSelectedRow = tr;
-                    tr.scrollIntoView({block: 'nearest'});
-                    updateSelectionBar();
-                } else {
-                    selectRow(visible[prev], false);
-                }
-            } else if (e.key === 'ArrowRight') {
-                if (lastSelectedRow && lastSelectedRow.dataset.isdir === 'true') {
-                    e.preventDefault();
-                    window.location.href = lastSelectedRow.dataset.path;
-                }
-            } else if (e.key === 'Enter') {
-                if (lastSelectedRow) {
-                    e.preventDefault();
-                    openRow(lastSelectedRow);
-                }
-            } else if (e.key === 'Home') {
-                e.preventDefault();
-                if (visible.length > 0) selectRow(visible[0], false);
-            } else if (e.key === 'End') {
-                e.preventDefault();
-                if (visible.length > 0) selectRow(visible[visible.length - 1], false);
-            }
-        });
-
-        // Footer menu
-        function toggleFooterMenu(e) {
-            e.stopPropagation();
-            var menu = document.getElementById('footerMenu');
-            menu.classList.toggle('active');
-        }
-        function closeFooterMenu() {
-            document.getElementById('footerMenu').classList.remove('active');
-        }
-
-        // Context menus
-        function hideAllMenus() {
-            document.querySelectorAll('.context-menu').forEach(m => m.classList.remove('show'));
-            closeFooterMenu();
-        }
-
-        function showMenuAt(menu, x, y) {
-            hideAllMenus();
-            menu.classList.add('show');
-            var mw = menu.offsetWidth, mh = menu.offsetHeight;
-            menu.style.left = Math.min(x, window.innerWidth - mw - 4) + 'px';
-            menu.style.top = Math.min(y, window.innerHeight - mh - 4) + 'px';
-        }
-
-        // Folder context menu (breadcrumb caret)
-        function toggleContextMenu(e) {
-            e.stopPropagation();
-            var menu = document.getElementById('folderContextMenu');
-            if (menu.classList.contains('show')) {
-                hideAllMenus();
-                return;
-            }
-            var rect = e.target.getBoundingClientRect();
-            showMenuAt(menu, rect.left, rect.bottom + 2);
-        }
-
-        document.addEventListener('click', function() { hideAllMenus(); });
-
-        // Right-click on table header → folder context menu
-        document.querySelector('#fileTable thead')?.addEventListener('contextmenu', function(e) {
-            if (!document.querySelector('#folderContextMenu .context-menu-item')) return;
-            e.preventDefault();
-            showMenuAt(document.getElementById('folderContextMenu'), e.clientX, e.clientY);
-        });
-
-        // Right-click on table row → row context menu
-        document.querySelector('#fileTable tbody')?.addEventListener('contextmenu', function(e) {
-            e.preventDefault();
-            var tr = e.target.closest('tr');
-            if (!tr || !tr.dataset.path) return;
-            // Select row if not already selected
-            if (!tr.classList.contains('selected')) {
-                selectRow(tr, false);
-            }
-            // Show/hide items based on single vs multi selection
-            var single = selectedRows.length === 1;
-            var renameBtn = document.getElementById('ctxRename');
-            var editBtn = document.getElementById('ctxEdit');
-            if (renameBtn) renameBtn.style.display = single ? '' : 'none';
-            if (editBtn) editBtn.style.display = (single && selectedRows[0].dataset.editable) ? '' : 'none';
-            showMenuAt(document.getElementById('rowContextMenu'), e.clientX, e.clientY);
-        });
-
-        // Row context menu actions
-        function ctxDownloadSelected() {
-            hideAllMenus();
-            if (selectedRows.length === 0) return;
-            if (selectedRows.length === 1) {
-                var path = selectedRows[0].dataset.path;
-                var isDir = selectedRows[0].dataset.isdir === 'true';
-                if (isDir) {
-                    window.location.href = path + '?zip=1';
-                } else {
-                    // Direct file download
-                    var a = document.createElement('a');
-                    a.href = path;
-                    a.download = selectedRows[0].dataset.name || '';
-                    document.body.appendChild(a);
-                    a.click();
-                    document.body.removeChild(a);
-                }
-            } else {
-                // Multi-file: POST paths to get a ZIP
-                var paths = selectedRows.map(r => r.dataset.path);
-                var form = document.createElement('form');
-                form.method = 'POST';
-                form.action = window.location.pathname + '?zipfiles=1';
-                form.style.display = 'none';
-                paths.forEach(p => {
-                    var input = document.createElement('input');
-                    input.type = 'hidden';
-                    input.name = 'files';
-                    input.value = p;
-                    form.appendChild(input);
-                });
-                document.body.appendChild(form);
-                form.submit();
-                document.body.removeChild(form);
-            }
-        }
-
-        function ctxEditSelected() {
-            hideAllMenus();
-            if (selectedRows.length !== 1) return;
-            var tr = selectedRows[0];
-            editFile(tr.dataset.path, tr.dataset.name);
-        }
-
-        function ctxRenameSelected() {
-            hideAllMenus();
-            if (selectedRows.length !== 1) return;
-            var tr = selectedRows[0];
-            renameFile(tr.dataset.path, tr.dataset.name);
-        }
-
-        function ctxCopyLink() {
-            hideAllMenus();
-            if (selectedRows.length === 0) return;
-            var base = window.location.origin;
-            var urls = selectedRows.map(r => base + r.dataset.path);
-            var text = urls.join('\n');
-            navigator.clipboard.writeText(text).then(function() {
-                // Brief visual feedback
-                var count = document.getElementById('selectionCount');
-                if (count) { var orig = count.textContent; count.textContent = 'Link copied!'; setTimeout(function() { count.textContent = orig; }, 1500); }
-            }).catch(function() {
-                showPrompt('Copy link:', text, 'Copy Link');
-            });
-        }
-
-        function copyFolderLink() {
-            hideAllMenus();
-            var url = window.location.origin + window.location.pathname;
-            navigator.clipboard.writeText(url).then(function() {
-                // silent copy
-            }).catch(function() {
-                showPrompt('Copy link:', url, 'Copy Link');
-            });
-        }
-
-        function ctxDeleteSelected() {
-            hideAllMenus();
-            if (selectedRows.length === 0) return;
-            var names = selectedRows.map(r => r.dataset.name || r.dataset.path);
-            var msg = selectedRows.length === 1
-                ? 'Delete ' + names[0] + '?'
-                : 'Delete ' + selectedRows.length + ' items?\n' + names.join('\n');
-            showConfirm(msg, 'Delete', true).then(function(ok) {
-                if (!ok) return;
-                var paths = selectedRows.map(r => r.dataset.path);
-                var chain = Promise.resolve();
-                paths.forEach(function(p) {
-                    chain = chain.then(function() {
-                        return fetch('?delete=' + encodeURIComponent(p), { method: 'POST' })
-                            .then(r => r.json())
-                            .then(data => { if (!data.success) showAlert('Error deleting ' + p + ': ' + data.error); });
-                    });
-                });
-                chain.then(function() { location.reload(); });
-            });
-        }
-
-        // New Folder modal
-        function showNewFolderModal() {
-            hideAllMenus();
-            document.getElementById('newFolderName').value = '';
-            document.getElementById('newFolderModal').style.display = 'block';
-            setTimeout(() => document.getElementById('newFolderName').focus(), 100);
-        }
-
-        function closeNewFolderModal() {
-            document.getElementById('newFolderModal').style.display = 'none';
-        }
-
-        function createNewFolder() {
-            const name = document.getElementById('newFolderName').value.trim();
-            if (!name) return;
-            if (name.includes('/') || name.includes('\\') || name.includes('..')) {
-                showAlert('Invalid folder name');
-                return;
-            }
-            fetch(window.location.pathname + '?mkdir=' + encodeURIComponent(name), { method: 'POST' })
-                .then(r => r.json())
-                .then(data => {
-                    if (data.success) { closeNewFolderModal(); location.reload(); }
-                    else showAlert('Error: ' + data.error);
-                })
-                .catch(err => showAlert('Error creating folder: ' + err.message));
-        }
-
-        // File/folder upload via context menu
-        function triggerFileUpload() {
-            hideAllMenus();
-            document.getElementById('fileInput')?.click();
-        }
-
-        function triggerFolderUpload() {
-            hideAllMenus();
-            document.getElementById('dirInput')?.click();
-        }
-
-        function uploadFiles(files) {
-            const formData = new FormData();
-            files.forEach(file => {
-                const path = file.webkitRelativePath || file.name;
-                formData.append('files', file, path);
-            });
-            fetch(window.location.pathname + '?upload=1', {
-                method: 'POST',
-                body: formData
-            }).then(response => {
-                if (response.ok) window.location.reload();
-                else showAlert('Upload failed');
-            }).catch(err => {
-                showAlert('Upload error: ' + err.message);
-            });
-        }
-
-        document.getElementById('fileInput')?.addEventListener('change', function(e) {
-            const files = Array.from(e.target.files);
-            if (files.length > 0) uploadFiles(files);
-            e.target.value = '';
-        });
-
-        document.getElementById('dirInput')?.addEventListener('change', function(e) {
-            const files = Array.from(e.target.files);
-            if (files.length > 0) uploadFiles(files);
-            e.target.value = '';
-        });
-
-        // Inject breadcrumb caret on last item (only if folder context menu has items)
-        (function() {
-            if (!document.querySelector('#folderContextMenu .context-menu-item')) return;
-            const crumbs = document.querySelectorAll('.breadcrumb a');
-            if (crumbs.length > 0) {
-                const last = crumbs[crumbs.length - 1];
-                const caret = document.createElement('button');
-                caret.className = 'breadcrumb-caret';
-                caret.textContent = '\u25BE';
-                caret.title = 'Actions';
-                caret.onclick = function(e) { toggleContextMenu(e); };
-                last.insertAdjacentElement('afterend', caret);
-            }
-        })();
-
-        // Auto-select folder we navigated up from
-        (function() {
-            var name = sessionStorage.getItem('goserve_select');
-            if (!name) return;
-            sessionStorage.removeItem('goserve_select');
-            var rows = document.querySelectorAll('#fileTable tbody tr');
-            for (var i = 0; i < rows.length; i++) {
-                if (rows[i].dataset.name === name) {
-                    selectRow(rows[i], false);
-                    return;
-                }
-            }
-        })();
-    </script>
+    <script id="pageData" type="application/json">{{.Client}}</script>
+    <script src="{{asset "ui.js"}}"></script>
+    <script src="{{asset "listing.js"}}"></script>
+    <script src="{{asset "editor.js"}}"></script>
+    <script src="{{asset "theme.js"}}"></script>
+    <script src="{{asset "search.js"}}"></script>
+    <script src="{{asset "jobs.js"}}"></script>
+    <script src="{{asset "downloads.js"}}"></script>
+    <script src="{{asset "links.js"}}"></script>
+    <script src="{{asset "help.js"}}"></script>
+    <script src="{{asset "media.js"}}"></script>
+    <script src="{{asset "about.js"}}"></script>
+    <script src="{{asset "guests.js"}}"></script>
+    <script src="{{asset "files.js"}}"></script>
 </body>
 </html>`
 
@@ -1803,6 +788,7 @@ func loadUsers(filePath string) error {
 		return err
 	}
 
+	var plaintext []string
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -1811,38 +797,86 @@ func loadUsers(filePath string) error {
 			continue
 		}
 
-		parts := strings.Split(line, ":")
-		if len(parts) != 3 {
+		// The home may be a Windows path, so it takes the rest of the line
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 {
 			continue
 		}
 
 		username := strings.TrimSpace(parts[0])
 		password := strings.TrimSpace(parts[1])
-		permission := strings.TrimSpace(parts[2])
+		field, expires, err := cutExpiry(parts[2])
+		if err != nil {
+			log.Printf("Warning: %s: skipping %s: %v", filePath, username, err)
+			continue
+		}
+		field, quota, err := cutQuota(field)
+		if err != nil {
+			log.Printf("Warning: %s: skipping %s: %v", filePath, username, err)
+			continue
+		}
+		permission, rules, err := parsePermission(field)
+		if err != nil {
+			log.Printf("Warning: %s: skipping %s: %v", filePath, username, err)
+			continue
+		}
+		home := ""
+		if len(parts) == 4 {
+			home = strings.TrimSpace(parts[3])
+		}
 
 		users[username] = User{
 			Username:   username,
 			Password:   password,
 			Permission: permission,
+			Rules:      rules,
+			Home:       home,
+			Expires:    expires,
+			Quota:      quota,
+		}
+		if !expires.IsZero() && time.Now().After(expires) {
+			log.Printf("Note: %s: %s expired %s", filePath, username, expires.Format("2006-01-02 15:04"))
+		}
+		if !isHashedPassword(password) {
+			plaintext = append(plaintext, username)
 		}
 	}
+	if len(plaintext) > 0 {
+		log.Printf("Warning: %s has plaintext passwords for %s; replace them with hashes from `goserve hash-password`",
+			filePath, strings.Join(plaintext, ", "))
+	}
 
 	return nil
 }
 
 func getUserFromRequest(r *http.Request) *User {
-	if !requireAuth {
+	// Checks made for another account (see uploadLink.ownerRequest)
+	if u, ok := r.Context().Value(actingUserKey{}).(*User); ok {
+		return u
+	}
+	if !authRequired(r) {
 		return nil
 	}
 
+	if _, ok := bearerToken(r); ok {
+		if authLockedOut(r, "") > 0 {
+			return nil
+		}
+		return userFromToken(r)
+	}
+	if user := userFromSession(r); user != nil {
+		return user
+	}
+
 	username, password, ok := r.BasicAuth()
-	if !ok {
+	if !ok || authLockedOut(r, username) > 0 {
 		return nil
 	}
 
-	user, exists := users[username]
-	if !exists || user.Password != password {
-		return nil
+	user, exists := lookupUser(username)
+	if !exists || !checkPassword(user, password) {
+		// WebDAV clients of single sign-on users use an app password
+		return userFromAppPassword(username, password)
 	}
 
 	return &user
@@ -1850,22 +884,120 @@ func getUserFromRequest(r *http.Request) *User {
 
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !requireAuth {
+		if !authRequired(r) {
 			next(w, r)
 			return
 		}
 
 		user := getUserFromRequest(r)
+		_, hasToken := bearerToken(r)
+		username, _, hasBasic := r.BasicAuth()
+		if user == nil && (hasToken || hasBasic) {
+			if hasToken {
+				username = ""
+			}
+			if wait := authLockedOut(r, username); wait > 0 {
+				authLockoutError(w, wait)
+				return
+			}
+			recordAuthFailure(r, username)
+		} else if hasBasic {
+			clearAuthFailures(r, user.Username)
+		}
 		if user == nil {
+			if hasToken {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if wantsLoginPage(r) {
+				if r.Method == "GET" && r.Header.Get("Sec-Fetch-Mode") == "navigate" {
+					http.Redirect(w, r, loginPageURL(r.URL.RequestURI()), http.StatusSeeOther)
+					return
+				}
+				// A request from a page whose session ran out
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
 			w.Header().Set("WWW-Authenticate", `Basic realm="Go-Serve"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		if home, ok := userHome(user); ok {
+			if home == "" {
+				http.Error(w, "Forbidden: home folder is outside the served directory", http.StatusForbidden)
+				return
+			}
+			r = withHome(r, home)
+		}
 		next(w, r)
 	}
 }
 
+// permissionsFor resolves what the requester may do: the permission level
+// of the listener the request came in on (-permlevel unless overridden),
+// narrowed by the authenticated user's permission.
+func permissionsFor(r *http.Request) (canUpload, canModify bool) {
+	return permissionsAt(r, "")
+}
+
+// permissionsAt is permissionsFor at fullPath, where the user's path rules
+// may give them another permission. An empty fullPath uses the user's
+// default.
+func permissionsAt(r *http.Request, fullPath string) (canUpload, canModify bool) {
+	levelUpload, levelModify := allowUpload, allowModify
+	if cfg := listenerFor(r); cfg != nil {
+		levelUpload, levelModify = levelPermissions(cfg.PermLevel)
+	}
+	canUpload, canModify = levelUpload, levelModify
+
+	user := getUserFromRequest(r)
+	if user != nil {
+		permission := user.Permission
+		if fullPath != "" {
+			permission = user.permissionAt(urlForRequest(r, fullPath))
+		}
+		switch permission {
+		case "readonly":
+			canUpload = false
+			canModify = false
+		case "readwrite":
+			canUpload = levelUpload
+			canModify = false
+		case "all":
+			canUpload = levelUpload
+			canModify = levelModify
+		}
+	}
+	if overMonthlyCap(r) {
+		canUpload, canModify = false, false
+	}
+	ceilingUpload, ceilingModify := levelPermissions(permCeiling())
+	return canUpload && ceilingUpload, canModify && ceilingModify
+}
+
+// resolvePath maps a URL path to its location under the base directory,
+// reporting false if it would escape it.
+func resolvePath(urlPath string) (string, bool) {
+	baseDir := getBaseDir()
+	fullPath := filepath.Join(baseDir, filepath.Clean("/"+urlPath))
+	return fullPath, reachable(fullPath, baseDir)
+}
+
+// writeJSON sends v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// jsonError sends the {"success": false, "error": ...} shape used by the API.
+func jsonError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"success": false, "error": msg})
+}
+
 func isEditableFile(name string) bool {
 	ext := strings.ToLower(filepath.Ext(name))
 	editableExts := map[string]bool{
@@ -1946,37 +1078,17 @@ func buildBreadcrumbs(urlPath string) []Breadcrumb {
 	return crumbs
 }
 
-// GZIP middleware
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
-
-func (w gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
-func gzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next(w, r)
-			return
-		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		next(gzipResponseWriter{Writer: gz, ResponseWriter: w}, r)
-	}
+// isArchiveRequest reports whether r asks for a ZIP or TAR download, which
+// count as bulk traffic.
+func isArchiveRequest(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("zip") != "" || q.Get("zipfiles") != "" || q.Get("tar") != "" || q.Get("tarfiles") != "" ||
+		(q.Get("targz") != "" && q.Get("manifest") == "")
 }
 
-func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
+func dirHandler(tmpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Log request
-		if verbose {
-			fmt.Printf("[%s] %s %s\n", r.Method, r.RemoteAddr, r.URL.Path)
-		}
-
-		baseDir := getBaseDir()
+		baseDir := baseDirFor(r)
 		urlPath := filepath.Clean(r.URL.Path)
 		fullPath := filepath.Join(baseDir, urlPath)
 
@@ -1985,41 +1097,56 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		if err := symlinkError(fullPath, baseDir); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if ignoredPath(fullPath) {
+			http.NotFound(w, r)
+			return
+		}
 
-		// Get user and check permissions
-		user := getUserFromRequest(r)
-		canUpload := allowUpload
-		canModify := allowModify
-
-		if requireAuth && user != nil {
-			switch user.Permission {
-			case "readonly":
-				canUpload = false
-				canModify = false
-			case "readwrite":
-				canUpload = allowUpload
-				canModify = false
-			case "all":
-				canUpload = allowUpload
-				canModify = allowModify
-			}
+		// Get user and check what they may do here. Delete, rename and
+		// touch name their target in the query, so check that path instead.
+		caps := capabilitiesFor(r, fullPath)
+		capsAt := func(target string) Capabilities {
+			return capabilitiesFor(r, filepath.Join(baseDir, filepath.Clean("/"+target)))
 		}
 
 		// Handle upload
 		if r.URL.Query().Get("upload") != "" && r.Method == "POST" {
-			if !canUpload {
-				http.Error(w, "Forbidden: Upload not allowed", http.StatusForbidden)
+			if !caps.Upload {
+				deny(w, r, fullPath, "upload")
+				return
+			}
+			handleUpload(w, r, fullPath, baseDir)
+			return
+		}
+
+		// Save a file pasted from the clipboard
+		if r.URL.Query().Get("paste") != "" && r.Method == "POST" {
+			if !caps.Upload {
+				deny(w, r, fullPath, "upload")
+				return
+			}
+			handlePasteUpload(w, r, fullPath)
+			return
+		}
+
+		// Plan a folder merge before uploading
+		if r.URL.Query().Get("merge") != "" && r.Method == "POST" {
+			if !caps.Upload {
+				deny(w, r, fullPath, "upload")
 				return
 			}
-			handleUpload(w, r, fullPath)
+			handleMergePlan(w, r, fullPath)
 			return
 		}
 
 		// Handle delete
 		if r.URL.Query().Get("delete") != "" && r.Method == "POST" {
-			if !canModify {
-				w.Header().Set("Content-Type", "application/json")
-				fmt.Fprintf(w, `{"success": false, "error": "Forbidden: Delete not allowed"}`)
+			if target := r.URL.Query().Get("delete"); !capsAt(target).Delete {
+				deny(w, r, filepath.Join(baseDir, filepath.Clean("/"+target)), "delete")
 				return
 			}
 			handleDelete(w, r, baseDir)
@@ -2028,31 +1155,58 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 
 		// Handle rename
 		if r.URL.Query().Get("rename") != "" && r.Method == "POST" {
-			if !canModify {
-				w.Header().Set("Content-Type", "application/json")
-				fmt.Fprintf(w, `{"success": false, "error": "Forbidden: Rename not allowed"}`)
+			if target := r.URL.Query().Get("rename"); !capsAt(target).Rename {
+				deny(w, r, filepath.Join(baseDir, filepath.Clean("/"+target)), "rename")
 				return
 			}
 			handleRename(w, r, baseDir)
 			return
 		}
 
+		// Handle touch (set modification time)
+		if r.URL.Query().Get("touch") != "" && r.Method == "POST" {
+			if target := r.URL.Query().Get("touch"); !capsAt(target).Touch {
+				deny(w, r, filepath.Join(baseDir, filepath.Clean("/"+target)), "touch")
+				return
+			}
+			handleTouch(w, r, baseDir)
+			return
+		}
+
+		// Handle chmod
+		if r.URL.Query().Get("chmod") != "" && r.Method == "POST" {
+			if target := r.URL.Query().Get("chmod"); !capsAt(target).Chmod {
+				deny(w, r, filepath.Join(baseDir, filepath.Clean("/"+target)), "chmod")
+				return
+			}
+			handleChmod(w, r, baseDir)
+			return
+		}
+
 		// Handle mkdir
 		if r.URL.Query().Get("mkdir") != "" && r.Method == "POST" {
-			if !canModify {
-				w.Header().Set("Content-Type", "application/json")
-				fmt.Fprintf(w, `{"success": false, "error": "Forbidden: Modify not allowed"}`)
+			if !caps.Mkdir {
+				deny(w, r, fullPath, "mkdir")
 				return
 			}
 			handleMkdir(w, r, fullPath)
 			return
 		}
 
+		// Handle editor lock / unlock
+		if (r.URL.Query().Get("lock") != "" || r.URL.Query().Get("unlock") != "") && r.Method == "POST" {
+			if !caps.Edit {
+				deny(w, r, fullPath, "edit")
+				return
+			}
+			handleEditLock(w, r, urlFor(fullPath))
+			return
+		}
+
 		// Handle file edit
 		if r.URL.Query().Get("edit") != "" && r.Method == "POST" {
-			if !canModify {
-				w.Header().Set("Content-Type", "application/json")
-				fmt.Fprintf(w, `{"success": false, "error": "Forbidden: Edit not allowed"}`)
+			if !caps.Edit {
+				deny(w, r, fullPath, "edit")
 				return
 			}
 			handleEdit(w, r, fullPath, baseDir)
@@ -2061,7 +1215,59 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 
 		// Handle ZIP download
 		if r.URL.Query().Get("zip") != "" {
-			handleZipDownload(w, fullPath, urlPath)
+			handleZipDownload(w, r, fullPath, urlPath)
+			return
+		}
+
+		// Handle uncompressed TAR download
+		if r.URL.Query().Get("tar") != "" {
+			handleTarDownload(w, r, fullPath, urlPath)
+			return
+		}
+
+		// Handle resumable tar.gz download
+		if r.URL.Query().Get("targz") != "" {
+			handleTarGzDownload(w, r, fullPath, urlPath)
+			return
+		}
+
+		// Handle multi-file TAR download
+		if r.URL.Query().Get("tarfiles") != "" && r.Method == "POST" {
+			handleMultiTarDownload(w, r, fullPath, baseDir)
+			return
+		}
+
+		// Handle find in files
+		if r.URL.Query().Get("grep") != "" {
+			handleGrep(w, r, fullPath)
+			return
+		}
+
+		// Handle word search through the content index
+		if r.URL.Query().Get("content") != "" {
+			handleContentSearch(w, r, fullPath)
+			return
+		}
+
+		// Handle folder sizes still being worked out
+		if r.URL.Query().Get("dirsizes") != "" {
+			handleDirSizes(w, r, fullPath)
+			return
+		}
+
+		// Handle search by name through subfolders
+		if r.URL.Query().Get("find") != "" {
+			handleFind(w, r, fullPath)
+			return
+		}
+
+		// Handle server-side find/replace
+		if r.URL.Query().Get("replace") != "" && r.Method == "POST" {
+			if !caps.Edit {
+				deny(w, r, fullPath, "edit")
+				return
+			}
+			handleReplace(w, r, fullPath)
 			return
 		}
 
@@ -2080,17 +1286,86 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 
 		// Handle markdown preview
 		if !info.IsDir() && r.URL.Query().Get("markdown") != "" {
+			if l, ok := lockHolder(urlFor(fullPath)); ok {
+				w.Header().Set(lockedByHeader, l.Owner)
+			}
 			handleMarkdownPreview(w, fullPath)
 			return
 		}
 
+		// JPEG previews of HEIC/RAW photos
+		if !info.IsDir() && r.URL.Query().Get("preview") != "" {
+			handleImagePreview(w, r, fullPath, info)
+			return
+		}
+
+		// Video tracks: listing, subtitles as WebVTT, audio-track remux
+		if !info.IsDir() {
+			q := r.URL.Query()
+			switch {
+			case q.Get("tracks") != "":
+				handleTracks(w, r, fullPath, info)
+				return
+			case q.Get("vtt") != "":
+				handleSubtitleVTT(w, r, fullPath)
+				return
+			case q.Get("subtitle") != "":
+				handleEmbeddedSubtitle(w, r, fullPath)
+				return
+			case q.Get("audio") != "":
+				handleAudioRemux(w, r, fullPath)
+				return
+			case q.Get("contents") != "":
+				handleArchiveContents(w, r, fullPath)
+				return
+			}
+		}
+
 		// If it's a file, serve it
 		if !info.IsDir() {
-			http.ServeFile(w, r, fullPath)
+			f, err := os.Open(fullPath)
+			if err != nil {
+				switch {
+				case fileBusy(err):
+					serveFileBusy(w, info.Name())
+				case errors.Is(err, os.ErrPermission):
+					http.Error(w, "Forbidden", http.StatusForbidden)
+				default:
+					http.Error(w, "Cannot open file", http.StatusInternalServerError)
+				}
+				return
+			}
+			defer f.Close()
+			// Strong ETag so segmented downloads can use If-Range; unless
+			// -cache-control says otherwise, browsers revalidate so an
+			// overwritten file is never served stale
+			setFileCaching(w, r, fullPath, info)
+			setChecksumHeader(w, fullPath, info)
+			if l, ok := lockHolder(urlFor(fullPath)); ok {
+				w.Header().Set(lockedByHeader, l.Owner)
+			}
+			http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+			return
+		}
+
+		// Scripts and sync tools poll folders as JSON
+		if r.URL.Query().Get("format") == "json" {
+			handleListingJSON(w, r, fullPath, info)
+			return
+		}
+
+		// Open pages follow changes to the folder
+		if isLiveListing(r) {
+			handleLiveListing(w, r, fullPath)
 			return
 		}
 
 		// Read directory
+		view, err := parseListingView(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		entries, err := os.ReadDir(fullPath)
 		if err != nil {
 			http.Error(w, "Cannot read directory", http.StatusInternalServerError)
@@ -2099,49 +1374,81 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 
 		// Build file list
 		var files []FileInfo
+		withHidden, hidden := hiddenShown(r), 0
+		ignore := ignoreMatcherFor(fullPath)
 		for _, entry := range entries {
-			info, err := entry.Info()
-			if err != nil {
+			name := entry.Name()
+			if name == dirSettingsFile || (view.filter != nil && !view.filter(name)) || ignore.ignored(filepath.Join(fullPath, name), entry.IsDir()) {
 				continue
 			}
-
-			name := entry.Name()
 			urlPath := path.Join(r.URL.Path, name)
-			if entry.IsDir() {
+			info, linkTarget, err := statListed(filepath.Join(fullPath, name), baseDir)
+			if !withHidden && isHidden(name, info) {
+				hidden++
+				continue
+			}
+			if err != nil {
+				// Shown greyed out with the reason rather than left out
+				files = append(files, FileInfo{
+					Name:       name,
+					Path:       urlPath,
+					Size:       "-",
+					Icon:       "⚠️",
+					Error:      entryErrorReason(filepath.Join(fullPath, name), err),
+					LinkTarget: linkTarget,
+				})
+				continue
+			}
+			if info.IsDir() {
 				urlPath += "/"
 			}
 
 			size := ""
-			if !entry.IsDir() {
+			if !info.IsDir() {
 				size = formatSize(info.Size())
 			} else {
 				size = "-"
 			}
 
 			rawSize := int64(0)
-			if !entry.IsDir() {
+			if !info.IsDir() {
 				rawSize = info.Size()
 			}
+			sizePending := false
+			if info.IsDir() && dirSizes != nil {
+				if n, ok := dirSizes.size(filepath.Join(fullPath, name)); ok {
+					size, rawSize = formatSize(n), n
+				} else {
+					size, sizePending = "…", true
+				}
+			}
 			files = append(files, FileInfo{
-				Name:       name,
-				Path:       urlPath,
-				Size:       size,
-				ModTime:    info.ModTime().Format("2006-01-02 15:04:05"),
-				IsDir:      entry.IsDir(),
-				Icon:       getIcon(name, entry.IsDir()),
-				IsEditable: !entry.IsDir() && isEditableFile(name),
-				RawSize:    rawSize,
-				RawMod:     info.ModTime().Unix(),
+				Name:        name,
+				Path:        urlPath,
+				Size:        size,
+				ModTime:     info.ModTime().Format("2006-01-02 15:04:05"),
+				IsDir:       info.IsDir(),
+				Icon:        getIcon(name, info.IsDir()),
+				IsEditable:  !info.IsDir() && isEditableFile(name),
+				RawSize:     rawSize,
+				RawMod:      info.ModTime().Unix(),
+				SizePending: sizePending,
+				LinkTarget:  linkTarget,
 			})
+			if showPerms {
+				f := &files[len(files)-1]
+				f.Mode, f.Perm, f.Owner = info.Mode().String(), octalMode(info.Mode()), fileOwner(info)
+			}
 		}
 
-		// Sort: directories first, then by name
-		sort.Slice(files, func(i, j int) bool {
-			if files[i].IsDir != files[j].IsDir {
-				return files[i].IsDir
-			}
-			return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
-		})
+		// Sort: ?sort, the folder's own order, the -sort default, or
+		// directories first, then by name
+		settings := loadDirSettings(fullPath)
+		spec, sorted := listingSort(settings)
+		if view.sorted && !(sorted && settings.ForceSort) {
+			spec, sorted = view.sort, true
+		}
+		sortFiles(files, spec)
 
 		// Render template
 		data := PageData{
@@ -2149,89 +1456,275 @@ func dirHandler(tmpl *template.Template, verbose bool) http.HandlerFunc {
 			FullPath:    fullPath,
 			Files:       files,
 			Breadcrumbs: buildBreadcrumbs(r.URL.Path),
-			CanUpload:   canUpload,
-			CanModify:   canModify,
+			Caps:        caps,
+			ArchiveJobs: spoolDir != "",
+			Indexed:     contentIdx != nil,
 			Version:     version,
+			Origin:      advertisedOrigin(r),
+			SortCol:     -1,
+			SortDesc:    spec.Desc,
+			ForceSort:   sorted && settings.ForceSort,
+			Filter:      r.URL.Query().Get("filter"),
+			Brand:       brandingFor(fullPath),
+			SignedInAs:  sessionUser(r),
+			SSO:         isSSOUser(r),
+			GuestAdmin:  settingsAdmin(r),
+			Space:       listingSpace(fullPath, r.URL.Path),
+			Quota:       listingQuota(r),
+			ShowPerms:   showPerms,
+			ShowHidden:  withHidden,
+			HiddenFiles: hidden,
+		}
+		if sorted {
+			data.SortCol = spec.Col
+		}
+		if basicListing(r) {
+			writeBasicListing(w, r, data, baseDir)
+			return
+		}
+		if compactListing(r) {
+			data.Compact = true
+			if len(files) > compactPageSize && r.URL.Query().Get("all") == "" {
+				data.Files = files[:compactPageSize]
+				data.Hidden = len(files) - compactPageSize
+				data.MoreURL = moreURL(r)
+			}
 		}
 
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl.Execute(w, data)
-	}
+		// Listings must show uploads and other changes right away, so the
+		// page is revalidated on every visit
+		var page bytes.Buffer
+		if err := tmpl.Execute(&page, data); err != nil {
+			http.Error(w, "Cannot render listing", http.StatusInternalServerError)
+			return
+		}
+		writeListingPage(w, r, page.Bytes())
+	}
 }
 
-func handleUpload(w http.ResponseWriter, r *http.Request, targetDir string) {
+// handleUpload saves a multipart upload into targetDir. Nothing may be
+// created outside root: the requester's base directory, where their
+// permissions and ignore files apply, or a drop-box session folder, which
+// takes what its visitor sends.
+func handleUpload(w http.ResponseWriter, r *http.Request, targetDir, root string) {
+	conflict, err := conflictPolicyFor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	r.ParseMultipartForm(maxUploadSize * 10) // Allow larger total size for multiple files
 
 	// Get all uploaded files
 	files := r.MultipartForm.File["files"]
-	if len(files) == 0 {
+	dirs := r.MultipartForm.Value["dirs"]
+	if len(files) == 0 && len(dirs) == 0 {
 		http.Error(w, "No files uploaded", http.StatusBadRequest)
 		return
 	}
 
+	// Optional per-file modification times, sent in the same order as files
+	mtimes := r.MultipartForm.Value["mtime"]
+	if len(mtimes) != len(files) {
+		mtimes = nil
+	}
+
+	// Folder uploads send each file's path in the same order too, since
+	// the multipart parser keeps only the last element of a file name
+	paths := r.MultipartForm.Value["paths"]
+	if len(paths) != len(files) {
+		paths = nil
+	}
+
+	// Write-once folders count retention from arrival, so uploads there
+	// can't be backdated
+	if _, writeOnce := writeOnceRetention(targetDir); writeOnce {
+		mtimes = nil
+	}
+
 	uploadedCount := 0
 	var lastError error
+	lastStatus := http.StatusInternalServerError // for lastError, when nothing was saved
+	var saved []entryMeta
+	results := make([]uploadResult, 0, len(files)+len(dirs))
+	var stored int64 // bytes saved so far, for quotas
+	fail := func(name string, err error) {
+		lastError, lastStatus = err, http.StatusInternalServerError
+		results = append(results, uploadResult{Name: name, Status: "failed", Error: err.Error()})
+	}
+	refuse := func(name string, status int, err error) {
+		fail(name, err)
+		lastStatus = status
+	}
+
+	for i, fileHeader := range files {
+		// The path relative to the target folder; for single files, just
+		// the file name
+		name := fileHeader.Filename
+		if paths != nil {
+			name = paths[i]
+		}
+		relativePath, err := cleanUploadPath(name)
+		if err != nil {
+			fail(name, err)
+			continue
+		}
+
+		// Full destination path, which must be somewhere r may upload to,
+		// and the size and types its folder takes
+		destPath := filepath.Join(targetDir, relativePath)
+		if err := uploadRefusal(r, root, destPath, "upload"); err != nil {
+			refuse(name, http.StatusForbidden, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		policy := uploadPolicyFor(destPath)
+		if err := policy.check(relativePath, fileHeader.Size, policy.limit()); err != nil {
+			refuse(name, policy.status(relativePath), err)
+			continue
+		}
 
-	for _, fileHeader := range files {
-		// Check file size
-		if fileHeader.Size > maxUploadSize {
-			lastError = fmt.Errorf("file %s too large", fileHeader.Filename)
+		// A file already there is replaced, kept or kept beside the upload
+		destPath, status := resolveConflict(destPath, conflict)
+		if destPath == "" {
+			results = append(results, uploadResult{Name: name, Status: status})
+			continue
+		}
+		if err := quotaError(r, destPath, fileHeader.Size, stored); err != nil {
+			refuse(name, http.StatusInsufficientStorage, err)
 			continue
 		}
 
 		// Open uploaded file
 		file, err := fileHeader.Open()
 		if err != nil {
-			lastError = err
+			fail(name, err)
 			continue
 		}
 
-		// Extract relative path from filename (for directory uploads)
-		// For regular files, this is just the filename
-		relativePath := filepath.FromSlash(fileHeader.Filename)
-
-		// Prevent path traversal attacks
-		relativePath = filepath.Clean(relativePath)
-		if strings.Contains(relativePath, "..") {
+		if writeOnceLocked(destPath) {
 			file.Close()
-			lastError = fmt.Errorf("invalid path: %s", relativePath)
+			fail(name, fmt.Errorf("%s is in a write-once folder and can't be replaced", relativePath))
 			continue
 		}
 
-		// Full destination path
-		destPath := filepath.Join(targetDir, relativePath)
-
 		// Create parent directories if needed
 		destDir := filepath.Dir(destPath)
 		if err := os.MkdirAll(destDir, 0755); err != nil {
 			file.Close()
-			lastError = err
+			fail(name, err)
 			continue
 		}
 
 		// Save file
-		dst, err := os.Create(destPath)
-		if err != nil {
-			file.Close()
-			lastError = err
-			continue
+		_, statErr := os.Stat(destPath)
+		event := "created"
+		if statErr == nil {
+			event = "modified"
 		}
+		shared := false
+		if dedupDir != "" {
+			shared, err = storeDeduplicated(file, destPath)
+			file.Close()
+			if err != nil {
+				fail(name, err)
+				continue
+			}
+		} else {
+			dst, err := os.Create(destPath)
+			if err != nil {
+				file.Close()
+				fail(name, err)
+				continue
+			}
+
+			if _, err := io.Copy(dst, file); err != nil {
+				dst.Close()
+				file.Close()
+				fail(name, err)
+				continue
+			}
 
-		if _, err := io.Copy(dst, file); err != nil {
 			dst.Close()
 			file.Close()
-			lastError = err
-			continue
 		}
 
-		dst.Close()
-		file.Close()
+		// Deduplicated files share one inode, so only new content gets the client's mtime
+		if mtimes != nil && !shared {
+			if mt, err := parseModTime(mtimes[i]); err == nil {
+				os.Chtimes(destPath, time.Now(), mt)
+			}
+		}
+		emitFileEvent(r, event, destPath, "web")
+		if m, err := statEntry(r, destPath); err == nil {
+			saved = append(saved, m)
+		}
+		stored += fileHeader.Size
+		res := uploadResult{Name: name, Status: status}
+		if status == "renamed" {
+			res.StoredAs = relUpload(targetDir, destPath)
+		}
+		results = append(results, res)
+		uploadedCount++
+	}
+
+	// Recreate empty directories from folder uploads, each checked as a
+	// new folder of its own would be
+	for _, d := range dirs {
+		relativePath, err := cleanUploadPath(d)
+		if err != nil {
+			fail(d, err)
+			continue
+		}
+		dirPath := filepath.Join(targetDir, relativePath)
+		if err := uploadRefusal(r, root, dirPath, "mkdir"); err != nil {
+			refuse(d, http.StatusForbidden, fmt.Errorf("%s: %w", d, err))
+			continue
+		}
+		_, statErr := os.Stat(dirPath)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fail(d, err)
+			continue
+		}
+		if statErr != nil {
+			emitFileEvent(r, "created", dirPath, "web")
+		}
+		if m, err := statEntry(r, dirPath); err == nil {
+			saved = append(saved, m)
+		}
 		uploadedCount++
 	}
 
+	// Scripts asking for JSON get the new entries instead of a redirect
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		if uploadedCount == 0 && lastError != nil {
+			jsonError(w, lastStatus, fmt.Sprintf("Upload failed: %v", lastError))
+			return
+		}
+		resp := map[string]any{"success": true, "entries": saved, "results": results}
+		if lastError != nil {
+			resp["error"] = lastError.Error()
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	// Basic pages get a page saying what went wrong
+	if fromBasicPage(r) {
+		switch {
+		case uploadedCount == 0 && lastError != nil:
+			writeBasicMessage(w, r, lastStatus, "Upload failed", lastError.Error())
+		case lastError != nil:
+			writeBasicMessage(w, r, http.StatusOK, "Some files were not uploaded", lastError.Error())
+		case summarizeUpload(results) != "":
+			writeBasicMessage(w, r, http.StatusOK, "Upload finished", summarizeUpload(results))
+		default:
+			basicDone(w, r, "upload")
+		}
+		return
+	}
+
 	// Return response
 	if uploadedCount == 0 && lastError != nil {
-		http.Error(w, fmt.Sprintf("Upload failed: %v", lastError), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Upload failed: %v", lastError), lastStatus)
 		return
 	}
 
@@ -2239,17 +1732,79 @@ func handleUpload(w http.ResponseWriter, r *http.Request, targetDir string) {
 	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
 }
 
+// cleanUploadPath converts a client-supplied relative path to a clean
+// OS path, rejecting anything that would escape the target directory.
+func cleanUploadPath(name string) (string, error) {
+	relativePath := filepath.Clean(filepath.FromSlash(name))
+	if strings.Contains(relativePath, "..") {
+		return "", fmt.Errorf("invalid path: %s", relativePath)
+	}
+	return relativePath, nil
+}
+
+// uploadRefusal returns why r may not create fullPath under root, a file
+// for action "upload" or a folder for "mkdir", or nil. In the served tree
+// the folder it goes in must allow the action; elsewhere (a drop-box
+// session) it only has to stay inside root.
+func uploadRefusal(r *http.Request, root, fullPath, action string) error {
+	if root != baseDirFor(r) {
+		if !isUnderDir(fullPath, root) || symlinkError(fullPath, root) != nil {
+			return errors.New("invalid path")
+		}
+		return nil
+	}
+	if !reachable(fullPath, root) {
+		return errors.New("invalid path")
+	}
+	dir := fullPath
+	if action == "upload" {
+		dir = filepath.Dir(fullPath)
+	}
+	if !capabilitiesFor(r, dir).allows(action) {
+		return denyError(r, dir, action)
+	}
+	if isSettingsFile(filepath.Base(fullPath)) && !capabilitiesFor(r, fullPath).allows(action) {
+		return denyError(r, fullPath, action)
+	}
+	return nil
+}
+
+// parseModTime accepts a Unix timestamp in milliseconds (as sent by the
+// browser's File.lastModified) or an RFC 3339 time.
+func parseModTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func handleDelete(w http.ResponseWriter, r *http.Request, baseDir string) {
 	path := r.URL.Query().Get("delete")
 	fullPath := filepath.Join(baseDir, path)
 
-	if !isUnderDir(fullPath, baseDir) {
+	if !reachable(fullPath, baseDir) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{"success": false, "error": "Invalid path"}`)
 		return
 	}
+	if d, ok := writeOnceBelowDenial(r, fullPath, "delete"); ok {
+		writeDenial(w, r, d)
+		return
+	}
 
 	err := os.RemoveAll(fullPath)
+	if err == nil {
+		emitFileEvent(r, "deleted", fullPath, "web")
+	}
+	if fromBasicPage(r) {
+		if err != nil {
+			writeBasicMessage(w, r, http.StatusInternalServerError, "Delete failed", err.Error())
+		} else {
+			basicDone(w, r, "delete")
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
 		fmt.Fprintf(w, `{"success": false, "error": "%s"}`, err.Error())
@@ -2261,25 +1816,78 @@ func handleDelete(w http.ResponseWriter, r *http.Request, baseDir string) {
 func handleRename(w http.ResponseWriter, r *http.Request, baseDir string) {
 	oldPath := r.URL.Query().Get("rename")
 	newName := r.URL.Query().Get("newname")
+	// A rename stays in its folder; moving is a batch operation, which
+	// checks the destination
+	if newName == "" || newName == "." || newName == ".." || strings.ContainsAny(newName, `/\`) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success": false, "error": "Invalid name"}`)
+		return
+	}
 
 	oldFullPath := filepath.Join(baseDir, oldPath)
 	newFullPath := filepath.Join(filepath.Dir(oldFullPath), newName)
 
-	if !isUnderDir(oldFullPath, baseDir) || !isUnderDir(newFullPath, baseDir) {
+	if !reachable(oldFullPath, baseDir) || !reachable(newFullPath, baseDir) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{"success": false, "error": "Invalid path"}`)
 		return
 	}
+	if !capabilitiesFor(r, newFullPath).Rename {
+		deny(w, r, newFullPath, "rename")
+		return
+	}
+	if d, ok := writeOnceBelowDenial(r, oldFullPath, "rename"); ok {
+		writeDenial(w, r, d)
+		return
+	}
+	// Renaming mustn't get round the file types a folder accepts
+	if info, err := os.Stat(oldFullPath); err == nil && !info.IsDir() {
+		if err := uploadPolicyFor(newFullPath).check(newName, 0, 0); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+			return
+		}
+	}
 
 	err := os.Rename(oldFullPath, newFullPath)
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
 		fmt.Fprintf(w, `{"success": false, "error": "%s"}`, err.Error())
 	} else {
+		publishEvent(fileEvent{Type: "renamed", OldPath: urlFor(oldFullPath), Path: urlFor(newFullPath), User: requesterName(r), Source: "web"})
 		fmt.Fprintf(w, `{"success": true}`)
 	}
 }
 
+func handleTouch(w http.ResponseWriter, r *http.Request, baseDir string) {
+	target := r.URL.Query().Get("touch")
+	fullPath := filepath.Join(baseDir, target)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !reachable(fullPath, baseDir) {
+		fmt.Fprintf(w, `{"success": false, "error": "Invalid path"}`)
+		return
+	}
+
+	// Without an explicit time, behave like touch(1) and use the current time
+	mtime := time.Now()
+	if v := r.URL.Query().Get("mtime"); v != "" {
+		t, err := parseModTime(v)
+		if err != nil {
+			fmt.Fprintf(w, `{"success": false, "error": "Invalid time"}`)
+			return
+		}
+		mtime = t
+	}
+
+	if err := os.Chtimes(fullPath, time.Now(), mtime); err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+	emitFileEvent(r, "modified", fullPath, "web")
+	fmt.Fprintf(w, `{"success": true}`)
+}
+
 func handleMkdir(w http.ResponseWriter, r *http.Request, parentDir string) {
 	dirName := r.URL.Query().Get("mkdir")
 
@@ -2308,13 +1916,14 @@ func handleMkdir(w http.ResponseWriter, r *http.Request, parentDir string) {
 	if err != nil {
 		fmt.Fprintf(w, `{"success": false, "error": "%s"}`, err.Error())
 	} else {
+		emitFileEvent(r, "created", newPath, "web")
 		fmt.Fprintf(w, `{"success": true}`)
 	}
 }
 
 func handleEdit(w http.ResponseWriter, r *http.Request, fullPath, baseDir string) {
 	// Security check
-	if !isUnderDir(fullPath, baseDir) {
+	if !reachable(fullPath, baseDir) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{"success": false, "error": "Invalid path"}`)
 		return
@@ -2328,17 +1937,49 @@ func handleEdit(w http.ResponseWriter, r *http.Request, fullPath, baseDir string
 		return
 	}
 
-	// Write to file
-	err = os.WriteFile(fullPath, body, 0644)
+	// Saving makes a file as an upload would, so the same names and sizes
+	// are refused
+	policy := uploadPolicyFor(fullPath)
+	if err := policy.check(filepath.Base(fullPath), int64(len(body)), policy.limit()); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(policy.status(fullPath))
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+
+	if err := quotaError(r, fullPath, int64(len(body)), 0); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+
+	// Write to file, refusing if another editor or WebDAV client holds the lock
+	err = withEditLock(urlFor(fullPath), r.URL.Query().Get("token"), func() error {
+		if dedupDir != "" {
+			return writeFileUnlinked(fullPath, body, 0644)
+		}
+		return os.WriteFile(fullPath, body, 0644)
+	})
 	w.Header().Set("Content-Type", "application/json")
-	if err != nil {
+	switch {
+	case err == errFileLocked:
+		msg := "Locked by another user"
+		if l, ok := lockHolder(urlFor(fullPath)); ok {
+			msg = "Locked by " + l.Owner
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": msg})
+	case fileBusy(err):
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": "In use by another program; close it there and save again"})
+	case err != nil:
 		fmt.Fprintf(w, `{"success": false, "error": "%s"}`, err.Error())
-	} else {
+	default:
+		emitFileEvent(r, "modified", fullPath, "web")
 		fmt.Fprintf(w, `{"success": true}`)
 	}
 }
 
-func handleZipDownload(w http.ResponseWriter, fullPath, urlPath string) {
+func handleZipDownload(w http.ResponseWriter, r *http.Request, fullPath, urlPath string) {
 	info, err := os.Stat(fullPath)
 	if err != nil || !info.IsDir() {
 		http.Error(w, "Not found", http.StatusNotFound)
@@ -2350,51 +1991,108 @@ func handleZipDownload(w http.ResponseWriter, fullPath, urlPath string) {
 		zipName = filepath.Base(urlPath) + ".zip"
 	}
 
+	// A password comes in a POST body so it stays out of logs and history.
+	// Each encrypted download has its own salts, so none are spooled.
+	password := ""
+	if r.Method == "POST" {
+		password = r.PostFormValue("password")
+	}
+
+	if spoolDir != "" && password == "" && serveSpooled(w, r, treeFingerprint("zip", fullPath), fullPath, zipName, "application/zip", func(out io.Writer) ([]entryError, error) {
+		return writeZipTree(out, fullPath)
+	}) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", zipName))
+	w.Header().Add("Trailer", archiveSkippedHeader)
+	out, finish := checksumTrailer(w)
+	if skipped, err := writeZipTreeWith(out, fullPath, password); err == nil {
+		setSkipped(w, skipped)
+		finish()
+	}
+}
 
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+// writeZipTree writes a ZIP of everything under fullPath to out, returning
+// the entries it couldn't read. Those are also listed in the archive (see
+// finishZip).
+func writeZipTree(out io.Writer, fullPath string) ([]entryError, error) {
+	return writeZipTreeWith(out, fullPath, "")
+}
 
-	filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// writeZipTreeWith is writeZipTree with every file AES-encrypted under
+// password, unless it is empty.
+func writeZipTreeWith(out io.Writer, fullPath, password string) ([]entryError, error) {
+	zipWriter := newEncryptedZipWriter(out, password)
+	skipped, err := walkArchive(fullPath, fullPath, func(p, name string, info os.FileInfo) error {
+		return addZipEntry(zipWriter, p, name, info, password != "")
+	})
+	if err != nil {
+		zipWriter.Close()
+		return skipped, err
+	}
+	return skipped, finishZip(zipWriter, fullPath, skipped, password != "")
+}
 
-		relPath, _ := filepath.Rel(fullPath, path)
-		if relPath == "." {
-			return nil
+// addZipEntry writes one file or directory to zw under the slash-separated
+// archive name, encrypting files if encrypt is set (zw must then come from
+// newEncryptedZipWriter). Symlinks to files are stored as the file, if
+// -follow-symlinks allows; an entry that can't be read is rejected with an
+// *entryError before anything is written, and one that fails partway is
+// kept as far as it was read and reported the same way.
+func addZipEntry(zw *zip.Writer, fullPath, name string, info os.FileInfo, encrypt bool) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		if err := symlinkError(fullPath, getBaseDir()); err != nil {
+			return &entryError{name, err.Error()}
 		}
-
-		header, err := zip.FileInfoHeader(info)
+		target, err := os.Stat(fullPath)
 		if err != nil {
-			return err
+			return &entryError{name, entryErrorReason(fullPath, err)}
 		}
-
-		header.Name = relPath
-		header.Method = zip.Deflate
-
-		if info.IsDir() {
-			header.Name += "/"
+		if target.IsDir() {
+			return &entryError{name, "Link to a folder, not followed"}
 		}
+		info = target
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		// Sockets, devices and pipes have no place in a download
+		return nil
+	}
 
-		writer, err := zipWriter.CreateHeader(header)
+	var file *os.File
+	if !info.IsDir() {
+		f, err := os.Open(fullPath)
 		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			return err
+			return &entryError{name, entryErrorReason(fullPath, err)}
 		}
+		defer f.Close()
+		file = f
+	}
 
-		return nil
-	})
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	if info.IsDir() {
+		header.Name += "/"
+	} else if encrypt {
+		encryptZipHeader(header)
+	}
+	writer, err := zw.CreateHeader(header)
+	if err != nil || file == nil {
+		return err
+	}
+	src := &readFailure{r: file}
+	if _, err := io.Copy(writer, src); err != nil {
+		return err
+	}
+	if src.err != nil {
+		return &entryError{name, "Incomplete, reading failed partway: " + entryErrorReason(fullPath, src.err)}
+	}
+	return nil
 }
 
 func handleMultiZipDownload(w http.ResponseWriter, r *http.Request, currentDir, baseDir string) {
@@ -2407,78 +2105,63 @@ func handleMultiZipDownload(w http.ResponseWriter, r *http.Request, currentDir,
 
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", "attachment; filename=download.zip")
-
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+	w.Header().Add("Trailer", archiveSkippedHeader)
+	out, finish := checksumTrailer(w)
+
+	password := r.PostFormValue("password")
+	zipWriter := newEncryptedZipWriter(out, password)
+	var skipped []entryError
+	failed := false
+	defer func() {
+		if failed {
+			zipWriter.Close()
+		} else if finishZip(zipWriter, currentDir, skipped, password != "") == nil {
+			setSkipped(w, skipped)
+			finish()
+		}
+	}()
 
 	for _, fp := range filePaths {
 		// Resolve relative to current directory
 		fullPath := filepath.Join(currentDir, filepath.Base(fp))
 
 		// Security check
-		if !isUnderDir(fullPath, baseDir) {
+		if !isUnderDir(fullPath, baseDir) || ignoredPath(fullPath) {
 			continue
 		}
 
-		info, err := os.Stat(fullPath)
+		info, err := os.Lstat(fullPath)
 		if err != nil {
+			skipped = append(skipped, entryError{filepath.Base(fullPath), entryErrorReason(fullPath, err)})
 			continue
 		}
 
 		if info.IsDir() {
-			// Walk directory
-			filepath.Walk(fullPath, func(path string, fi os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				relPath, _ := filepath.Rel(currentDir, path)
-				header, err := zip.FileInfoHeader(fi)
-				if err != nil {
-					return err
-				}
-				header.Name = relPath
-				header.Method = zip.Deflate
-				if fi.IsDir() {
-					header.Name += "/"
-				}
-				writer, err := zipWriter.CreateHeader(header)
-				if err != nil {
-					return err
-				}
-				if !fi.IsDir() {
-					file, err := os.Open(path)
-					if err != nil {
-						return err
-					}
-					defer file.Close()
-					io.Copy(writer, file)
-				}
-				return nil
+			more, err := walkArchive(fullPath, currentDir, func(p, name string, fi os.FileInfo) error {
+				return addZipEntry(zipWriter, p, name, fi, password != "")
 			})
-		} else {
-			// Single file
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				continue
-			}
-			header.Name = filepath.Base(fullPath)
-			header.Method = zip.Deflate
-			writer, err := zipWriter.CreateHeader(header)
+			skipped = append(skipped, more...)
 			if err != nil {
-				continue
+				failed = true
+				return
 			}
-			file, err := os.Open(fullPath)
-			if err != nil {
-				continue
+		} else if err := addZipEntry(zipWriter, fullPath, filepath.Base(fullPath), info, password != ""); err != nil {
+			var skip *entryError
+			if !errors.As(err, &skip) {
+				failed = true
+				return
 			}
-			io.Copy(writer, file)
-			file.Close()
+			skipped = append(skipped, *skip)
 		}
 	}
 }
 
 func handleMarkdownPreview(w http.ResponseWriter, fullPath string) {
 	content, err := os.ReadFile(fullPath)
+	if fileBusy(err) {
+		serveFileBusy(w, filepath.Base(fullPath))
+		return
+	}
 	if err != nil {
 		http.Error(w, "Cannot read file", http.StatusInternalServerError)
 		return
@@ -2489,12 +2172,100 @@ func handleMarkdownPreview(w http.ResponseWriter, fullPath string) {
 	w.Write(html)
 }
 
+// apiHandler wraps an API endpoint with the standard GeoIP, rate limit and
+// authentication middleware.
+func apiHandler(h http.HandlerFunc) http.HandlerFunc {
+	return geoMiddleware(rateLimitMiddleware(authMiddleware(h)))
+}
+
+// webdavHandler serves the base directory over WebDAV; /_api/chdir
+// repoints it.
+var webdavHandler *webdav.Handler
+
+// davFileSystem returns the WebDAV filesystem for dir, wrapped so listings
+// leave out ignored entries and writes respect deduplicated storage when
+// enabled.
+func davFileSystem(dir string) webdav.FileSystem {
+	if dedupDir != "" {
+		return ignoreFS{dedupFS{webdav.Dir(dir)}, dir}
+	}
+	return ignoreFS{webdav.Dir(dir), dir}
+}
+
+// handleChdir serves /_api/chdir, which points the server at another
+// directory. It is only registered with -allow-chdir, and only signed-in
+// users whose own permission is all may use it.
+func handleChdir(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !capabilitiesFor(r, "").Chdir {
+		deny(w, r, "", "chdir")
+		return
+	}
+	var req struct {
+		Dir string `json:"dir"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"error":"Invalid request"}`)
+		return
+	}
+	newPath, err := filepath.Abs(req.Dir)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"error":"Invalid path"}`)
+		return
+	}
+	info, err := os.Stat(newPath)
+	if err != nil || !info.IsDir() {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"error":"Directory does not exist"}`)
+		return
+	}
+	if !chdirAllowed(newPath) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"success":false,"error":"Forbidden: Directory is not in the allowed list"}`)
+		return
+	}
+	setBaseDir(newPath)
+	webdavHandler.FileSystem = davFileSystem(newPath)
+	fmt.Printf("📂 Changed directory: %s\n", newPath)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"success":true,"dir":"%s"}`, strings.ReplaceAll(newPath, `\`, `\\`))
+}
+
 func main() {
+	// Maintenance subcommands
+	if len(os.Args) > 1 && (os.Args[1] == "export-state" || os.Args[1] == "import-state") {
+		if err := runStateCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		if err := runHashPasswordCommand(os.Args[2:]); err != nil {
+			log.Fatalf("hash-password: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		if err := runUpdateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("update: %v", err)
+		}
+		return
+	}
+
 	// Custom usage function with examples
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "GoServe - Lightweight HTTP File Server\n\n")
 		fmt.Fprintf(os.Stderr, "USAGE:\n")
-		fmt.Fprintf(os.Stderr, "  go run main.go [options]\n\n")
+		fmt.Fprintf(os.Stderr, "  go run main.go [options]\n")
+		fmt.Fprintf(os.Stderr, "  go run main.go export-state|import-state -state DIR [-logins FILE] [-f FILE] [-force]\n")
+		fmt.Fprintf(os.Stderr, "  goserve update [-check] [-force]\n")
+		fmt.Fprintf(os.Stderr, "  goserve hash-password [-cost N] < password\n\n")
 		fmt.Fprintf(os.Stderr, "OPTIONS:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nEXAMPLES:\n")
@@ -2514,8 +2285,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "    go run main.go -permlevel all\n\n")
 		fmt.Fprintf(os.Stderr, "  Per-user authentication:\n")
 		fmt.Fprintf(os.Stderr, "    go run main.go -logins logins.txt\n\n")
+		fmt.Fprintf(os.Stderr, "  Public read-only view on the LAN, full access over TLS with login:\n")
+		fmt.Fprintf(os.Stderr, "    go run main.go -listen :8080,readonly,noauth -listen :8443,tls,auth,all -tls-cert cert.pem -tls-key key.pem -logins logins.txt\n\n")
+		fmt.Fprintf(os.Stderr, "  Settings from a file, with one overridden from the environment:\n")
+		fmt.Fprintf(os.Stderr, "    GOSERVE_VERBOSE=true go run main.go -config goserve.yaml\n\n")
 		fmt.Fprintf(os.Stderr, "  Verbose mode (log every request):\n")
 		fmt.Fprintf(os.Stderr, "    go run main.go -verbose\n\n")
+		fmt.Fprintf(os.Stderr, "  Access log for a log analyser, rotated daily:\n")
+		fmt.Fprintf(os.Stderr, "    go run main.go -accesslog /var/log/goserve/access.log -accesslog-rotate 24h\n\n")
 		fmt.Fprintf(os.Stderr, "  Combined example:\n")
 		fmt.Fprintf(os.Stderr, "    go run main.go -listen :8000 -dir /var/www -permlevel all\n\n")
 		fmt.Fprintf(os.Stderr, "TAILSCALE SHARING:\n")
@@ -2528,14 +2305,96 @@ func main() {
 	}
 
 	// Command line flags
+	configFile := flag.String("config", "", "YAML or TOML file of flag settings (flags and GOSERVE_* environment variables override it)")
 	var listenAddrs stringSlice
-	flag.Var(&listenAddrs, "listen", "Address to listen on in host:port format (repeatable, default :8080)")
+	flag.Var(&listenAddrs, "listen", "Address to listen on in host:port format, optionally followed by ,tls ,auth ,noauth ,readonly ,readwrite or ,all (repeatable, default :8080)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for listeners marked ,tls")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for listeners marked ,tls")
+	flag.StringVar(tlsCert, "cert", "", "Short for -tls-cert")
+	flag.StringVar(tlsKey, "key", "", "Short for -tls-key")
+	acmeHosts := flag.String("acme", "", "Get certificates for listeners marked ,tls from Let's Encrypt for these comma-separated hostnames")
+	acmeCache := flag.String("acme-cache", "", "Directory for ACME certificates and account key (default: goserve/acme in the user cache directory)")
+	acmeEmail := flag.String("acme-email", "", "Contact email for the ACME account (optional)")
+	tlsSelfSigned := flag.Bool("tls-selfsigned", false, "Serve listeners marked ,tls with a generated self-signed certificate (kept in -state if set)")
+	flag.StringVar(&advertiseHost, "advertise-host", "", "Host (optionally host:port) to use in copied links and printed URLs instead of the detected address")
 	dir := flag.String("dir", ".", "Directory to serve")
-	verbose := flag.Bool("verbose", false, "Log every HTTP request to the console")
+	verbose := flag.Bool("verbose", false, "Log every request to the console, in -accesslog-format")
+	accessLogFile := flag.String("accesslog", "", "Write the access log to this file (- for the console) for log analysers")
+	accessLogFormat := flag.String("accesslog-format", "combined", "Access log format: combined (Apache) or json")
+	accessLogMB := flag.Int64("accesslog-maxsize", 100, "Rotate -accesslog when it reaches this many MB (0 = no size limit)")
+	accessLogRotate := flag.String("accesslog-rotate", "", "Also rotate -accesslog at this interval from midnight (e.g. 1h, 24h, 7d)")
+	accessLogKeep := flag.Int("accesslog-keep", 7, "Rotated -accesslog files to keep, as file.1 (newest) to file.N")
+	flag.BoolVar(&debugEnabled, "debug", false, "Serve Go profiling endpoints at /debug/pprof/ to users who can administer the server")
+	authzLog := flag.String("authz-log", "", "Log every permission denial with the rule that decided it (- for the console, or a file for JSON lines)")
 	permLevel := flag.String("permlevel", "readonly", "Permission level: readonly, readwrite, all")
 	maxSize := flag.Int64("maxsize", 100, "Max upload size in MB")
+	uploadAllowFlag := flag.String("upload-allow", "", "Comma-separated file name globs that uploads must match (e.g. *.jpg,*.png,*.pdf)")
+	uploadDenyFlag := flag.String("upload-deny", "", "Comma-separated file name globs never accepted as uploads (e.g. *.exe,*.dll,*.bat)")
+	flag.StringVar(&uploadConflict, "upload-conflict", conflictOverwrite, "When an upload's name is taken: overwrite, skip, or rename (save as \"name (1).ext\"); ?conflict= overrides it per request")
 	loginFile := flag.String("logins", "", "Enable authentication with login file (format: username:password:permission)")
+	tokensFile := flag.String("tokens", "", "API tokens accepted as Authorization: Bearer (format: name:token:permission)")
+	flag.BoolVar(&allowChdir, "allow-chdir", false, "Let signed-in users with all permission change the served directory from the search box (requires -logins)")
+	flag.BoolVar(&loginForm, "login-form", false, "Sign browsers in with a login page and session cookie instead of the Basic prompt")
+	sessionExpiryFlag := flag.String("session-expiry", "24h", "How long a login-page session lasts (e.g. 12h, 7d)")
+	flag.IntVar(&authMaxFailures, "auth-max-failures", 10, "Failed sign-ins from one IP or for one username before it is locked out (0 = never)")
+	flag.DurationVar(&authLockout, "auth-lockout", 15*time.Minute, "Window for counting failed sign-ins, and how long a lockout lasts")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect provider URL for single sign-on (e.g. https://accounts.google.com)")
+	oidcClientID := flag.String("oidc-client-id", "", "Client ID registered with the -oidc-issuer provider")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "Client secret for -oidc-client-id (or set GOSERVE_OIDC_CLIENT_SECRET)")
+	oidcRedirect := flag.String("oidc-redirect", "", "Redirect URI registered with the provider (default: this server's /_oidc/callback)")
+	oidcScopes := flag.String("oidc-scopes", "openid profile email", "Scopes requested at sign-in")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", "groups", "ID token claim listing the user's groups")
+	oidcRoles := flag.String("oidc-roles", "*=readonly", "Permission per group or claim:value, e.g. admins=all,staff=readwrite,*=readonly")
+	rate := flag.Float64("rate", 0, "Requests per second allowed per client for listings and downloads (0 = unlimited)")
+	flag.BoolVar(&qosEnabled, "qos", true, "Let listings, previews and other interactive requests go ahead of archive downloads, uploads and large transfers")
+	heavyRate := flag.Float64("rate-heavy", 0, "Archive and search requests per minute allowed per client (0 = unlimited)")
+	maxConns := flag.Int("max-conns", 0, "Open connections allowed per listener; more wait to be accepted (0 = unlimited)")
+	flag.IntVar(&maxRequests, "max-requests", 0, "Requests handled at once; more wait in line for -queue-timeout, then get 503 (0 = unlimited)")
+	flag.IntVar(&maxDownloadsPerIP, "max-downloads-per-ip", 0, "File and archive downloads one client address may run at once (0 = unlimited)")
+	flag.DurationVar(&queueTimeout, "queue-timeout", 10*time.Second, "How long a request over -max-requests or -max-downloads-per-ip waits for a slot before 503 (0 = answer 503 at once)")
+	geoipFile := flag.String("geoip", "", "MaxMind country/city database (.mmdb) for tagging requests by country")
+	flag.StringVar(&geoAllowList, "geoip-allow", "", "Comma-separated ISO country codes allowed to connect (requires -geoip)")
+	flag.StringVar(&geoDenyList, "geoip-deny", "", "Comma-separated ISO country codes refused (requires -geoip)")
+	flag.StringVar(&dropboxDir, "dropbox", "", "Enable anonymous drop-box uploads at /_drop/, stored per uploader under this directory (relative to -dir)")
+	flag.StringVar(&dedupDir, "dedup", "", "Store uploads once by content hash in this directory and hard-link them into place (same filesystem as -dir)")
+	flag.StringVar(&pasteDir, "paste", "", "Enable the pastebin at /_paste, saving pastes as timestamped files in this directory (relative to -dir)")
+	flag.BoolVar(&showDiskSpace, "disk-space", true, "Show free disk space in folder footers and the API")
+	flag.StringVar(&followSymlinks, "follow-symlinks", symlinksWithinRoot, "Which symlinks to follow: serve-within-root (links that stay inside the served folder), serve-all, or deny")
+	flag.BoolVar(&showHidden, "show-hidden", false, "List dotfiles and files with the Windows hidden attribute; users can show them for their session either way")
+	flag.BoolVar(&showPerms, "show-perms", false, "Show file owners and modes in listings and let users who can modify files change modes (Unix only)")
+	flag.Int64Var(&lowSpaceMB, "low-space", 10240, "Warn when a folder's volume has less than this many MB free (0 = never)")
+	flag.StringVar(&spoolDir, "spool", "", "Spool folder ZIP/TAR downloads to this directory so interrupted downloads can resume")
+	cacheDir := flag.String("cache", "", "Local disk cache for remote objects and generated previews")
+	cacheControl := flag.String("cache-control", "", "Browser cache lifetimes for files by content type, e.g. image/*=7d,text/css=1h,*=0 (default: always revalidate)")
+	flag.StringVar(&etagMode, "etag", "mtime", "Base file ETags on size and modification time (mtime) or on content (hash)")
+	cacheSize := flag.Int64("cache-size", 1024, "Max size of the -cache directory in MB (least recently used entries are evicted)")
+	capGB := flag.Float64("monthly-cap", 0, "Monthly transfer cap per user in GB; users over it become read-only (0 = no cap)")
+	quotaGB := flag.Float64("quota", 0, "Most the served directory may hold in GB; uploads past it are refused (0 = no limit, needs -dir-sizes)")
+	userQuotaGB := flag.Float64("user-quota", 0, "Most each user's uploads may take up in GB; quota= in the logins file overrides it per user (0 = no limit)")
+	transferRetentionFlag := flag.String("transfer-retention", "365d", "How long to keep transfer history in the -state directory (0 = forever)")
+	rulesFile := flag.String("rules", "", "JSON file of automation rules (move, tag, webhook, expire) applied on file events")
+	searchExclude := flag.String("search-exclude", "", "Comma-separated globs skipped by Find in Files (e.g. node_modules,*.iso,/backups)")
+	searchMaxSize := flag.Int64("search-max-size", 50, "Largest file in MB that Find in Files reads")
+	searchConcurrency := flag.Int("search-concurrency", 2, "Find in Files searches run at once; others wait (0 = unlimited)")
+	searchRateMB := flag.Float64("search-rate", 0, "Max MB/s each Find in Files search reads (0 = unlimited)")
+	dirSizesFlag := flag.Bool("dir-sizes", true, "Show folder sizes in listings, worked out in the background (turn off for trees too big to walk)")
+	index := flag.Bool("index", false, "Keep an index of the words in text files so Find in Files can search by word at once")
+	indexRescan := flag.Duration("index-rescan", time.Hour, "How often the -index is checked against the disk for changes made outside goserve (0 = never)")
+	flag.DurationVar(&searchTimeout, "search-timeout", 2*time.Minute, "Stop a Find in Files search after this long and return what it found (0 = no limit)")
+	flag.StringVar(&defaultSort, "sort", "", "Default listing order: name, size or modified, optionally with ,desc (folders can override in .goserve.json)")
+	flag.StringVar(&stateDir, "state", "", "Directory for persistent metadata (shares, tags, counters, audit log)")
+	flag.BoolVar(&stateShared, "state-shared", false, "Several instances share the -state directory (on NFS or similar); lock and reload documents across them")
+	replicaOfFlag := flag.String("replica-of", "", "Keep -dir a read-only copy of this goserve primary (http[s]://host:port), following its changes")
+	flag.StringVar(&replica.token, "replica-token", "", "API token the replica sends to a primary that requires sign-in")
+	flag.DurationVar(&replica.resync, "replica-resync", time.Hour, "How often a replica compares its whole tree with the primary's")
+	mdns := flag.Bool("mdns", false, "Advertise the web UI and WebDAV on the LAN with Bonjour/zeroconf (mDNS)")
+	mdnsName := flag.String("mdns-name", "", "Name to advertise with -mdns (default \"GoServe on <hostname>\")")
+	proxyOfFlag := flag.String("proxy-of", "", "Pass every request on to this origin file server (http[s]://host:port), caching downloaded file chunks in -cache")
+	stateRedisURL := flag.String("state-redis", "", "Keep shared state in Redis (redis://[:password@]host[:port][/db]) instead of the -state directory")
 	flag.Parse()
+	if err := applyConfig(configFile); err != nil {
+		log.Fatalf("Config: %v", err)
+	}
 
 	if len(listenAddrs) == 0 {
 		listenAddrs = stringSlice{"localhost:8080"}
@@ -2556,15 +2415,173 @@ func main() {
 		log.Fatalf("Invalid -permlevel %q. Valid: readonly, readwrite, all", *permLevel)
 	}
 	maxUploadSize = *maxSize * 1024 * 1024
+	policy, err := parseCachePolicy(*cacheControl)
+	if err != nil {
+		log.Fatalf("Invalid -cache-control: %v", err)
+	}
+	cachePolicy = policy
+	if etagMode != "mtime" && etagMode != "hash" {
+		log.Fatalf("Invalid -etag %q. Valid: mtime, hash", etagMode)
+	}
+	if uploadAllow, err = parseUploadGlobs(*uploadAllowFlag); err != nil {
+		log.Fatalf("Invalid -upload-allow: %v", err)
+	}
+	if uploadDeny, err = parseUploadGlobs(*uploadDenyFlag); err != nil {
+		log.Fatalf("Invalid -upload-deny: %v", err)
+	}
+	if !validConflictPolicy(uploadConflict) {
+		log.Fatalf("Invalid -upload-conflict %q. Valid: overwrite, skip, rename", uploadConflict)
+	}
+	if !validSymlinkPolicy(followSymlinks) {
+		log.Fatalf("Invalid -follow-symlinks %q. Valid: serve-within-root, serve-all, deny", followSymlinks)
+	}
+	if showPerms && !permsSupported {
+		log.Fatal("-show-perms is only available on Unix")
+	}
+	if transferRetention, err = parseTransferRetention(*transferRetentionFlag); err != nil {
+		log.Fatalf("Invalid -transfer-retention: %v", err)
+	}
+	if *replicaOfFlag != "" {
+		u, err := url.Parse(*replicaOfFlag)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -replica-of %q: expected http://host:port or https://host", *replicaOfFlag)
+		}
+		if replica.resync < time.Minute {
+			log.Fatalf("Invalid -replica-resync %v: must be at least a minute", replica.resync)
+		}
+		replicaOf = u
+	}
+	if *proxyOfFlag != "" {
+		u, err := url.Parse(*proxyOfFlag)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -proxy-of %q: expected http://host:port or https://host", *proxyOfFlag)
+		}
+		if *cacheDir == "" {
+			log.Fatal("-proxy-of needs -cache")
+		}
+		if replicaOf != nil {
+			log.Fatal("-proxy-of and -replica-of can't be used together")
+		}
+		proxyOf = u
+	}
+	if err := setSearchExcludes(*searchExclude); err != nil {
+		log.Fatalf("Invalid -search-exclude: %v", err)
+	}
+	searchMaxFileSize = *searchMaxSize * 1024 * 1024
+	searchRate = int64(*searchRateMB * 1024 * 1024)
+	if *searchConcurrency > 0 {
+		searchSlots = make(chan struct{}, *searchConcurrency)
+	}
+	describeMetric("goserve_search_files_total", "Files read by Find in Files searches.")
+	describeMetric("goserve_search_bytes_total", "Bytes read by Find in Files searches.")
+	if defaultSort != "" {
+		if _, err := parseSort(defaultSort); err != nil {
+			log.Fatalf("Invalid -sort: %v", err)
+		}
+	}
 
-	// Load users if authentication is enabled (ignored if -permlevel is not readonly)
-	if *loginFile != "" && *permLevel == "readonly" {
-		err := loadUsers(*loginFile)
+	// Rate limits: bursts of a few seconds' (or one minute's) worth of requests
+	serverInfo.rate, serverInfo.heavyRate = *rate, *heavyRate
+	cheapLimiter = newRateLimiter(*rate, math.Max(1, *rate*5))
+	heavyLimiter = newRateLimiter(*heavyRate/60, math.Max(1, *heavyRate))
+	if *geoipFile != "" {
+		if err := loadGeoIP(*geoipFile); err != nil {
+			log.Fatalf("Failed to load GeoIP database: %v", err)
+		}
+		describeMetric("goserve_requests_by_country_total", "Requests by client country (GeoIP).")
+		describeMetric("goserve_geoip_denied_total", "Requests refused by -geoip-allow/-geoip-deny.")
+	}
+	describeMetric("goserve_ratelimit_allowed_total", "Requests admitted by the rate limiter.")
+	describeMetric("goserve_ratelimit_rejected_total", "Requests rejected with 429 by the rate limiter.")
+	if *maxConns < 0 || maxRequests < 0 || maxDownloadsPerIP < 0 || queueTimeout < 0 {
+		log.Fatalf("Invalid limits: -max-conns, -max-requests, -max-downloads-per-ip and -queue-timeout can't be negative")
+	}
+	if maxRequests > 0 {
+		requestSlots = make(chan struct{}, maxRequests)
+	}
+	serverInfo.maxConns = *maxConns
+	describeMetric("goserve_limit_rejected_total", "Requests answered 503 by -max-requests or -max-downloads-per-ip.")
+	describeMetric("goserve_limit_queued_seconds_total", "Time requests spent waiting for a -max-requests or -max-downloads-per-ip slot.")
+	describeMetric("goserve_auth_failures_total", "Failed sign-ins with a password or API token.")
+	describeMetric("goserve_auth_lockouts_total", "IPs and usernames locked out after too many failed sign-ins.")
+
+	// Per-listener options. Without one, a listener requires login when
+	// -logins is given and -permlevel is readonly.
+	defaultAuth := (*loginFile != "" || *tokensFile != "" || *oidcIssuer != "") && *permLevel == "readonly"
+	var listenConfigs []*listenerConfig
+	anyAuth, anyTLS := defaultAuth, false
+	for _, spec := range listenAddrs {
+		cfg, err := parseListenSpec(spec, defaultAuth, *permLevel)
 		if err != nil {
-			log.Fatalf("Failed to load login file: %v", err)
+			log.Fatal(err)
+		}
+		anyAuth = anyAuth || cfg.Auth
+		anyTLS = anyTLS || cfg.TLS
+		listenConfigs = append(listenConfigs, &cfg)
+	}
+
+	// Certificates for listeners marked ,tls: from files, Let's Encrypt or
+	// made up on the spot
+	var tlsConfig *tls.Config
+	var selfSignedFingerprint string
+	sources := 0
+	for _, set := range []bool{*tlsCert != "" || *tlsKey != "", *acmeHosts != "", *tlsSelfSigned} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		log.Fatal("Use only one of -tls-cert/-tls-key, -acme and -tls-selfsigned")
+	}
+	switch {
+	case !anyTLS:
+		if sources > 0 {
+			log.Printf("No listener is marked ,tls, so the TLS settings are not used (e.g. -listen :8443,tls)")
+		}
+	case *acmeHosts != "":
+		if err := setupACME(*acmeHosts, *acmeCache, *acmeEmail); err != nil {
+			log.Fatalf("Cannot set up ACME: %v", err)
+		}
+		tlsConfig = acmeManager.TLSConfig()
+	case *tlsSelfSigned:
+		cert, err := selfSignedCert(stateDir)
+		if err != nil {
+			log.Fatalf("Cannot create self-signed certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		selfSignedFingerprint = certFingerprint(cert)
+	default:
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("Listeners marked ,tls need -tls-cert and -tls-key, -acme or -tls-selfsigned")
+		}
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	// Load users if authentication is enabled on any listener
+	if anyAuth {
+		if *loginFile == "" && *tokensFile == "" && *oidcIssuer == "" {
+			log.Fatal("Listeners marked ,auth need -logins, -tokens or -oidc-issuer")
+		}
+		if *loginFile != "" {
+			if err := loadUsers(*loginFile); err != nil {
+				log.Fatalf("Failed to load login file: %v", err)
+			}
+			fmt.Printf("✓ Loaded %d users from %s\n", len(users), *loginFile)
 		}
-		requireAuth = true
-		fmt.Printf("✓ Loaded %d users from %s\n", len(users), *loginFile)
+		if *tokensFile != "" {
+			if err := loadTokens(*tokensFile); err != nil {
+				log.Fatalf("Failed to load tokens file: %v", err)
+			}
+			fmt.Printf("✓ Loaded %d API tokens from %s\n", len(apiTokens), *tokensFile)
+		}
+		requireAuth = defaultAuth
+	}
+	if allowChdir && !anyAuth {
+		log.Printf("Warning: -allow-chdir has no effect without a listener marked ,auth")
 	}
 
 	// Get absolute path
@@ -2579,15 +2596,121 @@ func main() {
 	}
 
 	// Parse template
-	tmpl, err := template.New("index").Parse(htmlTemplate)
+	tmpl, err := template.New("index").Funcs(template.FuncMap{"asset": assetURL}).Parse(htmlTemplate)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if stateDir != "" {
+		if stateDir, err = filepath.Abs(stateDir); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			log.Fatalf("Cannot create state directory: %v", err)
+		}
+	}
+	if *stateRedisURL != "" {
+		if stateRedis, err = newRedisClient(*stateRedisURL); err != nil {
+			log.Fatal(err)
+		}
+		stateShared = true
+	}
+	if stateShared && stateDir == "" && stateRedis == nil {
+		log.Fatal("-state-shared needs -state")
+	}
+	if err := setupAccessLog(*accessLogFile, *accessLogFormat, *accessLogMB, *accessLogRotate, *accessLogKeep, *verbose); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupAuthzLog(*authzLog); err != nil {
+		log.Fatal(err)
+	}
+	shareState("editlocks", &editLocksMu, &editLocks)
+
+	if *oidcIssuer != "" {
+		if err := setupOIDC(*oidcIssuer, *oidcClientID, *oidcClientSecret, *oidcRedirect, *oidcScopes, *oidcGroupsClaim, *oidcRoles); err != nil {
+			log.Fatalf("Single sign-on: %v", err)
+		}
+		fmt.Printf("✓ Single sign-on through %s\n", oidc.Issuer)
+	}
+	if loginForm && *loginFile == "" {
+		log.Fatal("-login-form needs -logins")
+	}
+	if sessionsEnabled() {
+		if sessionExpiry, err = parseDuration(*sessionExpiryFlag); err != nil || sessionExpiry <= 0 {
+			log.Fatalf("Invalid -session-expiry %q", *sessionExpiryFlag)
+		}
+		if err := loadSessions(); err != nil {
+			log.Fatalf("Failed to load sessions: %v", err)
+		}
+	}
+
+	monthlyCap = int64(*capGB * 1024 * 1024 * 1024)
+	loadUsage()
+	pruneTransfers()
+	loadUploadLinks()
+	loadGuests()
+	loadShortLinks()
+	startWatches()
+	startChangeLog()
+	if *index {
+		startContentIndex(*indexRescan)
+	}
+	if *dirSizesFlag {
+		startDirSizes()
+	}
+	serverQuota = int64(*quotaGB * 1024 * 1024 * 1024)
+	userQuota = int64(*userQuotaGB * 1024 * 1024 * 1024)
+	if serverQuota > 0 && !*dirSizesFlag {
+		log.Fatal("-quota needs -dir-sizes")
+	}
+	userQuotas := userQuota > 0
+	for _, u := range users {
+		userQuotas = userQuotas || u.Quota > 0
+	}
+	if serverQuota > 0 || userQuotas {
+		startQuotas()
+	}
+	if replicaOf != nil {
+		startReplica()
+	}
+	loadSettings()
+	startTags()
+	if *rulesFile != "" {
+		if err := loadRules(*rulesFile); err != nil {
+			log.Fatalf("Failed to load rules: %v", err)
+		}
+		startRules()
+		describeMetric("goserve_rules_applied_total", "Automation rule actions applied.")
+	}
+
+	if *cacheDir != "" {
+		if blobCache, err = newDiskCache(*cacheDir, *cacheSize*1024*1024); err != nil {
+			log.Fatalf("Cannot open cache: %v", err)
+		}
+		describeMetric("goserve_cache_hits_total", "Disk cache hits.")
+		describeMetric("goserve_cache_misses_total", "Disk cache misses (object fetched or generated).")
+		describeMetric("goserve_cache_evictions_total", "Objects evicted from the disk cache to stay under -cache-size.")
+	}
+	if proxyOf != nil {
+		describeMetric("goserve_proxy_chunks_total", "File chunks served in proxy mode, by source (cache or origin).")
+		describeMetric("goserve_proxy_origin_bytes_total", "Bytes of file chunks fetched from the origin.")
+		describeMetric("goserve_proxy_origin_seconds_total", "Time spent fetching file chunks from the origin.")
+	}
+
+	if dedupDir != "" {
+		if dedupDir, err = filepath.Abs(dedupDir); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.MkdirAll(dedupDir, 0755); err != nil {
+			log.Fatalf("Cannot create dedup store: %v", err)
+		}
+		describeMetric("goserve_dedup_saved_bytes_total", "Upload bytes not stored thanks to deduplication.")
+	}
+
 	// Setup WebDAV handler
-	webdavHandler := &webdav.Handler{
-		FileSystem: webdav.Dir(absPath),
-		LockSystem: webdav.NewMemLS(),
+	webdavHandler = &webdav.Handler{
+		FileSystem: davFileSystem(absPath),
+		LockSystem: lockSystem,
 		Logger: func(r *http.Request, err error) {
 			if err != nil {
 				log.Printf("WebDAV: %s %s - %v", r.Method, r.URL.Path, err)
@@ -2597,64 +2720,166 @@ func main() {
 
 	// WebDAV handler with authentication
 	webdavHTTP := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fullPath, _ := resolvePathFor(r, strings.TrimPrefix(r.URL.Path, "/webdav"))
+		// The folder's upload limits, or -maxsize, as for any upload
+		if r.Method == "PUT" {
+			policy := uploadPolicyFor(fullPath)
+			limit := policy.limit()
+			if err := policy.check(path.Base(r.URL.Path), r.ContentLength, limit); err != nil {
+				http.Error(w, err.Error(), policy.status(fullPath))
+				return
+			}
+			if err := quotaError(r, fullPath, r.ContentLength, 0); err != nil {
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
+				return
+			}
+			if mtime, ok := ocMtime(r); ok && capabilitiesFor(r, fullPath).Touch {
+				w = &ocMtimeWriter{ResponseWriter: w, fullPath: fullPath, mtime: mtime}
+			}
+			if limit > 0 {
+				body := &capReader{r: r.Body, limit: limit}
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{body, r.Body}
+				defer func() {
+					if body.n > body.limit {
+						log.Printf("WebDAV: %s is over the %s limit; removed", fullPath, formatSize(limit))
+						os.Remove(fullPath)
+					}
+				}()
+			}
+		}
+		if r.Method == "PROPPATCH" && davSetTimes(w, r, fullPath, r.URL.EscapedPath()) {
+			return
+		}
+		// Clients must not reuse listings or files from before a write
+		w.Header().Set("Cache-Control", "no-cache")
+
 		// Strip /webdav prefix for the webdav handler
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/webdav")
 		if r.URL.Path == "" {
 			r.URL.Path = "/"
 		}
+		// and from the destination of a COPY or MOVE, so it lands where
+		// its permissions were checked
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil && strings.HasPrefix(u.Path, "/webdav/") {
+			u.Path = strings.TrimPrefix(u.Path, "/webdav")
+			u.RawPath = ""
+			r.Header.Set("Destination", u.String())
+		}
+		if home, ok := homeOf(r); ok {
+			homeDavHandler(home, webdavHandler.Logger).ServeHTTP(w, r)
+			return
+		}
 		webdavHandler.ServeHTTP(w, r)
 	})
 
-	if requireAuth {
-		http.HandleFunc("/webdav/", authMiddleware(webdavHTTP))
-	} else {
-		http.HandleFunc("/webdav/", webdavHTTP)
-	}
+	http.HandleFunc("/webdav/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(usageMiddleware(authMiddleware(davEventsMiddleware("/webdav", davPermissionMiddleware("/webdav", davLockUserMiddleware(webdavHTTP)))))))))
 
-	// Setup handler with authentication and GZIP
-	setBaseDir(absPath)
-	handler := dirHandler(tmpl, *verbose)
-	if requireAuth {
-		handler = authMiddleware(handler)
+	// Read-only virtual views (Recent, tags, name search) over WebDAV
+	viewsHandler := &webdav.Handler{
+		Prefix:     "/_views",
+		FileSystem: viewFS{},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("WebDAV views: %s %s - %v", r.Method, r.URL.Path, err)
+			}
+		},
 	}
-	http.HandleFunc("/", gzipMiddleware(handler))
-
-	// Change directory API
-	http.HandleFunc("/_api/chdir", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		var req struct {
-			Dir string `json:"dir"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, `{"success":false,"error":"Invalid request"}`)
-			return
-		}
-		newPath, err := filepath.Abs(req.Dir)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, `{"success":false,"error":"Invalid path"}`)
+	viewsHTTP := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "PROPFIND":
+		default:
+			http.Error(w, "Views are read-only", http.StatusForbidden)
 			return
 		}
-		info, err := os.Stat(newPath)
-		if err != nil || !info.IsDir() {
-			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, `{"success":false,"error":"Directory does not exist"}`)
+		// Views span the whole server
+		if _, ok := homeOf(r); ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-		setBaseDir(newPath)
-		webdavHandler.FileSystem = webdav.Dir(newPath)
-		fmt.Printf("📂 Changed directory: %s\n", newPath)
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"success":true,"dir":"%s"}`, strings.ReplaceAll(newPath, `\`, `\\`))
+		w.Header().Set("Cache-Control", "no-cache")
+		viewsHandler.ServeHTTP(w, r)
 	})
+	http.HandleFunc("/_views/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(usageMiddleware(authMiddleware(viewsHTTP))))))
+
+	// Setup handler with authentication and GZIP
+	setBaseDir(absPath)
+	checkHomes()
+	handler := authMiddleware(dirHandler(tmpl))
+	http.HandleFunc("/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(usageMiddleware(gzipMiddleware(handler))))))
+
+	// Upload-only links (no login; holders can only add files to one folder)
+	http.HandleFunc("/_login", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleLogin))))
+	http.HandleFunc("/_logout", geoMiddleware(accessLogMiddleware(handleLogout)))
+	http.HandleFunc("/_oidc/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleOIDC))))
+	http.HandleFunc("/_up/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleUploadLink))))
+	http.HandleFunc("/_s/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleShortLink))))
+	http.HandleFunc("/_feed/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleFeed))))
+
+	// Anonymous drop box (no login; each uploader only sees their own folder)
+	if dropboxDir != "" {
+		if isUnderDir(absPath, dropboxRoot()) {
+			log.Fatalf("Invalid -dropbox %q: it would hide the whole served directory; use a folder below it or elsewhere", dropboxDir)
+		}
+		http.HandleFunc("/_drop/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(handleDropbox))))
+	}
+	if pasteDir != "" {
+		http.HandleFunc("/_paste", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(authMiddleware(handlePaste)))))
+		http.HandleFunc("/_paste/", geoMiddleware(accessLogMiddleware(rateLimitMiddleware(authMiddleware(handlePaste)))))
+	}
+
+	// Background jobs API
+	http.HandleFunc("/api/v1/jobs", apiHandler(handleJobs))
+	http.HandleFunc("/api/v1/jobs/", apiHandler(handleJobs))
+	http.HandleFunc("/api/v1/batch", apiHandler(handleBatch))
+	http.HandleFunc("/api/v1/usage", apiHandler(handleUsage))
+	http.HandleFunc("/api/v1/transfers", apiHandler(handleTransfers))
+	http.HandleFunc("/api/v1/changes", apiHandler(handleChanges))
+	http.HandleFunc("/api/v1/download-info", apiHandler(handleDownloadInfo))
+	http.HandleFunc("/api/v1/access-log", apiHandler(handleAccessLog))
+	http.HandleFunc("/api/v1/capabilities", apiHandler(handleCapabilities))
+	http.HandleFunc("/api/v1/search-status", apiHandler(handleSearchStatus))
+	http.HandleFunc("/api/v1/upload-links", apiHandler(handleUploadLinks))
+	http.HandleFunc("/api/v1/upload-links/", apiHandler(handleUploadLinks))
+	http.HandleFunc("/api/v1/short-links", apiHandler(handleShortLinks))
+	http.HandleFunc("/api/v1/short-links/", apiHandler(handleShortLinks))
+	http.HandleFunc("/api/v1/watches", apiHandler(handleWatches))
+	http.HandleFunc("/api/v1/watches/", apiHandler(handleWatches))
+	http.HandleFunc("/api/v1/admin/settings", apiHandler(handleAdminSettings))
+	http.HandleFunc("/api/v1/admin/audit", apiHandler(handleAdminAudit))
+	http.HandleFunc("/api/v1/admin/guests", apiHandler(handleGuests))
+	http.HandleFunc("/api/v1/admin/guests/", apiHandler(handleGuests))
+	http.HandleFunc("/api/v1/denials", apiHandler(handleDenials))
+	http.HandleFunc("/api/v1/space", apiHandler(handleSpace))
+	http.HandleFunc("/api/v1/quota", apiHandler(handleQuota))
+	http.HandleFunc("/api/v1/volumes", apiHandler(handleVolumes))
+	http.HandleFunc("/api/v1/openapi.json", handleOpenAPI)
+
+	// Embedded UI assets (CSS and JavaScript)
+	http.HandleFunc("/_static/", handleStatic)
+
+	// Prometheus metrics, server info and update check
+	http.HandleFunc("/_metrics", authMiddleware(handleMetrics))
+	http.HandleFunc("/_info", authMiddleware(handleInfo))
+	http.HandleFunc("/_update", authMiddleware(handleUpdateCheck))
+
+	// Profiling, only with -debug
+	if debugEnabled {
+		http.HandleFunc("/debug/", authMiddleware(handleDebug))
+	}
+
+	// Change directory API, only with -allow-chdir
+	if allowChdir {
+		http.HandleFunc("/_api/chdir", authMiddleware(handleChdir))
+	}
 
 	// Create listeners
 	var listeners []net.Listener
-	for _, addr := range listenAddrs {
+	for _, cfg := range listenConfigs {
+		addr := cfg.Addr
 		ln, err := net.Listen("tcp", addr)
 		if err != nil {
 			for _, l := range listeners {
@@ -2673,50 +2898,154 @@ func main() {
 		listeners = append(listeners, ln)
 	}
 
+	serverInfo.started = time.Now()
+	serverInfo.permLevel = *permLevel
+	if blobCache != nil {
+		serverInfo.cacheSize = *cacheSize * 1024 * 1024
+	}
+	for i, ln := range listeners {
+		serverInfo.listeners = append(serverInfo.listeners, ln.Addr().String()+" ("+listenConfigs[i].String()+")")
+	}
+
 	// Display startup info
 	fmt.Printf("\nGoServe %s\n", version)
-	fmt.Printf("📂 Serving: %s\n", absPath)
-	fmt.Printf("⏰ Started: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	if proxyOf != nil {
+		fmt.Printf("📂 Proxy for: %s\n", proxyOf.Redacted())
+	} else {
+		fmt.Printf("📂 Serving: %s\n", absPath)
+	}
+	fmt.Printf("⏰ Started: %s\n", serverInfo.started.Format("2006-01-02 15:04:05"))
 
 	fmt.Printf("\n⚙️  Permissions: %s\n", *permLevel)
-	if requireAuth {
+	if users != nil {
 		fmt.Printf("   Auth: %d users\n", len(users))
 	}
 	if allowUpload {
 		fmt.Printf("   Max upload size: %dMB\n", *maxSize)
 	}
+	if spoolDir != "" {
+		fmt.Printf("   Archive spool: %s\n", spoolDir)
+	}
+	if *accessLogFile != "" && *accessLogFile != "-" {
+		fmt.Printf("   Access log: %s (%s)\n", *accessLogFile, *accessLogFormat)
+	}
+	if stateDir != "" {
+		fmt.Printf("   State: %s\n", stateDir)
+	}
+	if debugEnabled {
+		fmt.Printf("   Profiling: /debug/pprof/ (administrators only)\n")
+	}
+	if overridden := overriddenSettings(settingsDoc.Settings); len(overridden) > 0 {
+		fmt.Printf("   Runtime settings override: %s\n", strings.Join(overridden, ", "))
+	}
+	if stateRedis != nil {
+		fmt.Printf("   Shared state: Redis at %s\n", stateRedis.addr)
+	} else if stateShared {
+		fmt.Printf("   Shared state: %s\n", stateDir)
+	}
+	if len(rules) > 0 {
+		fmt.Printf("   Rules: %d from %s\n", len(rules), *rulesFile)
+	}
+	if monthlyCap > 0 {
+		fmt.Printf("   Monthly transfer cap: %gGB per user\n", *capGB)
+	}
+	if serverQuota > 0 {
+		fmt.Printf("   Storage quota: %gGB\n", *quotaGB)
+	}
+	if userQuota > 0 {
+		fmt.Printf("   Storage quota per user: %gGB\n", *userQuotaGB)
+	}
+	if blobCache != nil {
+		n, size := blobCache.Usage()
+		fmt.Printf("   Cache: %s (%d objects, %s of %dMB)\n", *cacheDir, n, formatSize(size), *cacheSize)
+	}
+	if dedupDir != "" {
+		fmt.Printf("   Dedup store: %s\n", dedupDir)
+	}
+	if dropboxDir != "" {
+		fmt.Printf("   Drop box: /_drop/ -> %s\n", dropboxRoot())
+	}
+	if pasteDir != "" {
+		fmt.Printf("   Paste: /_paste -> %s\n", pasteRoot())
+	}
+	if geoDB != nil {
+		fmt.Printf("   GeoIP: %s", *geoipFile)
+		if geoAllowList != "" {
+			fmt.Printf(" (allow %s)", geoAllowList)
+		}
+		if geoDenyList != "" {
+			fmt.Printf(" (deny %s)", geoDenyList)
+		}
+		fmt.Println()
+	}
+	if *rate > 0 || *heavyRate > 0 {
+		fmt.Printf("   Rate limits: %g req/s, %g archive+search req/min per client\n", *rate, *heavyRate)
+	}
 
 	fmt.Println("\n🌐 Listeners:")
-	var wildcardPorts []string
-	for _, ln := range listeners {
+	var wildcards []int
+	for i, ln := range listeners {
 		host, port, _ := net.SplitHostPort(ln.Addr().String())
+		scheme := "http"
+		if listenConfigs[i].TLS {
+			scheme = "https"
+		}
 		if host == "::" || host == "0.0.0.0" || host == "" {
-			fmt.Printf("   • http://localhost:%s\n", port)
-			wildcardPorts = append(wildcardPorts, port)
+			fmt.Printf("   • %s://localhost:%s (%s)\n", scheme, port, listenConfigs[i])
+			wildcards = append(wildcards, i)
 		} else {
-			fmt.Printf("   • http://%s:%s\n", host, port)
+			fmt.Printf("   • %s://%s:%s (%s)\n", scheme, host, port, listenConfigs[i])
 		}
 	}
-	if len(wildcardPorts) > 0 {
-		ifaces, err := net.InterfaceAddrs()
-		if err == nil {
-			for _, a := range ifaces {
-				if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
-					for _, port := range wildcardPorts {
-						fmt.Printf("   • http://%s:%s (LAN)\n", ipnet.IP.String(), port)
+	if len(wildcards) > 0 && advertiseHost == "" {
+		tailscale, lan := interfaceIPs()
+		for _, ips := range []struct {
+			label string
+			ips   []net.IP
+		}{{"Tailscale", tailscale}, {"LAN", lan}} {
+			for _, ip := range ips.ips {
+				for _, i := range wildcards {
+					_, port, _ := net.SplitHostPort(listeners[i].Addr().String())
+					scheme := "http"
+					if listenConfigs[i].TLS {
+						scheme = "https"
 					}
+					fmt.Printf("   • %s://%s:%s (%s)\n", scheme, ip.String(), port, ips.label)
 				}
 			}
 		}
 	}
+	if advertiseHost != "" {
+		for i, ln := range listeners {
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			scheme := "http"
+			if listenConfigs[i].TLS {
+				scheme = "https"
+			}
+			fmt.Printf("   • %s://%s (advertised)\n", scheme, joinHostPort(advertiseHost, port))
+		}
+	}
+
+	if selfSignedFingerprint != "" {
+		fmt.Println("\n🔒 Self-signed certificate, SHA-256 fingerprint:")
+		fmt.Printf("   %s\n", selfSignedFingerprint)
+	}
 
 	fmt.Println("\n📁 WebDAV:")
-	for _, ln := range listeners {
+	for i, ln := range listeners {
 		host, port, _ := net.SplitHostPort(ln.Addr().String())
-		if host == "::" || host == "0.0.0.0" || host == "" {
-			fmt.Printf("   • http://localhost:%s/webdav/\n", port)
+		scheme := "http"
+		if listenConfigs[i].TLS {
+			scheme = "https"
+		}
+		fmt.Printf("   • %s://%s/webdav/\n", scheme, joinHostPort(preferredHost(host), port))
+	}
+
+	if *mdns {
+		if err := startMDNS(*mdnsName, listeners, listenConfigs); err != nil {
+			log.Printf("Cannot advertise with mDNS: %v", err)
 		} else {
-			fmt.Printf("   • http://%s:%s/webdav/\n", host, port)
+			fmt.Println("📣 Advertised on the LAN with mDNS (Bonjour)")
 		}
 	}
 
@@ -2725,10 +3054,23 @@ func main() {
 
 	// Start server on all listeners
 	errc := make(chan error, 1)
-	for _, ln := range listeners {
-		go func(l net.Listener) {
-			errc <- http.Serve(l, nil)
-		}(ln)
+	for i, ln := range listeners {
+		go func(l net.Listener, cfg *listenerConfig) {
+			serve := http.DefaultServeMux.ServeHTTP
+			if proxyOf != nil {
+				serve = geoMiddleware(accessLogMiddleware(rateLimitMiddleware(newProxyHandler())))
+			}
+			handler := withListener(cfg, maintenanceMiddleware(limitMiddleware(qosMiddleware(serve))))
+			if *maxConns > 0 {
+				l = netutil.LimitListener(l, *maxConns)
+			}
+			if cfg.TLS {
+				srv := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+				errc <- srv.ServeTLS(l, "", "")
+			} else {
+				errc <- http.Serve(l, acmeHTTPHandler(handler))
+			}
+		}(ln, listenConfigs[i])
 	}
 	log.Fatal(<-errc)
 }
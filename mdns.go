@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// Zeroconf advertisement. With -mdns the server answers multicast DNS
+// (Bonjour) queries on the LAN for _http._tcp and _webdav._tcp (or
+// _https._tcp and _webdavs._tcp on TLS listeners), so it shows up in
+// Finder's Network sidebar, in Windows Explorer and in phone apps that
+// browse for servers, without anyone typing an address. Each listener that
+// isn't bound to loopback is advertised once per service under the
+// -mdns-name instance name, pointing at <hostname>.local and the
+// listener's addresses. The server announces itself at start and answers
+// queries after that; it doesn't probe for name conflicts, so give each
+// instance on a LAN its own -mdns-name.
+
+const mdnsTTL = 120 // seconds
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type mdnsService struct {
+	service  string // e.g. _http._tcp.local.
+	instance string // e.g. GoServe on nas._http._tcp.local.
+	port     uint16
+	txt      []string
+}
+
+type mdnsResponder struct {
+	host     string // e.g. nas.local.
+	ips      []net.IP
+	services []mdnsService
+	conn     *ipv4.PacketConn
+}
+
+// startMDNS advertises the listeners under the instance name name.
+func startMDNS(name string, listeners []net.Listener, configs []*listenerConfig) error {
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+	if hostname == "" {
+		hostname = "goserve"
+	}
+	if name == "" {
+		name = "GoServe on " + hostname
+	}
+	// Dots would split the instance label, which DNS caps at 63 bytes
+	name = strings.ReplaceAll(name, ".", "-")
+	for len(name) > 50 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	m := &mdnsResponder{host: mdnsLabel(hostname) + ".local."}
+
+	seenIP, seenService := map[string]bool{}, map[string]bool{}
+	addIP := func(ip net.IP) {
+		if ip4 := ip.To4(); ip4 != nil && !seenIP[ip4.String()] {
+			seenIP[ip4.String()] = true
+			m.ips = append(m.ips, ip4)
+		}
+	}
+	for i, ln := range listeners {
+		addr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok || addr.IP.IsLoopback() {
+			continue
+		}
+		if addr.IP.IsUnspecified() {
+			tailscale, lan := interfaceIPs()
+			for _, ip := range append(lan, tailscale...) {
+				addIP(ip)
+			}
+		} else {
+			addIP(addr.IP)
+		}
+		web, dav := "_http._tcp.local.", "_webdav._tcp.local."
+		if configs[i].TLS {
+			web, dav = "_https._tcp.local.", "_webdavs._tcp.local."
+		}
+		kinds := []struct{ service, path string }{{web, "/"}}
+		if proxyOf == nil {
+			kinds = append(kinds, struct{ service, path string }{dav, "/webdav/"})
+		}
+		for _, k := range kinds {
+			instance := name
+			if seenService[k.service] {
+				// A second listener of the same kind needs a name of its own
+				instance = fmt.Sprintf("%s (%d)", name, addr.Port)
+			}
+			seenService[k.service] = true
+			m.services = append(m.services, mdnsService{
+				service:  k.service,
+				instance: instance + "." + k.service,
+				port:     uint16(addr.Port),
+				txt:      []string{"path=" + k.path},
+			})
+		}
+	}
+	if len(m.services) == 0 || len(m.ips) == 0 {
+		return fmt.Errorf("no listener is reachable from the LAN")
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	m.conn = ipv4.NewPacketConn(conn)
+	m.conn.SetControlMessage(ipv4.FlagInterface, true)
+	m.conn.SetMulticastTTL(255)
+	ifaces := mdnsInterfaces()
+	for i := range ifaces {
+		m.conn.JoinGroup(&ifaces[i], mdnsGroup)
+	}
+
+	go m.serve()
+	go func() {
+		// Announce twice, as RFC 6762 asks, in case the first is lost
+		for range 2 {
+			if msg, err := m.announcement(); err == nil {
+				for _, ifi := range ifaces {
+					m.conn.WriteTo(msg, &ipv4.ControlMessage{IfIndex: ifi.Index}, mdnsGroup)
+				}
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	return nil
+}
+
+// mdnsInterfaces returns the interfaces that can carry multicast.
+func mdnsInterfaces() []net.Interface {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var ifaces []net.Interface
+	for _, ifi := range all {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0 {
+			ifaces = append(ifaces, ifi)
+		}
+	}
+	return ifaces
+}
+
+// mdnsLabel makes a host name usable as a DNS label.
+func mdnsLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, s)
+	return s[:min(len(s), 63)]
+}
+
+// serve answers queries until the connection fails.
+func (m *mdnsResponder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, cm, src, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("mDNS: %v", err)
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.Response {
+			continue
+		}
+		questions, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		var answers, extra []dnsmessage.Resource
+		unicast := false
+		for _, q := range questions {
+			a, x := m.records(strings.ToLower(q.Name.String()), q.Type)
+			answers, extra = append(answers, a...), append(extra, x...)
+			unicast = unicast || q.Class&0x8000 != 0
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		reply := dnsmessage.Message{
+			Header:      dnsmessage.Header{Response: true, Authoritative: true},
+			Answers:     answers,
+			Additionals: extra,
+		}
+		dst := net.Addr(mdnsGroup)
+		if udp, ok := src.(*net.UDPAddr); ok && udp.Port != mdnsGroup.Port {
+			// A one-shot query from an ordinary resolver wants a plain
+			// DNS answer back where it came from
+			reply.Header.ID, reply.Questions, dst = h.ID, questions, src
+		} else if unicast {
+			dst = src
+		}
+		msg, err := reply.Pack()
+		if err != nil {
+			continue
+		}
+		var out *ipv4.ControlMessage
+		if cm != nil {
+			out = &ipv4.ControlMessage{IfIndex: cm.IfIndex}
+		}
+		m.conn.WriteTo(msg, out, dst)
+	}
+}
+
+// records returns the answers to a question for name and type t, with the
+// records a browser will want next as extras.
+func (m *mdnsResponder) records(name string, t dnsmessage.Type) (answers, extra []dnsmessage.Resource) {
+	want := func(rt dnsmessage.Type) bool { return t == rt || t == dnsmessage.TypeALL }
+	if name == "_services._dns-sd._udp.local." && want(dnsmessage.TypePTR) {
+		listed := map[string]bool{}
+		for _, s := range m.services {
+			if !listed[s.service] {
+				listed[s.service] = true
+				answers = append(answers, m.ptr(name, s.service))
+			}
+		}
+		return answers, nil
+	}
+	for _, s := range m.services {
+		switch name {
+		case s.service:
+			if want(dnsmessage.TypePTR) {
+				answers = append(answers, m.ptr(name, s.instance))
+				extra = append(extra, m.srv(s), m.txt(s))
+			}
+		case strings.ToLower(s.instance):
+			if want(dnsmessage.TypeSRV) {
+				answers = append(answers, m.srv(s))
+			}
+			if want(dnsmessage.TypeTXT) {
+				answers = append(answers, m.txt(s))
+			}
+		}
+	}
+	if name == strings.ToLower(m.host) && want(dnsmessage.TypeA) {
+		return append(answers, m.addrs()...), extra
+	}
+	if len(extra) > 0 || len(answers) > 0 {
+		extra = append(extra, m.addrs()...)
+	}
+	return answers, extra
+}
+
+// announcement is every record, sent unasked at start.
+func (m *mdnsResponder) announcement() ([]byte, error) {
+	var answers []dnsmessage.Resource
+	for _, s := range m.services {
+		answers = append(answers, m.ptr(s.service, s.instance), m.srv(s), m.txt(s))
+	}
+	answers = append(answers, m.addrs()...)
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: answers,
+	}
+	return msg.Pack()
+}
+
+// header describes a record; unique records (all but PTR) set the
+// cache-flush bit so stale copies elsewhere are replaced.
+func mdnsHeader(name string, unique bool) dnsmessage.ResourceHeader {
+	class := dnsmessage.ClassINET
+	if unique {
+		class |= 0x8000
+	}
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: class, TTL: mdnsTTL}
+}
+
+func (m *mdnsResponder) ptr(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mdnsHeader(name, false),
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)},
+	}
+}
+
+func (m *mdnsResponder) srv(s mdnsService) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mdnsHeader(s.instance, true),
+		Body:   &dnsmessage.SRVResource{Port: s.port, Target: dnsmessage.MustNewName(m.host)},
+	}
+}
+
+func (m *mdnsResponder) txt(s mdnsService) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mdnsHeader(s.instance, true),
+		Body:   &dnsmessage.TXTResource{TXT: s.txt},
+	}
+}
+
+func (m *mdnsResponder) addrs() []dnsmessage.Resource {
+	var rs []dnsmessage.Resource
+	for _, ip := range m.ips {
+		var a [4]byte
+		copy(a[:], ip.To4())
+		rs = append(rs, dnsmessage.Resource{Header: mdnsHeader(m.host, true), Body: &dnsmessage.AResource{A: a}})
+	}
+	return rs
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subtitle and audio-track support for the video player. Sidecar .srt/.vtt
+// files next to a video are offered as subtitle tracks (SRT converted to
+// WebVTT on the fly). When ffprobe/ffmpeg are installed, embedded subtitle
+// streams are extracted as WebVTT and alternate audio tracks are remuxed
+// into a stream the browser can play.
+
+type mediaTrack struct {
+	Label string `json:"label"`
+	Lang  string `json:"lang,omitempty"`
+	Src   string `json:"src,omitempty"`
+	Index int    `json:"index"`
+	Codec string `json:"codec,omitempty"`
+}
+
+type probeResult struct {
+	modTime   time.Time
+	subtitles []mediaTrack
+	audio     []mediaTrack
+}
+
+var (
+	probeCache   = map[string]probeResult{}
+	probeCacheMu sync.Mutex
+)
+
+var subtitleExts = map[string]bool{".srt": true, ".vtt": true}
+
+// sidecarSubtitles finds subtitle files named after the video, e.g.
+// movie.srt, movie.en.srt or movie.English.vtt for movie.mkv.
+func sidecarSubtitles(fullPath, urlPath string) []mediaTrack {
+	base := strings.TrimSuffix(filepath.Base(fullPath), filepath.Ext(fullPath))
+	entries, err := os.ReadDir(filepath.Dir(fullPath))
+	if err != nil {
+		return nil
+	}
+	var tracks []mediaTrack
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if e.IsDir() || !subtitleExts[ext] || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		label := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(name, base), filepath.Ext(name)), ".")
+		lang := ""
+		if len(label) == 2 || len(label) == 3 {
+			lang = strings.ToLower(label)
+		}
+		if label == "" {
+			label = "Default"
+		}
+		src := (&url.URL{Path: path.Join(path.Dir(urlPath), name)}).String() + "?vtt=1"
+		tracks = append(tracks, mediaTrack{Label: label, Lang: lang, Src: src, Index: -1})
+	}
+	return tracks
+}
+
+// probeTracks lists embedded subtitle and audio streams with ffprobe,
+// caching the result per file version. Without ffprobe it returns nothing.
+func probeTracks(ctx context.Context, fullPath string, info os.FileInfo) ([]mediaTrack, []mediaTrack) {
+	probeCacheMu.Lock()
+	cached, ok := probeCache[fullPath]
+	probeCacheMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.subtitles, cached.audio
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", fullPath).Output()
+	if err != nil {
+		return nil, nil
+	}
+	var probe struct {
+		Streams []struct {
+			CodecType string            `json:"codec_type"`
+			CodecName string            `json:"codec_name"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if json.Unmarshal(out, &probe) != nil {
+		return nil, nil
+	}
+
+	var subs, audio []mediaTrack
+	for _, s := range probe.Streams {
+		t := mediaTrack{Lang: s.Tags["language"], Codec: s.CodecName, Label: s.Tags["title"]}
+		switch s.CodecType {
+		case "subtitle":
+			// Bitmap subtitles (PGS, VobSub) can't be converted to text
+			if s.CodecName == "hdmv_pgs_subtitle" || s.CodecName == "dvd_subtitle" || s.CodecName == "dvb_subtitle" {
+				continue
+			}
+			t.Index = len(subs)
+			subs = append(subs, t)
+		case "audio":
+			t.Index = len(audio)
+			audio = append(audio, t)
+		}
+	}
+	for i := range subs {
+		if subs[i].Label == "" {
+			subs[i].Label = trackLabel("Subtitle", subs[i])
+		}
+	}
+	for i := range audio {
+		if audio[i].Label == "" {
+			audio[i].Label = trackLabel("Audio", audio[i])
+		}
+	}
+
+	probeCacheMu.Lock()
+	probeCache[fullPath] = probeResult{modTime: info.ModTime(), subtitles: subs, audio: audio}
+	probeCacheMu.Unlock()
+	return subs, audio
+}
+
+func trackLabel(kind string, t mediaTrack) string {
+	label := fmt.Sprintf("%s %d", kind, t.Index+1)
+	if t.Lang != "" && t.Lang != "und" {
+		label += " (" + t.Lang + ")"
+	}
+	return label
+}
+
+// handleTracks serves ?tracks=1 for a video: its subtitle tracks (sidecar
+// and embedded) and audio tracks as JSON.
+func handleTracks(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo) {
+	subs := sidecarSubtitles(fullPath, r.URL.Path)
+	embedded, audio := probeTracks(r.Context(), fullPath, info)
+	self := (&url.URL{Path: r.URL.Path}).String()
+	for _, t := range embedded {
+		t.Src = self + "?subtitle=" + strconv.Itoa(t.Index)
+		subs = append(subs, t)
+	}
+	if subs == nil {
+		subs = []mediaTrack{}
+	}
+	if audio == nil {
+		audio = []mediaTrack{}
+	}
+	_, ffmpegErr := exec.LookPath("ffmpeg")
+	writeJSON(w, map[string]any{
+		"success":   true,
+		"subtitles": subs,
+		"audio":     audio,
+		"remux":     ffmpegErr == nil,
+	})
+}
+
+// handleSubtitleVTT serves ?vtt=1 on a sidecar subtitle file as WebVTT.
+func handleSubtitleVTT(w http.ResponseWriter, r *http.Request, fullPath string) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	if strings.ToLower(filepath.Ext(fullPath)) == ".vtt" {
+		io.Copy(w, f)
+		return
+	}
+	srtToVTT(f, w)
+}
+
+// srtToVTT converts SubRip to WebVTT: add the header and use '.' as the
+// millisecond separator in cue timings.
+func srtToVTT(in io.Reader, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	bw.WriteString("WEBVTT\n\n")
+	scanner := bufio.NewScanner(in)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		line = strings.TrimRight(line, "\r")
+		if strings.Contains(line, "-->") {
+			line = strings.ReplaceAll(line, ",", ".")
+		}
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// handleEmbeddedSubtitle serves ?subtitle=N: the Nth embedded subtitle
+// stream converted to WebVTT by ffmpeg.
+func handleEmbeddedSubtitle(w http.ResponseWriter, r *http.Request, fullPath string) {
+	n, err := strconv.Atoi(r.URL.Query().Get("subtitle"))
+	if err != nil || n < 0 {
+		http.Error(w, "Invalid subtitle index", http.StatusBadRequest)
+		return
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", "-v", "error", "-i", fullPath,
+		"-map", fmt.Sprintf("0:s:%d", n), "-f", "webvtt", "-")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		http.Error(w, "Cannot extract subtitles: "+strings.TrimSpace(err.Error()+" "+stderr.String()), http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Write(out)
+}
+
+// handleAudioRemux serves ?audio=N: the video with only the Nth audio track,
+// remuxed into fragmented MP4 (audio transcoded to AAC) and streamed.
+// Optional &t=seconds starts part-way through. The stream has no Range
+// support, so the player seeks by re-requesting with a new t.
+func handleAudioRemux(w http.ResponseWriter, r *http.Request, fullPath string) {
+	n, err := strconv.Atoi(r.URL.Query().Get("audio"))
+	if err != nil || n < 0 {
+		http.Error(w, "Invalid audio track", http.StatusBadRequest)
+		return
+	}
+	args := []string{"-v", "error"}
+	if t, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64); err == nil && t > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(t, 'f', 3, 64))
+	}
+	args = append(args, "-i", fullPath,
+		"-map", "0:v:0", "-map", fmt.Sprintf("0:a:%d", n),
+		"-c:v", "copy", "-c:a", "aac", "-b:a", "192k",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4", "-")
+
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", args...)
+	cmd.Stdout = w
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "Cannot remux: "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	cmd.Wait()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Folder merges. Before a folder upload, or a drop that holds folders, the
+// page posts the list of files it is about to send to ?merge=1 on the
+// target folder and gets back what each one would do there: be added,
+// overwrite an existing file (with both sizes and times to compare), leave
+// an identical file alone, or be refused. When anything already exists the
+// page shows the plan, with the overwrites of older files ticked, and
+// uploads only the files left ticked. Files count as identical when their
+// sizes match and their times are within mergeTimeSlack, which covers
+// filesystems that store times to the second or two.
+
+const mergeTimeSlack = 2 * time.Second
+
+type mergeFile struct {
+	Path  string `json:"path"`  // relative to the target folder, as it will be uploaded
+	Size  int64  `json:"size"`  // bytes
+	MTime int64  `json:"mtime"` // milliseconds since the epoch, 0 if unknown
+}
+
+type mergeEntry struct {
+	Path     string     `json:"path"`
+	Action   string     `json:"action"` // add, overwrite, same or refused
+	Size     int64      `json:"size"`
+	Modified *time.Time `json:"modified,omitempty"`
+	// The file already there, for overwrite and same
+	ExistingSize     int64      `json:"existingSize,omitempty"`
+	ExistingModified *time.Time `json:"existingModified,omitempty"`
+	Newer            bool       `json:"newer,omitempty"` // the upload is newer than the file it replaces
+	Reason           string     `json:"reason,omitempty"`
+}
+
+// planMerge works out what uploading each of files into targetDir would do
+// for r, checking each path as handleUpload will.
+func planMerge(r *http.Request, targetDir string, files []mergeFile) []mergeEntry {
+	entries := make([]mergeEntry, 0, len(files))
+	for _, f := range files {
+		e := mergeEntry{Path: f.Path, Size: f.Size}
+		if f.MTime > 0 {
+			mt := time.UnixMilli(f.MTime)
+			e.Modified = &mt
+		}
+		relativePath, err := cleanUploadPath(f.Path)
+		if err != nil {
+			e.Action, e.Reason = "refused", err.Error()
+			entries = append(entries, e)
+			continue
+		}
+		destPath := filepath.Join(targetDir, relativePath)
+		if !reachable(destPath, baseDirFor(r)) {
+			e.Action, e.Reason = "refused", "invalid path"
+			entries = append(entries, e)
+			continue
+		}
+		if !capabilitiesFor(r, filepath.Dir(destPath)).Upload {
+			e.Action, e.Reason = "refused", explainDenial(r, filepath.Dir(destPath), "upload").Message
+			entries = append(entries, e)
+			continue
+		}
+		if isSettingsFile(filepath.Base(destPath)) && !capabilitiesFor(r, destPath).Upload {
+			e.Action, e.Reason = "refused", explainDenial(r, destPath, "upload").Message
+			entries = append(entries, e)
+			continue
+		}
+		policy := uploadPolicyFor(destPath)
+		if err := policy.check(relativePath, f.Size, policy.limit()); err != nil {
+			e.Action, e.Reason = "refused", err.Error()
+			entries = append(entries, e)
+			continue
+		}
+		info, err := os.Stat(destPath)
+		switch {
+		case os.IsNotExist(err):
+			e.Action = "add"
+		case err != nil:
+			e.Action, e.Reason = "refused", "a file is in the way of its folder"
+		case info.IsDir():
+			e.Action, e.Reason = "refused", "a folder of that name is in the way"
+		case writeOnceLocked(destPath):
+			e.Action, e.Reason = "refused", "it is in a write-once folder and can't be replaced"
+		default:
+			existing := info.ModTime()
+			e.ExistingSize, e.ExistingModified = info.Size(), &existing
+			if e.Modified != nil {
+				diff := e.Modified.Sub(existing)
+				if info.Size() == f.Size && diff.Abs() <= mergeTimeSlack {
+					e.Action = "same"
+					break
+				}
+				e.Newer = diff > mergeTimeSlack
+			}
+			e.Action = "overwrite"
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// handleMergePlan serves POST ?merge=1 on a folder, with {"files": [...]}.
+func handleMergePlan(w http.ResponseWriter, r *http.Request, targetDir string) {
+	var req struct {
+		Files []mergeFile `json:"files"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	entries := planMerge(r, targetDir, req.Files)
+	counts := map[string]int{"add": 0, "overwrite": 0, "same": 0, "refused": 0}
+	for _, e := range entries {
+		counts[e.Action]++
+	}
+	writeJSON(w, map[string]any{"success": true, "entries": entries, "counts": counts})
+}
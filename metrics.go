@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus-style counters, exported at /_metrics.
+
+var (
+	metricsMu    sync.Mutex
+	metricValues = map[string]float64{} // full series name (with labels) -> value
+	metricHelp   = map[string]string{}
+)
+
+// describeMetric registers the HELP text for a counter family.
+func describeMetric(name, help string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricHelp[name] = help
+}
+
+// addMetric adds v to the counter name with the given label pairs
+// (e.g. addMetric("goserve_requests_total", 1, "class", "heavy")).
+func addMetric(name string, v float64, labels ...string) {
+	series := name
+	if len(labels) > 0 {
+		var parts []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		series += "{" + strings.Join(parts, ",") + "}"
+	}
+	metricsMu.Lock()
+	metricValues[series] += v
+	metricsMu.Unlock()
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	series := make([]string, 0, len(metricValues))
+	for s := range metricValues {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	lastFamily := ""
+	for _, s := range series {
+		family, _, _ := strings.Cut(s, "{")
+		if family != lastFamily {
+			if help, ok := metricHelp[family]; ok {
+				fmt.Fprintf(w, "# HELP %s %s\n", family, help)
+			}
+			fmt.Fprintf(w, "# TYPE %s counter\n", family)
+			lastFamily = family
+		}
+		fmt.Fprintf(w, "%s %g\n", s, metricValues[s])
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Single sign-on. With -oidc-issuer, browsers sign in through an OpenID
+// Connect provider (Okta, Entra ID, Google, Keycloak, Authentik, ...) with
+// the authorization code flow and PKCE, and get the same session cookie as
+// the login page. The ID token comes straight from the provider's token
+// endpoint over TLS, which OpenID Connect accepts in place of checking its
+// signature; its issuer, audience, expiry and nonce are still checked.
+//
+// -oidc-roles maps the signed-in user to a permission level:
+//
+//	admins=all,staff=readwrite,*=readonly
+//
+// Each name is a value of the groups claim (-oidc-groups-claim), or
+// claim:value for any other claim (hd:example.com, email:bob@example.com);
+// "*" matches everyone. A user gets the highest level that matches and is
+// turned away if nothing does. WebDAV clients can't follow a sign-in page,
+// so signed-in users create an app password in the settings menu and use it
+// with their username.
+
+type oidcProvider struct {
+	Issuer           string `json:"issuer"`
+	AuthEndpoint     string `json:"authorization_endpoint"`
+	TokenEndpoint    string `json:"token_endpoint"`
+	UserinfoEndpoint string `json:"userinfo_endpoint"`
+
+	clientID     string
+	clientSecret string
+	redirectURL  string // empty: this server's /_oidc/callback as reached
+	scopes       string
+	groupsClaim  string
+	roles        []oidcRole
+}
+
+type oidcRole struct {
+	claim, value, permission string
+}
+
+var oidc *oidcProvider
+
+var oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+const oidcCookie = "goserve_oidc"
+
+// oidcLogin is a sign-in in progress, from the redirect to the provider to
+// its callback. It travels in oidcCookie, signed with the session key, so
+// the callback works on any instance sharing state.
+type oidcLogin struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Next     string    `json:"next"`
+	Started  time.Time `json:"started"`
+}
+
+func (l oidcLogin) cookieValue() string {
+	data, _ := json.Marshal(l)
+	value := base64.RawURLEncoding.EncodeToString(data)
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return value + "." + signSessionID(value)
+}
+
+// oidcLoginFrom returns the sign-in r's cookie carries if its signature
+// holds.
+func oidcLoginFrom(r *http.Request) (oidcLogin, bool) {
+	var l oidcLogin
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return l, false
+	}
+	value, sig, _ := strings.Cut(c.Value, ".")
+	sessionsMu.Lock()
+	want := signSessionID(value)
+	sessionsMu.Unlock()
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(want)) != 1 {
+		return l, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	return l, err == nil && json.Unmarshal(data, &l) == nil
+}
+
+// setupOIDC discovers the provider at issuer and configures sign-in.
+func setupOIDC(issuer, clientID, clientSecret, redirectURL, scopes, groupsClaim, roles string) error {
+	if clientID == "" {
+		return fmt.Errorf("-oidc-issuer needs -oidc-client-id")
+	}
+	p := &oidcProvider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		groupsClaim:  groupsClaim,
+	}
+	for _, entry := range strings.Split(roles, ",") {
+		name, perm, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || (perm != "readonly" && perm != "readwrite" && perm != "all") {
+			return fmt.Errorf("-oidc-roles: %q is not name=readonly|readwrite|all", entry)
+		}
+		role := oidcRole{claim: groupsClaim, value: name, permission: perm}
+		if c, v, ok := strings.Cut(name, ":"); ok {
+			role.claim, role.value = c, v
+		}
+		p.roles = append(p.roles, role)
+	}
+
+	resp, err := oidcClient.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(p); err != nil {
+		return fmt.Errorf("discovery: %v", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return fmt.Errorf("discovery: provider calls itself %s", p.Issuer)
+	}
+	if p.AuthEndpoint == "" || p.TokenEndpoint == "" {
+		return fmt.Errorf("discovery: no authorization or token endpoint")
+	}
+	oidc = p
+	return nil
+}
+
+// callbackURL returns the redirect URI registered with the provider.
+func (p *oidcProvider) callbackURL(r *http.Request) string {
+	if p.redirectURL != "" {
+		return p.redirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/_oidc/callback"
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// handleOIDC serves /_oidc/login, /_oidc/callback and /_oidc/app-password.
+func handleOIDC(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil || !authRequired(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	switch r.URL.Path {
+	case "/_oidc/login":
+		oidcStart(w, r)
+	case "/_oidc/callback":
+		oidcCallback(w, r)
+	case "/_oidc/app-password":
+		handleAppPassword(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// oidcStart sends the browser to the provider's sign-in page.
+func oidcStart(w http.ResponseWriter, r *http.Request) {
+	login := oidcLogin{
+		State:    randomString(24),
+		Nonce:    randomString(24),
+		Verifier: randomString(48),
+		Next:     localRedirect(r.URL.Query().Get("next")),
+		Started:  time.Now(),
+	}
+
+	// Ties the callback to this browser, so nobody can sign a victim in to
+	// the attacker's account with a stolen callback URL
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    login.cookieValue(),
+		Path:     "/_oidc/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.clientID},
+		"redirect_uri":          {oidc.callbackURL(r)},
+		"scope":                 {oidc.scopes},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(oidc.AuthEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, oidc.AuthEndpoint+sep+q.Encode(), http.StatusSeeOther)
+}
+
+// oidcCallback finishes a sign-in: it exchanges the code for tokens, checks
+// the ID token, maps the user to a permission and starts a session.
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		oidcError(w, http.StatusUnauthorized, "The sign-in provider refused: "+e+" "+q.Get("error_description"))
+		return
+	}
+	login, ok := oidcLoginFrom(r)
+	if !ok || login.State != q.Get("state") {
+		oidcError(w, http.StatusBadRequest, "This sign-in was started in another browser or has expired.")
+		return
+	}
+	if time.Since(login.Started) > 10*time.Minute {
+		oidcError(w, http.StatusBadRequest, "This sign-in has expired.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/_oidc/", MaxAge: -1})
+
+	claims, err := oidc.exchange(r, q.Get("code"), login)
+	if err != nil {
+		log.Printf("Single sign-on: %v", err)
+		oidcError(w, http.StatusBadGateway, "Signing in failed. Try again, or ask the server's administrator.")
+		return
+	}
+	name := claimString(claims, "preferred_username")
+	if name == "" {
+		name = claimString(claims, "email")
+	}
+	if name == "" {
+		name = claimString(claims, "sub")
+	}
+	// Sessions carry only the name, so a provider's user mustn't be taken
+	// for an account of the same name here
+	if _, taken := findUser(name); taken {
+		log.Printf("Single sign-on: %s is already an account here; refused", name)
+		oidcError(w, http.StatusForbidden, "Your account name ("+name+") is already used by another account on this server. Ask the server's administrator.")
+		return
+	}
+	permission := oidc.permissionFor(claims)
+	if permission == "" {
+		log.Printf("Single sign-on: %s matches no -oidc-roles entry", name)
+		oidcError(w, http.StatusForbidden, "Your account ("+name+") doesn't have access to this server.")
+		return
+	}
+	startSession(w, r, name, permission)
+	http.Redirect(w, r, login.Next, http.StatusSeeOther)
+}
+
+// exchange redeems code at the token endpoint and returns the ID token's
+// claims, merged with the userinfo endpoint's where the token lacks them.
+func (p *oidcProvider) exchange(r *http.Request, code string, login oidcLogin) (map[string]any, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.callbackURL(r)},
+		"code_verifier": {login.Verifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID) // a public client
+	}
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s: %v", resp.Status, err)
+	}
+	if tok.Error != "" || tok.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: %s %s %s", resp.Status, tok.Error, tok.Description)
+	}
+
+	parts := strings.Split(tok.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token: %v", err)
+	}
+	claims := map[string]any{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token: %v", err)
+	}
+	if claimString(claims, "iss") != p.Issuer {
+		return nil, fmt.Errorf("ID token from %q, not %q", claimString(claims, "iss"), p.Issuer)
+	}
+	if !claimHas(claims, "aud", p.clientID) {
+		return nil, fmt.Errorf("ID token is not for client %q", p.clientID)
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if claimString(claims, "nonce") != login.Nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+
+	if p.UserinfoEndpoint != "" && tok.AccessToken != "" {
+		req, _ := http.NewRequest("GET", p.UserinfoEndpoint, nil)
+		req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+		if resp, err := oidcClient.Do(req); err == nil {
+			var info map[string]any
+			if resp.StatusCode == http.StatusOK && json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info) == nil &&
+				claimString(info, "sub") == claimString(claims, "sub") {
+				for k, v := range info {
+					if _, ok := claims[k]; !ok {
+						claims[k] = v
+					}
+				}
+			}
+			resp.Body.Close()
+		}
+	}
+	return claims, nil
+}
+
+// permissionFor returns the highest permission the roles grant for claims,
+// or "" if none matches.
+func (p *oidcProvider) permissionFor(claims map[string]any) string {
+	rank := map[string]int{"": 0, "readonly": 1, "readwrite": 2, "all": 3}
+	best := ""
+	for _, role := range p.roles {
+		if (role.value == "*" || claimHas(claims, role.claim, role.value)) && rank[role.permission] > rank[best] {
+			best = role.permission
+		}
+	}
+	return best
+}
+
+func claimString(claims map[string]any, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimHas reports whether the claim is value or a list containing it.
+func claimHas(claims map[string]any, name, value string) bool {
+	switch v := claims[name].(type) {
+	case string:
+		return v == value
+	case bool:
+		return fmt.Sprint(v) == value
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var oidcErrorTmpl = template.Must(template.New("oidcerror").Parse(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>GoServe - Sign In</title></head>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #eff1f5; color: #4c4f69; padding: 30px;">
+<p>{{.}}</p><p><a href="/_oidc/login" style="color: #1e66f5;">Sign in again</a></p>
+</body></html>`))
+
+func oidcError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	oidcErrorTmpl.Execute(w, msg)
+}
+
+// handleAppPassword creates a password a single sign-on user can give a
+// WebDAV client. It is a session of its own, so it lasts -session-expiry
+// and carries the permission the user has now.
+func handleAppPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := userFromSession(r)
+	if user == nil {
+		jsonError(w, http.StatusUnauthorized, "Sign in first")
+		return
+	}
+	if _, ok := findUser(user.Username); ok {
+		jsonError(w, http.StatusBadRequest, "Use your own password for WebDAV")
+		return
+	}
+	id, expires := newSession(user.Username, user.Permission, true)
+	writeJSON(w, map[string]any{"success": true, "username": user.Username, "password": id, "expires": expires})
+}
+
+// userFromAppPassword returns the single sign-on user whose app password
+// is password, or nil.
+func userFromAppPassword(username, password string) *User {
+	if oidc == nil {
+		return nil
+	}
+	user := sessionUserByID(password, true)
+	if user == nil || user.Username != username {
+		return nil
+	}
+	return user
+}
+
+// isSSOUser reports whether r is signed in through single sign-on, for
+// offering an app password.
+func isSSOUser(r *http.Request) bool {
+	if oidc == nil {
+		return false
+	}
+	user := userFromSession(r)
+	if user == nil {
+		return false
+	}
+	_, inLogins := findUser(user.Username)
+	return !inLogins
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI description of the /api/v1 endpoints. The Go client in ./client
+// is generated from the same file; regenerate it with `go generate
+// ./client` after changing the API.
+
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Organize by date. The "organize" job sorts the photos and videos directly
+// in a folder into Year/Month subfolders (2024/05/IMG_0001.jpg), the usual
+// chore after copying a camera card or phone onto the share. Each file is
+// dated by when it was taken: the EXIF DateTimeOriginal of JPEG and
+// TIFF-based RAW files, exiftool's reading of other formats if it is
+// installed, and the modification time otherwise. With "dryRun" the job
+// only works out the moves and lists them, so they can be checked before
+// anything is touched.
+
+var organizeExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".heif": true, ".tif": true, ".tiff": true,
+	".mp4": true, ".mov": true, ".m4v": true, ".3gp": true, ".avi": true, ".mts": true,
+}
+
+// At most this many planned moves are listed on a job.
+const maxJobMoves = 1000
+
+type jobMove struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Taken string `json:"taken"` // exif or modified
+}
+
+// organizeJob moves the media files in dir into Year/Month folders below
+// it, or with dryRun only records where they would go.
+func organizeJob(ctx context.Context, j *Job, dir string, dryRun bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.Type().IsRegular() && (organizeExts[ext] || rawExts[ext]) && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	j.setProgress(0, int64(len(files)), "Reading dates")
+
+	var moved int
+	var moves []jobMove
+	for _, src := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if writeOnceLocked(src) {
+			j.addFailure(urlFor(src), fmt.Errorf("in a write-once folder"))
+			j.addProgress(1, "")
+			continue
+		}
+		taken, source := takenTime(ctx, src)
+		destDir := filepath.Join(dir, taken.Format("2006"), taken.Format("01"))
+		dest := filepath.Join(destDir, filepath.Base(src))
+		if !dryRun {
+			if err := os.MkdirAll(destDir, 0755); err != nil {
+				j.addFailure(urlFor(src), err)
+				j.addProgress(1, "")
+				continue
+			}
+			dest = uniquePath(dest)
+			if err := os.Rename(src, dest); err != nil {
+				j.addFailure(urlFor(src), err)
+				j.addProgress(1, "")
+				continue
+			}
+			publishEvent(fileEvent{Type: "renamed", OldPath: urlFor(src), Path: urlFor(dest), User: j.Owner, Source: "job"})
+		}
+		moved++
+		if len(moves) < maxJobMoves {
+			moves = append(moves, jobMove{From: urlFor(src), To: urlFor(dest), Taken: source})
+		}
+		j.addProgress(1, filepath.Base(src))
+	}
+
+	jobsMu.Lock()
+	j.Moves = moves
+	jobsMu.Unlock()
+	if dryRun {
+		j.complete(fmt.Sprintf("%d files would move", moved), "")
+		return nil
+	}
+	j.complete(fmt.Sprintf("%d files moved", moved), j.Path)
+	return j.partialFailure("moved")
+}
+
+// takenTime returns when the photo or video at fullPath was taken and
+// where that came from: "exif" or "modified".
+func takenTime(ctx context.Context, fullPath string) (time.Time, string) {
+	if t, ok := exifDate(fullPath); ok {
+		return t, "exif"
+	}
+	if t, ok := exiftoolDate(ctx, fullPath); ok {
+		return t, "exif"
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return time.Now(), "modified"
+	}
+	return info.ModTime(), "modified"
+}
+
+// exifDate reads DateTimeOriginal (or failing that DateTimeDigitized or
+// DateTime) from a JPEG's Exif segment or a TIFF-based RAW file.
+func exifDate(fullPath string) (time.Time, bool) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var head [2]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return time.Time{}, false
+	}
+	switch string(head[:]) {
+	case "\xff\xd8":
+		seg := jpegExifSegment(bufio.NewReader(f))
+		if seg == nil {
+			return time.Time{}, false
+		}
+		return tiffDate(bytes.NewReader(seg))
+	case "II", "MM":
+		return tiffDate(f)
+	}
+	return time.Time{}, false
+}
+
+// jpegExifSegment returns the TIFF data of the Exif APP1 segment, reading
+// from just after the JPEG's start-of-image marker.
+func jpegExifSegment(r *bufio.Reader) []byte {
+	for {
+		b, err := r.ReadByte()
+		if err != nil || b != 0xff {
+			return nil
+		}
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xff {
+			marker, err = r.ReadByte()
+		}
+		if err != nil || marker == 0xda || marker == 0xd9 {
+			return nil // image data starts; no Exif before it
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil
+		}
+		if marker != 0xe1 {
+			if _, err := r.Discard(n); err != nil {
+				return nil
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil
+		}
+		if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			return data[6:]
+		}
+	}
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // the 4-byte value or offset field
+}
+
+// tiffDate finds the capture date in TIFF-structured data.
+func tiffDate(r io.ReaderAt) (time.Time, bool) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return time.Time{}, false
+	}
+	var bo binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+	ifd0 := readIFD(r, bo, bo.Uint32(hdr[4:]))
+	if ptr, ok := ifd0[0x8769]; ok {
+		exif := readIFD(r, bo, bo.Uint32(ptr.value))
+		for _, tag := range []uint16{0x9003, 0x9004} {
+			if t, ok := ifdDate(r, bo, exif[tag]); ok {
+				return t, true
+			}
+		}
+	}
+	return ifdDate(r, bo, ifd0[0x0132])
+}
+
+// readIFD reads the entries of the image file directory at off.
+func readIFD(r io.ReaderAt, bo binary.ByteOrder, off uint32) map[uint16]ifdEntry {
+	var n [2]byte
+	if off == 0 {
+		return nil
+	}
+	if _, err := r.ReadAt(n[:], int64(off)); err != nil {
+		return nil
+	}
+	count := int(bo.Uint16(n[:]))
+	if count > 1000 {
+		return nil
+	}
+	buf := make([]byte, 12*count)
+	if _, err := r.ReadAt(buf, int64(off)+2); err != nil {
+		return nil
+	}
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		e := buf[12*i:]
+		entries[bo.Uint16(e)] = ifdEntry{typ: bo.Uint16(e[2:]), count: bo.Uint32(e[4:]), value: e[8:12]}
+	}
+	return entries
+}
+
+// ifdDate parses an ASCII "2006:01:02 15:04:05" entry as local time.
+func ifdDate(r io.ReaderAt, bo binary.ByteOrder, e ifdEntry) (time.Time, bool) {
+	if e.typ != 2 || e.count < 19 || e.count > 64 {
+		return time.Time{}, false
+	}
+	buf := make([]byte, 19)
+	if _, err := r.ReadAt(buf, int64(bo.Uint32(e.value))); err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", string(buf), time.Local)
+	return t, err == nil && t.Year() > 1900
+}
+
+// exiftoolDate asks exiftool, if installed, for the capture date of
+// formats exifDate can't read, such as HEIC, CR3 and video.
+func exiftoolDate(ctx context.Context, fullPath string) (time.Time, bool) {
+	if _, err := exec.LookPath("exiftool"); err != nil {
+		return time.Time{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "exiftool", "-s3", "-d", "%Y:%m:%d %H:%M:%S",
+		"-DateTimeOriginal", "-CreateDate", fullPath).Output()
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if t, err := time.ParseInLocation("2006:01:02 15:04:05", strings.TrimSpace(line), time.Local); err == nil && t.Year() > 1900 {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes. The password field of a logins file may be a bcrypt
+// hash ($2a$, $2b$ or $2y$) or an argon2id hash in the usual PHC form
+// ($argon2id$v=19$m=65536,t=3,p=4$salt$hash); anything else is compared as
+// plaintext, and loadUsers warns about those entries. `goserve
+// hash-password` prints a bcrypt hash to paste into the file.
+//
+// Browsers send Basic credentials with every request, and checking a hash
+// deliberately takes tens of milliseconds, so successful checks are
+// remembered for passwordCacheTTL under a digest of the user, password and
+// hash; changing the hash in the file invalidates them.
+
+const (
+	passwordCacheTTL = 5 * time.Minute
+	passwordCacheMax = 1000
+)
+
+var (
+	passwordCache   = map[[32]byte]time.Time{}
+	passwordCacheMu sync.Mutex
+)
+
+// isHashedPassword reports whether a logins file password is a hash.
+func isHashedPassword(p string) bool {
+	return strings.HasPrefix(p, "$2a$") || strings.HasPrefix(p, "$2b$") ||
+		strings.HasPrefix(p, "$2y$") || strings.HasPrefix(p, "$argon2id$")
+}
+
+// checkPassword reports whether password is user's, in constant time.
+func checkPassword(user User, password string) bool {
+	if !isHashedPassword(user.Password) {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+	}
+
+	key := sha256.Sum256([]byte(user.Username + "\x00" + password + "\x00" + user.Password))
+	passwordCacheMu.Lock()
+	expires, ok := passwordCache[key]
+	passwordCacheMu.Unlock()
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+
+	var match bool
+	if strings.HasPrefix(user.Password, "$argon2id$") {
+		match = checkArgon2(user.Password, password)
+	} else {
+		match = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	}
+	if match {
+		passwordCacheMu.Lock()
+		if len(passwordCache) >= passwordCacheMax {
+			clear(passwordCache)
+		}
+		passwordCache[key] = time.Now().Add(passwordCacheTTL)
+		passwordCacheMu.Unlock()
+	}
+	return match
+}
+
+// checkArgon2 verifies password against an encoded argon2id hash.
+func checkArgon2(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return false
+	}
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, passes, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// runHashPasswordCommand handles "goserve hash-password": it reads a
+// password from standard input and prints its bcrypt hash.
+func runHashPasswordCommand(args []string) error {
+	fs := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	fs.Parse(args)
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
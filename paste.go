@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Paste mode (-paste DIR). Text POSTed to /_paste — from the form there or
+// with curl — is saved as a timestamped file under DIR and gets a short link
+// /_paste/<id>, which shows it with syntax highlighting (?raw=1 for plain
+// text). Pasting needs upload permission; anyone who can reach the server
+// can view a paste given its link.
+
+var pasteDir string
+
+// pasteLangs maps the language choices on the paste form to file
+// extensions; the extension picks the highlighting mode when viewed.
+var pasteLangs = []struct{ Name, Ext string }{
+	{"Plain text", "txt"},
+	{"Go", "go"},
+	{"JavaScript", "js"},
+	{"JSON", "json"},
+	{"Python", "py"},
+	{"Shell", "sh"},
+	{"HTML", "html"},
+	{"XML", "xml"},
+	{"CSS", "css"},
+	{"Markdown", "md"},
+	{"Log", "log"},
+}
+
+const pasteTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>GoServe - {{if .Name}}{{.Name}}{{else}}New Paste{{end}}</title>
+    <link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/codemirror.min.css">
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/codemirror.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/javascript/javascript.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/python/python.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/go/go.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/xml/xml.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/css/css.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/markdown/markdown.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.65.2/mode/shell/shell.min.js"></script>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #eff1f5; color: #4c4f69; margin: 0; padding: 30px; }
+        .box { max-width: 960px; margin: 0 auto; background: #fff; border: 1px solid #ccd0da; border-radius: 8px; padding: 24px; }
+        h1 { font-size: 20px; margin: 0 0 6px; }
+        h1 span { color: #1e66f5; }
+        p { color: #6c6f85; font-size: 14px; }
+        a { color: #1e66f5; text-decoration: none; }
+        .err { color: #d20f39; }
+        .btn { background: #1e66f5; color: #fff; border: none; border-radius: 4px; padding: 8px 16px; cursor: pointer; font-size: 14px; }
+        .bar { display: flex; gap: 12px; align-items: center; margin: 12px 0; font-size: 14px; }
+        textarea { width: 100%; box-sizing: border-box; height: 360px; font-family: Consolas, Monaco, monospace; font-size: 13px; padding: 8px; border: 1px solid #ccd0da; border-radius: 4px; }
+        select { padding: 6px; border: 1px solid #ccd0da; border-radius: 4px; }
+        code { background: #e6e9ef; padding: 2px 6px; border-radius: 3px; word-break: break-all; }
+        .CodeMirror { height: auto; border: 1px solid #ccd0da; border-radius: 4px; font-size: 13px; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        <h1>Go<span>Serve</span> Paste</h1>
+        {{if .Name}}
+        <p>{{.Name}} · {{.Size}} · {{.Created}}</p>
+        <div class="bar">
+            <code id="link">{{.Link}}</code>
+            <button class="btn" onclick="navigator.clipboard.writeText(document.getElementById('link').textContent)">Copy Link</button>
+            <a href="?raw=1">Raw</a>
+            <a href="/_paste">New paste</a>
+        </div>
+        <textarea id="text" readonly>{{.Text}}</textarea>
+        <script>
+            CodeMirror.fromTextArea(document.getElementById('text'), {
+                mode: {{.Mode}}, readOnly: true, lineNumbers: true, lineWrapping: true, viewportMargin: Infinity
+            });
+        </script>
+        {{else if .Denied}}
+        <p class="err">Pasting needs upload permission.</p>
+        {{else}}
+        <p>Paste text to get a short link to it. From a script: <code>curl --data-binary @file.txt {{.Link}}</code></p>
+        <form method="POST" action="/_paste?form=1">
+            <textarea name="text" required autofocus></textarea>
+            <div class="bar">
+                <select name="lang">
+                    {{range .Langs}}<option value="{{.Ext}}">{{.Name}}</option>{{end}}
+                </select>
+                <button class="btn" type="submit">Create Paste</button>
+            </div>
+        </form>
+        {{end}}
+    </div>
+</body>
+</html>`
+
+var pasteTmpl = template.Must(template.New("paste").Parse(pasteTemplate))
+
+// pasteRoot returns the directory pastes are stored in.
+func pasteRoot() string {
+	if filepath.IsAbs(pasteDir) {
+		return pasteDir
+	}
+	return filepath.Join(getBaseDir(), pasteDir)
+}
+
+// pasteExt returns the file extension for a language choice, defaulting
+// to plain text.
+func pasteExt(lang string) string {
+	lang = strings.ToLower(strings.TrimPrefix(lang, "."))
+	for _, l := range pasteLangs {
+		if l.Ext == lang || strings.EqualFold(l.Name, lang) {
+			return l.Ext
+		}
+	}
+	return "txt"
+}
+
+// findPaste returns the file holding paste id.
+func findPaste(id string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(pasteRoot(), "paste-*-"+id+".*"))
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+func pasteLink(r *http.Request, id string) string {
+	return advertisedOrigin(r) + "/_paste/" + id
+}
+
+// handlePaste serves /_paste (the form, and creating pastes) and
+// /_paste/<id> (viewing one).
+func handlePaste(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_paste"), "/")
+	if id != "" {
+		viewPaste(w, r, id)
+		return
+	}
+
+	canPaste := capabilitiesFor(r, "").Paste
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		pasteTmpl.Execute(w, map[string]any{
+			"Denied": !canPaste,
+			"Langs":  pasteLangs,
+			"Link":   strings.TrimSuffix(pasteLink(r, ""), "/"),
+		})
+	case "POST":
+		if !canPaste {
+			deny(w, r, "", "paste")
+			return
+		}
+		createPaste(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createPaste saves the posted text. The form on /_paste posts with
+// ?form=1 and is redirected to the new paste; anything else is taken as
+// the raw text (language from ?lang=) and answered with the link.
+func createPaste(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	form := r.URL.Query().Get("form") != ""
+	var text []byte
+	lang := r.URL.Query().Get("lang")
+	if form {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Paste too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		text = []byte(strings.ReplaceAll(r.PostForm.Get("text"), "\r\n", "\n"))
+		lang = r.PostForm.Get("lang")
+	} else {
+		var err error
+		if text, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, "Paste too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if len(strings.TrimSpace(string(text))) == 0 {
+		http.Error(w, "Nothing to paste", http.StatusBadRequest)
+		return
+	}
+
+	root := pasteRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		http.Error(w, "Cannot create paste folder", http.StatusInternalServerError)
+		return
+	}
+	id := newShortID(8)
+	name := fmt.Sprintf("paste-%s-%s.%s", time.Now().Format("20060102-150405"), id, pasteExt(lang))
+	dest := filepath.Join(root, name)
+	if err := os.WriteFile(dest, text, 0644); err != nil {
+		http.Error(w, "Cannot save paste", http.StatusInternalServerError)
+		return
+	}
+	emitFileEvent(r, "created", dest, "web")
+
+	if form {
+		http.Redirect(w, r, "/_paste/"+id, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, pasteLink(r, id))
+}
+
+func viewPaste(w http.ResponseWriter, r *http.Request, id string) {
+	if !validShortID(id, 8) {
+		http.NotFound(w, r)
+		return
+	}
+	file, ok := findPaste(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("raw") != "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeFile(w, r, file)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		http.Error(w, "Cannot read paste", http.StatusInternalServerError)
+		return
+	}
+	modes := map[string]string{
+		"go": "go", "js": "javascript", "json": "application/json", "py": "python",
+		"sh": "shell", "html": "xml", "xml": "xml", "css": "css", "md": "markdown",
+	}
+	mode := modes[strings.TrimPrefix(filepath.Ext(file), ".")]
+	if mode == "" {
+		mode = "text/plain"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pasteTmpl.Execute(w, map[string]any{
+		"Name":    filepath.Base(file),
+		"Size":    formatSize(info.Size()),
+		"Created": info.ModTime().Format("2006-01-02 15:04:05"),
+		"Link":    pasteLink(r, id),
+		"Text":    string(data),
+		"Mode":    mode,
+	})
+}
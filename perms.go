@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// File permissions, on Unix. With -show-perms listings gain Owner and Mode
+// columns, entries in JSON listings and API answers carry "owner" and
+// "mode" (octal), and users who may modify a path can change its mode from
+// the item menu or with POST ?chmod=<path>&mode=644 on a folder. Only the
+// permission bits change; setuid, setgid and sticky bits are kept. Owners
+// are shown, never changed, since the server rarely runs as root.
+
+var showPerms bool
+
+// parseFileMode reads an octal permission such as 644 or 0755.
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("Invalid mode %q (use octal permission bits such as 644 or 755)", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// octalMode is the permission bits of mode as chmod(1) takes them.
+func octalMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// handleChmod serves POST ?chmod=<path>&mode=<octal>.
+func handleChmod(w http.ResponseWriter, r *http.Request, baseDir string) {
+	fullPath := filepath.Join(baseDir, filepath.Clean("/"+r.URL.Query().Get("chmod")))
+	if !reachable(fullPath, baseDir) || fullPath == filepath.Clean(baseDir) {
+		jsonError(w, http.StatusBadRequest, "Invalid path")
+		return
+	}
+	perm, err := parseFileMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err := os.Chmod(fullPath, info.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)|perm); err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot change permissions: "+err.Error())
+		return
+	}
+	emitFileEvent(r, "modified", fullPath, "web")
+	m, err := statEntry(r, fullPath)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "entry": m})
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "os"
+
+const permsSupported = false
+
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
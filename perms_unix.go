@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const permsSupported = true
+
+var (
+	ownerNamesMu sync.Mutex
+	userNames    = map[uint32]string{}
+	groupNames   = map[uint32]string{}
+)
+
+// fileOwner returns "user:group" for info, with IDs where a name can't be
+// found.
+func fileOwner(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	ownerNamesMu.Lock()
+	defer ownerNamesMu.Unlock()
+	u, ok := userNames[st.Uid]
+	if !ok {
+		u = strconv.FormatUint(uint64(st.Uid), 10)
+		if found, err := user.LookupId(u); err == nil {
+			u = found.Username
+		}
+		userNames[st.Uid] = u
+	}
+	g, ok := groupNames[st.Gid]
+	if !ok {
+		g = strconv.FormatUint(uint64(st.Gid), 10)
+		if found, err := user.LookupGroupId(g); err == nil {
+			g = found.Name
+		}
+		groupNames[st.Gid] = g
+	}
+	return u + ":" + g
+}
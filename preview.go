@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JPEG previews for photo formats browsers can't display (HEIC/HEIF and
+// camera RAW). Conversion is done by whichever external tool is installed
+// (libheif, dcraw, exiftool or ImageMagick) and the result is kept in the
+// -cache directory, so each photo is only converted once.
+
+var heicExts = map[string]bool{".heic": true, ".heif": true}
+
+var rawExts = map[string]bool{
+	".cr2": true, ".cr3": true, ".nef": true, ".nrw": true, ".arw": true, ".srf": true,
+	".sr2": true, ".dng": true, ".orf": true, ".rw2": true, ".raf": true, ".pef": true,
+	".srw": true, ".x3f": true, ".3fr": true, ".iiq": true,
+}
+
+const (
+	previewTimeout = 60 * time.Second
+	maxThumbSize   = 2048
+)
+
+// At most two conversions run at once; RAW decoding is CPU- and memory-hungry.
+var previewSlots = make(chan struct{}, 2)
+
+// needsServerPreview reports whether name is a photo format that must be
+// converted before a browser can show it.
+func needsServerPreview(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return heicExts[ext] || rawExts[ext]
+}
+
+// previewCommands lists converters to try for ext, in order of preference.
+// "{in}" is replaced by the source path and "{out}" by a temporary .jpg for
+// tools that can't write to stdout; otherwise the JPEG is read from stdout.
+func previewCommands(ext string, size int) [][]string {
+	var cmds [][]string
+	if size > 0 {
+		// Only ImageMagick resizes; fall through to full-size previews without it
+		geom := fmt.Sprintf("%dx%d>", size, size)
+		cmds = append(cmds,
+			[]string{"magick", "{in}[0]", "-auto-orient", "-thumbnail", geom, "-quality", "85", "jpg:-"},
+			[]string{"convert", "{in}[0]", "-auto-orient", "-thumbnail", geom, "-quality", "85", "jpg:-"})
+	}
+	if heicExts[ext] {
+		cmds = append(cmds, []string{"heif-convert", "-q", "90", "{in}", "{out}"})
+	} else {
+		// Embedded camera previews are instant compared to a full decode
+		cmds = append(cmds,
+			[]string{"exiftool", "-b", "-PreviewImage", "{in}"},
+			[]string{"exiftool", "-b", "-JpgFromRaw", "{in}"},
+			[]string{"dcraw", "-c", "-e", "{in}"})
+	}
+	return append(cmds,
+		[]string{"magick", "{in}[0]", "-auto-orient", "-quality", "90", "jpg:-"},
+		[]string{"convert", "{in}[0]", "-auto-orient", "-quality", "90", "jpg:-"})
+}
+
+// renderPreview converts fullPath to JPEG with the first converter that
+// is installed and succeeds.
+func renderPreview(ctx context.Context, fullPath string, size int, out io.Writer) error {
+	previewSlots <- struct{}{}
+	defer func() { <-previewSlots }()
+
+	lastErr := fmt.Errorf("no converter installed (need heif-convert, exiftool, dcraw or ImageMagick)")
+	for _, cmd := range previewCommands(strings.ToLower(filepath.Ext(fullPath)), size) {
+		if _, err := exec.LookPath(cmd[0]); err != nil {
+			continue
+		}
+		data, err := runConverter(ctx, cmd, fullPath)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", cmd[0], err)
+			continue
+		}
+		// exiftool exits 0 with no output when the tag is missing
+		if len(data) < 3 || !bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}) {
+			lastErr = fmt.Errorf("%s: no JPEG produced", cmd[0])
+			continue
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	return lastErr
+}
+
+func runConverter(ctx context.Context, cmd []string, fullPath string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	outFile := ""
+	args := make([]string, len(cmd)-1)
+	for i, a := range cmd[1:] {
+		switch {
+		case a == "{out}":
+			tmp, err := os.CreateTemp("", "goserve-preview-*.jpg")
+			if err != nil {
+				return nil, err
+			}
+			tmp.Close()
+			defer os.Remove(tmp.Name())
+			outFile = tmp.Name()
+			a = outFile
+		case strings.HasPrefix(a, "{in}"):
+			a = fullPath + strings.TrimPrefix(a, "{in}")
+		}
+		args[i] = a
+	}
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd[0], args...)
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	if outFile != "" {
+		return os.ReadFile(outFile)
+	}
+	return stdout.Bytes(), nil
+}
+
+// handleImagePreview serves ?preview=1[&size=N] for HEIC and RAW files: a
+// JPEG rendition, optionally scaled to fit N×N pixels for thumbnails.
+func handleImagePreview(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo) {
+	if !needsServerPreview(fullPath) {
+		http.Error(w, "No preview for this file type", http.StatusBadRequest)
+		return
+	}
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if size < 0 || size > maxThumbSize {
+		size = maxThumbSize
+	}
+	name := strings.TrimSuffix(filepath.Base(fullPath), filepath.Ext(fullPath)) + ".jpg"
+
+	// Revalidated on every view so a replaced photo never shows its old
+	// preview; unchanged ones cost a 304 without rendering.
+	etag := fmt.Sprintf(`"%x-%x-%d"`, info.Size(), info.ModTime().UnixNano(), size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if blobCache != nil {
+		key := fmt.Sprintf("preview\x00%s\x00%d\x00%d\x00%d", fullPath, info.Size(), info.ModTime().UnixNano(), size)
+		f, err := blobCache.Open(key, func(out io.Writer) error {
+			return renderPreview(r.Context(), fullPath, size, out)
+		})
+		if err != nil {
+			http.Error(w, "Cannot render preview: "+err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeContent(w, r, name, info.ModTime(), f)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := renderPreview(r.Context(), fullPath, size, &buf); err != nil {
+		http.Error(w, "Cannot render preview: "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caching proxy. With -proxy-of https://files.example.com this instance
+// serves nothing of its own: it passes every request on to the origin, so
+// people in a branch office browse the origin's live listings (and upload
+// to it, where the origin lets them), but file downloads are answered from
+// chunks of proxyChunkSize kept in -cache. Each chunk is fetched from the
+// origin with a range request the first time anyone needs it, so a large
+// file crosses the WAN once however many people download it, and a resumed
+// or seeked download only fetches the chunks nobody has asked for yet.
+//
+// Before a download the proxy asks the origin for the file's headers with
+// the client's own credentials, so the origin still decides who may read
+// what, and sees at once when the file has changed: chunks are cached
+// under the file's size and ETag (or Last-Modified), and chunks of an old
+// version are left for LRU eviction. Files the origin won't serve in
+// ranges, or serves compressed, are passed through uncached. Only
+// /_metrics is answered locally.
+
+const proxyChunkSize = 4 << 20
+
+var (
+	proxyOf     *url.URL // nil unless this is a caching proxy
+	proxyClient = &http.Client{
+		// Redirects go back to the client through the plain proxy
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+)
+
+// proxyForwardHeaders are the client's request headers sent to the origin
+// along with the proxy's own requests for a file.
+var proxyForwardHeaders = []string{"Authorization", "Cookie", "User-Agent"}
+
+// newProxyHandler returns the handler that serves every request in proxy
+// mode.
+func newProxyHandler() http.HandlerFunc {
+	origin := strings.TrimSuffix(proxyOf.String(), "/")
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(proxyOf)
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// Keep clients on the proxy when the origin redirects to itself
+			if loc := resp.Header.Get("Location"); strings.HasPrefix(loc, origin+"/") {
+				resp.Header.Set("Location", strings.TrimPrefix(loc, origin))
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxy: %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "The origin server can't be reached", http.StatusBadGateway)
+		},
+	}
+	metrics := authMiddleware(handleMetrics)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_metrics" {
+			metrics(w, r)
+			return
+		}
+		if isDownloadRequest(r) && !isArchiveRequest(r) && serveProxied(w, r) {
+			return
+		}
+		rp.ServeHTTP(w, r)
+	}
+}
+
+// originURL is the origin's address for the proxy's urlPath.
+func originURL(urlPath string) string {
+	u := *proxyOf
+	u.Path = strings.TrimSuffix(proxyOf.Path, "/") + urlPath
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String()
+}
+
+// originRequest builds a request to the origin for the file r asks for,
+// carrying r's credentials.
+func originRequest(r *http.Request, method, u string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.Context(), method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range proxyForwardHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("X-Forwarded-For", authClientIP(r))
+	return req, nil
+}
+
+// serveProxied answers a file download from cached chunks, returning false
+// if the file can't be served that way and r should go to the origin as it
+// is.
+func serveProxied(w http.ResponseWriter, r *http.Request) bool {
+	u := originURL(r.URL.Path)
+	req, err := originRequest(r, "HEAD", u)
+	if err != nil {
+		return false
+	}
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	h := resp.Header
+	etag, lastModified := h.Get("ETag"), h.Get("Last-Modified")
+	// A strong validator is what lets chunks fetched at different times be
+	// put together; If-Range doesn't accept weak ETags
+	validator := etag
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = lastModified
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 || validator == "" ||
+		h.Get("Accept-Ranges") != "bytes" || h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	f := &proxyFile{
+		r:         r,
+		url:       u,
+		validator: validator,
+		key:       "proxy\x00" + u + "\x00" + strconv.FormatInt(resp.ContentLength, 10) + "\x00" + etag + "\x00" + lastModified,
+		size:      resp.ContentLength,
+		chunk:     -1,
+	}
+	defer f.Close()
+	for _, name := range []string{"Content-Type", "Content-Disposition", "Cache-Control", "ETag"} {
+		if v := h.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	modified, _ := http.ParseTime(lastModified)
+	http.ServeContent(w, r, path.Base(r.URL.Path), modified, f)
+	return true
+}
+
+// proxyFile reads an origin file through the chunk cache, for ServeContent.
+type proxyFile struct {
+	r         *http.Request
+	url       string
+	validator string // ETag or Last-Modified, for If-Range
+	key       string // cache key prefix for this version of the file
+	size, off int64
+
+	chunk int64    // index of cur, or -1
+	cur   *os.File // the cached chunk being read
+}
+
+var errOriginChanged = errors.New("the file changed on the origin")
+
+func (f *proxyFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *proxyFile) Read(b []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	i := f.off / proxyChunkSize
+	start, end := i*proxyChunkSize, min((i+1)*proxyChunkSize, f.size)
+	if f.cur == nil || f.chunk != i {
+		f.Close()
+		key := f.key + "\x00" + strconv.FormatInt(i, 10)
+		source := "cache"
+		cf, err := blobCache.Open(key, func(out io.Writer) error {
+			source = "origin"
+			return f.fetch(out, start, end)
+		})
+		if err != nil {
+			log.Printf("Proxy: %s: %v", f.r.URL.Path, err)
+			return 0, err
+		}
+		addMetric("goserve_proxy_chunks_total", 1, "source", source)
+		f.cur, f.chunk = cf, i
+	}
+	if int64(len(b)) > end-f.off {
+		b = b[:end-f.off]
+	}
+	n, err := f.cur.ReadAt(b, f.off-start)
+	f.off += int64(n)
+	if n == len(b) {
+		return n, nil
+	}
+	if err == io.EOF {
+		// A cached chunk should never be short; drop it so it's fetched again
+		blobCache.Invalidate(f.key + "\x00" + strconv.FormatInt(i, 10))
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// fetch copies bytes start to end of the file from the origin to out.
+func (f *proxyFile) fetch(out io.Writer, start, end int64) error {
+	req, err := originRequest(f.r, "GET", f.url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	req.Header.Set("If-Range", f.validator)
+	began := time.Now()
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if resp.Header.Get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", start, end-1, f.size) {
+			return errOriginChanged
+		}
+	case resp.StatusCode == http.StatusOK:
+		// The whole file: fine if this chunk is the whole file, otherwise
+		// If-Range didn't match
+		if start != 0 || end != f.size || resp.ContentLength != f.size {
+			return errOriginChanged
+		}
+	default:
+		return fmt.Errorf("origin answered %s", resp.Status)
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, end-start))
+	addMetric("goserve_proxy_origin_bytes_total", float64(n))
+	addMetric("goserve_proxy_origin_seconds_total", time.Since(began).Seconds())
+	if err != nil {
+		return err
+	}
+	if n != end-start {
+		return fmt.Errorf("origin sent %d bytes, expected %d", n, end-start)
+	}
+	return nil
+}
+
+func (f *proxyFile) Close() error {
+	if f.cur == nil {
+		return nil
+	}
+	err := f.cur.Close()
+	f.cur, f.chunk = nil, -1
+	return err
+}
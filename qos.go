@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic classes. Every request starts out interactive (listings,
+// previews, thumbnails, API calls). Archive downloads and uploads are bulk
+// from the start, and any other transfer turns bulk once it has moved
+// qosBulkAfter bytes, so a large file download is bulk after its first few
+// megabytes. While interactive requests are in flight, bulk transfers pause
+// before each chunk they send or receive, leaving the link and the disk to
+// the requests someone is waiting on. The pause is capped, so bulk traffic
+// slows down under contention but never stalls, and with nothing
+// interactive running it goes at full speed. -qos=false turns this off.
+
+const (
+	qosBulkAfter = 4 << 20               // bytes before a transfer counts as bulk
+	qosChunk     = 64 << 10              // bulk writes are split into chunks this size
+	qosPause     = 2 * time.Millisecond  // how often a waiting chunk checks again
+	qosMaxYield  = 50 * time.Millisecond // longest a chunk waits for interactive requests
+)
+
+var (
+	qosEnabled        = true
+	interactiveActive atomic.Int64 // interactive requests in flight
+)
+
+// qosYield holds a bulk transfer back while interactive requests are
+// running, for at most qosMaxYield.
+func qosYield() {
+	if interactiveActive.Load() == 0 {
+		return
+	}
+	start := time.Now()
+	for interactiveActive.Load() > 0 && time.Since(start) < qosMaxYield {
+		time.Sleep(qosPause)
+	}
+	addMetric("goserve_qos_yield_seconds_total", time.Since(start).Seconds())
+}
+
+// isBulkRequest reports whether r is bulk before anything is transferred.
+func isBulkRequest(r *http.Request) bool {
+	return isArchiveRequest(r) || r.URL.Query().Get("upload") != "" ||
+		r.Method == "PUT" || r.ContentLength > qosBulkAfter
+}
+
+// qosRequest tracks one request's class and the bytes it has moved.
+type qosRequest struct {
+	bulk  bool
+	moved int64
+}
+
+func (q *qosRequest) toBulk() {
+	if !q.bulk {
+		q.bulk = true
+		interactiveActive.Add(-1)
+		addMetric("goserve_qos_bulk_requests_total", 1)
+	}
+}
+
+func (q *qosRequest) count(n int) {
+	if q.moved += int64(n); q.moved > qosBulkAfter {
+		q.toBulk()
+	}
+}
+
+type qosResponseWriter struct {
+	http.ResponseWriter
+	q *qosRequest
+}
+
+func (w *qosResponseWriter) Write(p []byte) (int, error) {
+	if !w.q.bulk {
+		n, err := w.ResponseWriter.Write(p)
+		w.q.count(n)
+		return n, err
+	}
+	written := 0
+	for len(p) > 0 {
+		qosYield()
+		n, err := w.ResponseWriter.Write(p[:min(len(p), qosChunk)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *qosResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type qosReader struct {
+	io.ReadCloser
+	q *qosRequest
+}
+
+func (r *qosReader) Read(p []byte) (int, error) {
+	if r.q.bulk {
+		qosYield()
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.q.count(n)
+	return n, err
+}
+
+// qosMiddleware classifies each request and makes bulk transfers yield.
+func qosMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A page's event stream idles for as long as the page is open and
+		// would keep bulk traffic yielding the whole time
+		if !qosEnabled || isLiveListing(r) {
+			next(w, r)
+			return
+		}
+		q := &qosRequest{}
+		interactiveActive.Add(1)
+		defer func() {
+			if !q.bulk {
+				interactiveActive.Add(-1)
+			}
+		}()
+		if isBulkRequest(r) {
+			q.toBulk()
+		}
+		if r.Body != nil {
+			r.Body = &qosReader{r.Body, q}
+		}
+		next(&qosResponseWriter{w, q}, r)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage quotas. -quota caps how much the served directory may hold in
+// all, and -user-quota how much each signed-in user's uploads may take up;
+// quota= in a user's permission field in the logins file (in GB, or
+// "none") replaces -user-quota for them. An upload, WebDAV PUT, paste or
+// save in the editor that would go over either is refused with 507
+// Insufficient Storage before anything is written.
+//
+// A user's usage is the size of the files they created, as the file
+// events (see events.go) report them: a file belongs to whoever first put
+// it there, keeps its owner when someone else edits it or it is renamed or
+// moved, and stops counting when it is deleted. Files that were there
+// before quotas were turned on belong to no one. The record is kept in the
+// -state directory. The directory's total comes from the folder size
+// worker (see dirsizes.go), so -quota needs -dir-sizes. Quotas are checked
+// as each file starts, so uploads running side by side can go a little
+// over.
+//
+// Usage shows in each folder's footer, and GET /api/v1/quota returns it;
+// admins can add ?all=1 for every user's.
+
+var (
+	serverQuota int64        // bytes; 0 = no limit
+	userQuota   int64        // bytes; 0 = no limit
+	quotas      *quotaLedger // nil without either
+)
+
+// quotaFile is who a file is charged to.
+type quotaFile struct {
+	User string `json:"user"`
+	Size int64  `json:"size"`
+}
+
+type quotaLedger struct {
+	mu       sync.Mutex
+	files    map[string]quotaFile // by URL path from the base directory
+	byUser   map[string]int64
+	baseUsed int64 // the directory's size when last measured
+	dirty    bool
+}
+
+// parseQuotaGB converts a size in GB, as the flags and logins file give
+// it, to bytes.
+func parseQuotaGB(s string) (int64, error) {
+	gb, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || gb < 0 {
+		return 0, fmt.Errorf("bad quota %q (want a size in GB such as 5 or 0.5)", s)
+	}
+	return int64(gb * 1024 * 1024 * 1024), nil
+}
+
+// cutQuota removes quota=<GB> from a logins file permission field. The
+// quota is -1 for quota=none and 0 if the field has none.
+func cutQuota(field string) (string, int64, error) {
+	var kept []string
+	var quota int64
+	for part := range strings.SplitSeq(field, ",") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(part), "quota=")
+		if !ok {
+			kept = append(kept, part)
+			continue
+		}
+		if v = strings.TrimSpace(v); v == "none" {
+			quota = -1
+			continue
+		}
+		n, err := parseQuotaGB(v)
+		if err != nil {
+			return "", 0, err
+		}
+		if quota = n; quota == 0 {
+			quota = -1 // quota=0 can't mean "use the default"
+		}
+	}
+	return strings.Join(kept, ","), quota, nil
+}
+
+// quotaFor returns user's quota in bytes, 0 for none.
+func quotaFor(user *User) int64 {
+	switch {
+	case user == nil || user.Quota < 0:
+		return 0
+	case user.Quota > 0:
+		return user.Quota
+	}
+	return userQuota
+}
+
+// startQuotas loads the record of who owns what and keeps it up to date.
+func startQuotas() {
+	q := &quotaLedger{files: map[string]quotaFile{}, byUser: map[string]int64{}}
+	if err := loadState("quotas", &q.files); err != nil {
+		log.Printf("Cannot load quota usage: %v", err)
+	}
+	if q.files == nil {
+		q.files = map[string]quotaFile{}
+	}
+	for _, f := range q.files {
+		q.byUser[f.User] += f.Size
+	}
+	quotas = q
+	q.serverUsed() // start measuring
+
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			q.apply(ev)
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.save()
+		}
+	}()
+}
+
+// apply updates the record for one file event.
+func (q *quotaLedger) apply(ev fileEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch ev.Type {
+	case "deleted":
+		q.forgetTree(ev.Path)
+	case "renamed":
+		moved := map[string]quotaFile{}
+		for p, f := range q.files {
+			if rel, ok := underURLPath(p, ev.OldPath); ok {
+				moved[ev.Path+rel] = f
+				q.set(p, quotaFile{})
+			}
+		}
+		for p, f := range moved {
+			q.set(p, f)
+		}
+	case "created", "modified":
+		// A new folder (a copy, an extracted archive) brings its files
+		// with it; a changed folder doesn't mean its files changed
+		full := filepath.Join(getBaseDir(), filepath.FromSlash(ev.Path))
+		filepath.Walk(full, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() && ev.Type == "modified" {
+				return filepath.SkipDir
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			urlPath := urlFor(p)
+			f, ok := q.files[urlPath]
+			if !ok {
+				if ev.User == "" {
+					return nil
+				}
+				f.User = ev.User
+			}
+			f.Size = info.Size()
+			q.set(urlPath, f)
+			return nil
+		})
+	}
+}
+
+// underURLPath reports whether p is root or inside it, with the rest of p.
+func underURLPath(p, root string) (string, bool) {
+	if p == root {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(p, strings.TrimSuffix(root, "/")+"/")
+	return "/" + rest, ok
+}
+
+// set records f for p, or forgets p if f has no user. Callers hold q.mu.
+func (q *quotaLedger) set(p string, f quotaFile) {
+	if old, ok := q.files[p]; ok {
+		q.byUser[old.User] -= old.Size
+		if q.byUser[old.User] <= 0 {
+			delete(q.byUser, old.User)
+		}
+		delete(q.files, p)
+	}
+	if f.User != "" {
+		q.files[p] = f
+		q.byUser[f.User] += f.Size
+	}
+	q.dirty = true
+}
+
+// forgetTree forgets root and everything below it. Callers hold q.mu.
+func (q *quotaLedger) forgetTree(root string) {
+	for p := range q.files {
+		if _, ok := underURLPath(p, root); ok {
+			q.set(p, quotaFile{})
+		}
+	}
+}
+
+func (q *quotaLedger) save() {
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return
+	}
+	q.dirty = false
+	snapshot := make(map[string]quotaFile, len(q.files))
+	for p, f := range q.files {
+		snapshot[p] = f
+	}
+	q.mu.Unlock()
+	if err := saveState("quotas", snapshot); err != nil {
+		log.Printf("Cannot save quota usage: %v", err)
+	}
+}
+
+// userUsed returns the bytes charged to name.
+func (q *quotaLedger) userUsed(name string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.byUser[name]
+}
+
+// serverUsed returns the size of the served directory as last measured;
+// a change since is picked up once the folder size worker gets to it.
+func (q *quotaLedger) serverUsed() int64 {
+	if dirSizes == nil {
+		return 0
+	}
+	n, ok := dirSizes.size(getBaseDir())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if ok {
+		q.baseUsed = n
+	}
+	return q.baseUsed
+}
+
+// quotaError returns why writing size bytes (-1 if not known yet) to
+// fullPath for r would go over a quota, or nil. pending is what the same
+// request has already stored, which the record may not show yet.
+func quotaError(r *http.Request, fullPath string, size, pending int64) error {
+	if quotas == nil {
+		return nil
+	}
+	// Replacing a file frees what it held
+	var old int64
+	if info, err := os.Stat(fullPath); err == nil && info.Mode().IsRegular() {
+		old = info.Size()
+	}
+	grow := max(size-old, 0) + pending
+	if serverQuota > 0 {
+		if used := quotas.serverUsed(); used+grow > serverQuota || used >= serverQuota {
+			return fmt.Errorf("The server's storage quota is full (%s of %s used)", formatSize(used), formatSize(serverQuota))
+		}
+	}
+	user := getUserFromRequest(r)
+	limit := quotaFor(user)
+	if limit == 0 {
+		return nil
+	}
+	quotas.mu.Lock()
+	if f, ok := quotas.files[urlFor(fullPath)]; ok && f.User != user.Username {
+		grow = pending // charged to its owner
+	}
+	quotas.mu.Unlock()
+	if used := quotas.userUsed(user.Username); used+grow > limit || used >= limit {
+		return fmt.Errorf("Over your storage quota (%s of %s used)", formatSize(used), formatSize(limit))
+	}
+	return nil
+}
+
+// quotaUsage is one quota and what is used of it.
+type quotaUsage struct {
+	Name  string `json:"name,omitempty"`
+	Used  int64  `json:"used"`
+	Limit int64  `json:"limit"` // 0 = none
+}
+
+// Summary is the usage as the footer shows it.
+func (u quotaUsage) Summary() string {
+	if u.Limit == 0 {
+		return formatSize(u.Used) + " used"
+	}
+	return fmt.Sprintf("%s of %s used", formatSize(u.Used), formatSize(u.Limit))
+}
+
+// Low reports whether less than a tenth of the quota is left.
+func (u quotaUsage) Low() bool {
+	return u.Limit > 0 && u.Used*10 >= u.Limit*9
+}
+
+// listingQuota returns the requester's own quota for the footer, or nil
+// if they have none.
+func listingQuota(r *http.Request) *quotaUsage {
+	if quotas == nil {
+		return nil
+	}
+	user := getUserFromRequest(r)
+	limit := quotaFor(user)
+	if limit == 0 {
+		return nil
+	}
+	return &quotaUsage{Name: user.Username, Used: quotas.userUsed(user.Username), Limit: limit}
+}
+
+// handleQuota serves GET /api/v1/quota.
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	if quotas == nil {
+		jsonError(w, http.StatusNotFound, "No storage quotas are set on this server")
+		return
+	}
+	resp := map[string]any{
+		"success": true,
+		"server":  quotaUsage{Used: quotas.serverUsed(), Limit: serverQuota},
+	}
+	if user := getUserFromRequest(r); user != nil {
+		resp["user"] = quotaUsage{Name: user.Username, Used: quotas.userUsed(user.Username), Limit: quotaFor(user)}
+	}
+	if r.URL.Query().Get("all") != "" {
+		if _, admin := permissionsFor(r); !admin {
+			jsonError(w, http.StatusForbidden, "Only admins can see everyone's usage")
+			return
+		}
+		quotas.mu.Lock()
+		all := make([]quotaUsage, 0, len(quotas.byUser))
+		for name, used := range quotas.byUser {
+			u := quotaUsage{Name: name, Used: used}
+			if user, ok := users[name]; ok {
+				u.Limit = quotaFor(&user)
+			}
+			all = append(all, u)
+		}
+		quotas.mu.Unlock()
+		sort.Slice(all, func(i, j int) bool { return all[i].Used > all[j].Used })
+		resp["users"] = all
+	}
+	writeJSON(w, resp)
+}
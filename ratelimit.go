@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Request rate limiting. Each client (the authenticated user, otherwise the
+// remote IP) gets two token buckets: one for cheap requests such as
+// listings and file downloads, and a much smaller one for expensive
+// requests like archive generation and server-side search. The rates can
+// be changed at runtime (see settings.go); a rate of 0 turns a bucket off.
+
+var (
+	cheapLimiter *rateLimiter
+	heavyLimiter *rateLimiter
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	l := &rateLimiter{rate: rate, burst: burst, buckets: map[string]*tokenBucket{}}
+	go l.sweep()
+	return l
+}
+
+// adjust changes the limiter's rate and burst, keeping its buckets.
+func (l *rateLimiter) adjust(rate, burst float64) {
+	l.mu.Lock()
+	l.rate, l.burst = rate, burst
+	l.mu.Unlock()
+}
+
+// allow takes a token for key, or reports how long until one is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *rateLimiter) sweep() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if time.Since(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// clientKey identifies the requester for rate limiting purposes.
+func clientKey(r *http.Request) string {
+	if user := getUserFromRequest(r); user != nil {
+		return "user:" + user.Username
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// isHeavyRequest reports whether r is an expensive operation (archives,
+// recursive search) that draws from the heavy bucket.
+func isHeavyRequest(r *http.Request) bool {
+	q := r.URL.Query()
+	return isArchiveRequest(r) || q.Get("grep") != "" || q.Get("find") != "" || q.Get("replace") != ""
+}
+
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rate, heavyRate := currentRates()
+		limiter, class := cheapLimiter, "cheap"
+		perSecond, burst := rate, math.Max(1, rate*5)
+		if isHeavyRequest(r) {
+			limiter, class = heavyLimiter, "heavy"
+			perSecond, burst = heavyRate/60, math.Max(1, heavyRate)
+		}
+		if perSecond <= 0 {
+			next(w, r)
+			return
+		}
+		limiter.adjust(perSecond, burst)
+
+		ok, wait := limiter.allow(clientKey(r))
+		if !ok {
+			addMetric("goserve_ratelimit_rejected_total", 1, "class", class)
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		addMetric("goserve_ratelimit_allowed_total", 1, "class", class)
+		next(w, r)
+	}
+}
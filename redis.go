@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal Redis client for the shared state backend (-state-redis). It
+// speaks just enough RESP for what state documents need: GET and SET, an
+// INCR'd version per document, and SET NX PX locks released by a
+// compare-and-delete script. One connection is shared under a mutex and
+// redialed after any error.
+
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+const redisKeyPrefix = "goserve:state:"
+
+var stateRedis *redisClient
+
+func redisStateKey(name string) string {
+	return redisKeyPrefix + name
+}
+
+// newRedisClient parses redis://[:password@]host[:port][/db] and checks
+// that the server answers.
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("-state-redis: want redis://[:password@]host[:port][/db], got %q", rawURL)
+	}
+	c := &redisClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			c.password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("-state-redis: bad database number %q", db)
+		}
+	}
+	if _, err := c.do("PING"); err != nil {
+		return nil, fmt.Errorf("-state-redis: %v", err)
+	}
+	return c, nil
+}
+
+// do sends one command and returns its reply: a string, an int64, nil for
+// a null reply, or a []any for an array.
+func (c *redisClient) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args)
+	if _, isReplyErr := err.(redisError); err != nil && !isReplyErr {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisClient) dial() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(args []string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisClient) readReply() (any, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// get returns the value of key, or nil if it doesn't exist.
+func (c *redisClient) get(key string) ([]byte, error) {
+	reply, err := c.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	return []byte(reply.(string)), nil
+}
+
+// putVersioned sets key and bumps its version, which other instances poll
+// to notice the change.
+func (c *redisClient) putVersioned(key string, data []byte) error {
+	if _, err := c.do("SET", key, string(data)); err != nil {
+		return err
+	}
+	_, err := c.do("INCR", key+":v")
+	return err
+}
+
+// version returns key's version counter, "" if it was never written.
+func (c *redisClient) version(key string) (string, error) {
+	reply, err := c.do("GET", key+":v")
+	if err != nil || reply == nil {
+		return "", err
+	}
+	return reply.(string), nil
+}
+
+// tryLock takes the lock key with token for ttl, reporting whether it was
+// free.
+func (c *redisClient) tryLock(key, token string, ttl time.Duration) (bool, error) {
+	reply, err := c.do("SET", key, token, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return reply != nil, err
+}
+
+// unlock releases key if token still holds it.
+func (c *redisClient) unlock(key, token string) error {
+	_, err := c.do("EVAL", `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`, "1", key, token)
+	return err
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read replicas. With -replica-of https://primary:8080 this instance keeps
+// its -dir a copy of the primary's tree and serves it read-only, so a
+// heavily downloaded release archive can be served from several places.
+// It first compares the whole tree through ?format=json listings, fetching
+// files whose size or modification time differ and deleting what the
+// primary no longer has, then follows the primary's change feed
+// (/api/v1/changes) and applies each change as it happens. A new epoch on
+// the primary (it restarted), a gap in the feed, and every -replica-resync
+// lead to another full comparison, which also picks up files changed on
+// the primary's disk behind its back.
+//
+// Files are downloaded next to their destination under a hidden temporary
+// name and renamed into place, so clients never see half a file. Every
+// permission is capped at readonly. -replica-token is sent to the primary
+// as a bearer token, for primaries that require sign-in; user:password in
+// the -replica-of URL works as well.
+
+const (
+	replicaRetry   = 10 * time.Second
+	replicaTempPfx = ".goserve-replica-"
+)
+
+var (
+	replicaOf *url.URL // nil unless this is a replica
+	replica   = &replicaClient{http: &http.Client{}}
+)
+
+type replicaClient struct {
+	token  string
+	resync time.Duration
+	http   *http.Client
+
+	mu       sync.Mutex
+	epoch    string
+	seq      int64
+	lastFull time.Time // when the last full comparison finished
+	lastSync time.Time // when the replica last knew it was current
+	fetched  int64     // files downloaded since start
+	lastErr  string
+}
+
+// replicaStatus describes the replica for /_info.
+func replicaStatus() map[string]any {
+	c := replica
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := map[string]any{
+		"primary": replicaOf.Redacted(),
+		"seq":     c.seq,
+		"fetched": c.fetched,
+	}
+	if !c.lastFull.IsZero() {
+		status["lastFullSync"] = c.lastFull
+	}
+	if !c.lastSync.IsZero() {
+		status["lastSync"] = c.lastSync
+	}
+	if c.lastErr != "" {
+		status["error"] = c.lastErr
+	}
+	return status
+}
+
+// startReplica keeps the local tree in step with the primary for as long as
+// the server runs.
+func startReplica() {
+	go func() {
+		for {
+			err := replica.run()
+			if err == nil {
+				continue // time for another full comparison
+			}
+			replica.mu.Lock()
+			replica.lastErr = err.Error()
+			replica.mu.Unlock()
+			log.Printf("Replica: %v; retrying in %v", err, replicaRetry)
+			time.Sleep(replicaRetry)
+		}
+	}()
+}
+
+// run does a full comparison and then follows the change feed until it has
+// to start over, or fails.
+func (c *replicaClient) run() error {
+	var head changesResponse
+	if err := c.getJSON("/api/v1/changes", nil, &head); err != nil {
+		return err
+	}
+	start := time.Now()
+	n, err := c.syncDir("/", getBaseDir())
+	if err != nil {
+		return err
+	}
+	log.Printf("Replica: compared the tree with %s in %v, %d files fetched", replicaOf.Redacted(), time.Since(start).Round(time.Millisecond), n)
+	c.mu.Lock()
+	c.epoch, c.seq = head.Epoch, head.Seq
+	c.lastFull, c.lastSync, c.lastErr = time.Now(), time.Now(), ""
+	c.mu.Unlock()
+
+	for time.Since(start) < c.resync {
+		var resp changesResponse
+		q := url.Values{"since": {strconv.FormatInt(c.seq, 10)}, "wait": {"30"}}
+		if err := c.getJSON("/api/v1/changes", q, &resp); err != nil {
+			return err
+		}
+		if resp.Epoch != c.epoch || resp.Resync {
+			log.Printf("Replica: lost track of the primary's changes, comparing the tree again")
+			return nil
+		}
+		for _, ch := range resp.Changes {
+			if err := c.apply(ch); err != nil {
+				log.Printf("Replica: %s %s: %v", ch.Type, ch.Path, err)
+			}
+		}
+		c.mu.Lock()
+		c.seq, c.lastSync, c.lastErr = resp.Seq, time.Now(), ""
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+type changesResponse struct {
+	Epoch   string   `json:"epoch"`
+	Seq     int64    `json:"seq"`
+	Resync  bool     `json:"resync"`
+	Changes []change `json:"changes"`
+}
+
+// apply makes one change from the primary's feed locally.
+func (c *replicaClient) apply(ch change) error {
+	local, ok := resolvePath(ch.Path)
+	if !ok || local == getBaseDir() {
+		return fmt.Errorf("path outside the served folder")
+	}
+	switch ch.Type {
+	case "deleted":
+		return os.RemoveAll(local)
+	case "renamed":
+		if old, ok := resolvePath(ch.OldPath); ok && old != getBaseDir() {
+			if err := os.Rename(old, local); err == nil {
+				return nil
+			}
+		}
+	}
+	// Created, modified, or renamed from something this replica doesn't
+	// have: fetch it as it is now
+	if ch.IsDir {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+		_, err := c.syncDir(ch.Path, local)
+		return err
+	}
+	var info struct {
+		Size     int64  `json:"size"`
+		Modified string `json:"modified"`
+	}
+	if err := c.getJSON("/api/v1/download-info", url.Values{"path": {ch.Path}}, &info); err != nil {
+		return err
+	}
+	return c.fetch(ch.Path, local, info.Size, info.Modified)
+}
+
+// syncDir makes the local folder localDir match the primary's urlPath and
+// everything below it, returning how many files it fetched.
+func (c *replicaClient) syncDir(urlPath, localDir string) (int, error) {
+	var listing struct {
+		Entries []entryMeta `json:"entries"`
+	}
+	folderURL := strings.TrimSuffix(urlPath, "/") + "/"
+	// Dotfiles are copied too, though listings leave them out by default
+	if err := c.getJSON(folderURL, url.Values{"format": {"json"}, "hidden": {"1"}}, &listing); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return 0, err
+	}
+	fetched := 0
+	remote := map[string]bool{}
+	for _, e := range listing.Entries {
+		if e.Error != "" || strings.ContainsAny(e.Name, `/\`) || e.Name == "." || e.Name == ".." ||
+			strings.HasPrefix(e.Name, replicaTempPfx) {
+			continue
+		}
+		remote[e.Name] = true
+		local := filepath.Join(localDir, e.Name)
+		childURL := path.Join(urlPath, e.Name)
+		if e.IsDir {
+			if info, err := os.Lstat(local); err == nil && !info.IsDir() {
+				os.Remove(local)
+			}
+			n, err := c.syncDir(childURL, local)
+			fetched += n
+			if err != nil {
+				return fetched, err
+			}
+			continue
+		}
+		if info, err := os.Lstat(local); err == nil {
+			if mt, err := time.Parse(time.RFC3339Nano, e.Modified); err == nil &&
+				info.Mode().IsRegular() && info.Size() == e.Size && info.ModTime().Equal(mt) {
+				continue
+			}
+			if info.IsDir() {
+				os.RemoveAll(local)
+			}
+		}
+		if err := c.fetch(childURL, local, e.Size, e.Modified); err != nil {
+			log.Printf("Replica: %s: %v", childURL, err)
+			continue
+		}
+		fetched++
+	}
+
+	// Whatever the primary doesn't have goes, apart from this folder's own
+	// settings, which listings don't show
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return fetched, err
+	}
+	for _, e := range entries {
+		if !remote[e.Name()] && e.Name() != dirSettingsFile {
+			os.RemoveAll(filepath.Join(localDir, e.Name()))
+		}
+	}
+	return fetched, nil
+}
+
+// fetch downloads the primary's file urlPath to local, checking it is size
+// bytes and giving it the primary's modification time.
+func (c *replicaClient) fetch(urlPath, local string, size int64, modified string) error {
+	resp, err := c.get(urlPath, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary answered %s", resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(local), replicaTempPfx+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("got %d bytes, expected %d; the file changed while it was copied", n, size)
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if mt, err := time.Parse(time.RFC3339Nano, modified); err == nil {
+		os.Chtimes(tmp.Name(), time.Now(), mt)
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.fetched++
+	c.mu.Unlock()
+	return nil
+}
+
+// get requests urlPath with query from the primary.
+func (c *replicaClient) get(urlPath string, query url.Values) (*http.Response, error) {
+	u := *replicaOf
+	u.Path = strings.TrimSuffix(replicaOf.Path, "/") + urlPath
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("User-Agent", "goserve-replica/"+version)
+	return c.http.Do(req)
+}
+
+// getJSON requests urlPath with query from the primary and decodes the
+// answer into v.
+func (c *replicaClient) getJSON(urlPath string, query url.Values, v any) error {
+	resp, err := c.get(urlPath, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error != "" {
+			return fmt.Errorf("%s: primary answered %s: %s", urlPath, resp.Status, e.Error)
+		}
+		return fmt.Errorf("%s: primary answered %s", urlPath, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
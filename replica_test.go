@@ -0,0 +1,52 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A full comparison copies dotfiles and hidden folders, which listings
+// leave out by default, and a second one keeps them.
+func TestReplicaSyncDirCopiesDotfiles(t *testing.T) {
+	primary, local := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"a.txt":           "a",
+		".env":            "secret",
+		".config/app.ini": "x=1",
+	}
+	for name, data := range files {
+		p := filepath.Join(primary, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldBase := getBaseDir()
+	setBaseDir(primary)
+	defer setBaseDir(oldBase)
+	srv := httptest.NewServer(dirHandler(template.New("index")))
+	defer srv.Close()
+	oldOf := replicaOf
+	replicaOf, _ = url.Parse(srv.URL)
+	defer func() { replicaOf = oldOf }()
+
+	c := &replicaClient{http: srv.Client()}
+	for pass := 1; pass <= 2; pass++ {
+		if _, err := c.syncDir("/", local); err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+		for name, want := range files {
+			got, err := os.ReadFile(filepath.Join(local, filepath.FromSlash(name)))
+			if err != nil || string(got) != want {
+				t.Errorf("pass %d: %s = %q, %v; want %q", pass, name, got, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Automation rules. A JSON file given with -rules lists rules of the form
+// "when event X happens to a path matching glob Y, do action Z":
+//
+//	[
+//	  {"name": "file PDFs", "on": ["created"], "match": "/incoming/*.pdf",
+//	   "action": "move", "to": "/documents/{year}/{month}"},
+//	  {"match": "/drop/**", "action": "webhook", "url": "https://example.com/hook"},
+//	  {"match": "/tmp/**", "action": "expire", "after": "7d"},
+//	  {"match": "*.raw", "action": "tag", "tags": ["photo"]}
+//	]
+//
+// "on" defaults to ["created"]. Events caused by rules themselves don't
+// trigger further rules.
+
+type rule struct {
+	Name   string   `json:"name"`
+	On     []string `json:"on"`
+	Match  string   `json:"match"`
+	Action string   `json:"action"`
+	To     string   `json:"to"`
+	Tags   []string `json:"tags"`
+	URL    string   `json:"url"`
+	After  string   `json:"after"`
+
+	re    *regexp.Regexp
+	after time.Duration
+}
+
+var rules []*rule
+
+var (
+	expiries   = map[string]time.Time{} // URL path -> deletion time
+	expiriesMu sync.Mutex
+)
+
+// compileGlob turns a glob into a regexp over slash paths: "*" and "?"
+// stay within one path segment and "**" spans segments. A pattern without
+// a slash matches the base name anywhere in the tree.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	if !strings.Contains(pattern, "/") {
+		pattern = "/**/" + pattern
+	} else if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" also matches zero directories
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// parseDuration accepts Go durations plus a "d" suffix for days.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// loadRules reads and validates the rules file.
+func loadRules(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var list []*rule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for i, rl := range list {
+		if rl.Name == "" {
+			rl.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rl.On) == 0 {
+			rl.On = []string{"created"}
+		}
+		if rl.re, err = compileGlob(rl.Match); err != nil {
+			return fmt.Errorf("%s: bad match: %v", rl.Name, err)
+		}
+		switch rl.Action {
+		case "move":
+			if rl.To == "" {
+				return fmt.Errorf("%s: move needs \"to\"", rl.Name)
+			}
+		case "tag":
+			if len(rl.Tags) == 0 {
+				return fmt.Errorf("%s: tag needs \"tags\"", rl.Name)
+			}
+		case "webhook":
+			if rl.URL == "" {
+				return fmt.Errorf("%s: webhook needs \"url\"", rl.Name)
+			}
+		case "expire":
+			if rl.after, err = parseDuration(rl.After); err != nil {
+				return fmt.Errorf("%s: bad \"after\": %v", rl.Name, err)
+			}
+		default:
+			return fmt.Errorf("%s: unknown action %q", rl.Name, rl.Action)
+		}
+	}
+	rules = list
+	return nil
+}
+
+// startRules evaluates rules on every file event and deletes expired files.
+func startRules() {
+	if err := loadState("expiry", &expiries); err != nil {
+		log.Printf("Cannot load expiry schedule: %v", err)
+	}
+	shareState("expiry", &expiriesMu, &expiries)
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			if ev.Source == "rules" {
+				continue
+			}
+			for _, rl := range rules {
+				if rl.matches(ev) {
+					rl.apply(ev)
+				}
+			}
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Minute) {
+			sweepExpired()
+		}
+	}()
+}
+
+func (rl *rule) matches(ev fileEvent) bool {
+	for _, typ := range rl.On {
+		if typ == ev.Type || typ == "*" {
+			return rl.re.MatchString(ev.Path)
+		}
+	}
+	return false
+}
+
+func (rl *rule) apply(ev fileEvent) {
+	var err error
+	switch rl.Action {
+	case "move":
+		err = rl.move(ev)
+	case "tag":
+		err = addTags(ev.Path, rl.Tags...)
+	case "webhook":
+		go rl.webhook(ev)
+	case "expire":
+		err = updateState("expiry", &expiriesMu, &expiries, func() {
+			expiries[ev.Path] = ev.Time.Add(rl.after)
+		})
+	}
+	if err != nil {
+		log.Printf("Rule %q on %s: %v", rl.Name, ev.Path, err)
+		return
+	}
+	addMetric("goserve_rules_applied_total", 1, "rule", rl.Name)
+}
+
+// move moves the file into rl.To, expanding {year}, {month} and {day} from
+// the event time and adding " (n)" to the name if the target exists.
+func (rl *rule) move(ev fileEvent) error {
+	dir := strings.NewReplacer(
+		"{year}", ev.Time.Format("2006"),
+		"{month}", ev.Time.Format("01"),
+		"{day}", ev.Time.Format("02"),
+	).Replace(rl.To)
+	src, ok := resolvePath(ev.Path)
+	if !ok {
+		return fmt.Errorf("invalid path")
+	}
+	if writeOnceLocked(src) {
+		return fmt.Errorf("in a write-once folder")
+	}
+	if dir, _ := writeOnceBelow(src); dir != "" {
+		return fmt.Errorf("holds %s, which is write-once", urlFor(dir))
+	}
+	destDir, ok := resolvePath(dir)
+	if !ok {
+		return fmt.Errorf("invalid destination %s", dir)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	dest := uniquePath(filepath.Join(destDir, filepath.Base(src)))
+	if err := os.Rename(src, dest); err != nil {
+		return err
+	}
+	publishEvent(fileEvent{Type: "renamed", OldPath: ev.Path, Path: urlFor(dest), IsDir: ev.IsDir, Source: "rules", User: rl.Name})
+	return nil
+}
+
+// uniquePath returns p, or p with " (n)" before the extension if p exists.
+func uniquePath(p string) string {
+	if _, err := os.Lstat(p); err != nil {
+		return p
+	}
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, err := os.Lstat(candidate); err != nil {
+			return candidate
+		}
+	}
+}
+
+func (rl *rule) webhook(ev fileEvent) {
+	body, _ := json.Marshal(map[string]any{"rule": rl.Name, "event": ev})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(rl.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Rule %q webhook: %v", rl.Name, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Rule %q webhook: %s", rl.Name, resp.Status)
+	}
+}
+
+// sweepExpired deletes files whose expiry time has passed.
+func sweepExpired() {
+	now := time.Now()
+	takeDue := func() (due []string) {
+		for p, t := range expiries {
+			if now.After(t) {
+				due = append(due, p)
+				delete(expiries, p)
+			}
+		}
+		return due
+	}
+	expiriesMu.Lock()
+	due := takeDue()
+	if len(due) > 0 && !stateShared {
+		saveState("expiry", expiries)
+	}
+	expiriesMu.Unlock()
+	if len(due) > 0 && stateShared {
+		// Claim the due files under the shared lock so only one instance
+		// deletes each
+		err := updateState("expiry", &expiriesMu, &expiries, func() { due = takeDue() })
+		if err != nil {
+			log.Printf("Expire: %v", err)
+			return
+		}
+	}
+
+	for _, p := range due {
+		fullPath, ok := resolvePath(p)
+		if !ok || fullPath == filepath.Clean(getBaseDir()) {
+			continue
+		}
+		if writeOnceLocked(fullPath) {
+			log.Printf("Expire %s: in a write-once folder", p)
+			continue
+		}
+		if dir, _ := writeOnceBelow(fullPath); dir != "" {
+			log.Printf("Expire %s: holds %s, which is write-once", p, urlFor(dir))
+			continue
+		}
+		if err := os.RemoveAll(fullPath); err != nil {
+			log.Printf("Expire %s: %v", p, err)
+			continue
+		}
+		publishEvent(fileEvent{Type: "deleted", Path: path.Clean(p), Source: "rules"})
+	}
+}
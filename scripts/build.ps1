@@ -2,7 +2,7 @@
 #
 Push-Location (Split-Path $PSScriptRoot -Parent)
 Write-Host "Building goserve..." -ForegroundColor Cyan
-go build -ldflags="-s -w -X main.version=dev" -o goserve.exe main.go
+go build -ldflags="-s -w -X main.version=dev" -o goserve.exe .
 if ($LASTEXITCODE -eq 0) {
     Write-Host "  goserve.exe" -ForegroundColor Green
 } else {
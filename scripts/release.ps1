@@ -41,7 +41,7 @@ try {
         $env:GOARCH = $t.GOARCH
         $out = Join-Path $dist $t.Out
         Write-Host "  $($t.GOOS)/$($t.GOARCH) -> $($t.Out)"
-        go build -ldflags "-s -w -X main.version=$Tag" -o $out .
+        go build -ldflags "-s -w -X main.version=$Tag -X main.updatePublicKey=$env:GOSERVE_UPDATE_PUBKEY" -o $out .
         if ($LASTEXITCODE -ne 0) { throw "Build failed for $($t.GOOS)/$($t.GOARCH)" }
     }
 } finally {
@@ -50,12 +50,29 @@ try {
     Pop-Location
 }
 
-# Collect assets (binaries only — README.txt is generated by install scripts)
+# Collect assets (binaries and checksums — README.txt is generated by install scripts)
 $assets = @()
 foreach ($t in $targets) {
     $assets += Join-Path $dist $t.Out
 }
 
+# Checksums for "goserve update", signed with the release key when
+# GOSERVE_SIGNING_KEY points at an ed25519 PEM private key. Builds verify
+# the signature when given its public key via -X main.updatePublicKey.
+$sums = Join-Path $dist "SHA256SUMS"
+$lines = foreach ($t in $targets) {
+    $hash = (Get-FileHash (Join-Path $dist $t.Out) -Algorithm SHA256).Hash.ToLower()
+    "$hash  $($t.Out)"
+}
+[IO.File]::WriteAllText($sums, (($lines -join "`n") + "`n"))
+$assets += $sums
+if ($env:GOSERVE_SIGNING_KEY) {
+    $sig = Join-Path $dist "SHA256SUMS.sig"
+    openssl pkeyutl -sign -rawin -inkey $env:GOSERVE_SIGNING_KEY -in $sums -out $sig
+    if ($LASTEXITCODE -ne 0) { throw "Signing SHA256SUMS failed" }
+    $assets += $sig
+}
+
 if ($DryRun) {
     Write-Host ""
     Write-Host "Dry run complete. Artifacts in: $dist"
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server-side text search ("find in files"), the search box's search by
+// name through subfolders, and streaming replace for files too large to
+// load comfortably in the browser editor.
+//
+// Searches read files on demand rather than from an index (word searches
+// can use one with -index, see contentindex.go), so on a large share they
+// are bounded: -search-exclude skips paths by glob, files over
+// -search-max-size are not read, at most -search-concurrency searches run
+// at once (others wait their turn), each reads at most -search-rate MB/s
+// and stops after -search-timeout. /api/v1/search-status reports the
+// settings and, to admins, the searches in progress.
+
+const (
+	grepMaxMatches = 1000
+	grepMaxLineLen = 1024 * 1024
+	findMaxResults = 200
+	// Unreadable entries named in a search response; the rest are counted
+	searchMaxUnreadable = 20
+)
+
+var (
+	searchExcludes    []*regexp.Regexp
+	searchExcludeList string
+	searchMaxFileSize int64 = 50 * 1024 * 1024
+	searchRate        int64 // bytes per second per search, 0 = unlimited
+	searchTimeout     time.Duration
+	searchSlots       chan struct{} // nil = unlimited concurrency
+)
+
+// setSearchExcludes compiles the comma-separated -search-exclude globs.
+func setSearchExcludes(list string) error {
+	searchExcludeList = list
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.TrimSpace(g); g == "" {
+			continue
+		}
+		re, err := compileGlob(g)
+		if err != nil {
+			return fmt.Errorf("%q: %v", g, err)
+		}
+		searchExcludes = append(searchExcludes, re)
+	}
+	return nil
+}
+
+func searchExcluded(urlPath string) bool {
+	for _, re := range searchExcludes {
+		if re.MatchString(urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchRun is one search in progress, for limits and the status report.
+type searchRun struct {
+	ID      int64     `json:"id"`
+	Path    string    `json:"path"`
+	Query   string    `json:"query"`
+	User    string    `json:"user"`
+	Started time.Time `json:"started"`
+	Files   int64     `json:"files"`   // files read
+	Bytes   int64     `json:"bytes"`   // bytes read
+	Skipped int64     `json:"skipped"` // excluded or too large
+	Waiting bool      `json:"waiting"` // queued behind -search-concurrency
+
+	ctx context.Context
+}
+
+var (
+	searchesMu   sync.Mutex
+	searches     = map[int64]*searchRun{}
+	searchNextID int64
+	searchTotals struct {
+		Searches, Files, Bytes, Skipped, TimedOut atomic.Int64
+	}
+)
+
+// startSearch registers a search and waits for a free slot. The returned
+// function releases it; ok is false if the client gave up while waiting.
+func startSearch(r *http.Request, query string) (run *searchRun, done func(), ok bool) {
+	searchesMu.Lock()
+	searchNextID++
+	run = &searchRun{ID: searchNextID, Path: r.URL.Path, Query: query, User: requesterName(r), Started: time.Now()}
+	run.Waiting = searchSlots != nil
+	searches[run.ID] = run
+	searchesMu.Unlock()
+	unregister := func() {
+		searchesMu.Lock()
+		delete(searches, run.ID)
+		searchesMu.Unlock()
+	}
+
+	if searchSlots != nil {
+		select {
+		case searchSlots <- struct{}{}:
+		case <-r.Context().Done():
+			unregister()
+			return nil, nil, false
+		}
+		searchesMu.Lock()
+		run.Waiting = false
+		run.Started = time.Now() // the timeout and rate run from here
+		searchesMu.Unlock()
+	}
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if searchTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, searchTimeout)
+	}
+	run.ctx = ctx
+	searchTotals.Searches.Add(1)
+	return run, func() {
+		cancel()
+		if searchSlots != nil {
+			<-searchSlots
+		}
+		addMetric("goserve_search_files_total", float64(atomic.LoadInt64(&run.Files)))
+		addMetric("goserve_search_bytes_total", float64(atomic.LoadInt64(&run.Bytes)))
+		unregister()
+	}, true
+}
+
+// throttledReader counts what a search reads and, with -search-rate,
+// sleeps to keep it under the limit.
+type throttledReader struct {
+	r   io.Reader
+	run *searchRun
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if err := t.run.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	total := atomic.AddInt64(&t.run.Bytes, int64(n))
+	searchTotals.Bytes.Add(int64(n))
+	if searchRate > 0 {
+		due := t.run.Started.Add(time.Duration(float64(total) / float64(searchRate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-t.run.ctx.Done():
+			}
+		}
+	}
+	return n, err
+}
+
+type grepMatch struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// grepPattern builds the matcher for ?grep= requests. Plain queries are
+// literal; regex=1 enables regular expressions and case=1 case-sensitivity.
+func grepPattern(r *http.Request, q string) (*regexp.Regexp, error) {
+	expr := q
+	if r.URL.Query().Get("regex") == "" {
+		expr = regexp.QuoteMeta(q)
+	}
+	if r.URL.Query().Get("case") == "" {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// isBinaryFile reports whether the first block of f contains a NUL byte.
+func isBinaryFile(f *os.File) bool {
+	buf := make([]byte, 8000)
+	n, _ := f.Read(buf)
+	f.Seek(0, io.SeekStart)
+	return bytes.IndexByte(buf[:n], 0) >= 0
+}
+
+// unreadableEntries collects the entries a search couldn't read.
+type unreadableEntries struct {
+	count   int
+	entries []entryError
+}
+
+// add records fullPath, rel to the folder searched, as unreadable.
+func (u *unreadableEntries) add(fullPath, rel string, err error) {
+	u.count++
+	if len(u.entries) < searchMaxUnreadable {
+		u.entries = append(u.entries, entryError{filepath.ToSlash(rel), entryErrorReason(fullPath, err)})
+	}
+}
+
+// list returns the entries named, never nil.
+func (u *unreadableEntries) list() []entryError {
+	if u.entries == nil {
+		return []entryError{}
+	}
+	return u.entries
+}
+
+// grepFile appends the matching lines of fullPath to matches, stopping at
+// grepMaxMatches. It returns false once the limit is reached, and the error
+// if the file can't be opened.
+func grepFile(run *searchRun, fullPath, urlPath string, re *regexp.Regexp, matches *[]grepMatch) (bool, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return true, err
+	}
+	defer f.Close()
+	if isBinaryFile(f) {
+		return true, nil
+	}
+	atomic.AddInt64(&run.Files, 1)
+	searchTotals.Files.Add(1)
+
+	scanner := bufio.NewScanner(throttledReader{f, run})
+	scanner.Buffer(make([]byte, 64*1024), grepMaxLineLen)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if !re.MatchString(text) {
+			continue
+		}
+		if len(text) > 300 {
+			text = text[:300]
+		}
+		*matches = append(*matches, grepMatch{
+			Path: urlPath,
+			Name: path.Base(urlPath),
+			Line: line,
+			Text: text,
+		})
+		if len(*matches) >= grepMaxMatches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// handleGrep searches a single file or, for a directory, every text file
+// beneath it and returns the matching lines as JSON.
+func handleGrep(w http.ResponseWriter, r *http.Request, fullPath string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	re, err := grepPattern(r, r.URL.Query().Get("grep"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		fmt.Fprintf(w, `{"success": false, "error": "Not found"}`)
+		return
+	}
+
+	run, done, ok := startSearch(r, r.URL.Query().Get("grep"))
+	if !ok {
+		return
+	}
+	defer done()
+
+	matches := []grepMatch{}
+	truncated := false
+	var unreadable unreadableEntries
+	if !info.IsDir() {
+		more, err := grepFile(run, fullPath, r.URL.Path, re, &matches)
+		if err != nil {
+			unreadable.add(fullPath, filepath.Base(fullPath), err)
+		}
+		truncated = !more
+	} else {
+		withHidden, ignore := hiddenShown(r), ignoreWalker{}
+		filepath.Walk(fullPath, func(p string, fi os.FileInfo, err error) error {
+			if run.ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			rel, _ := filepath.Rel(fullPath, p)
+			if err != nil {
+				unreadable.add(p, rel, err)
+				return nil
+			}
+			if p != fullPath && searchExcluded(urlFor(p)) {
+				atomic.AddInt64(&run.Skipped, 1)
+				searchTotals.Skipped.Add(1)
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if p != fullPath && ((!withHidden && isHidden(fi.Name(), fi)) || ignore.skip(p, fi)) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if fi.Size() > searchMaxFileSize {
+				atomic.AddInt64(&run.Skipped, 1)
+				searchTotals.Skipped.Add(1)
+				return nil
+			}
+			more, err := grepFile(run, p, path.Join(r.URL.Path, filepath.ToSlash(rel)), re, &matches)
+			if err != nil {
+				unreadable.add(p, rel, err)
+			}
+			if !more {
+				truncated = true
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+	timedOut := errors.Is(run.ctx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		searchTotals.TimedOut.Add(1)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":   true,
+		"matches":   matches,
+		"truncated": truncated || timedOut,
+		"timedOut":  timedOut,
+		"files":     atomic.LoadInt64(&run.Files),
+		"skipped":   atomic.LoadInt64(&run.Skipped),
+		// Files and folders that couldn't be read, and the first few of them
+		"unreadable":        unreadable.count,
+		"unreadableEntries": unreadable.list(),
+	})
+}
+
+// handleFind serves ?find=<text>, the search box's subfolder mode: the
+// files and folders beneath fullPath whose name contains text, or matches
+// a glob such as *.pdf. Paths are relative to fullPath; at most
+// findMaxResults are returned.
+func handleFind(w http.ResponseWriter, r *http.Request, fullPath string) {
+	text := r.URL.Query().Get("find")
+	match := func(name string) bool {
+		return strings.Contains(strings.ToLower(name), strings.ToLower(text))
+	}
+	if strings.ContainsAny(text, "*?") {
+		re, err := compileGlob(text)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		match = func(name string) bool { return re.MatchString("/" + name) }
+	}
+
+	run, done, ok := startSearch(r, text)
+	if !ok {
+		return
+	}
+	defer done()
+
+	type findResult struct {
+		Path  string `json:"path"` // relative to the folder searched
+		IsDir bool   `json:"isDir"`
+	}
+	results := []findResult{}
+	truncated := false
+	var unreadable unreadableEntries
+	withHidden, ignore := hiddenShown(r), ignoreWalker{}
+	filepath.Walk(fullPath, func(p string, fi os.FileInfo, err error) error {
+		if run.ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil && p != fullPath {
+			rel, _ := filepath.Rel(fullPath, p)
+			unreadable.add(p, rel, err)
+			return nil
+		}
+		if err != nil || p == fullPath || fi.Name() == dirSettingsFile {
+			return nil
+		}
+		if searchExcluded(urlFor(p)) {
+			atomic.AddInt64(&run.Skipped, 1)
+			searchTotals.Skipped.Add(1)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if (!withHidden && isHidden(fi.Name(), fi)) || ignore.skip(p, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		atomic.AddInt64(&run.Files, 1)
+		if !match(fi.Name()) {
+			return nil
+		}
+		if len(results) == findMaxResults {
+			truncated = true
+			return filepath.SkipAll
+		}
+		rel, _ := filepath.Rel(fullPath, p)
+		results = append(results, findResult{Path: filepath.ToSlash(rel), IsDir: fi.IsDir()})
+		return nil
+	})
+	timedOut := errors.Is(run.ctx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		searchTotals.TimedOut.Add(1)
+	}
+
+	writeJSON(w, map[string]any{
+		"success":   true,
+		"results":   results,
+		"truncated": truncated || timedOut,
+		"timedOut":  timedOut,
+		// Folders that couldn't be read, and the first few of them
+		"unreadable":        unreadable.count,
+		"unreadableEntries": unreadable.list(),
+	})
+}
+
+// handleSearchStatus serves /api/v1/search-status: the search limits, totals
+// since startup and, for admins, the searches running now.
+func handleSearchStatus(w http.ResponseWriter, r *http.Request) {
+	_, admin := permissionsFor(r)
+	status := map[string]any{
+		"success": true,
+		"limits": map[string]any{
+			"exclude":        searchExcludeList,
+			"maxFileBytes":   searchMaxFileSize,
+			"concurrency":    cap(searchSlots),
+			"bytesPerSecond": searchRate,
+			"timeoutSeconds": searchTimeout.Seconds(),
+		},
+		"totals": map[string]int64{
+			"searches": searchTotals.Searches.Load(),
+			"files":    searchTotals.Files.Load(),
+			"bytes":    searchTotals.Bytes.Load(),
+			"skipped":  searchTotals.Skipped.Load(),
+			"timedOut": searchTotals.TimedOut.Load(),
+		},
+	}
+	if admin {
+		active := []searchRun{}
+		searchesMu.Lock()
+		for _, s := range searches {
+			active = append(active, searchRun{
+				ID: s.ID, Path: s.Path, Query: s.Query, User: s.User, Started: s.Started, Waiting: s.Waiting,
+				Files: atomic.LoadInt64(&s.Files), Bytes: atomic.LoadInt64(&s.Bytes), Skipped: atomic.LoadInt64(&s.Skipped),
+			})
+		}
+		searchesMu.Unlock()
+		sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+		status["active"] = active
+	}
+	if contentIdx != nil {
+		status["index"] = contentIdx.status()
+	}
+	writeJSON(w, status)
+}
+
+// handleReplace performs a line-by-line find/replace on the file, streaming
+// through a temp file so huge files never have to be held in memory.
+func handleReplace(w http.ResponseWriter, r *http.Request, fullPath string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Find    string `json:"find"`
+		Replace string `json:"replace"`
+		Regex   bool   `json:"regex"`
+		Case    bool   `json:"case"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Find == "" {
+		fmt.Fprintf(w, `{"success": false, "error": "Invalid request"}`)
+		return
+	}
+	expr := req.Find
+	if !req.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if !req.Case {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+	replacement := req.Replace
+	if !req.Regex {
+		replacement = strings.ReplaceAll(replacement, "$", "$$")
+	}
+
+	count := 0
+	err = withEditLock(urlFor(fullPath), r.URL.Query().Get("token"), func() error {
+		src, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		info, err := src.Stat()
+		if err != nil {
+			return err
+		}
+		if isBinaryFile(src) {
+			return fmt.Errorf("binary file")
+		}
+
+		tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".goserve-replace-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+
+		reader := bufio.NewReader(src)
+		out := bufio.NewWriter(tmp)
+		for {
+			line, readErr := reader.ReadString('\n')
+			if line != "" {
+				body := strings.TrimSuffix(line, "\n")
+				n := len(re.FindAllStringIndex(body, -1))
+				if n > 0 {
+					count += n
+					body = re.ReplaceAllString(body, replacement)
+				}
+				out.WriteString(body)
+				if strings.HasSuffix(line, "\n") {
+					out.WriteByte('\n')
+				}
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				tmp.Close()
+				return readErr
+			}
+		}
+		if err := out.Flush(); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		os.Chmod(tmp.Name(), info.Mode())
+		src.Close()
+		return os.Rename(tmp.Name(), fullPath)
+	})
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error()})
+		return
+	}
+	if count > 0 {
+		emitFileEvent(r, "modified", fullPath, "web")
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "replaced": count})
+}
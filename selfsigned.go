@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Self-signed certificates. -tls-selfsigned serves ,tls listeners with a
+// certificate made at startup, for encrypted sharing on a LAN without a
+// CA. Browsers will warn about it, so the banner prints its SHA-256
+// fingerprint for visitors to compare. With -state the certificate is kept
+// there and reused, so the fingerprint stays the same across restarts;
+// otherwise it only lives in memory.
+
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// selfSignedCert returns a certificate for this machine's names and
+// addresses, loaded from dir if one is saved there and not about to
+// expire, otherwise newly generated (and saved to dir if it is set).
+func selfSignedCert(dir string) (tls.Certificate, error) {
+	certFile := filepath.Join(dir, "selfsigned-cert.pem")
+	keyFile := filepath.Join(dir, "selfsigned-key.pem")
+	if dir != "" {
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil &&
+			time.Until(cert.Leaf.NotAfter) > 30*24*time.Hour {
+			return cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	hostname, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "GoServe " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostname)
+	}
+	tailscale, lan := interfaceIPs()
+	tmpl.IPAddresses = append(tmpl.IPAddresses, append(tailscale, lan...)...)
+	if advertiseHost != "" {
+		if ip := net.ParseIP(advertiseHost); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, advertiseHost)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certFingerprint formats the SHA-256 fingerprint of cert's leaf the way
+// browsers show it.
+func certFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexes, ":")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login page and sessions. With -login-form, browsers that need a login get
+// a sign-in page instead of the Basic prompt, which is clumsy on phones and
+// can't be signed out of. Signing in starts a server-side session named by
+// a random ID in a signed, HttpOnly cookie; the session ends after
+// -session-expiry or at "Sign Out" in the footer menu. Sessions and the
+// signing key are kept in the -state directory, so a restart doesn't sign
+// everyone out. Basic credentials and API tokens keep working alongside,
+// and WebDAV clients and scripts still get the Basic challenge.
+
+const sessionCookie = "goserve_session"
+
+var (
+	loginForm     bool
+	sessionExpiry = 24 * time.Hour
+)
+
+type session struct {
+	User       string    `json:"user"`
+	Permission string    `json:"permission,omitempty"` // set for single sign-on users, who aren't in the logins file
+	Expires    time.Time `json:"expires"`
+
+	// An app password for a WebDAV client: only good as a password, never
+	// as a cookie, and browser sessions aren't good as passwords
+	AppPassword bool `json:"appPassword,omitempty"`
+}
+
+// sessionStore is the "sessions" state document. Sessions are keyed by the
+// SHA-256 of their ID, so the file alone can't be used to sign in.
+type sessionStore struct {
+	Key      []byte              `json:"key"`
+	Sessions map[string]*session `json:"sessions"`
+}
+
+var (
+	sessions   sessionStore
+	sessionsMu sync.Mutex
+)
+
+// loadSessions restores saved sessions, or makes a new signing key.
+func loadSessions() error {
+	if err := loadState("sessions", &sessions); err != nil {
+		return err
+	}
+	err := updateState("sessions", &sessionsMu, &sessions, func() {
+		if len(sessions.Key) < 32 {
+			sessions.Key = make([]byte, 32)
+			rand.Read(sessions.Key)
+		}
+		if sessions.Sessions == nil {
+			sessions.Sessions = map[string]*session{}
+		}
+		pruneSessions()
+	})
+	shareState("sessions", &sessionsMu, &sessions)
+	return err
+}
+
+// pruneSessions drops expired sessions. Callers hold sessionsMu.
+func pruneSessions() {
+	for k, s := range sessions.Sessions {
+		if time.Now().After(s.Expires) {
+			delete(sessions.Sessions, k)
+		}
+	}
+}
+
+// updateSessions runs change on the sessions under sessionsMu and persists
+// them.
+func updateSessions(change func()) {
+	if err := updateState("sessions", &sessionsMu, &sessions, change); err != nil {
+		log.Printf("Saving sessions: %v", err)
+	}
+}
+
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func signSessionID(id string) string {
+	mac := hmac.New(sha256.New, sessions.Key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSession records a session for user and returns its ID. permission is
+// empty for users of the logins file.
+func newSession(user, permission string, appPassword bool) (string, time.Time) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+	expires := time.Now().Add(sessionExpiry)
+
+	updateSessions(func() {
+		pruneSessions()
+		sessions.Sessions[sessionKey(id)] = &session{User: user, Permission: permission, Expires: expires, AppPassword: appPassword}
+	})
+	return id, expires
+}
+
+// startSession creates a session for user and sets its cookie on w.
+func startSession(w http.ResponseWriter, r *http.Request, user, permission string) {
+	id, expires := newSession(user, permission, false)
+	sessionsMu.Lock()
+	value := id + "." + signSessionID(id)
+	sessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionID returns the session ID from r's cookie if its signature holds.
+func sessionID(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	id, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return "", false
+	}
+	sessionsMu.Lock()
+	want := signSessionID(id)
+	sessionsMu.Unlock()
+	return id, subtle.ConstantTimeCompare([]byte(sig), []byte(want)) == 1
+}
+
+// userFromSession returns the user r's session cookie belongs to, or nil.
+func userFromSession(r *http.Request) *User {
+	id, ok := sessionID(r)
+	if !ok {
+		return nil
+	}
+	return sessionUserByID(id, false)
+}
+
+// sessionUserByID returns the user of the session id, or nil. appPassword
+// says which kind of session is wanted: a browser's or an app password.
+// Users of the logins file are looked up there each time, so removing a
+// login or changing its permission applies to open sessions too.
+func sessionUserByID(id string, appPassword bool) *User {
+	if sessions.Sessions == nil {
+		return nil
+	}
+	lookup := func() (*session, bool) {
+		sessionsMu.Lock()
+		defer sessionsMu.Unlock()
+		s, ok := sessions.Sessions[sessionKey(id)]
+		return s, ok
+	}
+	s, ok := lookup()
+	if !ok && refreshState("sessions", &sessionsMu, &sessions) {
+		s, ok = lookup()
+	}
+	if !ok || time.Now().After(s.Expires) || s.AppPassword != appPassword {
+		return nil
+	}
+	if s.Permission != "" {
+		return &User{Username: s.User, Permission: s.Permission}
+	}
+	user, ok := lookupUser(s.User)
+	if !ok {
+		return nil
+	}
+	return &user
+}
+
+// sessionUser returns the name r is signed in as through a session, for
+// showing "Sign Out" only where it does something.
+func sessionUser(r *http.Request) string {
+	if !sessionsEnabled() || !authRequired(r) {
+		return ""
+	}
+	if user := userFromSession(r); user != nil {
+		return user.Username
+	}
+	return ""
+}
+
+// wantsLoginPage reports whether an unauthenticated request came from a
+// browser, which should see the login page rather than a Basic prompt.
+// Browsers mark their requests with Sec-Fetch-Mode; WebDAV clients and
+// scripts don't.
+func wantsLoginPage(r *http.Request) bool {
+	return sessionsEnabled() && r.Header.Get("Sec-Fetch-Mode") != ""
+}
+
+// sessionsEnabled reports whether browsers sign in through a page rather
+// than the Basic prompt.
+func sessionsEnabled() bool {
+	return loginForm || oidc != nil
+}
+
+// loginPageURL is where a browser that needs to sign in is sent, coming
+// back to next afterwards.
+func loginPageURL(next string) string {
+	if loginForm {
+		return "/_login?next=" + url.QueryEscape(next)
+	}
+	return "/_oidc/login?next=" + url.QueryEscape(next)
+}
+
+const loginTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>GoServe - Sign In</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #eff1f5; color: #4c4f69; margin: 0; padding: 30px; }
+        .box { max-width: 360px; margin: 40px auto 0; background: #fff; border: 1px solid #ccd0da; border-radius: 8px; padding: 24px; }
+        h1 { font-size: 20px; margin: 0 0 16px; }
+        h1 span { color: #1e66f5; }
+        label { display: block; font-size: 13px; color: #6c6f85; margin-bottom: 12px; }
+        input { display: block; width: 100%; box-sizing: border-box; margin-top: 4px; padding: 10px; font-size: 16px; border: 1px solid #ccd0da; border-radius: 4px; }
+        .err { color: #d20f39; font-size: 14px; }
+        .sso { display: block; margin-top: 16px; text-align: center; color: #1e66f5; font-size: 14px; text-decoration: none; }
+        .btn { width: 100%; background: #1e66f5; color: #fff; border: none; border-radius: 4px; padding: 10px 16px; font-size: 15px; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        <h1>Go<span>Serve</span></h1>
+        {{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+        <form method="POST" action="/_login">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <label>Username<input name="username" value="{{.Username}}" autocomplete="username" autocapitalize="none" required autofocus></label>
+            <label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+            <button class="btn" type="submit">Sign In</button>
+        </form>
+        {{if .SSO}}<a class="sso" href="/_oidc/login?next={{.Next}}">Sign in with single sign-on</a>{{end}}
+    </div>
+</body>
+</html>`
+
+var loginTmpl = template.Must(template.New("login").Parse(loginTemplate))
+
+// localRedirect returns next if it is a path on this server, otherwise "/".
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin serves the login page and signs in on POST.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !loginForm || !authRequired(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	data := struct {
+		Next, Username, Error string
+		SSO                   bool
+	}{Next: localRedirect(r.FormValue("next")), SSO: oidc != nil}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		data.Username = r.FormValue("username")
+		user, ok := findUser(data.Username)
+		if wait := authLockedOut(r, data.Username); wait > 0 {
+			data.Error = "Too many failed sign-ins. Try again in a minute."
+			if m := int(math.Ceil(wait.Minutes())); m > 1 {
+				data.Error = fmt.Sprintf("Too many failed sign-ins. Try again in %d minutes.", m)
+			}
+			status = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+		} else if ok && checkPassword(user, r.FormValue("password")) {
+			clearAuthFailures(r, user.Username)
+			if !user.expired() {
+				startSession(w, r, user.Username, "")
+				http.Redirect(w, r, data.Next, http.StatusSeeOther)
+				return
+			}
+			data.Error = "This account expired on " + user.Expires.Format("2 Jan 2006 15:04")
+			status = http.StatusForbidden
+		} else {
+			data.Error = "Wrong username or password"
+			status = http.StatusUnauthorized
+			addMetric("goserve_login_failures_total", 1)
+			recordAuthFailure(r, data.Username)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	loginTmpl.Execute(w, data)
+}
+
+// handleLogout ends the session and returns to the login page.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id, ok := sessionID(r); ok {
+		updateSessions(func() { delete(sessions.Sessions, sessionKey(id)) })
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, loginPageURL("/"), http.StatusSeeOther)
+}
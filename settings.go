@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime settings. A few settings can be changed on a running server
+// through /api/v1/admin/settings, so fleet tooling can reconfigure
+// instances without restarting them:
+//
+//	permCeiling         the most any listener or user may do: readonly, readwrite or all
+//	maintenance         answer everyone but admins with 503 and maintenanceMessage
+//	rate, rateHeavy     as -rate and -rate-heavy
+//	chdirAllow          directories /_api/chdir may switch to (and below); empty allows any
+//
+// A changed setting overrides its command-line value until it is set to
+// null. Only signed-in users whose own permission is all, on a listener
+// that allows it, may change settings; the permission ceiling doesn't
+// apply to them, so it can always be lifted again. Every change is added
+// to an audit trail with who made it and the old and new values. Settings
+// and the trail are kept in the -state directory, shared by instances
+// that share it.
+
+type runtimeSettings struct {
+	PermCeiling        *string   `json:"permCeiling,omitempty"`
+	Maintenance        *bool     `json:"maintenance,omitempty"`
+	MaintenanceMessage *string   `json:"maintenanceMessage,omitempty"`
+	Rate               *float64  `json:"rate,omitempty"`
+	RateHeavy          *float64  `json:"rateHeavy,omitempty"`
+	ChdirAllow         *[]string `json:"chdirAllow,omitempty"`
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Setting string    `json:"setting"`
+	Old     any       `json:"old"`
+	New     any       `json:"new"`
+}
+
+// At most this many audit entries are kept.
+const maxAuditEntries = 1000
+
+const defaultMaintenanceMessage = "This server is down for maintenance. Please try again later."
+
+var (
+	settingsDoc struct {
+		Settings runtimeSettings `json:"settings"`
+		Audit    []auditEntry    `json:"audit"` // oldest first
+	}
+	settingsMu sync.Mutex
+)
+
+func loadSettings() {
+	if err := loadState("settings", &settingsDoc); err != nil {
+		log.Printf("Cannot load runtime settings: %v", err)
+	}
+	shareState("settings", &settingsMu, &settingsDoc)
+}
+
+// effectiveSettings returns every setting as it applies now, flags filled
+// in for those not overridden.
+func effectiveSettings() map[string]any {
+	settingsMu.Lock()
+	s := settingsDoc.Settings
+	settingsMu.Unlock()
+	e := map[string]any{
+		"permCeiling":        "all",
+		"maintenance":        false,
+		"maintenanceMessage": defaultMaintenanceMessage,
+		"rate":               serverInfo.rate,
+		"rateHeavy":          serverInfo.heavyRate,
+		"chdirAllow":         []string{},
+	}
+	if s.PermCeiling != nil {
+		e["permCeiling"] = *s.PermCeiling
+	}
+	if s.Maintenance != nil {
+		e["maintenance"] = *s.Maintenance
+	}
+	if s.MaintenanceMessage != nil {
+		e["maintenanceMessage"] = *s.MaintenanceMessage
+	}
+	if s.Rate != nil {
+		e["rate"] = *s.Rate
+	}
+	if s.RateHeavy != nil {
+		e["rateHeavy"] = *s.RateHeavy
+	}
+	if s.ChdirAllow != nil {
+		e["chdirAllow"] = *s.ChdirAllow
+	}
+	return e
+}
+
+// permCeiling returns the permission level no one may exceed, "all" if
+// none is set.
+func permCeiling() string {
+	if replicaOf != nil {
+		return "readonly"
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if c := settingsDoc.Settings.PermCeiling; c != nil {
+		return *c
+	}
+	return "all"
+}
+
+// maintenanceMode reports whether the server is in maintenance, and the
+// message to show.
+func maintenanceMode() (bool, string) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	s := settingsDoc.Settings
+	if s.Maintenance == nil || !*s.Maintenance {
+		return false, ""
+	}
+	if s.MaintenanceMessage != nil && *s.MaintenanceMessage != "" {
+		return true, *s.MaintenanceMessage
+	}
+	return true, defaultMaintenanceMessage
+}
+
+// currentRates returns the rate limits in force: requests per second, and
+// heavy requests per minute.
+func currentRates() (rate, heavyRate float64) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	rate, heavyRate = serverInfo.rate, serverInfo.heavyRate
+	if s := settingsDoc.Settings; s.Rate != nil {
+		rate = *s.Rate
+	}
+	if s := settingsDoc.Settings; s.RateHeavy != nil {
+		heavyRate = *s.RateHeavy
+	}
+	return rate, heavyRate
+}
+
+// chdirAllowed reports whether /_api/chdir may switch to dir.
+func chdirAllowed(dir string) bool {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	allow := settingsDoc.Settings.ChdirAllow
+	if allow == nil || len(*allow) == 0 {
+		return true
+	}
+	// Judge the directory a symlink leads to
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	for _, a := range *allow {
+		if real, err := filepath.EvalSymlinks(a); err == nil {
+			a = real
+		}
+		if isUnderDir(dir, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// settingsAdmin reports whether r may change runtime settings: a signed-in
+// user whose own permission is all, without a home folder, on a listener
+// whose level allows changes. The permission ceiling and monthly cap
+// don't apply.
+func settingsAdmin(r *http.Request) bool {
+	user := getUserFromRequest(r)
+	if user == nil || user.Permission != "all" {
+		return false
+	}
+	if _, ok := userHome(user); ok {
+		return false
+	}
+	levelModify := allowModify
+	if cfg := listenerFor(r); cfg != nil {
+		_, levelModify = levelPermissions(cfg.PermLevel)
+	}
+	return levelModify
+}
+
+// maintenanceMiddleware answers 503 during maintenance, except to admins
+// and for what they need to sign in and end it.
+func maintenanceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		on, message := maintenanceMode()
+		if !on || strings.HasPrefix(r.URL.Path, "/api/v1/admin/") || strings.HasPrefix(r.URL.Path, "/_static/") ||
+			r.URL.Path == "/_login" || r.URL.Path == "/_logout" || strings.HasPrefix(r.URL.Path, "/_oidc/") || settingsAdmin(r) {
+			next(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "300")
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.Header.Get("Accept") == "application/json" {
+			jsonError(w, http.StatusServiceUnavailable, message)
+			return
+		}
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+}
+
+// handleAdminSettings serves the settings API:
+//
+//	GET   /api/v1/admin/settings  settings in force, and which are overridden
+//	PATCH /api/v1/admin/settings  {"maintenance": true, "rate": null, ...}
+//
+// A setting given as null goes back to its command-line value.
+func handleAdminSettings(w http.ResponseWriter, r *http.Request) {
+	if !settingsAdmin(r) {
+		jsonError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "PATCH", "PUT":
+		if !patchSettings(w, r) {
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	settingsMu.Lock()
+	overridden := overriddenSettings(settingsDoc.Settings)
+	settingsMu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{"success": true, "settings": effectiveSettings(), "overridden": overridden})
+}
+
+// patchSettings applies the changes in r's body, reporting false after
+// answering with an error.
+func patchSettings(w http.ResponseWriter, r *http.Request) bool {
+	var changes map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return false
+	}
+	var next runtimeSettings
+	settingsMu.Lock()
+	next = settingsDoc.Settings
+	settingsMu.Unlock()
+	for name, raw := range changes {
+		if err := applySetting(&next, name, raw); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+	}
+
+	user, ip := requesterName(r), authClientIP(r)
+	var changed []auditEntry
+	err := updateState("settings", &settingsMu, &settingsDoc, func() {
+		before := settingValues(settingsDoc.Settings)
+		// Only the settings named in the request; another admin may have
+		// changed the others meanwhile
+		for name := range changes {
+			copySetting(&settingsDoc.Settings, next, name)
+		}
+		after := settingValues(settingsDoc.Settings)
+		now := time.Now().UTC()
+		for _, name := range slices.Sorted(maps.Keys(changes)) {
+			if !jsonEqual(before[name], after[name]) {
+				changed = append(changed, auditEntry{Time: now, User: user, IP: ip, Setting: name, Old: before[name], New: after[name]})
+			}
+		}
+		settingsDoc.Audit = append(settingsDoc.Audit, changed...)
+		if n := len(settingsDoc.Audit); n > maxAuditEntries {
+			settingsDoc.Audit = settingsDoc.Audit[n-maxAuditEntries:]
+		}
+	})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot save settings: "+err.Error())
+		return false
+	}
+	for _, e := range changed {
+		log.Printf("Setting %s changed by %s from %s: %s -> %s", e.Setting, e.User, e.IP, auditValue(e.Old), auditValue(e.New))
+	}
+	return true
+}
+
+// applySetting validates one setting from a request and sets it in s;
+// null clears it.
+func applySetting(s *runtimeSettings, name string, raw json.RawMessage) error {
+	clear := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+	switch name {
+	case "permCeiling":
+		var v string
+		if clear {
+			s.PermCeiling = nil
+		} else if json.Unmarshal(raw, &v) != nil || !validPermissions[v] {
+			return fmt.Errorf("permCeiling must be readonly, readwrite or all")
+		} else {
+			s.PermCeiling = &v
+		}
+	case "maintenance":
+		var v bool
+		if clear {
+			s.Maintenance = nil
+		} else if json.Unmarshal(raw, &v) != nil {
+			return fmt.Errorf("maintenance must be true or false")
+		} else {
+			s.Maintenance = &v
+		}
+	case "maintenanceMessage":
+		var v string
+		if clear {
+			s.MaintenanceMessage = nil
+		} else if json.Unmarshal(raw, &v) != nil || len(v) > 500 {
+			return fmt.Errorf("maintenanceMessage must be text of at most 500 bytes")
+		} else {
+			s.MaintenanceMessage = &v
+		}
+	case "rate", "rateHeavy":
+		var v float64
+		if !clear && (json.Unmarshal(raw, &v) != nil || v < 0) {
+			return fmt.Errorf("%s must be a number, 0 for unlimited", name)
+		}
+		p := &v
+		if clear {
+			p = nil
+		}
+		if name == "rate" {
+			s.Rate = p
+		} else {
+			s.RateHeavy = p
+		}
+	case "chdirAllow":
+		var v []string
+		if clear {
+			s.ChdirAllow = nil
+			break
+		}
+		if json.Unmarshal(raw, &v) != nil {
+			return fmt.Errorf("chdirAllow must be a list of directories")
+		}
+		for i, dir := range v {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("chdirAllow: %q is not an absolute path", dir)
+			}
+			v[i] = filepath.Clean(dir)
+		}
+		s.ChdirAllow = &v
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// copySetting sets the named setting of dst to its value in src.
+func copySetting(dst *runtimeSettings, src runtimeSettings, name string) {
+	switch name {
+	case "permCeiling":
+		dst.PermCeiling = src.PermCeiling
+	case "maintenance":
+		dst.Maintenance = src.Maintenance
+	case "maintenanceMessage":
+		dst.MaintenanceMessage = src.MaintenanceMessage
+	case "rate":
+		dst.Rate = src.Rate
+	case "rateHeavy":
+		dst.RateHeavy = src.RateHeavy
+	case "chdirAllow":
+		dst.ChdirAllow = src.ChdirAllow
+	}
+}
+
+// settingValues returns the overridden settings of s by name; the rest
+// are nil.
+func settingValues(s runtimeSettings) map[string]any {
+	values := map[string]any{}
+	data, _ := json.Marshal(s)
+	json.Unmarshal(data, &values)
+	return values
+}
+
+func overriddenSettings(s runtimeSettings) []string {
+	return append([]string{}, slices.Sorted(maps.Keys(settingValues(s)))...)
+}
+
+func jsonEqual(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// auditValue formats a setting's value for the log; nil is the
+// command-line value.
+func auditValue(v any) string {
+	if v == nil {
+		return "(default)"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// handleAdminAudit serves GET /api/v1/admin/audit[?limit=100]: settings
+// changes, newest first.
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if !settingsAdmin(r) {
+		jsonError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			jsonError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxAuditEntries)
+	}
+	settingsMu.Lock()
+	entries := make([]auditEntry, 0, min(limit, len(settingsDoc.Audit)))
+	for i := len(settingsDoc.Audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, settingsDoc.Audit[i])
+	}
+	settingsMu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{"success": true, "entries": entries})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Shared state for several instances behind a load balancer. With
+// -state-shared the -state directory is on disk every instance mounts (NFS,
+// SMB, a cluster filesystem); with -state-redis the documents live in Redis
+// instead. Either way, every change to a document is made under a lock
+// held across instances, on a freshly reloaded copy, so two instances
+// don't overwrite each other. Instances poll the documents they keep in
+// memory and reload those that changed, so a share link or session created
+// on one instance works on the others within statePollInterval.
+
+var stateShared bool
+
+const (
+	// A lock older than this was left by an instance that died holding it.
+	stateLockTTL      = 30 * time.Second
+	statePollInterval = 2 * time.Second
+)
+
+// updateState runs change on v, the in-memory copy of the named document
+// guarded by mu, and saves the result. With shared state, v is first
+// reloaded under the document's lock so change sees every other
+// instance's changes.
+func updateState(name string, mu *sync.Mutex, v any, change func()) error {
+	if !stateShared {
+		mu.Lock()
+		defer mu.Unlock()
+		change()
+		return saveState(name, v)
+	}
+	unlock, err := lockState(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if err := reloadState(name, v); err != nil {
+		return err
+	}
+	change()
+	return saveState(name, v)
+}
+
+// reloadState replaces v with the saved document. Decoding into v as it is
+// would keep entries another instance deleted.
+func reloadState(name string, v any) error {
+	data, err := readStateDoc(name)
+	if err != nil || data == nil {
+		return err
+	}
+	fresh := reflect.New(reflect.TypeOf(v).Elem())
+	if err := json.Unmarshal(data, fresh.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(v).Elem().Set(fresh.Elem())
+	return nil
+}
+
+// shareState keeps v, the named document guarded by mu, in step with
+// changes other instances save. It does nothing without shared state.
+func shareState(name string, mu *sync.Mutex, v any) {
+	if !stateShared {
+		return
+	}
+	go func() {
+		last, _ := stateVersion(name)
+		for range time.Tick(statePollInterval) {
+			ver, err := stateVersion(name)
+			if err != nil || ver == last {
+				continue
+			}
+			mu.Lock()
+			err = reloadState(name, v)
+			mu.Unlock()
+			if err != nil {
+				log.Printf("Reloading shared %s: %v", name, err)
+				continue
+			}
+			last = ver
+		}
+	}()
+}
+
+// stateVersion changes whenever the named document is saved.
+func stateVersion(name string) (string, error) {
+	if stateRedis != nil {
+		return stateRedis.version(redisStateKey(name))
+	}
+	info, err := os.Stat(filepath.Join(stateDir, name+".json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// lockState takes the named document's lock across instances, waiting up
+// to stateLockTTL for it, and returns the function that releases it.
+func lockState(name string) (func(), error) {
+	deadline := time.Now().Add(stateLockTTL)
+	if stateRedis != nil {
+		key, token := redisStateKey(name)+":lock", randomString(16)
+		for {
+			ok, err := stateRedis.tryLock(key, token, stateLockTTL)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return func() { stateRedis.unlock(key, token) }, nil
+			}
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("shared %s is locked by another instance", name)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	file := filepath.Join(stateDir, name+".lock")
+	for {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			host, _ := os.Hostname()
+			fmt.Fprintf(f, "%s %d\n", host, os.Getpid())
+			f.Close()
+			return func() { os.Remove(file) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > stateLockTTL {
+			log.Printf("Breaking stale lock %s", file)
+			os.Remove(file)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("shared %s is locked by another instance", name)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// refreshState reloads the named document right away when state is
+// shared, for a lookup that missed something another instance may have
+// just created. It reports whether it reloaded.
+func refreshState(name string, mu *sync.Mutex, v any) bool {
+	if !stateShared {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := reloadState(name, v); err != nil {
+		log.Printf("Reloading shared %s: %v", name, err)
+		return false
+	}
+	return true
+}
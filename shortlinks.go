@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Short links. /_s/<id> redirects to a file or folder, so deep paths can be
+// pasted into chat without turning into a wall of %20s. The link is only an
+// alias: the target is still subject to the usual login and permissions.
+// Links are kept in the -state directory next to the upload links.
+
+type shortLink struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+}
+
+var (
+	shortLinks   = map[string]*shortLink{}
+	shortLinksMu sync.Mutex
+)
+
+// shortIDChars leaves out look-alikes (0/o, 1/l) so IDs survive being read
+// aloud or retyped.
+const shortIDChars = "abcdefghijkmnpqrstuvwxyz23456789"
+
+func newShortID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = shortIDChars[int(b[i])%len(shortIDChars)]
+	}
+	return string(b)
+}
+
+func validShortID(id string, n int) bool {
+	if len(id) != n {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(shortIDChars, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadShortLinks() {
+	if err := loadState("shortlinks", &shortLinks); err != nil {
+		log.Printf("Cannot load short links: %v", err)
+	}
+	shareState("shortlinks", &shortLinksMu, &shortLinks)
+}
+
+// updateShortLinks runs change on the links under shortLinksMu and
+// persists them.
+func updateShortLinks(change func()) {
+	if err := updateState("shortlinks", &shortLinksMu, &shortLinks, change); err != nil {
+		log.Printf("Cannot save short links: %v", err)
+	}
+}
+
+// handleShortLinks serves the management API:
+//
+//	GET    /api/v1/short-links       links you created (all, for admins)
+//	POST   /api/v1/short-links       {"path": "/a/deep/folder/file.txt"}
+//	DELETE /api/v1/short-links/{id}  remove
+//
+// Creating a link for a path that already has one returns the existing link.
+func handleShortLinks(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/short-links"), "/")
+	_, admin := permissionsFor(r)
+	owner := requesterName(r)
+
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			list := []shortLink{}
+			shortLinksMu.Lock()
+			for _, l := range shortLinks {
+				if admin || l.Owner == owner {
+					list = append(list, *l)
+				}
+			}
+			shortLinksMu.Unlock()
+			sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+			writeJSON(w, map[string]any{"success": true, "links": list})
+		case "POST":
+			createShortLink(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	var l *shortLink
+	ok := false
+	find := func() {
+		l, ok = shortLinks[id]
+		ok = ok && (admin || l.Owner == owner)
+	}
+	switch r.Method {
+	case "GET":
+		shortLinksMu.Lock()
+		find()
+		shortLinksMu.Unlock()
+	case "DELETE":
+		updateShortLinks(func() {
+			if find(); ok {
+				delete(shortLinks, id)
+			}
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		jsonError(w, http.StatusNotFound, "No such link")
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, map[string]any{"success": true, "link": l})
+		return
+	}
+	writeJSON(w, map[string]any{"success": true})
+}
+
+func createShortLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	fullPath, ok := resolvePathFor(r, req.Path)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		jsonError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	target := urlFor(fullPath)
+
+	var l *shortLink
+	updateShortLinks(func() {
+		for _, existing := range shortLinks {
+			if existing.Path == target {
+				l = existing
+				return
+			}
+		}
+		l = &shortLink{Path: target, Owner: requesterName(r), Created: time.Now()}
+		for {
+			l.ID = newShortID(6)
+			if _, taken := shortLinks[l.ID]; !taken {
+				break
+			}
+		}
+		shortLinks[l.ID] = l
+	})
+	writeJSON(w, map[string]any{"success": true, "link": l, "url": "/_s/" + l.ID})
+}
+
+// handleShortLink serves /_s/<id> by redirecting to the link's target.
+func handleShortLink(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_s/"), "/")
+	var target string
+	ok := false
+	lookup := func() {
+		shortLinksMu.Lock()
+		if l, found := shortLinks[id]; found {
+			target, ok = l.Path, true
+		}
+		shortLinksMu.Unlock()
+	}
+	if lookup(); !ok && refreshState("shortlinks", &shortLinksMu, &shortLinks) {
+		lookup()
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fullPath, ok := resolvePath(target)
+	info, err := os.Stat(fullPath)
+	if !ok || err != nil {
+		http.Error(w, "The linked file no longer exists", http.StatusNotFound)
+		return
+	}
+	// A signed-in user with a home folder sees it as "/"
+	if home, ok := userHome(getUserFromRequest(r)); ok && home != "" && isUnderDir(fullPath, home) {
+		target = urlForRequest(withHome(r, home), fullPath)
+	}
+	u := (&url.URL{Path: target}).EscapedPath()
+	if info.IsDir() && !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Free disk space. The footer of a folder page says how much room is left
+// on the volume holding the folder, and turns into a warning under
+// -low-space MB; an upload bigger than what is left asks before it starts.
+// GET /api/v1/space?path=/dir reports the same for any folder, and GET
+// /api/v1/volumes lists the served folder's volume and each one mounted
+// somewhere beneath it (read from /proc/self/mountinfo, so on Linux only).
+// -disk-space=false leaves all of it out, for servers that would rather
+// not say.
+
+var (
+	showDiskSpace bool
+	lowSpaceMB    int64
+)
+
+type volumeSpace struct {
+	Path  string `json:"path"`  // folder the volume is mounted on, or was asked about
+	Free  uint64 `json:"free"`  // bytes goserve can still write
+	Total uint64 `json:"total"` // size of the volume in bytes
+	Low   bool   `json:"low"`   // free is under -low-space
+}
+
+// spaceAt returns the space on the volume holding fullPath, reported as
+// urlPath.
+func spaceAt(fullPath, urlPath string) (volumeSpace, error) {
+	free, total, err := diskSpace(fullPath)
+	if err != nil {
+		return volumeSpace{}, err
+	}
+	return volumeSpace{
+		Path:  urlPath,
+		Free:  free,
+		Total: total,
+		Low:   lowSpaceMB > 0 && free < uint64(lowSpaceMB)<<20,
+	}, nil
+}
+
+// Summary is the space as the footer shows it.
+func (v volumeSpace) Summary() string {
+	return fmt.Sprintf("%s free of %s", formatSize(int64(v.Free)), formatSize(int64(v.Total)))
+}
+
+// listingSpace returns the space for a folder page, or nil when it is
+// hidden or can't be read.
+func listingSpace(fullPath, urlPath string) *volumeSpace {
+	if !showDiskSpace {
+		return nil
+	}
+	v, err := spaceAt(fullPath, urlPath)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// mountsUnder returns the mount points strictly inside dir, sorted.
+func mountsUnder(dir string) []string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var mounts []string
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// id parent major:minor root mountpoint options ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mp := unescapeMountinfo(fields[4])
+		if mp != dir && isUnderDir(mp, dir) && !seen[mp] {
+			seen[mp] = true
+			mounts = append(mounts, mp)
+		}
+	}
+	sort.Strings(mounts)
+	return mounts
+}
+
+// unescapeMountinfo undoes the octal escapes (\040 for a space) the kernel
+// writes in mountinfo paths.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// handleSpace serves GET /api/v1/space?path=/dir.
+func handleSpace(w http.ResponseWriter, r *http.Request) {
+	if !showDiskSpace {
+		jsonError(w, http.StatusNotFound, "Disk space is not shown on this server")
+		return
+	}
+	urlPath := r.URL.Query().Get("path")
+	if urlPath == "" {
+		urlPath = "/"
+	}
+	fullPath, ok := resolvePathFor(r, urlPath)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	// A path that doesn't exist yet, such as where an upload will go, is on
+	// the volume of its nearest existing parent
+	for {
+		if _, err := os.Stat(fullPath); err == nil || fullPath == baseDirFor(r) {
+			break
+		}
+		fullPath = filepath.Dir(fullPath)
+	}
+	v, err := spaceAt(fullPath, urlForRequest(r, fullPath))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Cannot read disk space: "+err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "space": v})
+}
+
+// handleVolumes serves GET /api/v1/volumes.
+func handleVolumes(w http.ResponseWriter, r *http.Request) {
+	if !showDiskSpace {
+		jsonError(w, http.StatusNotFound, "Disk space is not shown on this server")
+		return
+	}
+	baseDir := baseDirFor(r)
+	volumes := []volumeSpace{}
+	for _, dir := range append([]string{baseDir}, mountsUnder(baseDir)...) {
+		// Pseudo filesystems such as /proc have no size
+		if v, err := spaceAt(dir, urlForRequest(r, dir)); err == nil && v.Total > 0 {
+			volumes = append(volumes, v)
+		}
+	}
+	writeJSON(w, map[string]any{"success": true, "volumes": volumes})
+}
@@ -0,0 +1,14 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// diskSpace returns the bytes available to this user and the size of the
+// volume holding path.
+func diskSpace(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = windows.GetDiskFreeSpaceEx(p, &free, &total, nil)
+	return free, total, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archive spooling. With -spool set, folder ZIP/TAR downloads are written
+// to a spool directory first and then served with Range support, so an
+// interrupted download can resume instead of regenerating the archive.
+// A ".done" marker holding the archive's SHA-256, and how many entries it
+// had to leave out, marks a complete spool file.
+
+var spoolDir string
+
+// Spooled archives not requested for this long are removed.
+const spoolTTL = 24 * time.Hour
+
+var (
+	spoolLocks   = map[string]*sync.Mutex{}
+	spoolLocksMu sync.Mutex
+)
+
+func spoolLock(key string) *sync.Mutex {
+	spoolLocksMu.Lock()
+	defer spoolLocksMu.Unlock()
+	mu, ok := spoolLocks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		spoolLocks[key] = mu
+	}
+	return mu
+}
+
+// treeFingerprint hashes the names, sizes, modes and modification times of
+// everything under root, identifying one version of an archive's contents.
+func treeFingerprint(kind, root string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", kind, root)
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00error\x00", p)
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\x00", p, info.Size(), info.Mode(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Spool files for folders bigger than this are only built by archive jobs.
+// On request, a download would wait for the whole archive before its
+// first byte, long after clients and proxies have given up, so it is
+// streamed as the folder is read instead.
+const spoolMaxOnDemand = 4 << 30
+
+// serveSpooled serves the archive of fullPath identified by key,
+// generating it with write on first request, and reports whether it did.
+// It doesn't for a folder over spoolMaxOnDemand with no spool file ready;
+// the caller streams that. name is the download file name.
+func serveSpooled(w http.ResponseWriter, r *http.Request, key, fullPath, name, contentType string, write func(io.Writer) ([]entryError, error)) bool {
+	marker := filepath.Join(spoolDir, key+filepath.Ext(name)) + ".done"
+	if _, err := os.Stat(marker); err != nil && spoolTreeSize(fullPath) > spoolMaxOnDemand {
+		return false
+	}
+	file, sum, skipped, err := ensureSpooled(key, filepath.Ext(name), write)
+	if err != nil {
+		http.Error(w, "Cannot create archive: "+err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, "Cannot open archive", http.StatusInternalServerError)
+		return true
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Cannot open archive", http.StatusInternalServerError)
+		return true
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	w.Header().Set("ETag", `"`+sum+`"`)
+	w.Header().Set("X-Checksum-SHA256", sum)
+	w.Header().Set(archiveSkippedHeader, strconv.Itoa(skipped))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+	return true
+}
+
+// spoolTreeSize returns the size of the files under fullPath, from the
+// folder size worker when it knows.
+func spoolTreeSize(fullPath string) int64 {
+	if dirSizes != nil {
+		if n, ok := dirSizes.size(fullPath); ok {
+			return n
+		}
+	}
+	return treeSize(fullPath)
+}
+
+// ensureSpooled returns the spool file for key, building it with write if
+// no complete copy exists, along with its hex SHA-256 and the number of
+// entries it left out. The marker holds both, space-separated; markers
+// from before skip counts were kept hold just the hash.
+func ensureSpooled(key, ext string, write func(io.Writer) ([]entryError, error)) (string, string, int, error) {
+	file := filepath.Join(spoolDir, key+ext)
+	marker := file + ".done"
+
+	mu := spoolLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		sum, skipped, err := buildSpoolFile(file, write)
+		if err != nil {
+			return "", "", 0, err
+		}
+		data = fmt.Appendf(nil, "%s %d", sum, len(skipped))
+		if err := os.WriteFile(marker, data, 0644); err != nil {
+			return "", "", 0, err
+		}
+	}
+	now := time.Now()
+	os.Chtimes(marker, now, now)
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", "", 0, fmt.Errorf("empty spool marker %s", marker)
+	}
+	skipped := 0
+	if len(fields) > 1 {
+		skipped, _ = strconv.Atoi(fields[1])
+	}
+	return file, fields[0], skipped, nil
+}
+
+// buildSpoolFile writes the archive to a temporary file and renames it into
+// place, returning its hex SHA-256 and the entries it left out.
+func buildSpoolFile(file string, write func(io.Writer) ([]entryError, error)) (string, []entryError, error) {
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return "", nil, err
+	}
+	cleanSpool()
+
+	tmp, err := os.CreateTemp(spoolDir, ".part-*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	skipped, err := write(io.MultiWriter(tmp, h))
+	if err != nil {
+		tmp.Close()
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), skipped, nil
+}
+
+// cleanSpool removes archives whose marker hasn't been touched within spoolTTL.
+func cleanSpool() {
+	entries, err := os.ReadDir(spoolDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".done") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < spoolTTL {
+			continue
+		}
+		marker := filepath.Join(spoolDir, e.Name())
+		os.Remove(marker)
+		os.Remove(strings.TrimSuffix(marker, ".done"))
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Persistent server metadata. Features that must survive a restart (shares,
+// tags, favorites, counters, audit logs) keep their data as JSON documents
+// in the -state directory through loadState/saveState. The export-state and
+// import-state subcommands bundle that directory together with the login
+// file, so a server can be moved to a new machine with its metadata.
+
+var stateDir string
+
+const stateArchiveVersion = 1
+
+// loadState decodes the named document into v. A missing document (or no
+// -state directory) leaves v untouched.
+func loadState(name string, v any) error {
+	data, err := readStateDoc(name)
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveState atomically replaces the named document with v. Without a
+// -state directory it does nothing.
+func saveState(name string, v any) error {
+	if stateDir == "" && stateRedis == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateDoc(name, data)
+}
+
+// readStateDoc returns the named document, or nil if there is none.
+func readStateDoc(name string) ([]byte, error) {
+	if stateRedis != nil {
+		return stateRedis.get(redisStateKey(name))
+	}
+	if stateDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(stateDir, name+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func writeStateDoc(name string, data []byte) error {
+	if stateRedis != nil {
+		return stateRedis.putVersioned(redisStateKey(name), data)
+	}
+	file := filepath.Join(stateDir, name+".json")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+type stateManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Logins  bool      `json:"logins"`
+	Files   []string  `json:"files"`
+}
+
+// runStateCommand handles "goserve export-state" and "goserve import-state".
+func runStateCommand(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.StringVar(&stateDir, "state", "", "State directory (as passed to -state)")
+	loginFile := fs.String("logins", "", "Login file to include or restore")
+	file := fs.String("f", "goserve-state.tar.gz", "Archive to write or read (- for stdout/stdin)")
+	force := fs.Bool("force", false, "import-state: overwrite existing state and login file")
+	fs.Parse(args)
+	if stateDir != "" {
+		stateDir = filepath.Clean(stateDir)
+	}
+
+	if stateDir == "" && *loginFile == "" {
+		return fmt.Errorf("nothing to do: give -state and/or -logins")
+	}
+	if cmd == "export-state" {
+		return exportState(*file, *loginFile)
+	}
+	return importState(*file, *loginFile, *force)
+}
+
+func exportState(file, loginFile string) error {
+	out := io.Writer(os.Stdout)
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	// The manifest goes first so import-state can check the version before
+	// restoring anything.
+	m := stateManifest{Version: stateArchiveVersion, Created: time.Now().UTC(), Logins: loginFile != ""}
+	var paths []string
+	if stateDir != "" {
+		err := filepath.Walk(stateDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".state-") || strings.HasSuffix(info.Name(), ".lock") {
+				return nil
+			}
+			rel, _ := filepath.Rel(stateDir, p)
+			paths = append(paths, p)
+			m.Files = append(m.Files, path.Join("state", filepath.ToSlash(rel)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if loginFile != "" {
+		paths = append(paths, loginFile)
+		m.Files = append(m.Files, "logins.txt")
+	}
+
+	data, _ := json.MarshalIndent(m, "", "  ")
+	hdr := &tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(data)), ModTime: m.Created}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for i, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if err := addTarEntry(tw, p, m.Files[i], info); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d files to %s\n", len(m.Files), file)
+	return nil
+}
+
+func importState(file, loginFile string, force bool) error {
+	in := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if !force {
+		if entries, err := os.ReadDir(stateDir); stateDir != "" && err == nil && len(entries) > 0 {
+			return fmt.Errorf("state directory %s is not empty (use -force to overwrite)", stateDir)
+		}
+		if _, err := os.Stat(loginFile); loginFile != "" && err == nil {
+			return fmt.Errorf("login file %s exists (use -force to overwrite)", loginFile)
+		}
+	}
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	restored := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var dest string
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == "manifest.json":
+			var m stateManifest
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return fmt.Errorf("bad manifest: %v", err)
+			}
+			if m.Version > stateArchiveVersion {
+				return fmt.Errorf("archive version %d is newer than this goserve supports", m.Version)
+			}
+			continue
+		case name == "logins.txt":
+			dest = loginFile
+		case strings.HasPrefix(name, "state/") && stateDir != "":
+			dest = filepath.Join(stateDir, filepath.FromSlash(strings.TrimPrefix(name, "state/")))
+			if !isUnderDir(dest, stateDir) {
+				return fmt.Errorf("invalid entry %q", hdr.Name)
+			}
+		}
+		if dest == "" {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d files from %s\n", restored, file)
+	return nil
+}
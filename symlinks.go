@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Symbolic links. isUnderDir only compares paths as they are written, so a
+// link inside the served folder could lead anywhere on the disk.
+// -follow-symlinks says which links are followed:
+//
+//	serve-within-root  links that end up inside the served folder (the
+//	                   default); for a user with a home folder, inside it
+//	serve-all          every link, as goserve always did
+//	deny               none
+//
+// A path is checked whole with filepath.EvalSymlinks, so a link to a folder
+// anywhere along it counts, and a path that doesn't exist yet (an upload, a
+// new folder) is judged by the part of it that does. A link to nothing is
+// never followed except under serve-all, since writing through it would
+// create whatever it points to. Links the policy won't follow can't be
+// opened, downloaded or changed through the web UI, the API or WebDAV, and
+// archives leave them out.
+//
+// Listings show where each link points. A link the policy won't follow is
+// listed greyed out with the reason, like an entry that can't be read.
+
+const (
+	symlinksWithinRoot = "serve-within-root"
+	symlinksAll        = "serve-all"
+	symlinksDeny       = "deny"
+)
+
+var followSymlinks = symlinksWithinRoot
+
+func validSymlinkPolicy(p string) bool {
+	return p == symlinksWithinRoot || p == symlinksAll || p == symlinksDeny
+}
+
+var (
+	errLinkDenied   = errors.New("Link, not followed on this server")
+	errLinkOutside  = errors.New("Link to outside the served folder, not followed")
+	errLinkDangling = errors.New("Broken link")
+)
+
+// reachable reports whether requests may reach fullPath: it is inside
+// baseDir both as written and once -follow-symlinks has had its say about
+// the links along it, and no ignore file leaves it out (see ignore.go).
+func reachable(fullPath, baseDir string) bool {
+	return isUnderDir(fullPath, baseDir) && symlinkError(fullPath, baseDir) == nil && !ignoredPath(fullPath)
+}
+
+// symlinkError returns why -follow-symlinks won't follow a link along
+// fullPath, a path under baseDir, or nil.
+func symlinkError(fullPath, baseDir string) error {
+	if followSymlinks == symlinksAll {
+		return nil
+	}
+	realBase, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return nil // nothing under it can be opened either
+	}
+	p := fullPath
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			rel, err := filepath.Rel(baseDir, p)
+			if err != nil {
+				return errLinkOutside
+			}
+			switch {
+			case samePath(real, filepath.Join(realBase, rel)):
+				return nil // no links along it
+			case followSymlinks == symlinksDeny:
+				return errLinkDenied
+			case !isUnderDir(real, realBase):
+				return errLinkOutside
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil // unreadable or a loop: opening it fails on its own
+		}
+		if _, err := os.Lstat(p); err == nil {
+			return errLinkDangling // p itself is a link to nothing
+		}
+		parent := filepath.Dir(p)
+		if parent == p || !isUnderDir(parent, baseDir) {
+			return nil
+		}
+		p = parent
+	}
+}
+
+// samePath compares paths as the filesystem would, ignoring case where
+// names usually do.
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// linkTarget returns where the link at fullPath points, as a listing under
+// baseDir shows it: a relative target as written, an absolute one inside
+// baseDir as a path from there, and "" for one outside it, so listings
+// don't give away the layout of the rest of the disk.
+func linkTarget(fullPath, baseDir string) string {
+	target, err := os.Readlink(fullPath)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		return filepath.ToSlash(target)
+	}
+	if !isUnderDir(target, baseDir) {
+		return ""
+	}
+	rel, _ := filepath.Rel(baseDir, target)
+	if rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// statListed stats an entry of a listing under baseDir, following a link
+// if -follow-symlinks allows, and returns where the entry points if it is
+// a link.
+func statListed(fullPath, baseDir string) (os.FileInfo, string, error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return info, "", err
+	}
+	target := linkTarget(fullPath, baseDir)
+	if err := symlinkError(fullPath, baseDir); err != nil {
+		return nil, target, err
+	}
+	info, err = os.Stat(fullPath)
+	return info, target, err
+}
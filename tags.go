@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// File tags: free-form labels attached to URL paths, persisted in the
+// -state directory. Tags follow files when they are renamed or moved and
+// are dropped when the file is deleted.
+
+var (
+	fileTags   = map[string][]string{}
+	fileTagsMu sync.Mutex
+)
+
+// addTags attaches tags to the file at urlPath.
+func addTags(urlPath string, tags ...string) error {
+	urlPath = path.Clean(urlPath)
+	return updateState("tags", &fileTagsMu, &fileTags, func() {
+		have := fileTags[urlPath]
+		for _, t := range tags {
+			if t = strings.TrimSpace(t); t != "" && !slices.Contains(have, t) {
+				have = append(have, t)
+			}
+		}
+		sort.Strings(have)
+		fileTags[urlPath] = have
+	})
+}
+
+// tagsFor returns the tags on urlPath.
+func tagsFor(urlPath string) []string {
+	fileTagsMu.Lock()
+	defer fileTagsMu.Unlock()
+	return fileTags[path.Clean(urlPath)]
+}
+
+// allTags returns every tag in use, sorted.
+func allTags() []string {
+	fileTagsMu.Lock()
+	defer fileTagsMu.Unlock()
+	var all []string
+	for _, tags := range fileTags {
+		for _, t := range tags {
+			if !slices.Contains(all, t) {
+				all = append(all, t)
+			}
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// pathsWithTag returns the URL paths tagged t, sorted.
+func pathsWithTag(t string) []string {
+	fileTagsMu.Lock()
+	defer fileTagsMu.Unlock()
+	var paths []string
+	for p, tags := range fileTags {
+		if slices.Contains(tags, t) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// startTags loads saved tags and keeps them in step with renames and deletes.
+func startTags() {
+	if err := loadState("tags", &fileTags); err != nil {
+		log.Printf("Cannot load tags: %v", err)
+	}
+	shareState("tags", &fileTagsMu, &fileTags)
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			if ev.Type == "renamed" || ev.Type == "deleted" {
+				moveTags(ev.OldPath, ev.Path, ev.Type == "deleted")
+			}
+		}
+	}()
+}
+
+// moveTags re-keys tags under a renamed path (or drops them on delete),
+// including everything beneath a renamed directory.
+func moveTags(oldPath, newPath string, deleted bool) {
+	if deleted {
+		oldPath = newPath
+	}
+	under := func(p string) bool { return p == oldPath || strings.HasPrefix(p, oldPath+"/") }
+	fileTagsMu.Lock()
+	tagged := false
+	for p := range fileTags {
+		if tagged = under(p); tagged {
+			break
+		}
+	}
+	fileTagsMu.Unlock()
+	if !tagged {
+		return
+	}
+	err := updateState("tags", &fileTagsMu, &fileTags, func() {
+		for p, tags := range fileTags {
+			if !under(p) {
+				continue
+			}
+			delete(fileTags, p)
+			if !deleted {
+				fileTags[newPath+strings.TrimPrefix(p, oldPath)] = tags
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Cannot save tags: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Tar streams. ?tar=1 is uncompressed: a fast alternative to ZIP for LAN
+// copies of already-compressed media, where deflate only burns CPU.
+// ?tar=gz and ?tar=zst compress it with gzip or Zstandard. Unlike ZIP,
+// tar keeps Unix permissions (setuid and executable bits included),
+// owners and symlinks as they are, which suits source trees going to
+// Linux and macOS users.
+
+// tarCompressions are what ?tar= takes, by the file extension and
+// Content-Type each gives.
+var tarCompressions = map[string]struct{ ext, contentType string }{
+	"":    {".tar", "application/x-tar"},
+	"gz":  {".tar.gz", "application/gzip"},
+	"zst": {".tar.zst", "application/zstd"},
+}
+
+// tarCompression reads ?tar=: "" for a plain tar, "gz" or "zst".
+func tarCompression(v string) (string, error) {
+	switch v {
+	case "1", "true":
+		return "", nil
+	case "gz", "zst":
+		return v, nil
+	}
+	return "", fmt.Errorf("Unknown tar compression %q (use tar=1, tar=gz or tar=zst)", v)
+}
+
+// addTarEntry writes one file or directory header (and file contents) to tw
+// under the slash-separated archive name. An entry that can't be read is
+// rejected with an *entryError before anything is written.
+func addTarEntry(tw *tar.Writer, fullPath, name string, info os.FileInfo) error {
+	link := ""
+	var file *os.File
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return &entryError{name, entryErrorReason(fullPath, err)}
+		}
+		link = target
+	} else if info.Mode().IsRegular() {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return &entryError{name, entryErrorReason(fullPath, err)}
+		}
+		defer f.Close()
+		file = f
+	} else if !info.IsDir() {
+		// Sockets, devices and pipes have no place in a download
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil || file == nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// addTarTree walks root and adds every entry, named relative to relBase,
+// returning the entries it couldn't read.
+func addTarTree(tw *tar.Writer, root, relBase string) ([]entryError, error) {
+	return walkArchive(root, relBase, func(p, name string, info os.FileInfo) error {
+		return addTarEntry(tw, p, name, info)
+	})
+}
+
+func handleTarDownload(w http.ResponseWriter, r *http.Request, fullPath, urlPath string) {
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.IsDir() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	compression, err := tarCompression(r.URL.Query().Get("tar"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := tarCompressions[compression]
+
+	tarName := "download" + format.ext
+	if urlPath != "/" && urlPath != "" {
+		tarName = filepath.Base(urlPath) + format.ext
+	}
+
+	// A password comes in a POST body and encrypts the stream with age
+	// (see agecrypt.go); such downloads are never spooled.
+	password := ""
+	if r.Method == "POST" {
+		password = r.PostFormValue("password")
+	}
+	if password != "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.age", tarName))
+		w.Header().Add("Trailer", archiveSkippedHeader)
+		out, finish := checksumTrailer(w)
+		enc, err := newAgeWriter(out, password)
+		if err != nil {
+			return
+		}
+		if skipped, err := writeTarTree(enc, fullPath, compression); err == nil && enc.Close() == nil {
+			setSkipped(w, skipped)
+			finish()
+		}
+		return
+	}
+
+	if spoolDir != "" && serveSpooled(w, r, treeFingerprint("tar"+compression, fullPath), fullPath, tarName, format.contentType, func(out io.Writer) ([]entryError, error) {
+		return writeTarTree(out, fullPath, compression)
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", tarName))
+	w.Header().Add("Trailer", archiveSkippedHeader)
+	out, finish := checksumTrailer(w)
+	if skipped, err := writeTarTree(out, fullPath, compression); err == nil {
+		setSkipped(w, skipped)
+		finish()
+	}
+}
+
+// writeTarTree writes a TAR of everything under fullPath to out,
+// compressed as compression says, returning the entries it couldn't read.
+func writeTarTree(out io.Writer, fullPath, compression string) ([]entryError, error) {
+	switch compression {
+	case "gz":
+		return writeTarGzTree(out, fullPath, "")
+	case "zst":
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		skipped, err := writeTarTree(zw, fullPath, "")
+		if err != nil {
+			zw.Close()
+			return skipped, err
+		}
+		return skipped, zw.Close()
+	}
+	tw := tar.NewWriter(out)
+	skipped, err := addTarTree(tw, fullPath, fullPath)
+	if err != nil {
+		tw.Close()
+		return skipped, err
+	}
+	return skipped, tw.Close()
+}
+
+func handleMultiTarDownload(w http.ResponseWriter, r *http.Request, currentDir, baseDir string) {
+	r.ParseForm()
+	filePaths := r.Form["files"]
+	if len(filePaths) == 0 {
+		http.Error(w, "No files specified", http.StatusBadRequest)
+		return
+	}
+
+	password := r.PostFormValue("password")
+	if password == "" {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", "attachment; filename=download.tar")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename=download.tar.age")
+	}
+	w.Header().Add("Trailer", archiveSkippedHeader)
+	out, finish := checksumTrailer(w)
+
+	var enc io.WriteCloser
+	if password != "" {
+		var err error
+		if enc, err = newAgeWriter(out, password); err != nil {
+			return
+		}
+		out = enc
+	}
+
+	tw := tar.NewWriter(out)
+	var skipped []entryError
+	failed := false
+	defer func() {
+		if tw.Close() == nil && !failed && (enc == nil || enc.Close() == nil) {
+			setSkipped(w, skipped)
+			finish()
+		}
+	}()
+
+	for _, fp := range filePaths {
+		// Resolve relative to current directory
+		fullPath := filepath.Join(currentDir, filepath.Base(fp))
+		if !isUnderDir(fullPath, baseDir) || ignoredPath(fullPath) {
+			continue
+		}
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			skipped = append(skipped, entryError{filepath.Base(fullPath), entryErrorReason(fullPath, err)})
+			continue
+		}
+		if info.IsDir() {
+			more, err := addTarTree(tw, fullPath, currentDir)
+			skipped = append(skipped, more...)
+			if err != nil {
+				failed = true
+				return
+			}
+		} else if err := addTarEntry(tw, fullPath, filepath.Base(fullPath), info); err != nil {
+			var skip *entryError
+			if !errors.As(err, &skip) {
+				failed = true
+				return
+			}
+			skipped = append(skipped, *skip)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Resumable tar.gz folder downloads. ?targz=1 streams a folder as tar.gz
+// with its entries in a fixed order: depth first, each folder's entries
+// sorted by name, a folder before what is in it. A client whose transfer
+// breaks off keeps the entries it received whole and asks again with
+// ?targz=1&after=<last entry>; the new stream is a complete tar.gz of the
+// entries that come after it. Because the order is by name rather than by
+// position, resuming still works when entries were added or removed
+// meanwhile. ?targz=1&manifest=1 lists the entries the archive will hold,
+// in order, with their sizes, so a client can check what it has.
+
+// tarOrderBefore reports whether entry a comes before entry b in the
+// archive order: compared a path element at a time, so a folder's
+// entries follow it directly.
+func tarOrderBefore(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// alreadySent reports whether the entry name comes no later than after,
+// the last entry a resuming client has. A folder whose entries all do is
+// skipped with filepath.SkipDir.
+func alreadySent(after, name string, info os.FileInfo) (bool, error) {
+	if after == "" || tarOrderBefore(after, name) {
+		return false, nil
+	}
+	if info.IsDir() && !strings.HasPrefix(after, name+"/") {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
+
+type targzEntry struct {
+	Name     string    `json:"name"` // a folder's ends in /
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+func handleTarGzDownload(w http.ResponseWriter, r *http.Request, fullPath, urlPath string) {
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.IsDir() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	after := strings.Trim(r.URL.Query().Get("after"), "/")
+
+	if r.URL.Query().Get("manifest") != "" {
+		entries := []targzEntry{}
+		var total int64
+		skipped, err := walkArchive(fullPath, fullPath, func(p, name string, info os.FileInfo) error {
+			if sent, err := alreadySent(after, name, info); sent {
+				return err
+			}
+			e := targzEntry{Name: name, Modified: info.ModTime().UTC()}
+			if info.IsDir() {
+				e.Name += "/"
+			} else if info.Mode().IsRegular() {
+				e.Size = info.Size()
+			} else if info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			total += e.Size
+			entries = append(entries, e)
+			return nil
+		})
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, "Cannot list folder: "+err.Error())
+			return
+		}
+		if skipped == nil {
+			skipped = []entryError{}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]any{"success": true, "entries": entries, "totalSize": total, "unreadable": skipped})
+		return
+	}
+
+	name := "download.tar.gz"
+	if urlPath != "/" && urlPath != "" {
+		name = filepath.Base(urlPath) + ".tar.gz"
+	}
+
+	if spoolDir != "" && after == "" && serveSpooled(w, r, treeFingerprint("targz", fullPath), fullPath, name, "application/gzip", func(out io.Writer) ([]entryError, error) {
+		return writeTarGzTree(out, fullPath, "")
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	w.Header().Add("Trailer", archiveSkippedHeader)
+	out, finish := checksumTrailer(w)
+	if skipped, err := writeTarGzTree(out, fullPath, after); err == nil {
+		setSkipped(w, skipped)
+		finish()
+	}
+}
+
+// writeTarGzTree writes a tar.gz of everything under fullPath that comes
+// after the entry named after (everything, if it is empty) to out,
+// returning the entries it couldn't read.
+func writeTarGzTree(out io.Writer, fullPath, after string) ([]entryError, error) {
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	skipped, err := walkArchive(fullPath, fullPath, func(p, name string, info os.FileInfo) error {
+		if sent, err := alreadySent(after, name, info); sent {
+			return err
+		}
+		return addTarEntry(tw, p, name, info)
+	})
+	if err != nil {
+		tw.Close()
+		gz.Close()
+		return skipped, err
+	}
+	if err := tw.Close(); err != nil {
+		return skipped, err
+	}
+	return skipped, gz.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// API tokens. Scripts and CI jobs can authenticate with
+// "Authorization: Bearer <token>" instead of a user's password. Tokens come
+// from the -tokens file, one per line in the same shape as the logins
+// file:
+//
+//	name:token:permission
+//
+// A request with a token acts as a user called name with that permission:
+// uploads, links and usage are attributed to name, so a token can stand in
+// for an existing login or be a separate identity. Tokens work wherever
+// Basic credentials do (the API, uploads, WebDAV and the UI) and only on
+// listeners that require a login.
+
+const minTokenLength = 16
+
+// apiTokens maps the SHA-256 of each token to the user it acts as. Looking
+// tokens up by digest keeps the comparison from leaking the token through
+// timing.
+var apiTokens = map[[32]byte]User{}
+
+func loadTokens(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	apiTokens = map[[32]byte]User{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("line %d: want name:token:permission", n+1)
+		}
+		name, token, permission := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+		if len(token) < minTokenLength {
+			return fmt.Errorf("line %d: token for %s is shorter than %d characters", n+1, name, minTokenLength)
+		}
+		apiTokens[sha256.Sum256([]byte(token))] = User{Username: name, Permission: permission}
+	}
+	if len(apiTokens) > 0 {
+		if info, err := os.Stat(filePath); err == nil && info.Mode().Perm()&0077 != 0 {
+			log.Printf("Warning: %s is readable by other users; chmod 600 it", filePath)
+		}
+	}
+	return nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// userFromToken returns the user r's bearer token acts as, or nil.
+func userFromToken(r *http.Request) *User {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil
+	}
+	user, ok := apiTokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil
+	}
+	return &user
+}
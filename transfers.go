@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer history, for chargeback and capacity planning. Every file that
+// goes up or down through the web UI or WebDAV — downloads of files and of
+// ZIP and TAR archives, uploads, WebDAV GETs and PUTs — is recorded with
+// who moved it, from which address and client, how many bytes and how
+// long it took. With -state the records are appended to one file a month,
+// transfers/YYYY-MM.jsonl in the state directory (a file per instance
+// when instances share it), and months older than -transfer-retention are
+// deleted; without it the most recent transferRingSize are kept in memory.
+//
+// GET /api/v1/transfers exports them, oldest first, as JSON or with
+// format=csv as CSV, filtered by user, direction, from and to. Admins
+// (modify permission) get everyone's; other users only their own.
+//
+// Per-month byte totals are in /api/v1/usage; this is the detail behind
+// them, request by request.
+
+type transferRecord struct {
+	Time      time.Time `json:"time"` // when the transfer started
+	User      string    `json:"user,omitempty"`
+	IP        string    `json:"ip"`
+	Client    string    `json:"client,omitempty"` // User-Agent
+	Direction string    `json:"direction"`        // upload or download
+	Path      string    `json:"path"`
+	Archive   string    `json:"archive,omitempty"` // zip, tar or targz for a folder download
+	Via       string    `json:"via"`               // web or webdav
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Millis    int64     `json:"ms"`
+}
+
+const transferRingSize = 10000
+
+var (
+	transferRetention time.Duration // 0 keeps everything
+	transfersMu       sync.Mutex
+	transferRing      []transferRecord // without -state
+	transferFile      *os.File         // this month's file, with -state
+	transferMonth     string           // the month transferFile is for
+)
+
+func transfersDir() string {
+	if stateDir == "" {
+		return ""
+	}
+	return filepath.Join(stateDir, "transfers")
+}
+
+// transferFileName is this instance's file for month.
+func transferFileName(month string) string {
+	if stateShared {
+		host, _ := os.Hostname()
+		return month + "-" + host + ".jsonl"
+	}
+	return month + ".jsonl"
+}
+
+// noteTransfer records the request r for urlPath as a transfer if it moved
+// a file: up bytes of it uploaded, or down bytes downloaded with status.
+// Handlers further in may rewrite r.URL, so the path is taken beforehand.
+func noteTransfer(r *http.Request, urlPath string, h http.Header, start time.Time, status int, up, down int64) {
+	t := transferRecord{
+		Time:   start,
+		IP:     authClientIP(r),
+		Client: r.UserAgent(),
+		Path:   urlPath,
+		Via:    "web",
+		Status: status,
+		Millis: time.Since(start).Milliseconds(),
+	}
+	if strings.HasPrefix(urlPath, "/webdav/") {
+		t.Via = "webdav"
+	}
+	q := r.URL.Query()
+	if isArchiveRequest(r) {
+		for _, kind := range []string{"zip", "zipfiles", "tar", "tarfiles", "targz"} {
+			if q.Get(kind) != "" {
+				t.Archive = strings.TrimSuffix(kind, "files")
+			}
+		}
+	}
+	switch {
+	case up > 0 && (r.Method == "PUT" || (r.Method == "POST" && q.Get("upload") != "")):
+		t.Direction, t.Bytes = "upload", up
+	case down > 0 && (status == http.StatusOK || status == http.StatusPartialContent) &&
+		// Files come with Last-Modified, pages and API answers without
+		((r.Method == "GET" && h.Get("Last-Modified") != "") || t.Archive != ""):
+		t.Direction, t.Bytes = "download", down
+	default:
+		return
+	}
+	if user := getUserFromRequest(r); user != nil {
+		t.User = user.Username
+	}
+	recordTransfer(t)
+}
+
+func recordTransfer(t transferRecord) {
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	dir := transfersDir()
+	if dir == "" {
+		if len(transferRing) >= transferRingSize {
+			// Drop the older half at once rather than shifting every time
+			transferRing = append(transferRing[:0], transferRing[transferRingSize/2:]...)
+		}
+		transferRing = append(transferRing, t)
+		return
+	}
+	month := usageMonth(t.Time)
+	if transferFile == nil || month != transferMonth {
+		if transferFile != nil {
+			transferFile.Close()
+			transferFile = nil
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Printf("Cannot record transfer: %v", err)
+			return
+		}
+		f, err := os.OpenFile(filepath.Join(dir, transferFileName(month)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Printf("Cannot record transfer: %v", err)
+			return
+		}
+		transferFile, transferMonth = f, month
+	}
+	line, _ := json.Marshal(t)
+	transferFile.Write(append(line, '\n'))
+}
+
+// pruneTransfers deletes the months that ended before -transfer-retention,
+// now and once a day.
+func pruneTransfers() {
+	prune := func() {
+		dir := transfersDir()
+		if dir == "" || transferRetention <= 0 {
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		cutoff := time.Now().Add(-transferRetention)
+		for _, e := range entries {
+			start, err := time.ParseInLocation("2006-01", e.Name()[:min(len(e.Name()), 7)], time.Local)
+			if err == nil && start.AddDate(0, 1, 0).Before(cutoff) {
+				os.Remove(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	prune()
+	go func() {
+		for range time.Tick(24 * time.Hour) {
+			prune()
+		}
+	}()
+}
+
+// scanTransfers calls fn for every recorded transfer from months that can
+// hold ones between from and to (zero for no limit), oldest month first.
+// Within a month records are in order per instance.
+func scanTransfers(from, to time.Time, fn func(transferRecord)) {
+	dir := transfersDir()
+	if dir == "" {
+		transfersMu.Lock()
+		records := append([]transferRecord(nil), transferRing...)
+		transfersMu.Unlock()
+		for _, t := range records {
+			fn(t)
+		}
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if len(name) < 7 || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		month := name[:7]
+		if (!from.IsZero() && month < usageMonth(from)) || (!to.IsZero() && month > usageMonth(to)) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var t transferRecord
+			if json.Unmarshal(scanner.Bytes(), &t) == nil {
+				fn(t)
+			}
+		}
+		f.Close()
+	}
+}
+
+// handleTransfers serves GET /api/v1/transfers[?user=][&direction=upload]
+// [&from=][&to=][&format=csv].
+func handleTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	user := q.Get("user")
+	if _, admin := permissionsFor(r); !admin {
+		self := getUserFromRequest(r)
+		if self == nil {
+			jsonError(w, http.StatusForbidden, "Permission denied")
+			return
+		}
+		if user != "" && user != self.Username {
+			jsonError(w, http.StatusForbidden, "You can only export your own transfers")
+			return
+		}
+		user = self.Username
+	}
+	direction := q.Get("direction")
+	if direction != "" && direction != "upload" && direction != "download" {
+		jsonError(w, http.StatusBadRequest, "direction must be upload or download")
+		return
+	}
+	var from, to time.Time
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := q.Get(t.name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				jsonError(w, http.StatusBadRequest, "Invalid "+t.name+": "+err.Error())
+				return
+			}
+			*t.dst = parsed
+		}
+	}
+	if transferRetention > 0 {
+		if cutoff := time.Now().Add(-transferRetention); from.Before(cutoff) {
+			from = cutoff
+		}
+	}
+	match := func(t transferRecord) bool {
+		return (user == "" || strings.EqualFold(t.User, user)) &&
+			(direction == "" || t.Direction == direction) &&
+			(from.IsZero() || !t.Time.Before(from)) &&
+			(to.IsZero() || !t.Time.After(to))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=goserve-transfers.csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "user", "ip", "client", "direction", "path", "archive", "via", "status", "bytes", "duration_ms"})
+		scanTransfers(from, to, func(t transferRecord) {
+			if match(t) {
+				cw.Write([]string{t.Time.UTC().Format(time.RFC3339), t.User, t.IP, t.Client, t.Direction, t.Path, t.Archive,
+					t.Via, strconv.Itoa(t.Status), strconv.FormatInt(t.Bytes, 10), strconv.FormatInt(t.Millis, 10)})
+			}
+		})
+		cw.Flush()
+		return
+	}
+	records := []transferRecord{}
+	var total int64
+	scanTransfers(from, to, func(t transferRecord) {
+		if match(t) {
+			records = append(records, t)
+			total += t.Bytes
+		}
+	})
+	writeJSON(w, map[string]any{"success": true, "transfers": records, "totalBytes": total})
+}
+
+// parseTransferRetention parses -transfer-retention; 0 keeps everything.
+func parseTransferRetention(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a duration such as 90d, or 0 to keep everything", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Self-update. "goserve update" fetches the latest GitHub release, checks
+// the binary against the release's SHA256SUMS (and its ed25519 signature
+// SHA256SUMS.sig when the binary was built with a release public key), and
+// atomically swaps it in place of the running executable. /_update reports
+// whether a newer release exists for the About dialog.
+
+const updateRepo = "staceyw/goserve"
+
+// updatePublicKey is the base64 ed25519 key release checksums are signed
+// with, set at build time with -ldflags "-X main.updatePublicKey=...".
+// Without it updates are verified by checksum only.
+var updatePublicKey = ""
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (rel *githubRelease) assetURL(name string) string {
+	for _, a := range rel.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+func fetchLatestRelease(ctx context.Context) (*githubRelease, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/repos/"+updateRepo+"/releases/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub releases: %s", resp.Status)
+	}
+	var rel githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// compareVersions compares "v1.4.0"-style versions numerically, returning
+// -1, 0 or 1. Missing components count as 0; pre-release suffixes are
+// ignored.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(strings.SplitN(pa[i], "-", 2)[0])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(strings.SplitN(pb[i], "-", 2)[0])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// isReleaseVersion reports whether v looks like a tagged release rather
+// than a development build.
+func isReleaseVersion(v string) bool {
+	return strings.HasPrefix(v, "v") && len(v) > 1 && v[1] >= '0' && v[1] <= '9'
+}
+
+// releaseAssetName is the binary name release.ps1 publishes for this
+// platform.
+func releaseAssetName() string {
+	name := "goserve-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func fetchAsset(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// verifyChecksums checks the SHA256SUMS signature (when a public key is
+// built in) and returns the expected hex SHA-256 for name.
+func verifyChecksums(sums, sig []byte, name string) (string, error) {
+	if updatePublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return "", fmt.Errorf("invalid built-in release key")
+		}
+		if sig == nil {
+			return "", fmt.Errorf("release has no SHA256SUMS.sig")
+		}
+		// Raw 64-byte signature, or base64 text
+		if len(sig) != ed25519.SignatureSize {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+			if err != nil {
+				return "", fmt.Errorf("malformed SHA256SUMS.sig")
+			}
+			sig = decoded
+		}
+		if !ed25519.Verify(key, sums, sig) {
+			return "", fmt.Errorf("SHA256SUMS signature does not verify")
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s not listed in SHA256SUMS", name)
+}
+
+// replaceExecutable writes data next to the running binary and renames it
+// into place. Windows can't overwrite a running executable, so the old one
+// is moved aside to .old first.
+func replaceExecutable(data []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".goserve-update-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return "", err
+		}
+		return exe, nil
+	}
+	return exe, os.Rename(tmp.Name(), exe)
+}
+
+// runUpdateCommand handles "goserve update".
+func runUpdateCommand(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	checkOnly := fs.Bool("check", false, "Only report whether an update is available")
+	force := fs.Bool("force", false, "Install even if not newer (or running a development build)")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	rel, err := fetchLatestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	newer := isReleaseVersion(version) && compareVersions(rel.TagName, version) > 0
+	fmt.Printf("Current version: %s\nLatest release:  %s\n", version, rel.TagName)
+	if *checkOnly {
+		if newer {
+			fmt.Printf("Update available: %s\n", rel.HTMLURL)
+		}
+		return nil
+	}
+	if !newer && !*force {
+		if !isReleaseVersion(version) {
+			return fmt.Errorf("development build; use -force to install %s", rel.TagName)
+		}
+		fmt.Println("Already up to date.")
+		return nil
+	}
+
+	name := releaseAssetName()
+	binURL, sumsURL := rel.assetURL(name), rel.assetURL("SHA256SUMS")
+	if binURL == "" {
+		return fmt.Errorf("release %s has no %s", rel.TagName, name)
+	}
+	if sumsURL == "" {
+		return fmt.Errorf("release %s has no SHA256SUMS; refusing to install unverified binary", rel.TagName)
+	}
+	sums, err := fetchAsset(ctx, sumsURL, 1<<20)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	if sigURL := rel.assetURL("SHA256SUMS.sig"); sigURL != "" {
+		if sig, err = fetchAsset(ctx, sigURL, 1<<10); err != nil {
+			return err
+		}
+	}
+	want, err := verifyChecksums(sums, sig, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Downloading %s ...\n", name)
+	data, err := fetchAsset(ctx, binURL, 512<<20)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+	exe, err := replaceExecutable(data)
+	if err != nil {
+		return err
+	}
+	how := "checksum"
+	if updatePublicKey != "" {
+		how = "signed checksum"
+	}
+	fmt.Printf("Updated %s to %s (%s verified). Restart goserve to use it.\n", exe, rel.TagName, how)
+	return nil
+}
+
+var (
+	latestRelease     *githubRelease
+	latestReleaseTime time.Time
+	latestReleaseMu   sync.Mutex
+)
+
+// handleUpdateCheck serves /_update: the latest release and whether it is
+// newer than this build. GitHub is asked at most every six hours.
+func handleUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	latestReleaseMu.Lock()
+	rel := latestRelease
+	if rel == nil || time.Since(latestReleaseTime) > 6*time.Hour {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		fetched, err := fetchLatestRelease(ctx)
+		cancel()
+		if err != nil {
+			latestReleaseMu.Unlock()
+			jsonError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		latestRelease, latestReleaseTime, rel = fetched, time.Now(), fetched
+	}
+	latestReleaseMu.Unlock()
+
+	writeJSON(w, map[string]any{
+		"success": true,
+		"current": version,
+		"latest":  rel.TagName,
+		"url":     rel.HTMLURL,
+		"newer":   isReleaseVersion(version) && compareVersions(rel.TagName, version) > 0,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Upload conflicts. What an upload does when a file of the same name is
+// already there is set with -upload-conflict, and a request can choose for
+// itself with ?conflict=:
+//
+//	overwrite  replace the file (the default, as before)
+//	skip       leave the file alone and don't store the upload
+//	rename     store the upload beside it as "name (1).ext"
+//
+// Uploads answered in JSON list what happened to each file, so the page
+// can say which were skipped or renamed rather than just reloading. Files
+// picked in the Merge Folder dialog are always sent with overwrite, since
+// the user chose them knowing they were there.
+
+const (
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+)
+
+var uploadConflict = conflictOverwrite
+
+func validConflictPolicy(s string) bool {
+	return s == conflictOverwrite || s == conflictSkip || s == conflictRename
+}
+
+// conflictPolicyFor returns the request's ?conflict=, or -upload-conflict.
+func conflictPolicyFor(r *http.Request) (string, error) {
+	p := r.URL.Query().Get("conflict")
+	if p == "" {
+		return uploadConflict, nil
+	}
+	if !validConflictPolicy(p) {
+		return "", fmt.Errorf("Invalid conflict policy %q (use overwrite, skip or rename)", p)
+	}
+	return p, nil
+}
+
+// uploadResult is what happened to one uploaded file.
+type uploadResult struct {
+	Name     string `json:"name"`               // as sent
+	StoredAs string `json:"storedAs,omitempty"` // if renamed
+	Status   string `json:"status"`             // created, replaced, renamed, skipped or failed
+	Error    string `json:"error,omitempty"`
+}
+
+// resolveConflict decides where an upload to destPath goes under policy.
+// It returns the path to write and the upload's status, or "" and
+// "skipped" if the upload should be dropped. A folder in the way is left
+// for the write to fail on.
+func resolveConflict(destPath, policy string) (string, string) {
+	info, err := os.Stat(destPath)
+	if err != nil || info.IsDir() {
+		return destPath, "created"
+	}
+	switch policy {
+	case conflictSkip:
+		return "", "skipped"
+	case conflictRename:
+		return uniquePath(destPath), "renamed"
+	}
+	return destPath, "replaced"
+}
+
+// summarizeUpload describes the files that weren't stored as sent, for
+// pages that can't show the results one by one.
+func summarizeUpload(results []uploadResult) string {
+	var skipped, renamed int
+	for _, res := range results {
+		switch res.Status {
+		case "skipped":
+			skipped++
+		case "renamed":
+			renamed++
+		}
+	}
+	var s string
+	if skipped > 0 {
+		s = fmt.Sprintf("%d file(s) already here were skipped.", skipped)
+	}
+	if renamed > 0 {
+		if s != "" {
+			s += " "
+		}
+		s += fmt.Sprintf("%d file(s) already here were saved under a new name.", renamed)
+	}
+	return s
+}
+
+// relUpload is the name results show for a file stored at p under dir.
+func relUpload(dir, p string) string {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return filepath.Base(p)
+	}
+	return filepath.ToSlash(rel)
+}
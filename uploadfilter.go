@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Server-wide upload filters, for servers that take uploads from people
+// who are only partly trusted. -upload-deny lists file name globs that are
+// never accepted (e.g. *.exe,*.dll,*.bat) and -upload-allow, if set, the
+// only ones that are. Globs match the file's name without its folder and
+// ignore case, so *.exe also stops SETUP.EXE; a name both allows and
+// denies is denied. They hold wherever a file can arrive: browser and API
+// uploads, pastes, upload links, WebDAV PUT, and renames or WebDAV moves
+// that would change a file's name to one the filters refuse. A folder's
+// "uploadTypes" (see dirsettings.go) narrows things further, never wider.
+
+var uploadAllow, uploadDeny []string
+
+// parseUploadGlobs splits a comma-separated glob list, lowercased.
+func parseUploadGlobs(list string) ([]string, error) {
+	var globs []string
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.ToLower(strings.TrimSpace(g)); g == "" {
+			continue
+		}
+		if strings.Contains(g, "/") {
+			return nil, fmt.Errorf("%q: globs match file names, not paths", g)
+		}
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("%q: %v", g, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+func matchUploadGlob(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadFilterError returns why the server won't take a file called name,
+// or nil if the filters let it through.
+func uploadFilterError(name string) error {
+	base := strings.ToLower(filepath.Base(filepath.FromSlash(name)))
+	if matchUploadGlob(uploadDeny, base) {
+		return fmt.Errorf("%s: files matching %s can't be uploaded to this server", filepath.Base(name), strings.Join(uploadDeny, ", "))
+	}
+	if len(uploadAllow) > 0 && !matchUploadGlob(uploadAllow, base) {
+		return fmt.Errorf("%s: only files matching %s can be uploaded to this server", filepath.Base(name), strings.Join(uploadAllow, ", "))
+	}
+	return nil
+}
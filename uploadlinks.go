@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upload-only links. A user who can upload into a folder can hand out
+// /_up/<id>, which lets anyone holding the link send files into that one
+// folder — without seeing what is already there — until the link expires or
+// its size cap is used up. Uploads never overwrite: clashing names get
+// " (n)" added. Links are kept in the -state directory. Every upload is
+// checked again against what the link's creator may do in the folder now,
+// so a link stops working when the folder turns read-only or its creator
+// loses upload permission.
+//
+// A link with a note works as a file request ("please upload the Q3 report
+// here"): it is pending until the first files arrive, and if it has a
+// notify URL the creator hears about each upload there (see notifyUpload).
+
+type uploadLink struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"` // URL path of the target folder
+	Label    string    `json:"label,omitempty"`
+	Owner    string    `json:"owner"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitzero"` // zero = never
+	MaxBytes int64     `json:"maxBytes"`         // 0 = no cap beyond -maxsize per file
+	Used     int64     `json:"used"`
+	Files    int       `json:"files"`             // 0 while a file request is pending
+	Notify   string    `json:"notify,omitempty"`  // webhook or ntfy URL told about uploads
+	Received time.Time `json:"received,omitzero"` // last upload
+}
+
+var (
+	uploadLinks   = map[string]*uploadLink{}
+	uploadLinksMu sync.Mutex
+	uploadNameMu  sync.Mutex // serializes picking a free file name
+
+	// Bytes of capped links held for uploads still being copied, by link
+	// ID, under uploadLinksMu, so uploads side by side can't overrun a cap
+	uploadLinksHeld = map[string]int64{}
+)
+
+type actingUserKey struct{}
+
+func (l *uploadLink) expired() bool {
+	return !l.Expires.IsZero() && time.Now().After(l.Expires)
+}
+
+// remaining returns the bytes the link may still accept, or -1 if uncapped.
+func (l *uploadLink) remaining() int64 {
+	if l.MaxBytes <= 0 {
+		return -1
+	}
+	return max(l.MaxBytes-l.Used, 0)
+}
+
+// ownerRequest stands for the link's creator when checking what they may
+// do where it points: signed in as them, on no particular listener, so the
+// server-wide permission level applies. A creator who isn't an account
+// here (an address on an open server, or a single sign-on user) is judged
+// by the level alone.
+func (l *uploadLink) ownerRequest() *http.Request {
+	ctx := context.Background()
+	if u, ok := lookupUser(l.Owner); ok {
+		ctx = context.WithValue(ctx, actingUserKey{}, &u)
+	}
+	r, _ := http.NewRequestWithContext(ctx, "POST", "/", nil)
+	return r
+}
+
+// ownerCanUpload reports whether the link's creator may still upload into
+// fullPath, its folder.
+func (l *uploadLink) ownerCanUpload(fullPath string) bool {
+	return capabilitiesFor(l.ownerRequest(), fullPath).Upload
+}
+
+func loadUploadLinks() {
+	if err := loadState("uploadlinks", &uploadLinks); err != nil {
+		log.Printf("Cannot load upload links: %v", err)
+	}
+	shareState("uploadlinks", &uploadLinksMu, &uploadLinks)
+}
+
+// updateUploadLinks runs change on the links under uploadLinksMu and
+// persists them.
+func updateUploadLinks(change func()) {
+	if err := updateState("uploadlinks", &uploadLinksMu, &uploadLinks, change); err != nil {
+		log.Printf("Cannot save upload links: %v", err)
+	}
+}
+
+// handleUploadLinks serves the management API:
+//
+//	GET    /api/v1/upload-links       links you created (all, for admins)
+//	POST   /api/v1/upload-links       {"path": "/in", "label": "...", "expires": "7d", "maxBytes": 1073741824}
+//	DELETE /api/v1/upload-links/{id}  revoke
+func handleUploadLinks(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/upload-links"), "/")
+	_, admin := permissionsFor(r)
+	owner := requesterName(r)
+
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			list := []uploadLink{}
+			uploadLinksMu.Lock()
+			for _, l := range uploadLinks {
+				if admin || l.Owner == owner {
+					list = append(list, *l)
+				}
+			}
+			uploadLinksMu.Unlock()
+			sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+			writeJSON(w, map[string]any{"success": true, "links": list})
+		case "POST":
+			createUploadLink(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	var l uploadLink
+	ok := false
+	find := func() {
+		if found, exists := uploadLinks[id]; exists && (admin || found.Owner == owner) {
+			l, ok = *found, true
+		}
+	}
+	switch r.Method {
+	case "GET":
+		uploadLinksMu.Lock()
+		find()
+		uploadLinksMu.Unlock()
+	case "DELETE":
+		updateUploadLinks(func() {
+			if find(); ok {
+				delete(uploadLinks, id)
+			}
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		jsonError(w, http.StatusNotFound, "No such link")
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, map[string]any{"success": true, "link": l})
+		return
+	}
+	writeJSON(w, map[string]any{"success": true})
+}
+
+func createUploadLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path     string `json:"path"`
+		Label    string `json:"label"`
+		Expires  string `json:"expires"`
+		MaxBytes int64  `json:"maxBytes"`
+		Notify   string `json:"notify"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	fullPath, ok := resolvePathFor(r, req.Path)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	if !capabilitiesFor(r, fullPath).Share {
+		deny(w, r, fullPath, "share")
+		return
+	}
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		jsonError(w, http.StatusNotFound, "Folder not found")
+		return
+	}
+	l := &uploadLink{
+		Path:     urlFor(fullPath),
+		Label:    strings.TrimSpace(req.Label),
+		Owner:    requesterName(r),
+		Created:  time.Now(),
+		MaxBytes: max(req.MaxBytes, 0),
+		Notify:   strings.TrimSpace(req.Notify),
+	}
+	if l.Notify != "" {
+		if u, err := url.Parse(l.Notify); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			jsonError(w, http.StatusBadRequest, "Notify must be an http(s) URL")
+			return
+		}
+	}
+	if req.Expires != "" {
+		d, err := parseDuration(req.Expires)
+		if err != nil || d <= 0 {
+			jsonError(w, http.StatusBadRequest, "Invalid expiry")
+			return
+		}
+		l.Expires = l.Created.Add(d)
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	l.ID = hex.EncodeToString(b)
+
+	updateUploadLinks(func() { uploadLinks[l.ID] = l })
+	writeJSON(w, map[string]any{"success": true, "link": l, "url": "/_up/" + l.ID})
+}
+
+const uploadLinkTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{with .Brand.Title}}{{.}}{{else}}GoServe{{end}} - Send Files</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #eff1f5; color: #4c4f69; margin: 0; padding: 30px; }
+        .box { max-width: 560px; margin: 0 auto; background: #fff; border: 1px solid #ccd0da; border-radius: 8px; padding: 24px; }
+        h1 { font-size: 20px; margin: 0 0 6px; }
+        h1 span { color: {{.Accent}}; }
+        .logo { display: block; max-height: 48px; max-width: 200px; margin-bottom: 12px; }
+        p { color: #6c6f85; font-size: 14px; }
+        .ok { color: #40a02b; }
+        .err { color: #d20f39; }
+        .btn { background: {{.Accent}}; color: #fff; border: none; border-radius: 4px; padding: 8px 16px; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        {{with .Brand.Logo}}<img class="logo" src="{{.}}" alt="">{{end}}
+        <h1>{{with .Brand.Title}}{{.}}{{else}}Go<span>Serve</span>{{end}} — Send Files</h1>
+        <p>{{if .Label}}<strong>{{.Label}}</strong><br>{{end}}Files you upload go to <strong>{{.Folder}}</strong>. You won't be able to see other files there.</p>
+        {{if .Message}}<p class="{{if .Error}}err{{else}}ok{{end}}">{{.Message}}</p>{{end}}
+        {{if .Closed}}
+        <p class="err">{{.Closed}}</p>
+        {{else}}
+        <form method="POST" action="?upload=1" enctype="multipart/form-data">
+            <input type="file" name="files" multiple required>
+            <button class="btn" type="submit">Upload</button>
+        </form>
+        <p>{{with .Types}}Only {{.}}, up{{else}}Up{{end}} to {{.MaxFile}} per file{{if .Remaining}}, {{.Remaining}} left in total{{end}}{{if .Expires}}. Link expires {{.Expires}}{{end}}.</p>
+        {{end}}
+    </div>
+</body>
+</html>`
+
+var uploadLinkTmpl = template.Must(template.New("uploadlink").Parse(uploadLinkTemplate))
+
+var errUploadCap = errors.New("upload limit reached")
+
+// capReader fails once more than limit bytes have been read (limit < 0
+// means unlimited).
+type capReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.limit >= 0 && c.n > c.limit {
+		return n, errUploadCap
+	}
+	return n, err
+}
+
+// handleUploadLink serves /_up/<id>: the upload page and its form posts.
+func handleUploadLink(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_up/"), "/")
+	var link uploadLink
+	ok := false
+	lookup := func() {
+		uploadLinksMu.Lock()
+		if l, found := uploadLinks[id]; found {
+			link, ok = *l, true
+		}
+		uploadLinksMu.Unlock()
+	}
+	if lookup(); !ok && refreshState("uploadlinks", &uploadLinksMu, &uploadLinks) {
+		lookup()
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := map[string]any{
+		"Label":  link.Label,
+		"Folder": path.Base(link.Path),
+	}
+	if link.Path == "/" {
+		page["Folder"] = "the shared folder"
+	}
+	fullPath, ok := resolvePath(link.Path)
+	policy := uploadPolicyFor(fullPath)
+	page["MaxFile"] = formatSize(policy.limit())
+	if len(policy.Types) > 0 {
+		page["Types"] = strings.Join(policy.Types[0], ", ")
+	}
+	info, err := os.Stat(fullPath)
+	page["Brand"], page["Accent"] = branding{}, "#1e66f5"
+	if ok && err == nil {
+		brand := brandingFor(fullPath)
+		page["Brand"] = brand
+		if brand.Accent != "" {
+			page["Accent"] = brand.Accent
+		}
+	}
+	switch {
+	case link.expired():
+		page["Closed"] = "This upload link has expired."
+	case link.remaining() == 0:
+		page["Closed"] = "This upload link has reached its size limit."
+	case !ok || err != nil || !info.IsDir():
+		page["Closed"] = "The destination folder no longer exists."
+	case !link.ownerCanUpload(fullPath):
+		page["Closed"] = "The destination folder no longer accepts uploads."
+	}
+	if rem := link.remaining(); rem > 0 {
+		page["Remaining"] = formatSize(rem)
+	}
+	if !link.Expires.IsZero() {
+		page["Expires"] = link.Expires.Format("2006-01-02 15:04")
+	}
+
+	if r.Method == "POST" && r.URL.Query().Get("upload") != "" && page["Closed"] == nil {
+		names, err := receiveLinkUpload(r, id, fullPath)
+		n := len(names)
+		if n > 0 {
+			go notifyUpload(link, names)
+		}
+		switch {
+		case err != nil && n > 0:
+			page["Message"], page["Error"] = fmt.Sprintf("%d files received; the rest failed: %v", n, err), true
+		case err != nil:
+			page["Message"], page["Error"] = "Upload failed: "+err.Error(), true
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			page["Message"] = fmt.Sprintf("Thank you — %d files received.", n)
+		}
+		// Refresh quota shown on the page
+		uploadLinksMu.Lock()
+		if l, ok := uploadLinks[id]; ok {
+			if rem := l.remaining(); rem > 0 {
+				page["Remaining"] = formatSize(rem)
+			} else if rem == 0 {
+				page["Closed"] = "This upload link has reached its size limit."
+			}
+		}
+		uploadLinksMu.Unlock()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	uploadLinkTmpl.Execute(w, page)
+}
+
+// receiveLinkUpload streams the "files" parts of a multipart upload into
+// dir, charging their size to link id. It returns the names of the files
+// saved.
+func receiveLinkUpload(r *http.Request, id, dir string) ([]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	// A capped link holds what it has left, or just the request's size when
+	// that is known, until the files are copied and charged
+	uploadLinksMu.Lock()
+	held := int64(-1)
+	if l, ok := uploadLinks[id]; ok && l.MaxBytes > 0 {
+		held = max(l.MaxBytes-l.Used-uploadLinksHeld[id], 0)
+		if r.ContentLength >= 0 {
+			held = min(held, r.ContentLength)
+		}
+		uploadLinksHeld[id] += held
+	}
+	uploadLinksMu.Unlock()
+	defer func() {
+		if held > 0 {
+			uploadLinksMu.Lock()
+			if uploadLinksHeld[id] -= held; uploadLinksHeld[id] <= 0 {
+				delete(uploadLinksHeld, id)
+			}
+			uploadLinksMu.Unlock()
+		}
+	}()
+
+	var saved []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return saved, nil
+		}
+		if err != nil {
+			return saved, err
+		}
+		if part.FormName() != "files" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		name := filepath.Base(filepath.Clean("/" + filepath.FromSlash(part.FileName())))
+		if name == string(filepath.Separator) || name == "." {
+			part.Close()
+			continue
+		}
+
+		uploadLinksMu.Lock()
+		l, ok := uploadLinks[id]
+		var link uploadLink
+		if ok {
+			link = *l
+		}
+		uploadLinksMu.Unlock()
+		limit := held
+		if !ok || link.expired() || limit == 0 || !link.ownerCanUpload(dir) {
+			part.Close()
+			return saved, fmt.Errorf("this link is no longer accepting files")
+		}
+		// Link holders can't set rules for the folder or add what the
+		// rest of the server wouldn't reach
+		if isSettingsFile(name) || !reachable(filepath.Join(dir, name), getBaseDir()) {
+			part.Close()
+			return saved, fmt.Errorf("%s can't be uploaded here", name)
+		}
+		policy := uploadPolicyFor(filepath.Join(dir, name))
+		if !policy.allowsType(name) {
+			part.Close()
+			return saved, policy.check(name, -1, 0)
+		}
+		if limit < 0 || limit > policy.limit() {
+			limit = policy.limit()
+		}
+		if err := quotaError(r, filepath.Join(dir, name), -1, 0); err != nil {
+			part.Close()
+			return saved, err
+		}
+
+		src := &capReader{r: part, limit: limit}
+		dest, err := saveLinkFile(src, dir, name)
+		part.Close()
+		if err == errUploadCap {
+			return saved, fmt.Errorf("%s is larger than the allowed %s", name, formatSize(limit))
+		}
+		if err != nil {
+			return saved, err
+		}
+
+		// The bytes are charged, so no longer held
+		updateUploadLinks(func() {
+			if l, ok := uploadLinks[id]; ok {
+				l.Used += src.n
+				l.Files++
+				l.Received = time.Now()
+			}
+			if held > 0 {
+				held -= src.n
+				if uploadLinksHeld[id] -= src.n; uploadLinksHeld[id] <= 0 {
+					delete(uploadLinksHeld, id)
+				}
+			}
+		})
+		addUsage("share:"+id, src.n, 0)
+		publishEvent(fileEvent{Type: "created", Path: urlFor(dest), User: "upload link " + id[:6], Source: "web"})
+		saved = append(saved, filepath.Base(dest))
+	}
+}
+
+// saveLinkFile writes src to a new file named name in dir, never replacing
+// an existing file, and returns its path.
+func saveLinkFile(src io.Reader, dir, name string) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	os.Chmod(tmp.Name(), 0644)
+
+	uploadNameMu.Lock()
+	defer uploadNameMu.Unlock()
+	dest := uniquePath(filepath.Join(dir, name))
+	if dedupDir != "" {
+		f, err := os.Open(tmp.Name())
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		_, err = storeDeduplicated(f, dest)
+		return dest, err
+	}
+	return dest, os.Rename(tmp.Name(), dest)
+}
+
+// notifyUpload tells link's notify URL that files arrived.
+func notifyUpload(link uploadLink, names []string) {
+	if link.Notify == "" {
+		return
+	}
+	what := link.Label
+	if what == "" {
+		what = link.Path
+	}
+	msg := fmt.Sprintf("%d file(s) received in %s: %s", len(names), link.Path, strings.Join(names, ", "))
+	err := sendNotification(link.Notify, "Upload: "+what, "inbox_tray", msg, map[string]any{
+		"event": "upload",
+		"link":  link.ID,
+		"label": link.Label,
+		"path":  link.Path,
+		"files": names,
+	})
+	if err != nil {
+		log.Printf("Upload link %s notify: %v", link.ID[:6], err)
+	}
+}
+
+// sendNotification posts to a notify URL: ntfy servers (hosts ntfy.sh or
+// ntfy.*) get msg as a readable push message with title and tags; anything
+// else gets payload as a JSON webhook.
+func sendNotification(target, title, tags, msg string, payload any) error {
+	var req *http.Request
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if host := u.Hostname(); host == "ntfy.sh" || strings.HasPrefix(host, "ntfy.") {
+		req, _ = http.NewRequest("POST", target, strings.NewReader(msg))
+		req.Header.Set("Title", title)
+		req.Header.Set("Tags", tags)
+	} else {
+		body, _ := json.Marshal(payload)
+		req, _ = http.NewRequest("POST", target, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Link holders can't drop files that set rules for the folder.
+func TestReceiveLinkUploadRefusesSettingsFiles(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "in")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldBase, oldUpload, oldMax := getBaseDir(), allowUpload, maxUploadSize
+	setBaseDir(base)
+	allowUpload, maxUploadSize = true, 1<<20
+	defer func() { setBaseDir(oldBase); allowUpload, maxUploadSize = oldUpload, oldMax }()
+
+	const id = "0123456789abcdef01234567"
+	uploadLinksMu.Lock()
+	uploadLinks[id] = &uploadLink{ID: id, Path: "/in", Owner: "127.0.0.1"}
+	uploadLinksMu.Unlock()
+	defer func() {
+		uploadLinksMu.Lock()
+		delete(uploadLinks, id)
+		uploadLinksMu.Unlock()
+	}()
+
+	for _, name := range []string{ignoreFileName, dirSettingsFile, "ok.txt"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("files", name)
+		fw.Write([]byte(`{"maxUploadMB": 100000}`))
+		mw.Close()
+		r := httptest.NewRequest("POST", "/_up/"+id+"?upload=1", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+
+		saved, err := receiveLinkUpload(r, id, dir)
+		_, statErr := os.Stat(filepath.Join(dir, name))
+		if name == "ok.txt" {
+			if err != nil || len(saved) != 1 || statErr != nil {
+				t.Errorf("%s: saved %v, %v; want it saved", name, saved, err)
+			}
+			continue
+		}
+		if err == nil || len(saved) != 0 || statErr == nil {
+			t.Errorf("%s: saved %v, %v; want it refused", name, saved, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth accounting. Bytes uploaded and downloaded are counted per
+// calendar month for each user (or client IP without auth) and each share
+// link, persisted in the -state directory, and exposed at /api/v1/usage.
+// With -monthly-cap, a user whose transfer for the month exceeds the cap is
+// switched to read-only until the month ends.
+
+type usageCounter struct {
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// Months of history kept.
+const usageRetention = 13
+
+var (
+	usageMu    sync.Mutex
+	usage      = map[string]map[string]*usageCounter{} // month -> "user:alice" / "ip:…" / "share:id" -> counter
+	usageDirty bool
+	monthlyCap int64 // bytes; 0 = no cap
+)
+
+// With shared state, the bytes counted here since the last save, which are
+// added to the totals other instances have saved meanwhile.
+var usagePending = map[string]map[string]*usageCounter{}
+
+func usageMonth(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// requesterUsageKey names who a request is billed to.
+func requesterUsageKey(r *http.Request) string {
+	if user := getUserFromRequest(r); user != nil {
+		return "user:" + user.Username
+	}
+	return "ip:" + requesterName(r)
+}
+
+// addUsage adds transferred bytes to key for the current month.
+func addUsage(key string, up, down int64) {
+	if up == 0 && down == 0 {
+		return
+	}
+	month := usageMonth(time.Now())
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	c := usageCounterFor(usage, month, key)
+	c.Up += up
+	c.Down += down
+	if stateShared {
+		c := usageCounterFor(usagePending, month, key)
+		c.Up += up
+		c.Down += down
+	}
+	usageDirty = true
+}
+
+// usageCounterFor returns the counter for key in month, adding it to
+// counters if needed.
+func usageCounterFor(counters map[string]map[string]*usageCounter, month, key string) *usageCounter {
+	m, ok := counters[month]
+	if !ok {
+		m = map[string]*usageCounter{}
+		counters[month] = m
+	}
+	c, ok := m[key]
+	if !ok {
+		c = &usageCounter{}
+		m[key] = c
+	}
+	return c
+}
+
+// overMonthlyCap reports whether the requester has used up this month's cap.
+func overMonthlyCap(r *http.Request) bool {
+	if monthlyCap <= 0 {
+		return false
+	}
+	key := requesterUsageKey(r)
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	c, ok := usage[usageMonth(time.Now())][key]
+	return ok && c.Up+c.Down >= monthlyCap
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n      int64
+	status int
+}
+
+func (w *countingResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+// usageMiddleware counts request and response body bytes for the requester.
+func usageMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, urlPath := time.Now(), r.URL.Path
+		cw := &countingResponseWriter{ResponseWriter: w}
+		var body *countingReader
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		next(cw, r)
+		var up int64
+		if body != nil {
+			up = body.n
+		}
+		addUsage(requesterUsageKey(r), up, cw.n)
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		noteTransfer(r, urlPath, cw.Header(), start, cw.status, up, cw.n)
+	}
+}
+
+// loadUsage restores counters saved in the state directory and starts
+// saving them once a minute.
+func loadUsage() {
+	saved := map[string]map[string]*usageCounter{}
+	if err := loadState("usage", &saved); err != nil {
+		log.Printf("Cannot load usage counters: %v", err)
+	}
+	usageMu.Lock()
+	usage = saved
+	usageMu.Unlock()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			saveUsage()
+		}
+	}()
+}
+
+func saveUsage() {
+	cutoff := usageMonth(time.Now().AddDate(0, -usageRetention, 0))
+	if stateShared {
+		saveSharedUsage(cutoff)
+		return
+	}
+	usageMu.Lock()
+	if !usageDirty {
+		usageMu.Unlock()
+		return
+	}
+	usageDirty = false
+	snapshot := map[string]map[string]usageCounter{}
+	for month, m := range usage {
+		if month < cutoff {
+			delete(usage, month)
+			continue
+		}
+		snapshot[month] = map[string]usageCounter{}
+		for k, c := range m {
+			snapshot[month][k] = *c
+		}
+	}
+	usageMu.Unlock()
+
+	if err := saveState("usage", snapshot); err != nil {
+		log.Printf("Cannot save usage counters: %v", err)
+	}
+}
+
+// saveSharedUsage adds this instance's pending counts to the saved totals,
+// and picks up the other instances' counts for -monthly-cap. It runs every
+// minute even when nothing was counted here, for the latter.
+func saveSharedUsage(cutoff string) {
+	err := updateState("usage", &usageMu, &usage, func() {
+		if usage == nil {
+			usage = map[string]map[string]*usageCounter{}
+		}
+		for month, m := range usagePending {
+			for k, d := range m {
+				c := usageCounterFor(usage, month, k)
+				c.Up += d.Up
+				c.Down += d.Down
+			}
+		}
+		usagePending = map[string]map[string]*usageCounter{}
+		usageDirty = false
+		for month := range usage {
+			if month < cutoff {
+				delete(usage, month)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Cannot save usage counters: %v", err)
+	}
+}
+
+type usageRow struct {
+	Month string `json:"month"`
+	Key   string `json:"key"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+}
+
+// handleUsage serves /api/v1/usage[?month=2024-05][&format=csv]. Admins
+// (modify permission) see everyone; others only their own usage.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	_, admin := permissionsFor(r)
+	self := requesterUsageKey(r)
+	month := r.URL.Query().Get("month")
+
+	var rows []usageRow
+	usageMu.Lock()
+	for m, counters := range usage {
+		if month != "" && m != month {
+			continue
+		}
+		for k, c := range counters {
+			if admin || k == self {
+				rows = append(rows, usageRow{Month: m, Key: k, Up: c.Up, Down: c.Down})
+			}
+		}
+	}
+	usageMu.Unlock()
+	sort.Slice(rows, func(a, b int) bool {
+		if rows[a].Month != rows[b].Month {
+			return rows[a].Month > rows[b].Month
+		}
+		return rows[a].Up+rows[a].Down > rows[b].Up+rows[b].Down
+	})
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=goserve-usage.csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"month", "kind", "name", "uploaded_bytes", "downloaded_bytes"})
+		for _, row := range rows {
+			kind, name, _ := strings.Cut(row.Key, ":")
+			cw.Write([]string{row.Month, kind, name, strconv.FormatInt(row.Up, 10), strconv.FormatInt(row.Down, 10)})
+		}
+		cw.Flush()
+		return
+	}
+	if rows == nil {
+		rows = []usageRow{}
+	}
+	writeJSON(w, map[string]any{
+		"success":    true,
+		"usage":      rows,
+		"monthlyCap": monthlyCap,
+		"overCap":    overMonthlyCap(r),
+	})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Virtual views. /_views/ is a read-only WebDAV tree of collections that
+// don't exist on disk, so other tools can mount them:
+//
+//	/_views/recent/         files changed in the last week, newest first
+//	/_views/tags/           a folder per tag
+//	/_views/tags/<tag>/     everything with that tag
+//	/_views/search/<text>/  files whose name contains text, or matches a
+//	                        glob such as *.pdf
+//
+// Entries are the real files, flattened into one folder; when names clash
+// the later ones become "name (2).ext" and so on. Tagged folders can be
+// browsed into. Views skip -search-exclude paths and are rebuilt at most
+// every viewCacheTTL, so a mounted view doesn't walk the tree on every
+// request.
+
+const (
+	viewRecentAge  = 7 * 24 * time.Hour
+	viewMaxEntries = 500
+	viewMaxScan    = 200000 // entries walked before a view gives up
+	viewCacheTTL   = 30 * time.Second
+)
+
+// viewEntry is one item of a view: its name there and the URL path of the
+// real file or folder.
+type viewEntry struct {
+	Name string
+	Path string
+}
+
+type cachedView struct {
+	entries []viewEntry
+	built   time.Time
+}
+
+var (
+	viewCache   = map[string]cachedView{}
+	viewCacheMu sync.Mutex
+)
+
+// viewEntries returns the entries of the collection key ("recent",
+// "tags/<tag>" or "search/<text>"), or false if there is no such view.
+func viewEntries(key string) ([]viewEntry, bool) {
+	kind, arg, _ := strings.Cut(key, "/")
+	var build func() []viewEntry
+	switch {
+	case kind == "recent" && arg == "":
+		build = recentView
+	case kind == "tags" && arg != "":
+		build = func() []viewEntry { return tagView(arg) }
+	case kind == "search" && arg != "":
+		build = func() []viewEntry { return searchView(arg) }
+	default:
+		return nil, false
+	}
+
+	viewCacheMu.Lock()
+	c, ok := viewCache[key]
+	viewCacheMu.Unlock()
+	if ok && time.Since(c.built) < viewCacheTTL {
+		return c.entries, true
+	}
+	entries := uniqueViewNames(build())
+	viewCacheMu.Lock()
+	for k, v := range viewCache {
+		if time.Since(v.built) >= viewCacheTTL {
+			delete(viewCache, k)
+		}
+	}
+	viewCache[key] = cachedView{entries, time.Now()}
+	viewCacheMu.Unlock()
+	return entries, true
+}
+
+// uniqueViewNames gives every entry a distinct name, numbering clashes.
+func uniqueViewNames(entries []viewEntry) []viewEntry {
+	seen := map[string]bool{}
+	for i, e := range entries {
+		name := path.Base(e.Path)
+		ext := path.Ext(name)
+		for n := 2; seen[strings.ToLower(name)]; n++ {
+			name = strings.TrimSuffix(path.Base(e.Path), ext) + " (" + strconv.Itoa(n) + ")" + ext
+		}
+		seen[strings.ToLower(name)] = true
+		entries[i].Name = name
+	}
+	return entries
+}
+
+// walkForView calls fn for each file under the base directory, skipping
+// excluded and ignored paths and folder settings, until fn returns false
+// or the scan limit is reached.
+func walkForView(fn func(urlPath string, fi os.FileInfo) bool) {
+	baseDir := getBaseDir()
+	scanned := 0
+	ignore := ignoreWalker{}
+	filepath.Walk(baseDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == baseDir {
+			return nil
+		}
+		if scanned++; scanned > viewMaxScan {
+			return filepath.SkipAll
+		}
+		urlPath := urlFor(p)
+		if searchExcluded(urlPath) || ignore.skip(p, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || fi.Name() == dirSettingsFile {
+			return nil
+		}
+		if !fn(urlPath, fi) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+func recentView() []viewEntry {
+	type recent struct {
+		path string
+		mod  time.Time
+	}
+	var found []recent
+	since := time.Now().Add(-viewRecentAge)
+	walkForView(func(urlPath string, fi os.FileInfo) bool {
+		if fi.ModTime().After(since) {
+			found = append(found, recent{urlPath, fi.ModTime()})
+		}
+		return true
+	})
+	sort.Slice(found, func(a, b int) bool { return found[a].mod.After(found[b].mod) })
+	entries := []viewEntry{}
+	for _, f := range found[:min(len(found), viewMaxEntries)] {
+		entries = append(entries, viewEntry{Path: f.path})
+	}
+	return entries
+}
+
+func tagView(tag string) []viewEntry {
+	entries := []viewEntry{}
+	for _, p := range pathsWithTag(tag) {
+		if len(entries) == viewMaxEntries {
+			break
+		}
+		entries = append(entries, viewEntry{Path: p})
+	}
+	return entries
+}
+
+// searchView matches file names: a glob if text has wildcards, otherwise
+// a case-insensitive substring.
+func searchView(text string) []viewEntry {
+	match := func(urlPath string) bool {
+		return strings.Contains(strings.ToLower(path.Base(urlPath)), strings.ToLower(text))
+	}
+	if strings.ContainsAny(text, "*?") {
+		re, err := compileGlob(text)
+		if err != nil {
+			return []viewEntry{}
+		}
+		match = re.MatchString
+	}
+	entries := []viewEntry{}
+	walkForView(func(urlPath string, fi os.FileInfo) bool {
+		if match(urlPath) {
+			entries = append(entries, viewEntry{Path: urlPath})
+		}
+		return len(entries) < viewMaxEntries
+	})
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Path < entries[b].Path })
+	return entries
+}
+
+// viewFS serves the views as a webdav.FileSystem. Everything is read-only.
+type viewFS struct{}
+
+func (viewFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (viewFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (viewFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (viewFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	dir, realPath, err := resolveView(name)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		return dir, nil
+	}
+	return os.Open(realPath)
+}
+
+func (viewFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, realPath, err := resolveView(name)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		return dir.Stat()
+	}
+	fi, err := os.Stat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	return renamedInfo{fi, path.Base(name)}, nil
+}
+
+// resolveView maps a path inside /_views/ to either a virtual folder or
+// the real file it stands for.
+func resolveView(name string) (*viewDir, string, error) {
+	name = path.Clean("/" + name)
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+	switch {
+	case len(parts) == 0:
+		return newViewDir("/", []os.FileInfo{virtualDir("recent"), virtualDir("search"), virtualDir("tags")}), "", nil
+	case len(parts) == 1 && parts[0] == "search":
+		return newViewDir("search", nil), "", nil
+	case len(parts) == 1 && parts[0] == "tags":
+		var children []os.FileInfo
+		for _, t := range allTags() {
+			if !strings.Contains(t, "/") {
+				children = append(children, virtualDir(t))
+			}
+		}
+		return newViewDir("tags", children), "", nil
+	}
+
+	// The collection key is "recent" or "<kind>/<arg>"; the next part names
+	// an entry and the rest is a path inside it
+	n := 2
+	if parts[0] == "recent" {
+		n = 1
+	}
+	if len(parts) < n {
+		return nil, "", os.ErrNotExist
+	}
+	entries, ok := viewEntries(strings.Join(parts[:n], "/"))
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	if len(parts) == n {
+		var children []os.FileInfo
+		for _, e := range entries {
+			if fullPath, ok := resolvePath(e.Path); ok {
+				if fi, err := os.Stat(fullPath); err == nil {
+					children = append(children, renamedInfo{fi, e.Name})
+				}
+			}
+		}
+		return newViewDir(parts[n-1], children), "", nil
+	}
+	for _, e := range entries {
+		if e.Name == parts[n] {
+			fullPath, ok := resolvePath(path.Join(append([]string{e.Path}, parts[n+1:]...)...))
+			if !ok {
+				return nil, "", os.ErrPermission
+			}
+			return nil, fullPath, nil
+		}
+	}
+	return nil, "", os.ErrNotExist
+}
+
+// viewDir is an open virtual folder.
+type viewDir struct {
+	name     string
+	children []os.FileInfo
+	pos      int
+}
+
+func newViewDir(name string, children []os.FileInfo) *viewDir {
+	return &viewDir{name: name, children: children}
+}
+
+func (d *viewDir) Close() error                                 { return nil }
+func (d *viewDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *viewDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *viewDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *viewDir) Stat() (os.FileInfo, error)                   { return virtualDir(d.name), nil }
+
+func (d *viewDir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.children[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.children)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(rest))
+	d.pos += n
+	return rest[:n], nil
+}
+
+// virtualDir describes a folder that only exists in the views.
+type virtualDir string
+
+func (v virtualDir) Name() string       { return string(v) }
+func (v virtualDir) Size() int64        { return 0 }
+func (v virtualDir) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (v virtualDir) ModTime() time.Time { return serverInfo.started }
+func (v virtualDir) IsDir() bool        { return true }
+func (v virtualDir) Sys() any           { return nil }
+
+// renamedInfo is a real file listed under its name in a view.
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
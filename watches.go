@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watched folders. A user can watch a folder, such as a release or drop
+// directory, and follow just the changes under it: the last watchMaxEvents
+// file events are kept with the watch and served as an RSS or JSON Feed at
+// /_feed/<token>.rss or .json, and each can also be posted to a webhook or
+// ntfy topic as it happens. The token in the feed URL is the only key, so a
+// feed reader can poll it without signing in; removing the watch revokes
+// it. Watches are kept in the -state directory.
+
+// At most this many events are kept per watch.
+const watchMaxEvents = 100
+
+type folderWatch struct {
+	ID      string      `json:"id"`
+	Path    string      `json:"path"` // URL path of the folder, from the base dir
+	Home    string      `json:"home,omitempty"`
+	Owner   string      `json:"owner"`
+	Token   string      `json:"token"`
+	Webhook string      `json:"webhook,omitempty"` // webhook or ntfy URL told about each event
+	Created time.Time   `json:"created"`
+	Events  []fileEvent `json:"events,omitempty"` // newest first
+}
+
+var (
+	watches   = map[string]*folderWatch{}
+	watchesMu sync.Mutex
+)
+
+func loadWatches() {
+	if err := loadState("watches", &watches); err != nil {
+		log.Printf("Cannot load watches: %v", err)
+	}
+	shareState("watches", &watchesMu, &watches)
+}
+
+// updateWatches runs change on the watches under watchesMu and persists
+// them.
+func updateWatches(change func()) {
+	if err := updateState("watches", &watchesMu, &watches, change); err != nil {
+		log.Printf("Cannot save watches: %v", err)
+	}
+}
+
+// covers reports whether the event happened under the watched folder.
+func (fw *folderWatch) covers(ev fileEvent) bool {
+	under := func(p string) bool {
+		return p != "" && (fw.Path == "/" || p == fw.Path || strings.HasPrefix(p, fw.Path+"/"))
+	}
+	return under(ev.Path) || under(ev.OldPath)
+}
+
+// viewPath is p as the watch's owner sees it, inside their home if they
+// have one.
+func (fw *folderWatch) viewPath(p string) string {
+	if fw.Home == "" {
+		return p
+	}
+	return path.Join("/", strings.TrimPrefix(p, fw.Home))
+}
+
+// startWatches records file events with the watches they fall under.
+func startWatches() {
+	loadWatches()
+	events, _ := subscribeEvents()
+	go func() {
+		for ev := range events {
+			var hooks []folderWatch
+			match := func() (ids []string) {
+				for id, fw := range watches {
+					if fw.covers(ev) {
+						ids = append(ids, id)
+					}
+				}
+				return ids
+			}
+			watchesMu.Lock()
+			ids := match()
+			watchesMu.Unlock()
+			if len(ids) == 0 {
+				continue
+			}
+			updateWatches(func() {
+				for _, id := range match() {
+					fw := watches[id]
+					fw.Events = append([]fileEvent{ev}, fw.Events...)
+					if len(fw.Events) > watchMaxEvents {
+						fw.Events = fw.Events[:watchMaxEvents]
+					}
+					if fw.Webhook != "" {
+						hooks = append(hooks, *fw)
+					}
+				}
+			})
+			for _, fw := range hooks {
+				go notifyWatch(fw, ev)
+			}
+		}
+	}()
+}
+
+// describeEvent says what happened in a line, for feeds and push messages.
+func (fw *folderWatch) describeEvent(ev fileEvent) string {
+	who := ev.User
+	if who == "" {
+		who = "Someone"
+	}
+	if ev.Type == "renamed" {
+		return fmt.Sprintf("%s renamed %s to %s", who, fw.viewPath(ev.OldPath), fw.viewPath(ev.Path))
+	}
+	return fmt.Sprintf("%s %s %s", who, ev.Type, fw.viewPath(ev.Path))
+}
+
+// notifyWatch tells the watch's webhook about ev.
+func notifyWatch(fw folderWatch, ev fileEvent) {
+	err := sendNotification(fw.Webhook, "Change in "+fw.viewPath(fw.Path), "file_folder", fw.describeEvent(ev), map[string]any{
+		"event": "change",
+		"watch": fw.ID,
+		"path":  fw.viewPath(fw.Path),
+		"file":  ev,
+	})
+	if err != nil {
+		log.Printf("Watch %s webhook: %v", fw.ID, err)
+	}
+}
+
+// handleWatches serves the management API:
+//
+//	GET    /api/v1/watches       folders you watch (everyone's, for admins)
+//	POST   /api/v1/watches       {"path": "/releases", "webhook": "https://ntfy.sh/topic"}
+//	GET    /api/v1/watches/{id}  one watch with its recent events
+//	DELETE /api/v1/watches/{id}  stop watching and revoke the feed
+//
+// Watching a folder you already watch updates its webhook.
+func handleWatches(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/watches"), "/")
+	_, admin := permissionsFor(r)
+	owner := requesterName(r)
+
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			list := []folderWatch{}
+			watchesMu.Lock()
+			for _, fw := range watches {
+				if admin || fw.Owner == owner {
+					c := *fw
+					c.Events = nil
+					list = append(list, c)
+				}
+			}
+			watchesMu.Unlock()
+			sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+			writeJSON(w, map[string]any{"success": true, "watches": list})
+		case "POST":
+			createWatch(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	var fw folderWatch
+	ok := false
+	find := func() {
+		var p *folderWatch
+		p, ok = watches[id]
+		if ok = ok && (admin || p.Owner == owner); ok {
+			fw = *p
+		}
+	}
+	switch r.Method {
+	case "GET":
+		watchesMu.Lock()
+		find()
+		watchesMu.Unlock()
+	case "DELETE":
+		updateWatches(func() {
+			if find(); ok {
+				delete(watches, id)
+			}
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		jsonError(w, http.StatusNotFound, "No such watch")
+		return
+	}
+	if r.Method == "GET" {
+		if fw.Events == nil {
+			fw.Events = []fileEvent{}
+		}
+		writeJSON(w, map[string]any{"success": true, "watch": fw})
+		return
+	}
+	writeJSON(w, map[string]any{"success": true})
+}
+
+func createWatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path    string `json:"path"`
+		Webhook string `json:"webhook"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	fullPath, ok := resolvePathFor(r, req.Path)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		jsonError(w, http.StatusNotFound, "Folder not found")
+		return
+	}
+	webhook := strings.TrimSpace(req.Webhook)
+	if webhook != "" {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			jsonError(w, http.StatusBadRequest, "Webhook must be an http(s) URL")
+			return
+		}
+	}
+	target := urlFor(fullPath)
+	owner := requesterName(r)
+	home := ""
+	if h, ok := userHome(getUserFromRequest(r)); ok && h != "" {
+		home = urlFor(h)
+	}
+
+	var fw folderWatch
+	updateWatches(func() {
+		for _, existing := range watches {
+			if existing.Path == target && existing.Owner == owner {
+				existing.Webhook = webhook
+				fw = *existing
+				return
+			}
+		}
+		n := &folderWatch{
+			Path:    target,
+			Home:    home,
+			Owner:   owner,
+			Token:   randomString(24),
+			Webhook: webhook,
+			Created: time.Now(),
+		}
+		for {
+			n.ID = newShortID(8)
+			if _, taken := watches[n.ID]; !taken {
+				break
+			}
+		}
+		watches[n.ID] = n
+		fw = *n
+	})
+	fw.Events = nil
+	writeJSON(w, map[string]any{
+		"success": true,
+		"watch":   fw,
+		"rss":     "/_feed/" + fw.Token + ".rss",
+		"json":    "/_feed/" + fw.Token + ".json",
+	})
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link,omitempty"`
+	GUID    rssGUID `xml:"guid"`
+	PubDate string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	ID          string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// handleFeed serves /_feed/<token>.rss and /_feed/<token>.json, a watched
+// folder's recent events as RSS 2.0 or JSON Feed 1.1.
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/_feed/")
+	ext := path.Ext(name)
+	token := strings.TrimSuffix(name, ext)
+	var fw folderWatch
+	ok := false
+	lookup := func() {
+		watchesMu.Lock()
+		for _, p := range watches {
+			if token != "" && p.Token == token {
+				fw, ok = *p, true
+				break
+			}
+		}
+		watchesMu.Unlock()
+	}
+	if lookup(); !ok && refreshState("watches", &watchesMu, &watches) {
+		lookup()
+	}
+	if !ok || (ext != ".rss" && ext != ".json") {
+		http.NotFound(w, r)
+		return
+	}
+
+	origin := advertisedOrigin(r)
+	folder := fw.viewPath(fw.Path)
+	title := "Changes in " + folder
+	link := func(p string, isDir bool) string {
+		u := (&url.URL{Path: fw.viewPath(p)}).EscapedPath()
+		if isDir && !strings.HasSuffix(u, "/") {
+			u += "/"
+		}
+		return origin + u
+	}
+	itemLink := func(ev fileEvent) string {
+		if ev.Type == "deleted" {
+			return ""
+		}
+		return link(ev.Path, ev.IsDir)
+	}
+	itemID := func(ev fileEvent) string {
+		return fmt.Sprintf("%s-%d-%s", fw.ID, ev.Time.UnixNano(), ev.Path)
+	}
+
+	if ext == ".json" {
+		type jsonItem struct {
+			ID        string    `json:"id"`
+			URL       string    `json:"url,omitempty"`
+			Title     string    `json:"title"`
+			Content   string    `json:"content_text"`
+			Published time.Time `json:"date_published"`
+		}
+		items := []jsonItem{}
+		for _, ev := range fw.Events {
+			d := fw.describeEvent(ev)
+			items = append(items, jsonItem{itemID(ev), itemLink(ev), d, d, ev.Time})
+		}
+		w.Header().Set("Content-Type", "application/feed+json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"version":       "https://jsonfeed.org/version/1.1",
+			"title":         title,
+			"home_page_url": link(fw.Path, true),
+			"feed_url":      origin + r.URL.Path,
+			"items":         items,
+		})
+		return
+	}
+
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       title,
+		Link:        link(fw.Path, true),
+		Description: "Files created, changed, renamed and deleted under " + folder,
+	}}
+	for _, ev := range fw.Events {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:   fw.describeEvent(ev),
+			Link:    itemLink(ev),
+			GUID:    rssGUID{ID: itemID(ev)},
+			PubDate: ev.Time.Format(time.RFC1123Z),
+		})
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
@@ -0,0 +1,91 @@
+// About box: server info, update check and the WebDAV mount URL
+document.getElementById('webdavUrl').value = shareOrigin + '/webdav/';
+
+function showAbout() {
+    updateAboutLogo(localStorage.getItem('theme') || 'light');
+    document.getElementById('aboutModal').style.display = 'block';
+    loadServerInfo();
+    var checkUpdates = localStorage.getItem('updateCheck') === '1';
+    document.getElementById('updateCheckToggle').checked = checkUpdates;
+    if (checkUpdates) checkForUpdate();
+}
+
+function setUpdateCheck(on) {
+    localStorage.setItem('updateCheck', on ? '1' : '0');
+    if (on) checkForUpdate();
+    else document.getElementById('updateBanner').style.display = 'none';
+}
+
+function checkForUpdate() {
+    var banner = document.getElementById('updateBanner');
+    fetch('/_update').then(r => r.json()).then(function(data) {
+        if (!data.success || !data.newer) { banner.style.display = 'none'; return; }
+        banner.innerHTML = '🎉 GoServe <strong>' + escapeHtml(data.latest) + '</strong> is available (running ' + escapeHtml(data.current) +
+            '). <a href="' + escapeHtml(data.url) + '" target="_blank" style="color: var(--accent);">Release notes</a> — run <code>goserve update</code> on the server to install.';
+        banner.style.display = 'block';
+    }).catch(function() {});
+}
+
+var serverInfoRows = [];
+
+function formatUptime(secs) {
+    var d = Math.floor(secs / 86400), h = Math.floor(secs % 86400 / 3600), m = Math.floor(secs % 3600 / 60);
+    return (d ? d + 'd ' : '') + (d || h ? h + 'h ' : '') + m + 'm';
+}
+
+function loadServerInfo() {
+    var el = document.getElementById('serverInfo');
+    fetch('/_info').then(r => r.json()).then(function(info) {
+        var enabled = Object.keys(info.features).filter(function(k) { return info.features[k]; });
+        serverInfoRows = [
+            ['Version', info.version + ' (' + info.goVersion + ', ' + info.os + '/' + info.arch + ')'],
+            ['Started', new Date(info.started).toLocaleString()],
+            ['Uptime', formatUptime(info.uptimeSeconds)],
+            ['Listeners', info.listeners.join(', ')],
+            ['Shared links use', info.origin],
+            ['Permission level', info.permLevel + (info.auth ? ' (with logins)' : '')],
+            ['Features', enabled.join(', ')],
+            ['Max upload', formatBytes(info.limits.maxUploadBytes)]
+        ];
+        if (info.baseDir) serverInfoRows.splice(4, 0, ['Directory', info.baseDir]);
+        if (info.limits.monthlyCapBytes) serverInfoRows.push(['Monthly cap', formatBytes(info.limits.monthlyCapBytes)]);
+        if (info.limits.ratePerSecond || info.limits.heavyRatePerMinute) {
+            serverInfoRows.push(['Rate limits', info.limits.ratePerSecond + ' req/s, ' + info.limits.heavyRatePerMinute + ' archive+search req/min']);
+        }
+        el.innerHTML = '<table style="font-size: inherit;">' + serverInfoRows.map(function(row) {
+            return '<tr><td style="padding-right: 12px; white-space: nowrap;">' + row[0] + '</td><td style="color: var(--text-primary); word-break: break-all;">' + escapeHtml(String(row[1])) + '</td></tr>';
+        }).join('') + '</table>';
+    }).catch(function() { el.textContent = 'Unavailable'; });
+}
+
+function printServerInfo() {
+    var win = window.open('', '_blank');
+    if (!win) return;
+    win.document.write('<title>GoServe server info</title><h2>GoServe on ' + escapeHtml(location.host) + '</h2><table cellpadding="4">' +
+        serverInfoRows.map(function(row) { return '<tr><th align="left">' + row[0] + '</th><td>' + escapeHtml(String(row[1])) + '</td></tr>'; }).join('') +
+        '</table><p>Printed ' + new Date().toLocaleString() + '</p>');
+    win.document.close();
+    win.print();
+}
+
+function closeAbout() {
+    document.getElementById('aboutModal').style.display = 'none';
+}
+
+function copyWebDAVUrl() {
+    const urlInput = document.getElementById('webdavUrl');
+    urlInput.select();
+    urlInput.setSelectionRange(0, 99999);
+
+    try {
+        navigator.clipboard.writeText(urlInput.value).then(() => {
+            showAlert('WebDAV URL copied to clipboard!', 'Copied');
+        }).catch(() => {
+            document.execCommand('copy');
+            showAlert('WebDAV URL copied!', 'Copied');
+        });
+    } catch (err) {
+        document.execCommand('copy');
+        showAlert('WebDAV URL copied!', 'Copied');
+    }
+}
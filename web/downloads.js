@@ -0,0 +1,195 @@
+// Download queue: files are fetched one at a time and resumed with
+// Range requests after network errors. Where the browser supports it
+// the data streams straight into a chosen folder; otherwise it is
+// collected in memory and saved when complete.
+var dlQueue = [];
+var dlActive = false;
+var dlDir = null;
+
+function ctxQueueDownloads() {
+    hideAllMenus();
+    var rows = selectedRows.slice();
+    if (rows.length === 0) return;
+    var ready = Promise.resolve();
+    if (window.showDirectoryPicker && !dlDir) {
+        ready = window.showDirectoryPicker({mode: 'readwrite'}).then(h => { dlDir = h; }).catch(() => {});
+    }
+    ready.then(function() {
+        rows.forEach(function(r) {
+            var isDir = r.dataset.isdir === 'true';
+            dlQueue.push({
+                url: r.dataset.path + (isDir ? '?zip=1' : ''),
+                name: (r.dataset.name || 'download') + (isDir ? '.zip' : ''),
+                received: 0, total: 0, status: 'queued'
+            });
+        });
+        showDownloads();
+        pumpDownloads();
+    });
+}
+
+function pumpDownloads() {
+    if (dlActive) return;
+    var item = dlQueue.find(d => d.status === 'queued');
+    if (!item) return;
+    dlActive = true;
+    item.status = 'downloading';
+    runDownload(item).then(function() {
+        item.status = 'done';
+    }, function(err) {
+        if (item.status !== 'canceled') { item.status = 'failed'; item.error = err.message || String(err); }
+    }).then(function() {
+        dlActive = false;
+        renderDownloads();
+        pumpDownloads();
+    });
+}
+
+async function openDownloadSink(item) {
+    if (dlDir) {
+        var fh = await dlDir.getFileHandle(item.name, {create: true});
+        var w = await fh.createWritable();
+        return {
+            write: (chunk, pos) => w.write({type: 'write', position: pos, data: chunk}),
+            reset: () => {},
+            close: async () => { await w.truncate(item.received); await w.close(); },
+            abort: () => w.abort()
+        };
+    }
+    var chunks = [];
+    return {
+        write: chunk => { chunks.push(chunk); },
+        reset: () => { chunks = []; },
+        close: () => {
+            var a = document.createElement('a');
+            a.href = URL.createObjectURL(new Blob(chunks));
+            a.download = item.name;
+            document.body.appendChild(a);
+            a.click();
+            document.body.removeChild(a);
+            setTimeout(() => URL.revokeObjectURL(a.href), 60000);
+        },
+        abort: () => { chunks = []; }
+    };
+}
+
+async function runDownload(item) {
+    var sink = await openDownloadSink(item);
+    var retries = 0;
+    while (true) {
+        item.controller = new AbortController();
+        // Always ask for a range so the response is never gzip-encoded
+        var headers = {'Range': 'bytes=' + item.received + '-'};
+        if (item.received > 0 && item.validator) headers['If-Range'] = item.validator;
+        try {
+            var resp = await fetch(item.url, {headers: headers, signal: item.controller.signal});
+            if (!resp.ok) throw new Error('HTTP ' + resp.status);
+            if (resp.status !== 206 && item.received > 0) {
+                // Changed on the server (or no range support): start over
+                item.received = 0;
+                sink.reset();
+            }
+            item.validator = resp.headers.get('ETag') || resp.headers.get('Last-Modified');
+            var range = resp.headers.get('Content-Range');
+            item.total = range ? +range.split('/')[1] || 0 : +resp.headers.get('Content-Length') || 0;
+            var reader = resp.body.getReader();
+            while (true) {
+                var chunk = await reader.read();
+                if (chunk.done) break;
+                await sink.write(chunk.value, item.received);
+                item.received += chunk.value.length;
+                retries = 0;
+                renderDownloadsSoon();
+            }
+            await sink.close();
+            return;
+        } catch (err) {
+            if (item.status === 'canceled' || ++retries > 5) {
+                await sink.abort();
+                throw err;
+            }
+            item.error = 'retrying: ' + (err.message || err);
+            renderDownloads();
+            await new Promise(r => setTimeout(r, 1000 * retries));
+            item.error = '';
+        }
+    }
+}
+
+function cancelDownload(i) {
+    var item = dlQueue[i];
+    if (!item) return;
+    var running = item.status === 'downloading';
+    item.status = 'canceled';
+    if (running && item.controller) item.controller.abort();
+    renderDownloads();
+}
+
+function clearFinishedDownloads() {
+    dlQueue = dlQueue.filter(d => d.status === 'queued' || d.status === 'downloading');
+    renderDownloads();
+}
+
+function showDownloads() {
+    document.getElementById('downloadsModal').style.display = 'block';
+    renderDownloads();
+}
+
+function closeDownloads() {
+    document.getElementById('downloadsModal').style.display = 'none';
+}
+
+var dlRenderPending = false;
+function renderDownloadsSoon() {
+    if (dlRenderPending) return;
+    dlRenderPending = true;
+    setTimeout(function() { dlRenderPending = false; renderDownloads(); }, 250);
+}
+
+function renderDownloads() {
+    if (document.getElementById('downloadsModal').style.display !== 'block') return;
+    document.getElementById('downloadsTarget').textContent = dlDir
+        ? 'Saving to folder: ' + dlDir.name
+        : 'Files are saved by the browser when each download completes.';
+    var list = document.getElementById('downloadsList');
+    if (dlQueue.length === 0) {
+        list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No downloads queued</p>';
+        return;
+    }
+    list.innerHTML = dlQueue.map(function(d, i) {
+        var pct = d.total > 0 ? Math.round(d.received * 100 / d.total) : (d.status === 'done' ? 100 : 0);
+        var size = formatBytes(d.received) + (d.total > 0 ? ' of ' + formatBytes(d.total) : '');
+        var action = (d.status === 'queued' || d.status === 'downloading')
+            ? '<button class="btn" onclick="cancelDownload(' + i + ')">Cancel</button>' : '';
+        return '<div class="job-row"><div class="job-head"><span class="job-title">' + escapeHtml(d.name) + '</span>' + action +
+            '</div><div class="job-bar"><div style="width:' + pct + '%"></div></div>' +
+            '<div class="job-status">' + d.status + ' \u00b7 ' + size + (d.error ? ' \u00b7 ' + escapeHtml(d.error) : '') + '</div></div>';
+    }).join('');
+}
+
+function showUsage() {
+    document.getElementById('usageModal').style.display = 'block';
+    var body = document.getElementById('usageBody');
+    body.textContent = 'Loading...';
+    fetch('/api/v1/usage').then(r => r.json()).then(function(data) {
+        if (!data.success) { body.textContent = 'Error: ' + data.error; return; }
+        var html = '';
+        if (data.monthlyCap > 0) {
+            html += '<p style="font-size: 13px;">Monthly cap: ' + formatBytes(data.monthlyCap) + ' per user' +
+                (data.overCap ? ' \u2014 <strong style="color:#e74c3c;">exceeded, read-only until next month</strong>' : '') + '</p>';
+        }
+        if (data.usage.length === 0) {
+            body.innerHTML = html + '<p style="color: var(--text-secondary); font-size: 13px;">No transfers recorded</p>';
+            return;
+        }
+        html += '<table style="width:100%;font-size:13px;"><tr><th>Month</th><th>Who</th><th>Uploaded</th><th>Downloaded</th></tr>';
+        data.usage.forEach(function(u) {
+            html += '<tr><td>' + u.month + '</td><td>' + escapeHtml(u.key) + '</td><td>' + formatBytes(u.up) + '</td><td>' + formatBytes(u.down) + '</td></tr>';
+        });
+        body.innerHTML = html + '</table>';
+    });
+}
+
+function closeUsage() {
+    document.getElementById('usageModal').style.display = 'none';
+}
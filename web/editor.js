@@ -0,0 +1,122 @@
+// Text editor
+var editor = null;
+var currentEditPath = '';
+var editLockToken = '';
+var editLockTimer = null;
+
+// Take a soft lock before editing; if someone else holds it, offer read-only
+function editFile(path, name, line) {
+    fetch(path + '?lock=1', { method: 'POST' })
+        .then(r => r.json())
+        .then(data => {
+            if (data.success) {
+                openEditor(path, name, data.token, line);
+            } else if (data.lockedBy) {
+                showConfirm(name + ' is being edited by ' + data.lockedBy + '.\nOpen it read-only?', 'File Locked').then(function(ok) {
+                    if (ok) openEditor(path, name, '', line);
+                });
+            } else {
+                showAlert('Error: ' + data.error);
+            }
+        })
+        .catch(err => showAlert('Error locking file: ' + err.message));
+}
+
+function openEditor(path, name, token, line) {
+    var readOnly = !token;
+    currentEditPath = path;
+    editLockToken = token;
+    if (token) {
+        editLockTimer = setInterval(function() {
+            fetch(path + '?lock=1&token=' + encodeURIComponent(token), { method: 'POST' });
+        }, 30000);
+    }
+    document.getElementById('editorSaveBtn').style.display = readOnly ? 'none' : '';
+    document.getElementById('editorReplaceBtn').style.display = readOnly ? 'none' : '';
+
+    fetchFileText(path)
+        .then(f => {
+            var content = f.text;
+            document.getElementById('editorFileName').textContent = name +
+                (readOnly && f.lockedBy ? ' (read-only: locked by ' + f.lockedBy + ')' : readOnly ? ' (read-only)' : '');
+            document.getElementById('editor').value = content;
+            document.getElementById('editorModal').style.display = 'block';
+
+            // Initialize CodeMirror if not already initialized
+            if (!editor) {
+                const currentTheme = localStorage.getItem('theme') || 'light';
+                editor = CodeMirror.fromTextArea(document.getElementById('editor'), {
+                    lineNumbers: true,
+                    theme: isDarkTheme(currentTheme) ? 'monokai' : 'default',
+                    mode: getMode(name),
+                    indentUnit: 4,
+                    lineWrapping: true
+                });
+                editor.setSize('100%', '70vh');
+            } else {
+                editor.setValue(content);
+                editor.setOption('mode', getMode(name));
+            }
+            editor.setOption('readOnly', readOnly);
+            if (line) {
+                editor.setCursor(line - 1, 0);
+                editor.scrollIntoView(null, 100);
+                editor.focus();
+            }
+        })
+        .catch(function(err) {
+            releaseEditLock();
+            showFileError(err);
+        });
+}
+
+function getMode(filename) {
+    const ext = filename.split('.').pop().toLowerCase();
+    const modes = {
+        'js': 'javascript',
+        'json': 'javascript',
+        'py': 'python',
+        'go': 'go',
+        'html': 'xml',
+        'xml': 'xml',
+        'css': 'css',
+        'md': 'markdown',
+        'sh': 'shell',
+        'bash': 'shell',
+        'txt': 'text/plain'
+    };
+    return modes[ext] || 'text/plain';
+}
+
+function saveFile() {
+    const content = editor.getValue();
+    fetch(currentEditPath + '?edit=1&token=' + encodeURIComponent(editLockToken), {
+        method: 'POST',
+        headers: { 'Content-Type': 'text/plain' },
+        body: content
+    })
+    .then(r => r.json())
+    .then(data => {
+        if (data.success) {
+            showAlert('File saved successfully!', 'Saved').then(function() { closeEditor(); });
+        } else {
+            showAlert('Error: ' + data.error);
+        }
+    })
+    .catch(err => showAlert('Error saving file: ' + err.message));
+}
+
+function releaseEditLock() {
+    if (editLockTimer) { clearInterval(editLockTimer); editLockTimer = null; }
+    if (editLockToken) {
+        navigator.sendBeacon(currentEditPath + '?unlock=' + encodeURIComponent(editLockToken));
+        editLockToken = '';
+    }
+}
+
+function closeEditor() {
+    document.getElementById('editorModal').style.display = 'none';
+    releaseEditLock();
+}
+
+window.addEventListener('pagehide', releaseEditLock);
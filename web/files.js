@@ -0,0 +1,1072 @@
+// --- File list selection and navigation ---
+var selectedRows = [];
+var lastSelectedRow = null;
+
+function getVisibleRows() {
+    var rows = Array.from(document.querySelectorAll('#fileTable tbody tr'));
+    return rows.filter(r => r.style.display !== 'none');
+}
+
+function updateSelectionBar() {
+    var bar = document.getElementById('selectionBar');
+    var count = selectedRows.length;
+    if (count > 0) {
+        bar.classList.add('active');
+        document.getElementById('selectionCount').textContent = count + ' selected';
+        var single = count === 1;
+        var editBtn = document.getElementById('selEditBtn');
+        var renameBtn = document.getElementById('selRenameBtn');
+        if (editBtn) editBtn.style.display = (single && selectedRows[0].dataset.editable) ? '' : 'none';
+    var extractBtn = document.getElementById('ctxExtract');
+    if (extractBtn) extractBtn.style.display = (single && /\.zip$/i.test(selectedRows[0].dataset.name || '')) ? '' : 'none';
+        if (renameBtn) renameBtn.style.display = single ? '' : 'none';
+        document.getElementById('ctxShortLink').style.display = single ? '' : 'none';
+    } else {
+        bar.classList.remove('active');
+    }
+}
+
+function clearSelection() {
+    selectedRows.forEach(r => r.classList.remove('selected'));
+    selectedRows = [];
+    updateSelectionBar();
+}
+
+function selectRow(tr, keepExisting) {
+    if (!keepExisting) clearSelection();
+    if (!tr.classList.contains('selected')) {
+        tr.classList.add('selected');
+        selectedRows.push(tr);
+    }
+    lastSelectedRow = tr;
+    tr.scrollIntoView({block: 'nearest'});
+    updateSelectionBar();
+}
+
+function selectRange(fromTr, toTr) {
+    var visible = getVisibleRows();
+    var i1 = visible.indexOf(fromTr);
+    var i2 = visible.indexOf(toTr);
+    if (i1 < 0 || i2 < 0) return;
+    var start = Math.min(i1, i2), end = Math.max(i1, i2);
+    clearSelection();
+    for (var i = start; i <= end; i++) {
+        visible[i].classList.add('selected');
+        selectedRows.push(visible[i]);
+    }
+    lastSelectedRow = toTr;
+    updateSelectionBar();
+}
+
+function navigateUp() {
+    // Remember current folder name so parent page can select it
+    var parts = window.location.pathname.replace(/\/+$/, '').split('/');
+    var current = parts[parts.length - 1];
+    if (current) sessionStorage.setItem('goserve_select', current);
+    window.location.href = '../';
+}
+
+function openRow(tr) {
+    var path = tr.dataset.path;
+    var isDir = tr.dataset.isdir === 'true';
+    var name = tr.dataset.name || '';
+    if (!path) return;
+    if (tr.dataset.error) {
+        showAlert(name + ' cannot be opened: ' + tr.dataset.error + '.', 'Unreadable entry');
+        return;
+    }
+    if (path === '../') {
+        navigateUp();
+        return;
+    }
+    if (isDir) {
+        window.location.href = path;
+    } else {
+        // Trigger preview or download
+        var ext = name.split('.').pop().toLowerCase();
+        var images = ['jpg','jpeg','png','gif','svg','webp'];
+        var photos = ['heic','heif','cr2','cr3','nef','nrw','arw','srf','sr2','dng','orf','rw2','raf','pef','srw','x3f','3fr','iiq'];
+        var previewable = ['txt','md','json','js','go','py','html','css','xml','log'];
+        if (images.includes(ext)) {
+            document.getElementById('previewBody').innerHTML = '<img src="' + path + '" style="max-width:100%;height:auto;">';
+            document.getElementById('previewModal').style.display = 'block';
+        } else if (['mp4','webm','mkv','mov','m4v','ogv'].includes(ext)) {
+            openVideo(path);
+        } else if (photos.includes(ext)) {
+            // Converted to JPEG on the server
+            document.getElementById('previewBody').innerHTML = '<img src="' + path + '?preview=1" style="max-width:100%;height:auto;" alt="Converting..." onerror="this.replaceWith(document.createTextNode(\'No preview available for this file\'))">';
+            document.getElementById('previewModal').style.display = 'block';
+        } else if (ext === 'md') {
+            fetchFileText(path + '?markdown=1')
+                .then(f => { document.getElementById('previewBody').innerHTML = lockBanner(f.lockedBy) + '<div class="markdown-body">' + f.text + '</div>'; document.getElementById('previewModal').style.display = 'block'; })
+                .catch(showFileError);
+        } else if (/\.(zip|tar|tgz|tar\.gz)$/i.test(name)) {
+            showArchiveContents(path, name);
+        } else if (previewable.includes(ext)) {
+            fetchFileText(path)
+                .then(f => { document.getElementById('previewBody').innerHTML = lockBanner(f.lockedBy) + '<pre>' + escapeHtml(f.text) + '</pre>'; document.getElementById('previewModal').style.display = 'block'; })
+                .catch(showFileError);
+        } else {
+            window.open(path, '_blank');
+        }
+    }
+}
+
+// Fetch a file's text along with who holds its lock, if anyone. A file
+// another program has open comes back 423 with the reason.
+function fetchFileText(url) {
+    return fetch(url).then(function(r) {
+        if (r.ok) return r.text().then(function(text) { return { text: text, lockedBy: r.headers.get('X-Locked-By') }; });
+        return r.text().then(function(msg) {
+            var err = new Error(r.status === 423 ? msg.trim() : 'Failed to load file');
+            err.busy = r.status === 423;
+            throw err;
+        });
+    });
+}
+
+function showFileError(err) {
+    if (err.busy) showAlert(err.message + '.', 'File in use');
+    else showAlert('Error: ' + err.message);
+}
+
+function lockBanner(lockedBy) {
+    return lockedBy ? '<div class="lock-banner">🔒 Locked by ' + escapeHtml(lockedBy) + '; shown read-only</div>' : '';
+}
+
+// What's inside a ZIP or TAR, listed by the server without extracting it
+function showArchiveContents(path, name) {
+    fetch(path + '?contents=1').then(r => r.json()).then(function(data) {
+        if (!data.success) { showAlert('Error: ' + data.error); return; }
+        var a = data.archive;
+        var html = '<h3 style="margin-top:0;">' + escapeHtml(name) + '</h3>' +
+            '<p>' + a.format.toUpperCase() + ' archive: ' + a.entries + (a.complete ? '' : '+') + ' entries, ' +
+            a.files + ' files, ' + formatBytes(a.totalSize) + ' uncompressed' + (a.complete ? '' : ' (not fully read)') +
+            ' <button class="btn-primary" id="archiveDownload" style="margin-left:8px;">Download</button></p>';
+        html += '<table style="width:100%;font-size:12px;"><tr><th style="text-align:left;">Name</th><th style="text-align:right;">Size</th><th style="text-align:left;">Modified</th></tr>';
+        a.items.forEach(function(e) {
+            html += '<tr><td style="word-break:break-all;">' + escapeHtml(e.name) + '</td>' +
+                '<td style="text-align:right;white-space:nowrap;">' + (e.isDir ? '-' : formatBytes(e.size)) + '</td>' +
+                '<td style="white-space:nowrap;">' + (e.modified ? new Date(e.modified).toLocaleString() : '') + '</td></tr>';
+        });
+        html += '</table>';
+        if (a.entries > a.items.length) html += '<p style="color:var(--text-secondary);">Showing the first ' + a.items.length + ' entries.</p>';
+        document.getElementById('previewBody').innerHTML = '<div style="padding:16px;">' + html + '</div>';
+        document.getElementById('archiveDownload').onclick = function() { window.open(path, '_blank'); };
+        document.getElementById('previewModal').style.display = 'block';
+    }).catch(err => showAlert('Error: ' + err.message));
+}
+
+// Single-click: select row. Prevent <a> navigation.
+document.querySelector('#fileTable tbody')?.addEventListener('click', function(e) {
+    // Don't intercept action button clicks
+
+    e.preventDefault();
+    var tr = e.target.closest('tr');
+    if (!tr || !tr.dataset.path) return;
+
+    if (e.shiftKey && lastSelectedRow) {
+        selectRange(lastSelectedRow, tr);
+    } else if (e.ctrlKey || e.metaKey) {
+        if (tr.classList.contains('selected')) {
+            tr.classList.remove('selected');
+            selectedRows = selectedRows.filter(r => r !== tr);
+            updateSelectionBar();
+        } else {
+            selectRow(tr, true);
+        }
+    } else {
+        selectRow(tr, false);
+    }
+});
+
+// Double-click: open file/folder
+document.querySelector('#fileTable tbody')?.addEventListener('dblclick', function(e) {
+
+    var tr = e.target.closest('tr');
+    if (!tr || !tr.dataset.path) return;
+    e.preventDefault();
+    openRow(tr);
+});
+
+// Keyboard navigation
+document.addEventListener('keydown', function(e) {
+    // Close modals/menus on Escape
+    if (e.key === 'Escape') {
+        dialogCancel();
+        closePreview();
+        closeAbout();
+        closeEditor();
+        closeNewFolderModal();
+        closeFolderPicker();
+        closeGrepModal();
+        closeJobs();
+        closeDuplicates();
+        closeDownloads();
+        closeUsage();
+        closeAccessLog();
+        closeHelp();
+        closeUploadLinks();
+        hideAllMenus();
+        clearSelection();
+        return;
+    }
+
+    // Don't handle keys when typing in inputs or modals open
+    var tag = document.activeElement.tagName;
+    if (tag === 'INPUT' || tag === 'TEXTAREA' || tag === 'SELECT') return;
+    if (document.querySelector('.preview-modal[style*="display: block"]')) return;
+    if (document.getElementById('dialogOverlay').classList.contains('active')) return;
+
+    if (e.key === '?') {
+        e.preventDefault();
+        showHelp();
+        return;
+    }
+
+    if (e.key === 'ArrowLeft') {
+        e.preventDefault();
+        navigateUp();
+        return;
+    }
+
+    if (e.key === 'Delete' && selectedRows.length > 0 && caps.delete) {
+        e.preventDefault();
+        ctxDeleteSelected();
+        return;
+    }
+
+    var visible = getVisibleRows();
+    if (visible.length === 0) return;
+
+    var currentIdx = lastSelectedRow ? visible.indexOf(lastSelectedRow) : -1;
+
+    if (e.key === 'ArrowDown') {
+        e.preventDefault();
+        var next = currentIdx < visible.length - 1 ? currentIdx + 1 : currentIdx;
+        if (next < 0) next = 0;
+        if (e.shiftKey && lastSelectedRow) {
+            var tr = visible[next];
+            if (tr.classList.contains('selected') && next !== currentIdx) {
+                // Shrink selection: deselect current if moving back
+                lastSelectedRow.classList.remove('selected');
+                selectedRows = selectedRows.filter(r => r !== lastSelectedRow);
+            } else {
+                tr.classList.add('selected');
+                if (!selectedRows.includes(tr)) selectedRows.push(tr);
+            }
+            lastSelectedRow = tr;
+            tr.scrollIntoView({block: 'nearest'});
+            updateSelectionBar();
+        } else {
+            selectRow(visible[next], false);
+        }
+    } else if (e.key === 'ArrowUp') {
+        e.preventDefault();
+        var prev = currentIdx > 0 ? currentIdx - 1 : 0;
+        if (e.shiftKey && lastSelectedRow) {
+            var tr = visible[prev];
+            if (tr.classList.contains('selected') && prev !== currentIdx) {
+                lastSelectedRow.classList.remove('selected');
+                selectedRows = selectedRows.filter(r => r !== lastSelectedRow);
+            } else {
+                tr.classList.add('selected');
+                if (!selectedRows.includes(tr)) selectedRows.push(tr);
+            }
+            lastSelectedRow = tr;
+            tr.scrollIntoView({block: 'nearest'});
+            updateSelectionBar();
+        } else {
+            selectRow(visible[prev], false);
+        }
+    } else if (e.key === 'ArrowRight') {
+        if (lastSelectedRow && lastSelectedRow.dataset.isdir === 'true') {
+            e.preventDefault();
+            window.location.href = lastSelectedRow.dataset.path;
+        }
+    } else if (e.key === 'Enter') {
+        if (lastSelectedRow) {
+            e.preventDefault();
+            openRow(lastSelectedRow);
+        }
+    } else if (e.key === 'Home') {
+        e.preventDefault();
+        if (visible.length > 0) selectRow(visible[0], false);
+    } else if (e.key === 'End') {
+        e.preventDefault();
+        if (visible.length > 0) selectRow(visible[visible.length - 1], false);
+    }
+});
+
+// Footer menu
+function toggleFooterMenu(e) {
+    e.stopPropagation();
+    var menu = document.getElementById('footerMenu');
+    menu.classList.toggle('active');
+}
+function closeFooterMenu() {
+    document.getElementById('footerMenu').classList.remove('active');
+}
+
+// WebDAV clients can't use single sign-on, so they get an app password
+function createAppPassword() {
+    fetch('/_oidc/app-password', { method: 'POST' })
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            showPrompt('Use this password with the username ' + data.username + ' in WebDAV clients. It is shown only once and works until ' +
+                new Date(data.expires).toLocaleString() + '.', data.password, 'WebDAV Password');
+        })
+        .catch(err => showAlert('Error: ' + err.message));
+}
+
+// Context menus
+function hideAllMenus() {
+    document.querySelectorAll('.context-menu').forEach(m => m.classList.remove('show'));
+    closeFooterMenu();
+}
+
+function showMenuAt(menu, x, y) {
+    hideAllMenus();
+    menu.classList.add('show');
+    var mw = menu.offsetWidth, mh = menu.offsetHeight;
+    menu.style.left = Math.min(x, window.innerWidth - mw - 4) + 'px';
+    menu.style.top = Math.min(y, window.innerHeight - mh - 4) + 'px';
+}
+
+// Folder context menu (breadcrumb caret)
+function toggleContextMenu(e) {
+    e.stopPropagation();
+    var menu = document.getElementById('folderContextMenu');
+    if (menu.classList.contains('show')) {
+        hideAllMenus();
+        return;
+    }
+    var rect = e.target.getBoundingClientRect();
+    showMenuAt(menu, rect.left, rect.bottom + 2);
+}
+
+document.addEventListener('click', function() { hideAllMenus(); });
+
+// Right-click on table header → folder context menu
+document.querySelector('#fileTable thead')?.addEventListener('contextmenu', function(e) {
+    if (!document.querySelector('#folderContextMenu .context-menu-item')) return;
+    e.preventDefault();
+    showMenuAt(document.getElementById('folderContextMenu'), e.clientX, e.clientY);
+});
+
+// Right-click on table row → row context menu
+document.querySelector('#fileTable tbody')?.addEventListener('contextmenu', function(e) {
+    e.preventDefault();
+    var tr = e.target.closest('tr');
+    if (!tr || !tr.dataset.path) return;
+    // Select row if not already selected
+    if (!tr.classList.contains('selected')) {
+        selectRow(tr, false);
+    }
+    // Show/hide items based on single vs multi selection
+    var single = selectedRows.length === 1;
+    var renameBtn = document.getElementById('ctxRename');
+    var editBtn = document.getElementById('ctxEdit');
+    if (renameBtn) renameBtn.style.display = single ? '' : 'none';
+    if (editBtn) editBtn.style.display = (single && selectedRows[0].dataset.editable) ? '' : 'none';
+    var extractBtn = document.getElementById('ctxExtract');
+    if (extractBtn) extractBtn.style.display = (single && /\.zip$/i.test(selectedRows[0].dataset.name || '')) ? '' : 'none';
+    showMenuAt(document.getElementById('rowContextMenu'), e.clientX, e.clientY);
+});
+
+// Row context menu actions
+function ctxDownloadSelected() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    if (selectedRows.length === 1) {
+        var path = selectedRows[0].dataset.path;
+        var isDir = selectedRows[0].dataset.isdir === 'true';
+        if (isDir) {
+            window.location.href = path + '?zip=1';
+        } else {
+            // Direct file download
+            var a = document.createElement('a');
+            a.href = path;
+            a.download = selectedRows[0].dataset.name || '';
+            document.body.appendChild(a);
+            a.click();
+            document.body.removeChild(a);
+        }
+    } else {
+        // Multi-file: POST paths to get a ZIP
+        postSelection('zipfiles');
+    }
+}
+
+// Uncompressed TAR stream of the selection (no deflate, for fast LAN copies)
+function ctxDownloadTar() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    if (selectedRows.length === 1 && selectedRows[0].dataset.isdir === 'true') {
+        window.location.href = selectedRows[0].dataset.path + '?tar=1';
+        return;
+    }
+    postSelection('tarfiles');
+}
+
+// AES-encrypted ZIP of the selection. The password is posted, never put in the URL.
+function ctxDownloadEncryptedZip() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var dir = selectedRows.length === 1 && selectedRows[0].dataset.isdir === 'true' ? selectedRows[0].dataset.path : null;
+    askZipPassword().then(function(pw) {
+        if (!pw) return;
+        if (dir) postForm(dir + '?zip=1', {password: pw});
+        else postSelection('zipfiles', {password: pw});
+    });
+}
+
+// age-encrypted TAR of the selection: zstd-compressed for a folder, plain for several entries
+function ctxDownloadEncryptedTar() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var dir = selectedRows.length === 1 && selectedRows[0].dataset.isdir === 'true' ? selectedRows[0].dataset.path : null;
+    askTarPassword().then(function(pw) {
+        if (!pw) return;
+        if (dir) postForm(dir + '?tar=zst', {password: pw});
+        else postSelection('tarfiles', {password: pw});
+    });
+}
+
+function downloadEncryptedZip() {
+    hideAllMenus();
+    askZipPassword().then(function(pw) {
+        if (pw) postForm(window.location.pathname + '?zip=1', {password: pw});
+    });
+}
+
+function askZipPassword() {
+    return showPrompt('Password for the ZIP (AES-256; open it with 7-Zip, WinZip or Windows 11):', '', 'Encrypted ZIP', true);
+}
+
+function downloadEncryptedTar() {
+    hideAllMenus();
+    askTarPassword().then(function(pw) {
+        if (pw) postForm(window.location.pathname + '?tar=zst', {password: pw});
+    });
+}
+
+function askTarPassword() {
+    return showPrompt('Passphrase for the TAR (age, file names included; open it with age -d):', '', 'Encrypted TAR', true);
+}
+
+// Unzip into a new folder next to the archive, asking for the password if it has one
+function ctxExtractSelected(password) {
+    hideAllMenus();
+    if (selectedRows.length !== 1) return;
+    var tr = selectedRows[0];
+    var req = {type: 'extract', path: decodeURIComponent(tr.dataset.path), password: password || ''};
+    fetch('/api/v1/jobs', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json())
+        .then(data => {
+            if (data.passwordRequired) {
+                var msg = (password ? 'Wrong password. ' : '') + tr.dataset.name + ' is password-protected. Password:';
+                showPrompt(msg, '', 'Extract', true).then(function(pw) { if (pw) ctxExtractSelected(pw); });
+                return;
+            }
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            showJobs();
+            watchJob(data.job.id);
+        })
+        .catch(err => showAlert('Error starting job: ' + err.message));
+}
+
+function postSelection(action, fields) {
+    var form = postForm(window.location.pathname + '?' + action + '=1', fields, true);
+    selectedRows.forEach(r => {
+        var input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = 'files';
+        input.value = r.dataset.path;
+        form.appendChild(input);
+    });
+    form.submit();
+    document.body.removeChild(form);
+}
+
+// postForm submits fields to action as a regular form POST, so downloads
+// save normally. With keep the form is returned unsent for more fields.
+function postForm(action, fields, keep) {
+    var form = document.createElement('form');
+    form.method = 'POST';
+    form.action = action;
+    form.style.display = 'none';
+    Object.keys(fields || {}).forEach(k => {
+        var input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = k;
+        input.value = fields[k];
+        form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    if (keep) return form;
+    form.submit();
+    document.body.removeChild(form);
+}
+
+function ctxEditSelected() {
+    hideAllMenus();
+    if (selectedRows.length !== 1) return;
+    var tr = selectedRows[0];
+    editFile(tr.dataset.path, tr.dataset.name);
+}
+
+function ctxRenameSelected() {
+    hideAllMenus();
+    if (selectedRows.length !== 1) return;
+    var tr = selectedRows[0];
+    renameFile(tr.dataset.path, tr.dataset.name);
+}
+
+function ctxTouchSelected() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var first = new Date((parseInt(selectedRows[0].dataset.mod) || 0) * 1000);
+    var pad = function(n) { return String(n).padStart(2, '0'); };
+    var def = first.getFullYear() + '-' + pad(first.getMonth() + 1) + '-' + pad(first.getDate()) + ' ' +
+        pad(first.getHours()) + ':' + pad(first.getMinutes()) + ':' + pad(first.getSeconds());
+    showPrompt('Modified time (YYYY-MM-DD HH:MM:SS, local):', def, 'Set Modified Time').then(function(val) {
+        if (!val) return;
+        var t = new Date(val.trim().replace(' ', 'T'));
+        if (isNaN(t.getTime())) { showAlert('Invalid date: ' + val); return; }
+        var paths = selectedRows.map(r => r.dataset.path);
+        var chain = Promise.resolve();
+        paths.forEach(function(p) {
+            chain = chain.then(function() {
+                return fetch('?touch=' + encodeURIComponent(p) + '&mtime=' + t.getTime(), { method: 'POST' })
+                    .then(r => r.json())
+                    .then(data => { if (!data.success) showAlert('Error updating ' + p + ': ' + data.error); });
+            });
+        });
+        chain.then(function() { location.reload(); });
+    });
+}
+
+function ctxChmodSelected() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var what = selectedRows.length === 1 ? selectedRows[0].dataset.name : selectedRows.length + ' items';
+    var def = (selectedRows[0].dataset.perm || '0644').replace(/^0/, '');
+    showPrompt('Permissions for ' + what + ' in octal, such as 644 or 755:', def, 'Change Permissions').then(function(val) {
+        if (!val) return;
+        var mode = val.trim();
+        if (!/^[0-7]{3,4}$/.test(mode)) { showAlert('Invalid mode: ' + val); return; }
+        var paths = selectedRows.map(r => r.dataset.path);
+        var chain = Promise.resolve();
+        paths.forEach(function(p) {
+            chain = chain.then(function() {
+                return fetch('?chmod=' + encodeURIComponent(p) + '&mode=' + mode, { method: 'POST' })
+                    .then(r => r.json())
+                    .then(data => { if (!data.success) showAlert('Error updating ' + p + ': ' + data.error); });
+            });
+        });
+        chain.then(function() { location.reload(); });
+    });
+}
+
+function ctxCopyLink() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var base = shareOrigin;
+    var urls = selectedRows.map(r => base + r.dataset.path);
+    var text = urls.join('\n');
+    navigator.clipboard.writeText(text).then(function() {
+        // Brief visual feedback
+        var count = document.getElementById('selectionCount');
+        if (count) { var orig = count.textContent; count.textContent = 'Link copied!'; setTimeout(function() { count.textContent = orig; }, 1500); }
+    }).catch(function() {
+        showPrompt('Copy link:', text, 'Copy Link');
+    });
+}
+
+function copyFolderLink() {
+    hideAllMenus();
+    var url = shareOrigin + window.location.pathname;
+    navigator.clipboard.writeText(url).then(function() {
+        // silent copy
+    }).catch(function() {
+        showPrompt('Copy link:', url, 'Copy Link');
+    });
+}
+
+// Hidden files stay shown (or hidden) until the browser is closed: the
+// cookie has no expiry, and the server reads it for every listing.
+function toggleHiddenFiles(show) {
+    hideAllMenus();
+    document.cookie = 'goserve_hidden=' + (show ? '1' : '0') + '; path=/; SameSite=Lax';
+    location.reload();
+}
+
+function ctxDeleteSelected() {
+    hideAllMenus();
+    if (selectedRows.length === 0) return;
+    var names = selectedRows.map(r => r.dataset.name || r.dataset.path);
+    var msg = selectedRows.length === 1
+        ? 'Delete ' + names[0] + '?'
+        : 'Delete ' + selectedRows.length + ' items?\n' + names.join('\n');
+    showConfirm(msg, 'Delete', true).then(function(ok) {
+        if (!ok) return;
+        runBatch('delete', selectedRows.map(r => r.dataset.path));
+    });
+}
+
+function ctxMoveSelected() {
+    if (selectedRows.length === 0) return;
+    showFolderPicker('move', selectedRows.map(r => r.dataset.path));
+}
+
+// Delete or move items in one request, then list any that failed or were
+// left where they were
+function runBatch(op, paths, dest, conflict) {
+    fetch('/api/v1/batch', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({op: op, paths: paths, dest: dest || '', conflict: conflict || ''})
+    })
+        .then(r => r.json())
+        .then(data => {
+            if (!data.results) { showAlert('Error: ' + data.error); return; }
+            var skipped = data.results.filter(x => x.status === 'skipped').map(x => x.path);
+            if (data.failed === 0 && skipped.length) {
+                showAlert('Already there, so left alone:\n' + skipped.join('\n'), 'Skipped').then(() => location.reload());
+                return;
+            }
+            if (data.failed === 0) { location.reload(); return; }
+            var lines = data.results.filter(x => !x.ok).map(x => x.path + ': ' + x.error);
+            showAlert(data.failed + ' of ' + data.results.length + ' items failed:\n' + lines.join('\n'), 'Error')
+                .then(() => location.reload());
+        })
+        .catch(err => showAlert('Error: ' + err.message));
+}
+
+function ctxCopySelected() {
+    if (selectedRows.length === 0) return;
+    showFolderPicker('copy', selectedRows.map(r => r.dataset.path));
+}
+
+// Destination picker for Move To and Copy To. It browses folders through
+// the JSON listing, starting from this one, and sends the move or copy to
+// the folder it shows.
+var picker = null;
+
+function showFolderPicker(op, paths) {
+    hideAllMenus();
+    picker = {op: op, paths: paths, path: decodeURIComponent(window.location.pathname)};
+    var what = paths.length === 1 ? selectedRows[0].dataset.name : paths.length + ' items';
+    var verb = op === 'move' ? 'Move' : 'Copy';
+    document.getElementById('folderPickerTitle').textContent = verb + ' To';
+    document.getElementById('folderPickerItems').textContent = verb + ' ' + what + ' into the folder below. Click a folder to open it.';
+    document.getElementById('folderPickerRun').textContent = verb + ' Here';
+    document.getElementById('folderPickerConflict').value = '';
+    document.getElementById('folderPickerModal').style.display = 'block';
+    pickerOpen(picker.path);
+}
+
+function pickerOpen(dir) {
+    var list = document.getElementById('folderPickerList');
+    list.innerHTML = '<div class="organize-note">Loading…</div>';
+    var url = dir.replace(/\/*$/, '/').split('/').map(encodeURIComponent).join('/');
+    fetch(url + '?format=json')
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { list.innerHTML = '<div class="organize-note">' + escapeHtml(data.error) + '</div>'; return; }
+            picker.path = data.path;
+            document.getElementById('folderPickerPath').textContent = data.path;
+            document.getElementById('folderPickerUp').disabled = data.path === '/';
+            // The selected folders can't go inside themselves
+            var dirs = data.entries.filter(e => e.isDir && !e.error && !picker.paths.includes(e.path));
+            dirs.sort((a, b) => a.name.localeCompare(b.name));
+            list.innerHTML = dirs.length ? '' : '<div class="organize-note">No folders here</div>';
+            dirs.forEach(function(e) {
+                var row = document.createElement('div');
+                row.className = 'picker-row';
+                row.textContent = '📁 ' + e.name;
+                row.onclick = () => pickerOpen(e.path);
+                list.appendChild(row);
+            });
+        })
+        .catch(err => { list.innerHTML = '<div class="organize-note">Error: ' + escapeHtml(err.message) + '</div>'; });
+}
+
+function pickerUp() {
+    var p = picker.path.replace(/\/+$/, '');
+    pickerOpen(p.substring(0, p.lastIndexOf('/')) || '/');
+}
+
+function closeFolderPicker() {
+    document.getElementById('folderPickerModal').style.display = 'none';
+}
+
+function runFolderPicker() {
+    var dest = picker.path, conflict = document.getElementById('folderPickerConflict').value;
+    closeFolderPicker();
+    if (picker.op === 'move') {
+        runBatch('move', picker.paths, dest, conflict);
+        return;
+    }
+    var here = dest.replace(/\/+$/, '') === decodeURIComponent(window.location.pathname).replace(/\/+$/, '');
+    startJob('copy', {paths: picker.paths, dest: dest, conflict: conflict}, here);
+}
+
+// New Folder modal
+function showNewFolderModal() {
+    hideAllMenus();
+    document.getElementById('newFolderName').value = '';
+    document.getElementById('newFolderModal').style.display = 'block';
+    setTimeout(() => document.getElementById('newFolderName').focus(), 100);
+}
+
+function closeNewFolderModal() {
+    document.getElementById('newFolderModal').style.display = 'none';
+}
+
+function createNewFolder() {
+    const name = document.getElementById('newFolderName').value.trim();
+    if (!name) return;
+    if (name.includes('/') || name.includes('\\') || name.includes('..')) {
+        showAlert('Invalid folder name');
+        return;
+    }
+    fetch(window.location.pathname + '?mkdir=' + encodeURIComponent(name), { method: 'POST' })
+        .then(r => r.json())
+        .then(data => {
+            if (data.success) { closeNewFolderModal(); location.reload(); }
+            else showAlert('Error: ' + data.error);
+        })
+        .catch(err => showAlert('Error creating folder: ' + err.message));
+}
+
+// File/folder upload via context menu
+function triggerFileUpload() {
+    hideAllMenus();
+    document.getElementById('fileInput')?.click();
+}
+
+function triggerFolderUpload() {
+    hideAllMenus();
+    document.getElementById('dirInput')?.click();
+}
+
+// files: File objects or {file, path} pairs; dirs: empty directories to recreate.
+// A folder upload is checked against what is already here first (planMerge).
+function uploadFiles(files, dirs) {
+    if (files.some(item => uploadPath(item).includes('/'))) planMerge(files, dirs);
+    else checkSpace(files, dirs);
+}
+
+// The path a file is uploaded to, relative to this folder
+function uploadPath(item) {
+    const file = item.file || item;
+    return item.path || file.webkitRelativePath || file.name;
+}
+
+// Asks first when the upload is bigger than the space left on the volume.
+// conflict, if set, overrides the server's -upload-conflict for this upload.
+function checkSpace(files, dirs, conflict) {
+    if (!document.getElementById('diskSpace')) { sendUpload(files, dirs, conflict); return; }
+    const size = files.reduce((n, item) => n + (item.file || item).size, 0);
+    fetch('/api/v1/space?path=' + encodeURIComponent(decodeURIComponent(window.location.pathname)))
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success || size <= data.space.free) { sendUpload(files, dirs, conflict); return; }
+            showConfirm('This upload is ' + formatBytes(size) + ' but only ' + formatBytes(data.space.free) +
+                ' is free here, so it will probably fail part way. Upload anyway?', 'Not Enough Space', true)
+                .then(ok => { if (ok) sendUpload(files, dirs, conflict); });
+        }, () => sendUpload(files, dirs, conflict));
+}
+
+// Folder merge: the server says what each file would do here (?merge=1).
+// When some already exist, the user picks which to send: new files and
+// newer versions are ticked, identical files and older versions are not.
+var mergePending = null;
+
+function planMerge(files, dirs) {
+    const req = {files: files.map(item => {
+        const file = item.file || item;
+        return {path: uploadPath(item), size: file.size, mtime: file.lastModified};
+    })};
+    fetch(window.location.pathname + '?merge=1', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(req)
+    }).then(r => r.json()).then(data => {
+        if (!data.success) { showAlert('Upload failed: ' + data.error); return; }
+        const c = data.counts;
+        if (c.overwrite + c.same + c.refused === 0) { checkSpace(files, dirs); return; }
+        showMerge(files, dirs, data.entries);
+    }, () => checkSpace(files, dirs));
+}
+
+function showMerge(files, dirs, entries) {
+    mergePending = {files: files, dirs: dirs};
+    const groups = [
+        ['overwrite', 'Already here, different'],
+        ['add', 'New'],
+        ['same', 'Already here, unchanged'],
+        ['refused', 'Can\'t be uploaded']
+    ];
+    const when = t => t ? new Date(t).toLocaleString() : 'unknown date';
+    let html = '';
+    groups.forEach(([action, title]) => {
+        const rows = entries.map((e, i) => [e, i]).filter(([e]) => e.action === action);
+        if (rows.length === 0) return;
+        html += '<h4>' + title + ' (' + rows.length + ')</h4>';
+        rows.forEach(([e, i]) => {
+            const checked = action === 'add' || (action === 'overwrite' && e.newer);
+            let meta = formatBytes(e.size) + ', ' + when(e.modified);
+            if (action === 'overwrite') {
+                meta += (e.newer ? ' \u2014 newer than ' : ' \u2014 replaces ') + formatBytes(e.existingSize) + ', ' + when(e.existingModified);
+            } else if (action === 'refused') {
+                meta = escapeHtml(e.reason);
+            }
+            html += '<label class="merge-row' + (action === 'refused' ? ' refused' : '') + '">' +
+                '<input type="checkbox" data-i="' + i + '"' + (checked ? ' checked' : '') + (action === 'refused' ? ' disabled' : '') + '>' +
+                '<span class="merge-path">' + escapeHtml(e.path) + '</span><span class="merge-meta">' + meta + '</span></label>';
+        });
+    });
+    const c = {add: 0, overwrite: 0, same: 0, refused: 0};
+    entries.forEach(e => c[e.action]++);
+    document.getElementById('mergeSummary').textContent = c.add + ' new, ' + c.overwrite + ' to overwrite, ' +
+        c.same + ' unchanged' + (c.refused ? ', ' + c.refused + ' refused' : '') +
+        '. Only the ticked files are uploaded; files here that aren\'t in the upload are left as they are.';
+    document.getElementById('mergeList').innerHTML = html;
+    document.getElementById('mergeModal').style.display = 'block';
+}
+
+function closeMerge() {
+    document.getElementById('mergeModal').style.display = 'none';
+    mergePending = null;
+}
+
+function runMerge() {
+    const pending = mergePending;
+    const picked = Array.from(document.querySelectorAll('#mergeList input:checked'))
+        .map(box => pending.files[Number(box.dataset.i)]);
+    closeMerge();
+    // The user saw which files are here already and chose to replace them
+    if (picked.length > 0) checkSpace(picked, pending.dirs, 'overwrite');
+}
+
+function sendUpload(files, dirs, conflict) {
+    const formData = new FormData();
+    const keepDates = localStorage.getItem('keepDates') !== 'false';
+    files.forEach(item => {
+        const file = item.file || item;
+        const path = uploadPath(item);
+        formData.append('files', file, path);
+        formData.append('paths', path);
+        if (keepDates) formData.append('mtime', file.lastModified);
+    });
+    (dirs || []).forEach(d => formData.append('dirs', d));
+    fetch(window.location.pathname + '?upload=1' + (conflict ? '&conflict=' + conflict : ''), {
+        method: 'POST',
+        headers: {'Accept': 'application/json'},
+        body: formData
+    }).then(response => {
+        response.json().then(data => {
+            if (!data.success) { showAlert('Upload failed: ' + data.error); return; }
+            const note = uploadNote(data.results || []);
+            if (note) showAlert(note, 'Upload finished').then(() => window.location.reload());
+            else window.location.reload();
+        }, () => showAlert('Upload failed'));
+    }).catch(err => {
+        showAlert('Upload error: ' + err.message);
+    });
+}
+
+// uploadNote describes the files that weren't stored as sent, if any.
+function uploadNote(results) {
+    const by = status => results.filter(r => r.status === status);
+    const lines = [];
+    const list = rs => rs.slice(0, 10).map(r => '  ' + r.name + (r.storedAs ? ' \u2192 ' + r.storedAs : '') +
+        (r.error ? ': ' + r.error : '')).join('\n') + (rs.length > 10 ? '\n  and ' + (rs.length - 10) + ' more' : '');
+    const skipped = by('skipped'), renamed = by('renamed'), failed = by('failed');
+    if (skipped.length) lines.push('Already here, so skipped:\n' + list(skipped));
+    if (renamed.length) lines.push('Already here, so saved under a new name:\n' + list(renamed));
+    if (failed.length) lines.push('Not uploaded:\n' + list(failed));
+    return lines.join('\n\n');
+}
+
+document.getElementById('fileInput')?.addEventListener('change', function(e) {
+    const files = Array.from(e.target.files);
+    if (files.length > 0) uploadFiles(files);
+    e.target.value = '';
+});
+
+document.getElementById('dirInput')?.addEventListener('change', function(e) {
+    const files = Array.from(e.target.files);
+    if (files.length > 0) uploadFiles(files);
+    e.target.value = '';
+});
+
+// Drag-and-drop upload. Walks dropped folders so empty directories are kept.
+function walkEntry(entry, prefix, files, dirs) {
+    if (entry.isFile) {
+        return new Promise(function(resolve) {
+            entry.file(function(f) { files.push({file: f, path: prefix + f.name}); resolve(); }, resolve);
+        });
+    }
+    var reader = entry.createReader();
+    var children = [];
+    function readAll() {
+        return new Promise(function(resolve) {
+            reader.readEntries(function(batch) {
+                if (batch.length === 0) { resolve(); return; }
+                children = children.concat(batch);
+                readAll().then(resolve);
+            }, resolve);
+        });
+    }
+    return readAll().then(function() {
+        if (children.length === 0) { dirs.push(prefix + entry.name); return; }
+        return Promise.all(children.map(function(c) { return walkEntry(c, prefix + entry.name + '/', files, dirs); }));
+    });
+}
+
+if (document.getElementById('fileInput')) {
+    var dropTarget = document.querySelector('.table-container');
+    dropTarget.addEventListener('dragover', function(e) { e.preventDefault(); });
+    dropTarget.addEventListener('drop', function(e) {
+        e.preventDefault();
+        var files = [], dirs = [];
+        var entries = Array.from(e.dataTransfer.items || [])
+            .map(function(it) { return it.webkitGetAsEntry ? it.webkitGetAsEntry() : null; })
+            .filter(function(en) { return en; });
+        if (entries.length === 0) {
+            uploadFiles(Array.from(e.dataTransfer.files));
+            return;
+        }
+        Promise.all(entries.map(function(en) { return walkEntry(en, '', files, dirs); })).then(function() {
+            if (files.length > 0 || dirs.length > 0) uploadFiles(files, dirs);
+        });
+    });
+
+    // Ctrl+V saves a copied image or text here (?paste=<name>)
+    document.addEventListener('paste', function(e) {
+        var t = e.target;
+        if (t.isContentEditable || /^(INPUT|TEXTAREA|SELECT)$/.test(t.tagName)) return;
+        if (document.getElementById('dialogOverlay').classList.contains('active')) return;
+        if (Array.from(document.querySelectorAll('.preview-modal')).some(m => m.style.display === 'block')) return;
+        var data = e.clipboardData;
+        if (!data) return;
+        var file = Array.from(data.files || []).find(f => f.size > 0);
+        var text = data.getData('text/plain');
+        if (!file && !text) return;
+        e.preventDefault();
+        var d = new Date(), pad = n => String(n).padStart(2, '0');
+        var stamp = d.getFullYear() + '-' + pad(d.getMonth() + 1) + '-' + pad(d.getDate()) + ' ' +
+            pad(d.getHours()) + '.' + pad(d.getMinutes()) + '.' + pad(d.getSeconds());
+        var body, suggested;
+        if (file) {
+            // Screenshots arrive as "image.png"; a copied file keeps its name
+            var ext = (file.type.split('/')[1] || 'png').replace('jpeg', 'jpg');
+            suggested = /^image\.\w+$/.test(file.name) || !file.name ? 'Pasted image ' + stamp + '.' + ext : file.name;
+            body = file;
+        } else {
+            suggested = 'Pasted text ' + stamp + '.txt';
+            body = new Blob([text], {type: 'text/plain; charset=utf-8'});
+        }
+        showPrompt(file ? 'Save the pasted ' + (file.type.startsWith('image/') ? 'image' : 'file') + ' (' + formatBytes(body.size) + ') as:' :
+            'Save the pasted text as:', suggested, 'Paste').then(function(name) {
+            if (!name) return;
+            fetch(window.location.pathname + '?paste=' + encodeURIComponent(name), {
+                method: 'POST',
+                headers: {'Content-Type': body.type || 'application/octet-stream'},
+                body: body
+            }).then(r => r.json()).then(function(data) {
+                if (!data.success) { showAlert('Paste failed: ' + data.error); return; }
+                sessionStorage.setItem('goserve_select', data.entry.name);
+                location.reload();
+            }).catch(function(err) { showAlert('Paste failed: ' + err.message); });
+        });
+    });
+
+    var keepDatesBox = document.getElementById('keepDates');
+    if (keepDatesBox) {
+        keepDatesBox.checked = localStorage.getItem('keepDates') !== 'false';
+        keepDatesBox.addEventListener('change', function() { localStorage.setItem('keepDates', this.checked); });
+    }
+}
+
+// Inject breadcrumb caret on last item (only if folder context menu has items)
+(function() {
+    if (!document.querySelector('#folderContextMenu .context-menu-item')) return;
+    const crumbs = document.querySelectorAll('.breadcrumb a');
+    if (crumbs.length > 0) {
+        const last = crumbs[crumbs.length - 1];
+        const caret = document.createElement('button');
+        caret.className = 'breadcrumb-caret';
+        caret.textContent = '\u25BE';
+        caret.title = 'Actions';
+        caret.onclick = function(e) { toggleContextMenu(e); };
+        last.insertAdjacentElement('afterend', caret);
+    }
+})();
+
+updateSortArrows();
+
+// Auto-select folder we navigated up from
+(function() {
+    var name = sessionStorage.getItem('goserve_select');
+    if (!name) return;
+    sessionStorage.removeItem('goserve_select');
+    var rows = document.querySelectorAll('#fileTable tbody tr');
+    for (var i = 0; i < rows.length; i++) {
+        if (rows[i].dataset.name === name) {
+            selectRow(rows[i], false);
+            return;
+        }
+    }
+})();
+
+// Live listing: the server tells the page when something in this folder
+// changes and the rows are fetched again, keeping the selection, the
+// filter and the order. Changes often come in bursts (a folder upload, a
+// sync), so the reload waits for a quiet moment.
+(function() {
+    if (!window.EventSource) return;
+    var reloadTimer = null;
+    var source = new EventSource(window.location.pathname + '?events=1');
+    source.addEventListener('change', function() {
+        clearTimeout(reloadTimer);
+        reloadTimer = setTimeout(reloadListing, 500);
+    });
+
+    function reloadListing() {
+        // Without a server-side filter the box only hides rows, so ask for
+        // them all and filter here as before
+        var u = new URL(window.location.href);
+        if (!page.filter) u.searchParams.delete('filter');
+        fetch(u.pathname + u.search, {cache: 'no-store'})
+            .then(r => { if (!r.ok) throw new Error(r.status); return r.text(); })
+            .then(function(html) {
+                var doc = new DOMParser().parseFromString(html, 'text/html');
+                var fresh = doc.querySelector('#fileTable tbody');
+                if (!fresh) return;
+                var selected = selectedRows.map(r => r.dataset.path);
+                var last = lastSelectedRow ? lastSelectedRow.dataset.path : null;
+                var tbody = document.querySelector('#fileTable tbody');
+                tbody.innerHTML = fresh.innerHTML;
+                selectedRows = [];
+                lastSelectedRow = null;
+                tbody.querySelectorAll('tr').forEach(function(tr) {
+                    if (selected.includes(tr.dataset.path)) {
+                        tr.classList.add('selected');
+                        selectedRows.push(tr);
+                    }
+                    if (tr.dataset.path === last) lastSelectedRow = tr;
+                });
+                updateSelectionBar();
+                filterFiles();
+                updateItemCount();
+                loadFolderSizes(0);
+            })
+            .catch(function() {});
+    }
+})();
@@ -0,0 +1,72 @@
+// Guest accounts that expire (administrators)
+function showGuests() {
+    document.getElementById('guestsModal').style.display = 'block';
+    refreshGuests();
+}
+
+function closeGuests() {
+    document.getElementById('guestsModal').style.display = 'none';
+}
+
+function createGuest() {
+    var req = {
+        username: document.getElementById('guestName').value.trim(),
+        password: document.getElementById('guestPassword').value,
+        permission: document.getElementById('guestPermission').value.trim(),
+        home: document.getElementById('guestHome').value.trim(),
+        expires: document.getElementById('guestExpires').value
+    };
+    fetch('/api/v1/admin/guests', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json()).then(function(data) {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            document.getElementById('guestName').value = '';
+            document.getElementById('guestPassword').value = '';
+            document.getElementById('guestHome').value = '';
+            refreshGuests();
+            if (data.password) showPrompt('Password for ' + data.guest.username + ' (shown only now):', data.password, 'Guest Added');
+        });
+}
+
+function extendGuest(name) {
+    showPrompt('New expiry for ' + name + ': a duration from now such as 7d, or a date such as 2026-12-31', '7d', 'Extend Guest').then(function(v) {
+        if (!v) return;
+        fetch('/api/v1/admin/guests/' + encodeURIComponent(name), { method: 'PATCH', headers: {'Content-Type': 'application/json'}, body: JSON.stringify({expires: v}) })
+            .then(r => r.json()).then(function(data) {
+                if (!data.success) { showAlert('Error: ' + data.error); return; }
+                refreshGuests();
+            });
+    });
+}
+
+function removeGuest(name) {
+    showConfirm('Remove ' + name + '? They are signed out everywhere at once.', 'Remove Guest', true).then(function(ok) {
+        if (!ok) return;
+        fetch('/api/v1/admin/guests/' + encodeURIComponent(name), { method: 'DELETE' }).then(refreshGuests);
+    });
+}
+
+function refreshGuests() {
+    var list = document.getElementById('guestsList');
+    fetch('/api/v1/admin/guests').then(r => r.json()).then(function(data) {
+        if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+        if (data.guests.length === 0) {
+            list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No accounts expire</p>';
+            return;
+        }
+        var html = '<table style="width:100%;font-size:12px;"><tr><th>User</th><th>Permission</th><th>Expires</th><th>Added</th><th></th></tr>';
+        data.guests.forEach(function(g) {
+            var name = escapeHtml(g.username);
+            var arg = escapeHtml(JSON.stringify(g.username));
+            html += '<tr><td>' + name + (g.home ? '<br><span style="color: var(--text-secondary);">' + escapeHtml(g.home) + '</span>' : '') + '</td>' +
+                '<td style="word-break:break-all;">' + escapeHtml(g.permission) + '</td>' +
+                '<td style="white-space:nowrap;' + (g.expired ? ' color: #e74c3c;' : '') + '">' +
+                    (g.expired ? 'expired ' : '') + new Date(g.expires).toLocaleString() + '</td>' +
+                '<td style="white-space:nowrap;">' + (g.source === 'logins' ? 'logins file' :
+                    escapeHtml(g.createdBy) + '<br><span style="color: var(--text-secondary);">' + new Date(g.created).toLocaleDateString() + '</span>') + '</td>' +
+                '<td style="white-space:nowrap;">' + (g.source === 'logins' ? '' :
+                    '<button class="btn" onclick="extendGuest(' + arg + ')">Extend</button> ' +
+                    '<button class="btn" onclick="removeGuest(' + arg + ')">Remove</button>') + '</td></tr>';
+        });
+        list.innerHTML = html + '</table>';
+    });
+}
@@ -0,0 +1,77 @@
+// Help overlay, built from what this server and user can actually do
+function showHelp() {
+    document.getElementById('helpModal').style.display = 'block';
+    var body = document.getElementById('helpBody');
+    body.textContent = 'Loading...';
+    var here = decodeURIComponent(window.location.pathname);
+    fetch('/api/v1/capabilities?path=' + encodeURIComponent(here)).then(r => r.json()).then(function(info) {
+        var access = info.canModify ? 'Full access' : info.canUpload ? 'Browse and upload' : 'Read-only';
+        if (info.canUpload && !info.caps.upload) access += ' (this folder is read-only)';
+        var html = '<p style="color: var(--text-secondary); font-size: 13px; margin-top: 0;">' +
+            (info.user ? 'Signed in as <strong>' + escapeHtml(info.user) + '</strong> — ' : '') + access + '</p>';
+        html += '<h4 style="margin-bottom: 6px;">Keyboard shortcuts</h4><table style="width:100%;font-size:13px;">';
+        info.shortcuts.forEach(function(s) {
+            html += '<tr><td style="white-space:nowrap;padding-right:12px;"><kbd>' + escapeHtml(s.keys) + '</kbd></td><td>' +
+                escapeHtml(s.name) + ' <span style="color: var(--text-secondary);">— ' + escapeHtml(s.description) + '</span></td></tr>';
+        });
+        html += '</table><h4 style="margin-bottom: 6px;">What you can do here</h4><table style="width:100%;font-size:13px;">';
+        info.actions.forEach(function(a) {
+            html += '<tr><td style="white-space:nowrap;padding-right:12px;vertical-align:top;">' + escapeHtml(a.name) +
+                '</td><td style="color: var(--text-secondary);">' + escapeHtml(a.description) + '</td></tr>';
+        });
+        body.innerHTML = html + '</table>';
+    }).catch(function() { body.textContent = 'Help is unavailable'; });
+}
+
+function closeHelp() {
+    document.getElementById('helpModal').style.display = 'none';
+}
+
+// Access log viewer (admins)
+var accessLogPage = 1;
+
+function showAccessLog() {
+    document.getElementById('accessLogModal').style.display = 'block';
+    loadAccessLog(1);
+}
+
+function loadAccessLog(page) {
+    accessLogPage = Math.max(page, 1);
+    var params = new URLSearchParams({ page: accessLogPage, limit: 100 });
+    [['path', 'alPath'], ['user', 'alUser'], ['ip', 'alIP'], ['status', 'alStatus']].forEach(function(f) {
+        var v = document.getElementById(f[1]).value.trim();
+        if (v) params.set(f[0], v);
+    });
+    [['from', 'alFrom'], ['to', 'alTo']].forEach(function(f) {
+        var v = document.getElementById(f[1]).value;
+        if (v) params.set(f[0], new Date(v).toISOString());
+    });
+    var body = document.getElementById('accessLogBody');
+    var info = document.getElementById('accessLogInfo');
+    body.textContent = 'Loading...';
+    fetch('/api/v1/access-log?' + params).then(r => r.json()).then(function(data) {
+        if (!data.success) { body.textContent = 'Error: ' + data.error; info.textContent = ''; return; }
+        var pages = Math.max(Math.ceil(data.total / data.limit), 1);
+        info.textContent = data.total + ' requests' + (data.persisted ? '' : ' (since server start)') + ' — page ' + data.page + ' of ' + pages;
+        document.getElementById('alPrev').disabled = data.page <= 1;
+        document.getElementById('alNext').disabled = data.page >= pages;
+        if (data.entries.length === 0) {
+            body.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No matching requests</p>';
+            return;
+        }
+        var html = '<table style="width:100%;font-size:12px;"><tr><th>Time</th><th>User / IP</th><th>Method</th><th>Path</th><th>Status</th><th>Size</th></tr>';
+        data.entries.forEach(function(e) {
+            var who = (e.user ? escapeHtml(e.user) + ' ' : '') + '<span style="color: var(--text-secondary);">' + escapeHtml(e.ip) + (e.country ? ' ' + e.country : '') + '</span>';
+            var target = escapeHtml(e.path) + (e.query ? '<span style="color: var(--text-secondary);">?' + escapeHtml(e.query) + '</span>' : '');
+            var color = e.status >= 500 ? '#e74c3c' : e.status >= 400 ? '#e67e22' : 'inherit';
+            html += '<tr title="' + escapeHtml(e.agent || '') + '"><td style="white-space:nowrap;">' + new Date(e.time).toLocaleString() + '</td><td>' + who +
+                '</td><td>' + e.method + '</td><td style="word-break:break-all;">' + target + '</td><td style="color:' + color + ';">' + e.status +
+                '</td><td style="white-space:nowrap;">' + formatBytes(e.bytes) + '</td></tr>';
+        });
+        body.innerHTML = html + '</table>';
+    });
+}
+
+function closeAccessLog() {
+    document.getElementById('accessLogModal').style.display = 'none';
+}
@@ -0,0 +1,181 @@
+// Background jobs panel
+var jobsTimer = null;
+
+// startJob posts a job for the current folder and opens the Jobs panel.
+// With reloadWhenDone the listing is refreshed once the job succeeds.
+function startJob(type, extra, reloadWhenDone) {
+    hideAllMenus();
+    var req = Object.assign({type: type, path: decodeURIComponent(window.location.pathname)}, extra || {});
+    fetch('/api/v1/jobs', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            showJobs();
+            if (reloadWhenDone) watchJob(data.job.id);
+        })
+        .catch(err => showAlert('Error starting job: ' + err.message));
+}
+
+function watchJob(id) {
+    setTimeout(function() {
+        fetch('/api/v1/jobs/' + id).then(r => r.json()).then(data => {
+            if (!data.success) return;
+            if (data.job.status === 'running') watchJob(id);
+            else if (data.job.status === 'done') location.reload();
+        });
+    }, 1000);
+}
+
+function showJobs() {
+    document.getElementById('jobsModal').style.display = 'block';
+    refreshJobs();
+    if (!jobsTimer) jobsTimer = setInterval(refreshJobs, 1000);
+}
+
+function closeJobs() {
+    document.getElementById('jobsModal').style.display = 'none';
+    if (jobsTimer) { clearInterval(jobsTimer); jobsTimer = null; }
+}
+
+function cancelJob(id) {
+    fetch('/api/v1/jobs/' + id, { method: 'DELETE' }).then(refreshJobs);
+}
+
+function refreshJobs() {
+    fetch('/api/v1/jobs').then(r => r.json()).then(data => {
+        var list = document.getElementById('jobsList');
+        if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+        if (data.jobs.length === 0) {
+            list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No recent jobs</p>';
+            return;
+        }
+        list.innerHTML = data.jobs.map(function(j) {
+            var pct = j.total > 0 ? Math.round(j.done * 100 / j.total) : (j.status === 'running' ? 0 : 100);
+            var status = j.status + (j.message ? ' \u2014 ' + j.message : '') + (j.error ? ': ' + j.error : '');
+            var failures = (j.failures || []).map(f => '<div>' + escapeHtml(f) + '</div>').join('');
+            if (j.failedCount > (j.failures || []).length) failures += '<div>\u2026</div>';
+            var actions = '';
+            if (j.status === 'running') actions = '<button class="btn" onclick="cancelJob(\'' + j.id + '\')">Cancel</button>';
+            else if (j.result && j.status === 'done') actions = '<a class="btn" href="' + escapeHtml(j.result) + '">Open</a>';
+            return '<div class="job-row"><div class="job-head"><span class="job-title">' + escapeHtml(j.type) + ' ' + escapeHtml(j.path) +
+                '</span>' + actions + '</div><div class="job-bar"><div style="width:' + pct + '%"></div></div>' +
+                '<div class="job-status">' + escapeHtml(status) + ' \u00b7 ' + escapeHtml(j.owner) + '</div>' +
+                (failures ? '<div class="job-failures">' + failures + '</div>' : '') + '</div>';
+        }).join('');
+    });
+}
+
+// Organize by Date: a dry run lists the moves, then the real job runs them
+var organizeTimer = null;
+
+function showOrganize() {
+    hideAllMenus();
+    var list = document.getElementById('organizeList');
+    var run = document.getElementById('organizeRun');
+    list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">Reading dates\u2026</p>';
+    run.disabled = true;
+    document.getElementById('organizeModal').style.display = 'block';
+    var req = {type: 'organize', path: decodeURIComponent(window.location.pathname), dryRun: true};
+    fetch('/api/v1/jobs', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+            pollOrganize(data.job.id);
+        })
+        .catch(err => { list.textContent = 'Error: ' + err.message; });
+}
+
+function pollOrganize(id) {
+    organizeTimer = setTimeout(function() {
+        fetch('/api/v1/jobs/' + id).then(r => r.json()).then(data => {
+            if (!data.success) return;
+            var j = data.job, list = document.getElementById('organizeList');
+            if (j.status === 'running') {
+                list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">Reading dates\u2026 ' + j.done + ' / ' + j.total + '</p>';
+                pollOrganize(id);
+                return;
+            }
+            organizeTimer = null;
+            if (j.status !== 'done') { list.textContent = 'Error: ' + (j.error || j.status); return; }
+            var moves = j.moves || [];
+            if (moves.length === 0) {
+                list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No photos or videos to organize here</p>';
+                return;
+            }
+            var base = j.path.replace(/\/+$/, '') + '/';
+            list.innerHTML = '<p style="font-size: 13px;">' + escapeHtml(j.message) + '</p>' + moves.map(function(m) {
+                var note = m.taken === 'exif' ? '' : ' <span class="organize-note">(modified date)</span>';
+                return '<div>' + escapeHtml(m.from.slice(base.length)) + ' \u2192 ' + escapeHtml(m.to.slice(base.length)) + note + '</div>';
+            }).join('');
+            document.getElementById('organizeRun').disabled = false;
+        });
+    }, 500);
+}
+
+function closeOrganize() {
+    document.getElementById('organizeModal').style.display = 'none';
+    if (organizeTimer) { clearTimeout(organizeTimer); organizeTimer = null; }
+}
+
+function runOrganize() {
+    closeOrganize();
+    startJob('organize', null, true);
+}
+
+// Find Duplicates: a duplicates job lists groups of identical files and
+// similar images for review
+var duplicatesTimer = null;
+
+function showDuplicates() {
+    hideAllMenus();
+    var list = document.getElementById('duplicatesList');
+    list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">Scanning…</p>';
+    document.getElementById('duplicatesModal').style.display = 'block';
+    var req = {type: 'duplicates', path: decodeURIComponent(window.location.pathname)};
+    fetch('/api/v1/jobs', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+            pollDuplicates(data.job.id);
+        })
+        .catch(err => { list.textContent = 'Error: ' + err.message; });
+}
+
+function pollDuplicates(id) {
+    duplicatesTimer = setTimeout(function() {
+        fetch('/api/v1/jobs/' + id).then(r => r.json()).then(data => {
+            if (!data.success) return;
+            var j = data.job, list = document.getElementById('duplicatesList');
+            if (j.status === 'running') {
+                list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">' + escapeHtml(j.message || 'Scanning') +
+                    '… ' + j.done + ' / ' + j.total + '</p>';
+                pollDuplicates(id);
+                return;
+            }
+            duplicatesTimer = null;
+            if (j.status !== 'done') { list.textContent = 'Error: ' + (j.error || j.status); return; }
+            var groups = j.duplicates || [];
+            if (groups.length === 0) {
+                list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No duplicates found</p>';
+                return;
+            }
+            var base = window.location.pathname.replace(/\/+$/, '') + '/';
+            list.innerHTML = '<p style="font-size: 13px;">' + escapeHtml(j.message) + '</p>' + groups.map(function(g) {
+                var head = g.kind === 'identical' ?
+                    'Identical · ' + g.files.length + ' copies of ' + formatBytes(g.files[0].size) :
+                    'Similar images · ' + g.files.length;
+                return '<div class="dup-group"><div class="dup-head">' + head + '</div>' + g.files.map(function(f) {
+                    var href = escapeHtml(base + f.path.split('/').map(encodeURIComponent).join('/'));
+                    var meta = (f.width ? f.width + '×' + f.height + ' · ' : '') + formatBytes(f.size);
+                    return '<div class="dup-file">' + (g.kind === 'similar' ? '<img loading="lazy" src="' + href + '" alt="">' : '') +
+                        '<a href="' + href + '" target="_blank">' + escapeHtml(f.path) + '</a><span class="dup-meta">' + meta + '</span></div>';
+                }).join('') + '</div>';
+            }).join('');
+        });
+    }, 500);
+}
+
+function closeDuplicates() {
+    document.getElementById('duplicatesModal').style.display = 'none';
+    if (duplicatesTimer) { clearTimeout(duplicatesTimer); duplicatesTimer = null; }
+}
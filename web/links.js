@@ -0,0 +1,147 @@
+// Upload-only links for the current folder, short links and watched folders
+function showUploadLinks() {
+    hideAllMenus();
+    document.getElementById('uploadLinksModal').style.display = 'block';
+    if (document.getElementById('ulFolder')) {
+        document.getElementById('ulFolder').textContent = decodeURIComponent(window.location.pathname);
+        refreshUploadLinks();
+    }
+    refreshShortLinks();
+    document.getElementById('watchFolder').textContent = decodeURIComponent(window.location.pathname);
+    refreshWatches();
+}
+
+function closeUploadLinks() {
+    document.getElementById('uploadLinksModal').style.display = 'none';
+}
+
+function createUploadLink() {
+    var mb = parseFloat(document.getElementById('ulMaxMB').value) || 0;
+    var req = {
+        path: decodeURIComponent(window.location.pathname),
+        label: document.getElementById('ulLabel').value,
+        expires: document.getElementById('ulExpires').value,
+        maxBytes: Math.round(mb * 1024 * 1024),
+        notify: document.getElementById('ulNotify').value
+    };
+    fetch('/api/v1/upload-links', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json()).then(function(data) {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            var url = shareOrigin + data.url;
+            navigator.clipboard.writeText(url).catch(function() {});
+            document.getElementById('ulLabel').value = '';
+            document.getElementById('ulNotify').value = '';
+            refreshUploadLinks();
+        });
+}
+
+function revokeUploadLink(id) {
+    fetch('/api/v1/upload-links/' + id, { method: 'DELETE' }).then(refreshUploadLinks);
+}
+
+function refreshUploadLinks() {
+    var list = document.getElementById('uploadLinksList');
+    var here = decodeURIComponent(window.location.pathname).replace(/\/+$/, '') || '/';
+    fetch('/api/v1/upload-links').then(r => r.json()).then(function(data) {
+        if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+        var links = data.links.filter(function(l) { return l.path === here; });
+        if (links.length === 0) {
+            list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No upload links for this folder</p>';
+            return;
+        }
+        var html = '<table style="width:100%;font-size:12px;"><tr><th>Link</th><th>Received</th><th>Expires</th><th></th></tr>';
+        links.forEach(function(l) {
+            var url = shareOrigin + '/_up/' + l.id;
+            var expired = l.expires && new Date(l.expires) < new Date();
+            html += '<tr><td style="word-break:break-all;"><a href="' + url + '" target="_blank">' + escapeHtml(l.label || url) + '</a></td>' +
+                '<td style="white-space:nowrap;">' + (l.files === 0 ? 'Pending' :
+                    l.files + ' files, ' + formatBytes(l.used) + (l.maxBytes ? ' of ' + formatBytes(l.maxBytes) : '') +
+                    '<br><span style="color: var(--text-secondary);">last ' + new Date(l.received).toLocaleString() + '</span>') +
+                    (l.notify ? ' <span title="Notifies ' + escapeHtml(l.notify) + '">🔔</span>' : '') + '</td>' +
+                '<td style="white-space:nowrap;">' + (l.expires ? (expired ? 'expired' : new Date(l.expires).toLocaleDateString()) : 'never') + '</td>' +
+                '<td style="white-space:nowrap;"><button class="btn" onclick="navigator.clipboard.writeText(\'' + url + '\')">Copy</button> ' +
+                '<button class="btn" onclick="revokeUploadLink(\'' + l.id + '\')">Revoke</button></td></tr>';
+        });
+        list.innerHTML = html + '</table>';
+    });
+}
+
+function copyShortLink(path) {
+    hideAllMenus();
+    fetch('/api/v1/short-links', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify({path: path}) })
+        .then(r => r.json()).then(function(data) {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            var url = shareOrigin + data.url;
+            navigator.clipboard.writeText(url).then(function() {
+                var count = document.getElementById('selectionCount');
+                if (count && selectedRows.length) { var orig = count.textContent; count.textContent = 'Short link copied!'; setTimeout(function() { count.textContent = orig; }, 1500); }
+            }).catch(function() {
+                showPrompt('Copy link:', url, 'Copy Short Link');
+            });
+        });
+}
+
+function ctxCopyShortLink() {
+    if (selectedRows.length === 0) return;
+    copyShortLink(selectedRows[0].dataset.path);
+}
+
+function removeShortLink(id) {
+    fetch('/api/v1/short-links/' + id, { method: 'DELETE' }).then(refreshShortLinks);
+}
+
+function refreshShortLinks() {
+    var list = document.getElementById('shortLinksList');
+    fetch('/api/v1/short-links').then(r => r.json()).then(function(data) {
+        if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+        if (data.links.length === 0) {
+            list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No short links yet</p>';
+            return;
+        }
+        var html = '<table style="width:100%;font-size:12px;"><tr><th>Link</th><th>Target</th><th></th></tr>';
+        data.links.forEach(function(l) {
+            var url = shareOrigin + '/_s/' + l.id;
+            html += '<tr><td style="white-space:nowrap;"><a href="' + url + '" target="_blank">/_s/' + l.id + '</a></td>' +
+                '<td style="word-break:break-all;">' + escapeHtml(l.path) + '</td>' +
+                '<td style="white-space:nowrap;"><button class="btn" onclick="navigator.clipboard.writeText(\'' + url + '\')">Copy</button> ' +
+                '<button class="btn" onclick="removeShortLink(\'' + l.id + '\')">Remove</button></td></tr>';
+        });
+        list.innerHTML = html + '</table>';
+    });
+}
+
+function watchFolder() {
+    var req = { path: decodeURIComponent(window.location.pathname), webhook: document.getElementById('watchWebhook').value };
+    fetch('/api/v1/watches', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req) })
+        .then(r => r.json()).then(function(data) {
+            if (!data.success) { showAlert('Error: ' + data.error); return; }
+            navigator.clipboard.writeText(shareOrigin + data.rss).catch(function() {});
+            document.getElementById('watchWebhook').value = '';
+            refreshWatches();
+        });
+}
+
+function unwatchFolder(id) {
+    fetch('/api/v1/watches/' + id, { method: 'DELETE' }).then(refreshWatches);
+}
+
+function refreshWatches() {
+    var list = document.getElementById('watchesList');
+    fetch('/api/v1/watches').then(r => r.json()).then(function(data) {
+        if (!data.success) { list.textContent = 'Error: ' + data.error; return; }
+        if (data.watches.length === 0) {
+            list.innerHTML = '<p style="color: var(--text-secondary); font-size: 13px;">No watched folders yet</p>';
+            return;
+        }
+        var html = '<table style="width:100%;font-size:12px;"><tr><th>Folder</th><th>Feed</th><th></th></tr>';
+        data.watches.forEach(function(fw) {
+            var feed = shareOrigin + '/_feed/' + fw.token;
+            html += '<tr><td style="word-break:break-all;">' + escapeHtml(fw.path) +
+                    (fw.webhook ? ' <span title="Notifies ' + escapeHtml(fw.webhook) + '">🔔</span>' : '') + '</td>' +
+                '<td style="white-space:nowrap;"><a href="' + feed + '.rss" target="_blank">RSS</a> · <a href="' + feed + '.json" target="_blank">JSON</a></td>' +
+                '<td style="white-space:nowrap;"><button class="btn" onclick="navigator.clipboard.writeText(\'' + feed + '.rss\')">Copy</button> ' +
+                '<button class="btn" onclick="unwatchFolder(\'' + fw.id + '\')">Remove</button></td></tr>';
+        });
+        list.innerHTML = html + '</table>';
+    });
+}
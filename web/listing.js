@@ -0,0 +1,351 @@
+// listingURL is this page's address with query parameters changed; empty
+// values are dropped. ?sort, ?order and ?filter make the view linkable.
+function listingURL(params) {
+    var u = new URL(window.location.href);
+    Object.keys(params).forEach(function(k) {
+        if (params[k]) u.searchParams.set(k, params[k]);
+        else u.searchParams.delete(k);
+    });
+    return u.pathname + u.search + u.hash;
+}
+
+var filterReload;
+
+// Search/filter with wildcard support
+function filterFiles() {
+    const input = document.getElementById('searchBox');
+    const filter = input.value;
+
+    // Command mode: ":" prefix stops filtering
+    if (filter.startsWith(':')) {
+        input.style.borderColor = 'var(--accent)';
+        if (recursiveSearch) scheduleFind('');
+        return;
+    }
+    input.style.borderColor = '';
+
+    if (recursiveSearch) {
+        scheduleFind(filter);
+        return;
+    }
+
+    // The server left out what ?filter didn't match, so a different filter
+    // needs the listing again; otherwise the address just follows along
+    if (page.filter && filter !== page.filter) {
+        clearTimeout(filterReload);
+        filterReload = setTimeout(function() { window.location.replace(listingURL({filter: filter})); }, 400);
+        return;
+    }
+    history.replaceState(null, '', listingURL({filter: filter}));
+
+    const table = document.getElementById('fileTable');
+    const rows = table.getElementsByTagName('tr');
+
+    // Check if filter contains wildcards
+    const hasWildcard = filter.includes('*') || filter.includes('?');
+    let regex = null;
+
+    if (hasWildcard) {
+        // Convert wildcard pattern to regex
+        // Escape special regex chars except * and ?
+        let pattern = filter.replace(/[.+^${}()|[\]\\]/g, '\\$&');
+        // Convert wildcards: * -> .* and ? -> .
+        pattern = pattern.replace(/\*/g, '.*').replace(/\?/g, '.');
+        try {
+            regex = new RegExp('^' + pattern + '$', 'i');
+        } catch (e) {
+            // Invalid regex, fall back to substring search
+            regex = null;
+        }
+    }
+
+    for (let i = 1; i < rows.length; i++) {
+        const nameCell = rows[i].getElementsByClassName('name')[0];
+        if (nameCell) {
+            let txtValue = nameCell.textContent || nameCell.innerText;
+            // Remove trailing slash from directories
+            txtValue = txtValue.replace(/\/$/, '');
+
+            let matches = false;
+            if (regex) {
+                matches = regex.test(txtValue);
+            } else {
+                matches = txtValue.toLowerCase().indexOf(filter.toLowerCase()) > -1;
+            }
+
+            rows[i].style.display = matches ? '' : 'none';
+        }
+    }
+    updateItemCount();
+}
+
+function handleSearchKey(e) {
+    var input = document.getElementById('searchBox');
+    if (e.key === 'Enter' && caps.chdir && input.value.startsWith(':')) {
+        e.preventDefault();
+        var cmd = input.value.substring(1).trim();
+        if (!cmd) return;
+        fetch('/_api/chdir', {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json'},
+            body: JSON.stringify({dir: cmd})
+        })
+        .then(function(r) { return r.json(); })
+        .then(function(d) {
+            if (d.success) {
+                window.location.href = '/';
+            } else {
+                input.style.borderColor = '#f38ba8';
+                input.value = ':' + cmd + '  (' + d.error + ')';
+                setTimeout(function() {
+                    input.value = ':' + cmd;
+                    input.style.borderColor = 'var(--accent)';
+                }, 2000);
+            }
+        })
+        .catch(function() {
+            input.style.borderColor = '#f38ba8';
+        });
+        return;
+    }
+    if (recursiveSearch && findResults.length > 0 && !input.value.startsWith(':')) {
+        if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+            e.preventDefault();
+            var n = findResults.length;
+            findSelected = (findSelected + (e.key === 'ArrowDown' ? 1 : n - 1)) % n;
+            renderFindResults();
+            return;
+        }
+        if (e.key === 'Enter') {
+            e.preventDefault();
+            openFindResult(findSelected);
+            return;
+        }
+    }
+    if (e.key === 'Escape') {
+        input.value = '';
+        input.style.borderColor = '';
+        input.blur();
+        filterFiles();
+    }
+}
+
+// Subfolder search: with the toggle on, the search box asks the server for
+// names beneath this folder instead of filtering the listing. Typing is
+// debounced and the server returns at most 200 matches.
+var recursiveSearch = localStorage.getItem('recursiveSearch') === '1';
+var findQuery = '', findTimer = null, findRequest = null;
+var findResults = [], findSelected = 0, findNote = '';
+
+function toggleRecursiveSearch() {
+    var input = document.getElementById('searchBox');
+    if (!recursiveSearch) {
+        // Show the whole listing again; the results replace the filter
+        var value = input.value;
+        input.value = '';
+        filterFiles();
+        input.value = value;
+    } else {
+        scheduleFind('');
+    }
+    recursiveSearch = !recursiveSearch;
+    localStorage.setItem('recursiveSearch', recursiveSearch ? '1' : '0');
+    updateSearchMode();
+    input.focus();
+    filterFiles();
+}
+
+function updateSearchMode() {
+    var btn = document.getElementById('searchMode');
+    btn.classList.toggle('active', recursiveSearch);
+    btn.title = recursiveSearch ? 'Searching subfolders too (click to filter this folder only)' : 'Filtering this folder (click to search subfolders too)';
+    document.getElementById('searchBox').placeholder = recursiveSearch ? '⌕ Search subfolders  |  : command' : '⌕ Search  |  : command';
+}
+
+function scheduleFind(q) {
+    if (q === findQuery) return;
+    findQuery = q;
+    clearTimeout(findTimer);
+    if (findRequest) findRequest.abort();
+    if (!q) {
+        findResults = [];
+        document.getElementById('findResults').style.display = 'none';
+        return;
+    }
+    findTimer = setTimeout(function() { runFind(q); }, 300);
+}
+
+function runFind(q) {
+    findRequest = new AbortController();
+    fetch(window.location.pathname + '?find=' + encodeURIComponent(q), {signal: findRequest.signal})
+        .then(function(r) {
+            if (r.status === 429) throw new Error('Too many searches; wait a moment');
+            return r.json();
+        })
+        .then(function(data) {
+            if (!data.success) throw new Error(data.error);
+            findResults = data.results;
+            findSelected = 0;
+            findNote = data.results.length === 0 ? 'No matches' :
+                data.timedOut ? 'Search stopped early: time limit reached' :
+                data.truncated ? 'First ' + data.results.length + ' matches' : '';
+            if (data.unreadable) findNote += (findNote ? '; ' : '') + data.unreadable + " folder(s) couldn't be read";
+            renderFindResults();
+        })
+        .catch(function(err) {
+            if (err.name === 'AbortError') return;
+            findResults = [];
+            findNote = 'Error: ' + err.message;
+            renderFindResults();
+        });
+}
+
+function renderFindResults() {
+    var box = document.getElementById('findResults');
+    var html = '';
+    findResults.forEach(function(f, i) {
+        html += '<div class="find-result' + (i === findSelected ? ' selected' : '') + '" onmousedown="openFindResult(' + i + ')">' +
+            (f.isDir ? '📁 ' : '') + escapeHtml(f.path) + '</div>';
+    });
+    if (findNote) html += '<div class="find-note">' + escapeHtml(findNote) + '</div>';
+    box.innerHTML = html;
+    box.style.display = 'block';
+    var sel = box.querySelector('.selected');
+    if (sel) sel.scrollIntoView({block: 'nearest'});
+}
+
+// openFindResult goes to the folder containing result i and selects it there.
+function openFindResult(i) {
+    var f = findResults[i];
+    if (!f) return;
+    var parts = f.path.split('/');
+    var name = parts.pop();
+    var base = window.location.pathname.replace(/\/+$/, '') + '/';
+    sessionStorage.setItem('goserve_select', name);
+    window.location.href = base + parts.map(encodeURIComponent).join('/') + (parts.length ? '/' : '');
+}
+
+document.getElementById('searchBox').addEventListener('blur', function() {
+    document.getElementById('findResults').style.display = 'none';
+});
+document.getElementById('searchBox').addEventListener('focus', function() {
+    if (recursiveSearch && findQuery && (findResults.length || findNote)) renderFindResults();
+});
+updateSearchMode();
+
+// Folder sizes still being worked out on the server fill in as they're ready
+function loadFolderSizes(tries) {
+    var rows = document.querySelectorAll('#fileTable tbody tr[data-size-pending]');
+    if (rows.length === 0 || tries > 120) return;
+    fetch(window.location.pathname + '?dirsizes=1').then(r => r.json()).then(function(data) {
+        if (!data.success) return;
+        rows.forEach(function(row) {
+            var s = data.sizes[row.dataset.name];
+            if (!s) return;
+            row.dataset.size = s.bytes;
+            row.removeAttribute('data-size-pending');
+            row.querySelector('.size').textContent = s.size;
+        });
+        if (data.pending.length) setTimeout(function() { loadFolderSizes(tries + 1); }, 1000);
+    }).catch(function() {});
+}
+setTimeout(function() { loadFolderSizes(0); }, 300);
+
+function updateItemCount() {
+    var rows = document.querySelectorAll('#fileTable tbody tr');
+    var visible = 0;
+    rows.forEach(function(r) { if (r.style.display !== 'none') visible++; });
+    document.getElementById('itemCount').textContent = 'Items: ' + visible;
+}
+
+// Sort table
+// Listing order as sent by the server (folder settings or -sort)
+var currentSortCol = page.sortCol;
+var currentSortDir = page.sortDesc ? 'desc' : 'asc';
+var forceSort = page.forceSort;
+
+function sortTable(n) {
+    if (forceSort) return;
+    var tbody = document.querySelector('#fileTable tbody');
+    var rows = Array.from(tbody.querySelectorAll('tr'));
+
+    // Determine direction
+    if (currentSortCol === n) {
+        currentSortDir = currentSortDir === 'asc' ? 'desc' : 'asc';
+    } else {
+        currentSortCol = n;
+        currentSortDir = 'asc';
+    }
+
+    rows.sort(function(a, b) {
+        var av, bv;
+        if (n === 0) {
+            // Name: directories first, then alphabetical
+            var aDir = a.dataset.isdir === 'true' ? 0 : 1;
+            var bDir = b.dataset.isdir === 'true' ? 0 : 1;
+            if (aDir !== bDir) return aDir - bDir;
+            av = (a.dataset.name || '').toLowerCase();
+            bv = (b.dataset.name || '').toLowerCase();
+            return currentSortDir === 'asc' ? av.localeCompare(bv) : bv.localeCompare(av);
+        } else if (n === 1) {
+            // Size: numeric
+            av = parseInt(a.dataset.size) || 0;
+            bv = parseInt(b.dataset.size) || 0;
+            return currentSortDir === 'asc' ? av - bv : bv - av;
+        } else {
+            // Modified: numeric timestamp
+            av = parseInt(a.dataset.mod) || 0;
+            bv = parseInt(b.dataset.mod) || 0;
+            return currentSortDir === 'asc' ? av - bv : bv - av;
+        }
+    });
+
+    // Re-append in order
+    rows.forEach(function(r) { tbody.appendChild(r); });
+    history.replaceState(null, '', listingURL({sort: ['name', 'size', 'mtime'][n], order: currentSortDir}));
+
+    updateSortArrows();
+}
+
+function updateSortArrows() {
+    var ths = document.querySelectorAll('#fileTable thead th');
+    ths.forEach(function(th, i) {
+        var arrow = th.querySelector('.sort-arrow');
+        if (forceSort) {
+            th.style.cursor = 'default';
+            th.title = 'Sort order is fixed for this folder';
+        }
+        if (i === currentSortCol) {
+            th.classList.add('sorted');
+            arrow.textContent = currentSortDir === 'asc' ? '↑' : '↓';
+        } else {
+            th.classList.remove('sorted');
+            arrow.textContent = '';
+        }
+    });
+}
+
+// File operations
+function deleteFile(path, name) {
+    showConfirm('Delete ' + name + '?', 'Delete', true).then(function(ok) {
+        if (!ok) return;
+        fetch('?delete=' + encodeURIComponent(path), { method: 'POST' })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) location.reload();
+                else showAlert('Error: ' + data.error);
+            });
+    });
+}
+
+function renameFile(path, oldName) {
+    showPrompt('Rename to:', oldName, 'Rename').then(function(newName) {
+        if (!newName || newName === oldName) return;
+        fetch('?rename=' + encodeURIComponent(path) + '&newname=' + encodeURIComponent(newName), { method: 'POST' })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) location.reload();
+                else showAlert('Error: ' + data.error);
+            });
+    });
+}
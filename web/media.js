@@ -0,0 +1,49 @@
+// Video player with subtitle and audio-track selection
+function openVideo(path) {
+    var body = document.getElementById('previewBody');
+    body.innerHTML = '<video id="previewVideo" controls autoplay style="max-width:100%;max-height:75vh;" src="' + path + '"></video>' +
+        '<div id="videoTracks" style="display:flex;gap:10px;margin-top:8px;font-size:13px;"></div>';
+    document.getElementById('previewModal').style.display = 'block';
+    fetch(path + '?tracks=1').then(r => r.json()).then(function(data) {
+        if (!data.success) return;
+        var video = document.getElementById('previewVideo');
+        var bar = document.getElementById('videoTracks');
+        data.subtitles.forEach(function(t, i) {
+            var track = document.createElement('track');
+            track.kind = 'subtitles';
+            track.label = t.label;
+            track.src = t.src;
+            if (t.lang) track.srclang = t.lang;
+            video.appendChild(track);
+        });
+        if (data.subtitles.length > 0) {
+            var subSel = document.createElement('select');
+            subSel.innerHTML = '<option value="-1">Subtitles off</option>' +
+                data.subtitles.map((t, i) => '<option value="' + i + '">' + escapeHtml(t.label) + '</option>').join('');
+            subSel.onchange = function() {
+                for (var i = 0; i < video.textTracks.length; i++) {
+                    video.textTracks[i].mode = (i == subSel.value) ? 'showing' : 'disabled';
+                }
+            };
+            bar.appendChild(subSel);
+        }
+        if (data.audio.length > 1 && data.remux) {
+            var audSel = document.createElement('select');
+            audSel.innerHTML = data.audio.map((t, i) => '<option value="' + i + '">' + escapeHtml(t.label) + '</option>').join('');
+            var offset = 0;
+            audSel.onchange = function() {
+                // The remuxed stream can't seek, so restart it at the current position
+                offset += video.currentTime;
+                video.src = path + '?audio=' + audSel.value + '&t=' + offset.toFixed(1);
+                video.play();
+            };
+            bar.appendChild(audSel);
+        }
+    });
+}
+
+function closePreview() {
+    var video = document.getElementById('previewVideo');
+    if (video) video.pause();
+    document.getElementById('previewModal').style.display = 'none';
+}
@@ -0,0 +1,140 @@
+// Find in files
+var grepMatches = [];
+
+function grepOptions() {
+    return (document.getElementById('grepRegex').checked ? '&regex=1' : '') +
+        (document.getElementById('grepCase').checked ? '&case=1' : '');
+}
+
+function showGrepModal() {
+    hideAllMenus();
+    document.getElementById('grepModal').style.display = 'block';
+    setTimeout(() => document.getElementById('grepQuery').focus(), 100);
+}
+
+function closeGrepModal() {
+    document.getElementById('grepModal').style.display = 'none';
+}
+
+function runGrep() {
+    var q = document.getElementById('grepQuery').value;
+    if (!q) return;
+    var status = document.getElementById('grepStatus');
+    var results = document.getElementById('grepResults');
+    status.textContent = 'Searching...';
+    results.innerHTML = '';
+    var indexed = document.getElementById('grepIndexed');
+    if (indexed && indexed.checked) { runContentSearch(q); return; }
+    fetch(window.location.pathname + '?grep=' + encodeURIComponent(q) + grepOptions())
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { status.textContent = 'Error: ' + data.error; return; }
+            grepMatches = data.matches;
+            status.textContent = data.matches.length + ' match' + (data.matches.length === 1 ? '' : 'es') +
+                (data.timedOut ? ' (search stopped early: time limit reached)' : data.truncated ? ' (showing first ' + data.matches.length + ')' : '') +
+                ' in ' + data.files + ' file' + (data.files === 1 ? '' : 's') +
+                (data.skipped ? ', ' + data.skipped + ' skipped (excluded or too large)' : '') +
+                (data.unreadable ? ', ' + data.unreadable + " couldn't be read" : '');
+            status.title = (data.unreadableEntries || []).map(function(e) { return e.path + ': ' + e.error; }).join('\n');
+            data.matches.forEach(addGrepResult);
+        })
+        .catch(err => { status.textContent = 'Error: ' + err.message; });
+}
+
+function addGrepResult(m) {
+    var div = document.createElement('div');
+    div.className = 'grep-result';
+    div.innerHTML = '<span class="grep-loc">' + escapeHtml(m.path) + (m.line ? ':' + m.line : '') + '</span>' +
+        '<span class="grep-text">' + escapeHtml(m.text) + '</span>';
+    div.onclick = function() {
+        closeGrepModal();
+        if (caps.edit) editFile(m.path, m.name, m.line);
+        else openEditor(m.path, m.name, '', m.line);
+    };
+    document.getElementById('grepResults').appendChild(div);
+}
+
+// Word search through the server's content index (-index)
+function runContentSearch(q) {
+    var status = document.getElementById('grepStatus');
+    fetch(window.location.pathname + '?content=' + encodeURIComponent(q))
+        .then(r => r.json())
+        .then(data => {
+            if (!data.success) { status.textContent = 'Error: ' + data.error; return; }
+            // Replace works on lines; the index only knows files
+            grepMatches = [];
+            status.textContent = data.results.length + ' file' + (data.results.length === 1 ? '' : 's') +
+                (data.truncated ? ' (showing the best ' + data.results.length + ')' : '') +
+                ' of ' + data.files + ' indexed' +
+                (data.indexing ? '; still indexing, so some files may be missing' : '');
+            status.title = '';
+            data.results.forEach(addGrepResult);
+        })
+        .catch(err => { status.textContent = 'Error: ' + err.message; });
+}
+
+// Limits, totals and running searches (admins)
+function showSearchStatus() {
+    var results = document.getElementById('grepResults');
+    fetch('/api/v1/search-status').then(r => r.json()).then(function(data) {
+        if (!data.success) { results.textContent = 'Error: ' + data.error; return; }
+        var l = data.limits, t = data.totals;
+        var html = '<p>Limits: ' + (l.concurrency ? l.concurrency + ' at a time' : 'no concurrency limit') +
+            ', files up to ' + formatBytes(l.maxFileBytes) +
+            (l.bytesPerSecond ? ', ' + formatBytes(l.bytesPerSecond) + '/s each' : '') +
+            (l.timeoutSeconds ? ', ' + l.timeoutSeconds + 's max' : '') +
+            (l.exclude ? '; excluding ' + escapeHtml(l.exclude) : '') + '</p>' +
+            '<p>Since startup: ' + t.searches + ' searches, ' + t.files + ' files, ' + formatBytes(t.bytes) + ' read, ' +
+            t.skipped + ' skipped, ' + t.timedOut + ' timed out</p>';
+        if (data.index) {
+            html += '<p>Index: ' + data.index.files + ' files, ' + data.index.words + ' distinct words' +
+                (data.index.ready ? ', last checked ' + new Date(data.index.lastScan).toLocaleString() : ', first scan in progress') + '</p>';
+        }
+        var active = data.active || [];
+        if (active.length === 0) {
+            html += '<p>No searches running.</p>';
+        } else {
+            html += '<table style="width:100%;font-size:12px;"><tr><th>Folder</th><th>Query</th><th>User</th><th>Progress</th></tr>';
+            active.forEach(function(s) {
+                html += '<tr><td>' + escapeHtml(s.path) + '</td><td>' + escapeHtml(s.query) + '</td><td>' + escapeHtml(s.user) + '</td>' +
+                    '<td>' + (s.waiting ? 'waiting' : s.files + ' files, ' + formatBytes(s.bytes) + ', ' +
+                    Math.round((Date.now() - new Date(s.started)) / 1000) + 's') + '</td></tr>';
+            });
+            html += '</table>';
+        }
+        results.innerHTML = html;
+    });
+}
+
+// Replace runs on the server, file by file, so huge files are never loaded in the browser
+function replaceInResults() {
+    var find = document.getElementById('grepQuery').value;
+    var repl = document.getElementById('grepReplace').value;
+    var files = Array.from(new Set(grepMatches.map(m => m.path)));
+    if (!find || files.length === 0) return;
+    showConfirm('Replace all matches in ' + files.length + ' file(s)?', 'Replace All').then(function(ok) {
+        if (!ok) return;
+        var body = JSON.stringify({
+            find: find, replace: repl,
+            regex: document.getElementById('grepRegex').checked,
+            case: document.getElementById('grepCase').checked
+        });
+        var total = 0;
+        var chain = Promise.resolve();
+        files.forEach(function(p) {
+            chain = chain.then(function() {
+                return fetch(p + '?replace=1', { method: 'POST', headers: {'Content-Type': 'application/json'}, body: body })
+                    .then(r => r.json())
+                    .then(data => {
+                        if (data.success) total += data.replaced;
+                        else showAlert('Error replacing in ' + p + ': ' + data.error);
+                    });
+            });
+        });
+        chain.then(function() {
+            document.getElementById('grepStatus').textContent = 'Replaced ' + total + ' occurrence(s)';
+            document.getElementById('grepResults').innerHTML = '';
+            grepMatches = [];
+        });
+    });
+}
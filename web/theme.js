@@ -0,0 +1,31 @@
+// Theme system
+function isDarkTheme(theme) {
+    return theme !== 'light' && theme !== 'solarized-light' && theme !== 'github-light';
+}
+
+function updateAboutLogo(theme) {
+    const logoCenter = document.getElementById('aboutLogoCenter');
+    if (logoCenter) {
+        logoCenter.setAttribute('fill', isDarkTheme(theme) ? '#2d2d2d' : '#ffffff');
+    }
+}
+
+function changeTheme(theme) {
+    if (theme === 'light') {
+        document.documentElement.removeAttribute('data-theme');
+    } else {
+        document.documentElement.setAttribute('data-theme', theme);
+    }
+    localStorage.setItem('theme', theme);
+    document.getElementById('themeSelect').value = theme;
+    updateAboutLogo(theme);
+    if (editor) {
+        editor.setOption('theme', isDarkTheme(theme) ? 'monokai' : 'default');
+    }
+}
+
+// Load saved theme
+let savedTheme = localStorage.getItem('theme') || 'light';
+if (savedTheme === 'dark' || savedTheme === 'goserve-dark') savedTheme = 'catppuccin-mocha';
+if (savedTheme === 'vs-dark') savedTheme = 'one-dark';
+changeTheme(savedTheme);
@@ -0,0 +1,88 @@
+// Values the server renders into each listing page (PageData.Client)
+var page = JSON.parse(document.getElementById('pageData').textContent);
+
+// What this user may do in this folder, from the server (capabilitiesFor)
+var caps = page.caps;
+
+// Origin for copied links: the address other devices can reach (advertisedOrigin)
+var shareOrigin = page.origin;
+
+// A branded folder's accent colour overrides the theme's (brandingFor)
+if (page.accent) document.documentElement.style.setProperty('--accent', page.accent);
+
+// --- Custom dialog system ---
+var _dialogResolve = null;
+
+function dialogCancel() {
+    document.getElementById('dialogOverlay').classList.remove('active');
+    if (_dialogResolve) { _dialogResolve(null); _dialogResolve = null; }
+}
+
+function dialogOk() {
+    var overlay = document.getElementById('dialogOverlay');
+    var input = document.getElementById('dialogInput');
+    overlay.classList.remove('active');
+    if (_dialogResolve) {
+        var val = input.style.display === 'none' ? true : input.value;
+        _dialogResolve(val);
+        _dialogResolve = null;
+    }
+}
+
+function showAlert(msg, title) {
+    return new Promise(function(resolve) {
+        _dialogResolve = resolve;
+        document.getElementById('dialogTitle').textContent = title || '';
+        document.getElementById('dialogTitle').style.display = title ? '' : 'none';
+        document.getElementById('dialogMessage').textContent = msg;
+        document.getElementById('dialogInput').style.display = 'none';
+        document.getElementById('dialogButtons').innerHTML =
+            '<button class="dialog-btn primary" onclick="dialogOk()">OK</button>';
+        document.getElementById('dialogOverlay').classList.add('active');
+    });
+}
+
+function showConfirm(msg, title, danger) {
+    return new Promise(function(resolve) {
+        _dialogResolve = resolve;
+        document.getElementById('dialogTitle').textContent = title || 'Confirm';
+        document.getElementById('dialogTitle').style.display = '';
+        document.getElementById('dialogMessage').textContent = msg;
+        document.getElementById('dialogInput').style.display = 'none';
+        var btnClass = danger ? 'danger' : 'primary';
+        document.getElementById('dialogButtons').innerHTML =
+            '<button class="dialog-btn" onclick="dialogCancel()">Cancel</button>' +
+            '<button class="dialog-btn ' + btnClass + '" onclick="dialogOk()">' + (danger ? 'Delete' : 'OK') + '</button>';
+        document.getElementById('dialogOverlay').classList.add('active');
+    });
+}
+
+function showPrompt(msg, defaultVal, title, secret) {
+    return new Promise(function(resolve) {
+        _dialogResolve = resolve;
+        document.getElementById('dialogTitle').textContent = title || '';
+        document.getElementById('dialogTitle').style.display = title ? '' : 'none';
+        document.getElementById('dialogMessage').textContent = msg;
+        var input = document.getElementById('dialogInput');
+        input.style.display = '';
+        input.type = secret ? 'password' : 'text';
+        input.value = defaultVal || '';
+        document.getElementById('dialogButtons').innerHTML =
+            '<button class="dialog-btn" onclick="dialogCancel()">Cancel</button>' +
+            '<button class="dialog-btn primary" onclick="dialogOk()">OK</button>';
+        document.getElementById('dialogOverlay').classList.add('active');
+        setTimeout(function() { input.focus(); input.select(); }, 100);
+    });
+}
+
+function escapeHtml(text) {
+    const map = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#039;' };
+    return text.replace(/[&<>"']/g, m => map[m]);
+}
+
+function formatBytes(n) {
+    var units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    var i = 0;
+    while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+    return (i === 0 ? n : n.toFixed(1)) + ' ' + units[i];
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Password-protected ZIPs. Downloads are encrypted with AES-256 in the
+// WinZip AE-1 format, which 7-Zip, WinZip, macOS Archive Utility (via
+// ditto) and Windows 11 open; the legacy ZipCrypto scheme is too weak to
+// offer. Extraction reads both, since older tools still produce ZipCrypto.
+
+const (
+	zipMethodAES  = 99     // compression method of WinZip AES entries
+	zipExtraAES   = 0x9901 // extra field holding the AES parameters
+	zipEncrypted  = 0x1    // general purpose flag: entry is encrypted
+	aesIterations = 1000   // PBKDF2 rounds fixed by the WinZip spec
+	aesMACSize    = 10     // bytes of HMAC-SHA1 stored after the data
+)
+
+var (
+	errZipPassword      = errors.New("this archive is password-protected")
+	errZipWrongPassword = errors.New("wrong password")
+)
+
+// aesKeys derives the encryption key, MAC key and two-byte password
+// verifier for one entry.
+func aesKeys(password string, salt []byte, keyLen int) (encKey, macKey, verify []byte, err error) {
+	k, err := pbkdf2.Key(sha1.New, password, salt, aesIterations, 2*keyLen+2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return k[:keyLen], k[keyLen : 2*keyLen], k[2*keyLen:], nil
+}
+
+// aesCTR is AES in counter mode as WinZip uses it: a little-endian counter
+// starting at 1, which crypto/cipher's big-endian CTR can't produce.
+type aesCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newAESCTR(key []byte) (*aesCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesCTR{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *aesCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// aesExtra is the 0x9901 extra field for an AE-1 entry with AES-256 whose
+// data is compressed with method.
+func aesExtra(method uint16) []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], 1) // AE-1: the CRC is kept
+	copy(b[6:], "AE")
+	b[8] = 3 // AES-256
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// aesCompressor returns a zip.Compressor for zipMethodAES that deflates each
+// entry and encrypts it under password with a fresh salt.
+func aesCompressor(password string) zip.Compressor {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		encKey, macKey, verify, err := aesKeys(password, salt, 32)
+		if err != nil {
+			return nil, err
+		}
+		ctr, err := newAESCTR(encKey)
+		if err != nil {
+			return nil, err
+		}
+		ew := &aesWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, macKey), head: append(salt, verify...)}
+		fw, err := flate.NewWriter(ew, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &aesEntryWriter{fw, ew}, nil
+	}
+}
+
+// aesWriter encrypts what is written through it and MACs the ciphertext.
+// The salt and verifier go first, but only once data arrives: zip.Writer
+// sets up the compressor before it writes the local file header.
+type aesWriter struct {
+	w    io.Writer
+	ctr  *aesCTR
+	mac  hash.Hash
+	head []byte
+	buf  []byte
+}
+
+func (a *aesWriter) writeHead() error {
+	if a.head == nil {
+		return nil
+	}
+	_, err := a.w.Write(a.head)
+	a.head = nil
+	return err
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	if err := a.writeHead(); err != nil {
+		return 0, err
+	}
+	a.buf = append(a.buf[:0], p...)
+	a.ctr.XORKeyStream(a.buf, a.buf)
+	a.mac.Write(a.buf)
+	return a.w.Write(a.buf)
+}
+
+// aesEntryWriter flushes the deflate stream and appends the MAC on Close.
+type aesEntryWriter struct {
+	*flate.Writer
+	enc *aesWriter
+}
+
+func (e *aesEntryWriter) Close() error {
+	if err := e.Writer.Close(); err != nil {
+		return err
+	}
+	if err := e.enc.writeHead(); err != nil {
+		return err
+	}
+	_, err := e.enc.w.Write(e.enc.mac.Sum(nil)[:aesMACSize])
+	return err
+}
+
+// newEncryptedZipWriter returns a zip.Writer on out whose zipMethodAES
+// entries are encrypted under password.
+func newEncryptedZipWriter(out io.Writer, password string) *zip.Writer {
+	zw := zip.NewWriter(out)
+	zw.RegisterCompressor(zipMethodAES, aesCompressor(password))
+	return zw
+}
+
+// encryptZipHeader switches a file header to AES, keeping deflate as the
+// inner compression.
+func encryptZipHeader(h *zip.FileHeader) {
+	h.Method = zipMethodAES
+	h.Flags |= zipEncrypted
+	h.Extra = append(h.Extra, aesExtra(zip.Deflate)...)
+}
+
+// zipAESParams reads the key strength, vendor version and real compression
+// method from an entry's 0x9901 extra field.
+func zipAESParams(f *zip.File) (strength byte, version, method uint16, ok bool) {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == zipExtraAES && size >= 7 {
+			d := extra[4:]
+			return d[4], binary.LittleEndian.Uint16(d), binary.LittleEndian.Uint16(d[5:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, 0, false
+}
+
+// zipCryptoKeys is the PKWARE traditional encryption state.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(p []byte) {
+	for i := range p {
+		t := uint16(k[2]) | 2
+		p[i] ^= byte((uint32(t) * uint32(t^1)) >> 8)
+		k.update(p[i])
+	}
+}
+
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.keys.decrypt(p[:n])
+	return n, err
+}
+
+type aesReader struct {
+	r   io.Reader
+	ctr *aesCTR
+	mac hash.Hash
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	a.mac.Write(p[:n])
+	a.ctr.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// zipEntryReader is a decrypted, decompressed entry. Close reports a bad
+// MAC or CRC, so callers must check it before trusting what they read.
+type zipEntryReader struct {
+	io.Reader
+	data   io.Reader // the decrypted compressed data, drained on Close
+	crc    hash.Hash32
+	want   uint32
+	verify func() error
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	n, err := z.Reader.Read(p)
+	z.crc.Write(p[:n])
+	return n, err
+}
+
+func (z *zipEntryReader) Close() error {
+	if _, err := io.Copy(io.Discard, z); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, z.data)
+	if z.verify != nil {
+		if err := z.verify(); err != nil {
+			return err
+		}
+	}
+	if z.want != 0 && z.crc.Sum32() != z.want {
+		return zip.ErrChecksum
+	}
+	return nil
+}
+
+// openZipEntry opens f, decrypting it with password if it is encrypted. It
+// returns errZipPassword when a password is needed and none was given, and
+// errZipWrongPassword when the one given doesn't match.
+func openZipEntry(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&zipEncrypted == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, errZipPassword
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var data io.Reader
+	method := f.Method
+	want := f.CRC32
+	var verify func() error
+	if f.Method == zipMethodAES {
+		strength, version, inner, ok := zipAESParams(f)
+		if !ok || strength < 1 || strength > 3 {
+			return nil, fmt.Errorf("%s: unsupported AES parameters", f.Name)
+		}
+		keyLen := 8 + 8*int(strength)
+		saltLen := keyLen / 2
+		overhead := uint64(saltLen + 2 + aesMACSize)
+		if f.CompressedSize64 < overhead {
+			return nil, zip.ErrFormat
+		}
+		head := make([]byte, saltLen+2)
+		if _, err := io.ReadFull(raw, head); err != nil {
+			return nil, err
+		}
+		encKey, macKey, check, err := aesKeys(password, head[:saltLen], keyLen)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(check, head[saltLen:]) {
+			return nil, errZipWrongPassword
+		}
+		ctr, err := newAESCTR(encKey)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha1.New, macKey)
+		data = &aesReader{io.LimitReader(raw, int64(f.CompressedSize64-overhead)), ctr, mac}
+		verify = func() error {
+			stored := make([]byte, aesMACSize)
+			if _, err := io.ReadFull(raw, stored); err != nil {
+				return err
+			}
+			if !hmac.Equal(stored, mac.Sum(nil)[:aesMACSize]) {
+				return fmt.Errorf("%s: authentication failed", f.Name)
+			}
+			return nil
+		}
+		method = inner
+		if version == 2 {
+			want = 0 // AE-2 leaves the CRC out
+		}
+	} else {
+		if f.CompressedSize64 < 12 {
+			return nil, zip.ErrFormat
+		}
+		keys := newZipCryptoKeys(password)
+		head := make([]byte, 12)
+		if _, err := io.ReadFull(raw, head); err != nil {
+			return nil, err
+		}
+		keys.decrypt(head)
+		// The last header byte repeats the top of the CRC, or of the DOS
+		// time when sizes follow in a data descriptor
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&0x8 != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		if head[11] != check {
+			return nil, errZipWrongPassword
+		}
+		data = &zipCryptoReader{io.LimitReader(raw, int64(f.CompressedSize64-12)), keys}
+	}
+
+	var plain io.Reader
+	switch method {
+	case zip.Store:
+		plain = data
+	case zip.Deflate:
+		plain = flate.NewReader(data)
+	default:
+		return nil, fmt.Errorf("%s: %w", f.Name, zip.ErrAlgorithm)
+	}
+	return &zipEntryReader{Reader: plain, data: data, crc: crc32.NewIEEE(), want: want, verify: verify}, nil
+}
+
+// checkZipPassword tries password on the first encrypted entry of zr, so a
+// missing or wrong password is reported before anything is extracted.
+func checkZipPassword(zr *zip.Reader, password string) error {
+	for _, f := range zr.File {
+		if f.Flags&zipEncrypted == 0 || f.FileInfo().IsDir() {
+			continue
+		}
+		_, err := openZipEntry(f, password)
+		return err
+	}
+	return nil
+}
+
+// extractJob unpacks zr into destDir, a folder it creates. Entries that
+// fail, including ones that fail their MAC or CRC, are removed and listed
+// on the job; the rest are kept. Settings files in the archive are only
+// unpacked if settings is set (the requester is an administrator).
+func extractJob(ctx context.Context, j *Job, zr *zip.ReadCloser, destDir, destURL, password string, settings bool) error {
+	defer zr.Close()
+	var total int64
+	for _, f := range zr.File {
+		total += int64(f.UncompressedSize64)
+	}
+	j.setProgress(0, total, "Extracting")
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entryURL := path.Join(destURL, f.Name)
+		rel, err := cleanUploadPath(f.Name)
+		if err != nil || filepath.IsAbs(rel) || rel == "." {
+			j.addFailure(entryURL, errors.New("unsafe name in archive"))
+			continue
+		}
+		dst := filepath.Join(destDir, rel)
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				j.addFailure(entryURL, err)
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			// Symlinks and devices could point outside the share
+			continue
+		}
+		if isSettingsFile(filepath.Base(rel)) && !settings {
+			j.addFailure(entryURL, fmt.Errorf("%s sets the rules for its folder; only administrators can add it", filepath.Base(rel)))
+			continue
+		}
+		if err := extractZipEntry(ctx, j, f, dst, password); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			j.addFailure(entryURL, err)
+		}
+	}
+	publishEvent(fileEvent{Type: "created", Path: urlFor(destDir), IsDir: true, User: j.Owner, Source: "job"})
+	if err := j.partialFailure("extracted"); err != nil {
+		return err
+	}
+	j.complete("Extracted", destURL)
+	return nil
+}
+
+// extractZipEntry writes one file, removing it again if it doesn't verify.
+func extractZipEntry(ctx context.Context, j *Job, f *zip.File, dst, password string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	rc, err := openZipEntry(f, password)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, f.Mode().Perm()|0600)
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	_, err = io.Copy(progressWriter{ctx, out, j}, rc)
+	if cerr := rc.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	os.Chtimes(dst, f.Modified, f.Modified)
+	return nil
+}
+
+// createExtractJob starts extracting the ZIP at urlPath into a new folder
+// beside it, named after the archive. An encrypted archive is checked
+// against password first; without a valid one the answer carries
+// "passwordRequired" so the UI can ask for it.
+func createExtractJob(w http.ResponseWriter, r *http.Request, urlPath, password string) {
+	fullPath, ok := resolvePathFor(r, urlPath)
+	if !ok {
+		jsonError(w, http.StatusForbidden, "Invalid path")
+		return
+	}
+	if archiveFormat(fullPath) != "zip" {
+		jsonError(w, http.StatusBadRequest, "Not a ZIP archive")
+		return
+	}
+	parent := filepath.Dir(fullPath)
+	if !capabilitiesFor(r, parent).Upload {
+		deny(w, r, parent, "upload")
+		return
+	}
+	zr, err := zip.OpenReader(fullPath)
+	if err != nil {
+		jsonError(w, http.StatusUnprocessableEntity, "Cannot read archive: "+err.Error())
+		return
+	}
+	if err := checkZipPassword(&zr.Reader, password); err != nil {
+		zr.Close()
+		if errors.Is(err, errZipPassword) || errors.Is(err, errZipWrongPassword) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error(), "passwordRequired": true})
+			return
+		}
+		jsonError(w, http.StatusUnprocessableEntity, "Cannot read archive: "+err.Error())
+		return
+	}
+
+	settings := capabilitiesFor(r, parent).Admin
+	destDir := uniquePath(strings.TrimSuffix(fullPath, filepath.Ext(fullPath)))
+	destURL := urlForRequest(r, destDir)
+	j := startJob("extract", path.Clean("/"+urlPath), requesterName(r), func(ctx context.Context, j *Job) error {
+		return extractJob(ctx, j, zr, destDir, destURL, password, settings)
+	})
+	writeJSON(w, map[string]any{"success": true, "job": j.snapshot()})
+}
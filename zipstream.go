@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// ZIPs of very large folders. archive/zip turns to zip64 by itself where
+// a ZIP needs it: files and archives over 4 GB and more than 65,535
+// entries. Every file is written with a data descriptor, so its size
+// needn't be known before it is read, and nothing is held back: a folder
+// download goes out as the folder is read, whatever its size (spooling
+// only waits for the whole archive for folders up to spoolMaxOnDemand).
+//
+// Entries that can't be read are left out and listed twice: in the ZIP
+// comment, which unzip -z shows but which is capped at 64 KB, and in full
+// in UNREADABLE.txt, the last entry of the archive. A file that fails
+// partway through is kept as far as it was read and listed as incomplete,
+// since what has been sent can't be taken back.
+
+const zipManifestName = "UNREADABLE.txt"
+
+// finishZip lists skipped in zw's comment and in a text file at the top of
+// the archive, encrypted if the files are, and closes zw. dir is the
+// folder the archive's top level comes from, so the list doesn't take the
+// name of a file in it.
+func finishZip(zw *zip.Writer, dir string, skipped []entryError, encrypt bool) error {
+	if len(skipped) > 0 {
+		zw.SetComment(skippedComment(skipped))
+		header := &zip.FileHeader{
+			Name:     filepath.Base(uniquePath(filepath.Join(dir, zipManifestName))),
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		}
+		if encrypt {
+			encryptZipHeader(header)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			zw.Close()
+			return err
+		}
+		fmt.Fprintf(w, "%d entries could not be read, so they are missing from this archive, or incomplete where it says so:\n\n", len(skipped))
+		for _, e := range skipped {
+			fmt.Fprintln(w, e.Error())
+		}
+	}
+	return zw.Close()
+}
+
+// readFailure passes on reads from a file until one fails, then ends the
+// file there and keeps the error, so a bad disk sector doesn't end the
+// whole download.
+type readFailure struct {
+	r   io.Reader
+	err error
+}
+
+func (f *readFailure) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err != nil && err != io.EOF {
+		f.err = err
+		return n, io.EOF
+	}
+	return n, err
+}